@@ -0,0 +1,193 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "log"
+  "net"
+  "os"
+  "os/signal"
+  "sort"
+  "syscall"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  "github.com/salman-ahmad/grpc-streaming/endpoint"
+)
+
+type agent struct {
+  keys           map[string]crypto.PrivateKey
+  policy         *policy
+  confirmCommand string
+  uid            uint32
+}
+
+func main() {
+  
+  conf := loadConfig()
+  agent := &agent{
+    keys:           privateKeys(conf.AgentKeys),
+    policy:         loadAgentPolicy(conf.AgentPolicy),
+    confirmCommand: conf.AgentConfirm,
+    uid:            uint32(os.Getuid()),
+  }
+  
+  listener := startListener(conf.AgentSocket)
+  defer listener.Close()
+  
+  // remove the socket file when stopped
+  signals := make(chan os.Signal, 1)
+  signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+  go func() {
+    <-signals
+    listener.Close()
+  }()
+  
+  agent.serve(listener)
+}
+
+func loadConfig() *config.Config {
+  log.Println("loadConfig()")
+  conf, err := config.LoadConfig()
+  if err != nil {
+    log.Fatalf("failed to read configuration :%v\n", err)
+  }
+  log.Println("processed configuration")
+  return conf
+}
+
+func privateKeys(paths []string) map[string]crypto.PrivateKey {
+  log.Println("privateKeys()")
+  keys := make(map[string]crypto.PrivateKey)
+  for _, path := range paths {
+    privKeyPath, err := config.AbsolutePath(path)
+    if err != nil {
+      log.Fatalf("failed to calculate private key's absloute path :%v\n", err)
+    }
+    
+    fileKey, err := crypto.NewFileKey(privKeyPath)
+    if err != nil {
+      log.Fatalf("failed to load private key: %v\n", err)
+    }
+    privateKey, err := crypto.NewPrivateKey(fileKey.Bytes())
+    if err != nil {
+      log.Fatalf("failed to read private key %s: %v\n", privKeyPath, err)
+    }
+    keys[privateKey.KeyID()] = privateKey
+    log.Printf("loaded key %s from %s\n", privateKey.KeyID(), privKeyPath)
+  }
+  return keys
+}
+
+func loadAgentPolicy(path string) *policy {
+  log.Println("loadAgentPolicy()")
+  policyPath, err := config.AbsolutePath(path)
+  if err != nil {
+    log.Fatalf("failed to calculate policy's absloute path :%v\n", err)
+  }
+  p, err := loadPolicy(policyPath)
+  if err != nil {
+    log.Fatalf("failed to load agent policy: %v\n", err)
+  }
+  return p
+}
+
+// startListener creates the socket so that only the owner can connect,
+// keeping the socket of an agent that still runs; callers are still
+// checked against the policy on every request
+func startListener(socket string) *endpoint.UnixListener {
+  log.Println("startListener()")
+  socketPath, err := config.AbsolutePath(socket)
+  if err != nil {
+    log.Fatalf("failed to calculate socket's absloute path :%v\n", err)
+  }
+  
+  listener, err := endpoint.ListenUnix(socketPath, 0600)
+  if err != nil {
+    log.Fatalf("failed to listen on socket: %v\n", err)
+  }
+  log.Printf("agent listening on %s\n", socketPath)
+  return listener
+}
+
+func (a *agent) serve(listener *endpoint.UnixListener) {
+  for {
+    conn, err := listener.AcceptUnix()
+    if err != nil {
+      log.Printf("stopped accepting connections: %v\n", err)
+      return
+    }
+    go a.handle(conn)
+  }
+}
+
+// handle answers a single request and closes the connection
+func (a *agent) handle(conn *net.UnixConn) {
+  defer conn.Close()
+  conn.SetDeadline(time.Now().Add(2 * time.Minute))
+  
+  var request crypto.AgentRequest
+  if err := json.NewDecoder(conn).Decode(&request); err != nil {
+    log.Printf("failed to read request: %v\n", err)
+    return
+  }
+  
+  c, err := peerCaller(conn)
+  var response *crypto.AgentResponse
+  if err != nil {
+    log.Printf("failed to identify caller: %v\n", err)
+    response = &crypto.AgentResponse{Error: "caller could not be identified"}
+  } else {
+    response = a.respond(c, request)
+  }
+  
+  if err := json.NewEncoder(conn).Encode(response); err != nil {
+    log.Printf("failed to send response: %v\n", err)
+  }
+}
+
+func (a *agent) respond(c caller, request crypto.AgentRequest) *crypto.AgentResponse {
+  switch request.Op {
+  case crypto.AgentOpList:
+    return &crypto.AgentResponse{KeyIDs: a.allowedKeys(c)}
+  case crypto.AgentOpSign:
+    signature, err := a.sign(c, request.KeyID, request.Data)
+    if err != nil {
+      log.Printf("refused to sign for %s: %v\n", c, err)
+      return &crypto.AgentResponse{Error: err.Error()}
+    }
+    log.Printf("signed with key %s for %s\n", request.KeyID, c)
+    return &crypto.AgentResponse{Signature: signature}
+  default:
+    return &crypto.AgentResponse{Error: fmt.Sprintf("unknown operation %q", request.Op)}
+  }
+}
+
+// allowedKeys lists only the keys the caller may use
+func (a *agent) allowedKeys(c caller) []string {
+  var ids []string
+  for id := range a.keys {
+    if a.policy.forKey(id).allows(c, a.uid) {
+      ids = append(ids, id)
+    }
+  }
+  sort.Strings(ids)
+  return ids
+}
+
+func (a *agent) sign(c caller, keyID string, data []byte) ([]byte, error) {
+  key, ok := a.keys[keyID]
+  if !ok {
+    return nil, fmt.Errorf("unknown key %s", keyID)
+  }
+  
+  keyPolicy := a.policy.forKey(keyID)
+  if !keyPolicy.allows(c, a.uid) {
+    return nil, fmt.Errorf("caller is not allowed to use key %s", keyID)
+  }
+  if keyPolicy.Confirm && !confirm(a.confirmCommand, keyID, c) {
+    return nil, fmt.Errorf("use of key %s was not confirmed", keyID)
+  }
+  return key.Sign(data)
+}
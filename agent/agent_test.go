@@ -0,0 +1,134 @@
+package main
+
+import (
+  "crypto/rand"
+  "crypto/rsa"
+  "crypto/x509"
+  "encoding/pem"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  "github.com/salman-ahmad/grpc-streaming/endpoint"
+)
+
+func testKeys(t *testing.T) (crypto.PrivateKey, crypto.PublicKey) {
+  rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+  if err != nil {
+    t.Fatal(err)
+  }
+  privatePEM := pem.EncodeToMemory(&pem.Block{
+    Type:  "RSA PRIVATE KEY",
+    Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+  })
+  publicDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+  publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+  
+  privateKey, _ := crypto.NewPrivateKey(privatePEM)
+  publicKey, _ := crypto.NewPublicKey(publicPEM)
+  return privateKey, publicKey
+}
+
+// startAgent serves the key on a temporary socket until stopped
+func startAgent(t *testing.T, key crypto.PrivateKey, p *policy) (string, func()) {
+  dir, _ := ioutil.TempDir("", "agent")
+  socket := filepath.Join(dir, "agent.sock")
+  listener, err := endpoint.ListenUnix(socket, 0600)
+  if err != nil {
+    t.Fatal(err)
+  }
+  
+  a := &agent{
+    keys:   map[string]crypto.PrivateKey{key.KeyID(): key},
+    policy: p,
+    uid:    uint32(os.Getuid()),
+  }
+  go a.serve(listener)
+  return socket, func() {
+    listener.Close()
+    os.RemoveAll(dir)
+  }
+}
+
+func TestAgent_Sign(t *testing.T) {
+  privateKey, publicKey := testKeys(t)
+  socket, stop := startAgent(t, privateKey, &policy{})
+  defer stop()
+  
+  agentKey, err := crypto.NewAgentPrivateKey(socket, "")
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  if agentKey.KeyID() != publicKey.KeyID() {
+    t.Errorf("Got: %s, wanted: %s\n", agentKey.KeyID(), publicKey.KeyID())
+  }
+  
+  data := crypto.Int64ToBytes(42)
+  signature, err := agentKey.Sign(data)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  if valid, err := publicKey.Verify(data, signature); !valid {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+}
+
+func TestAgent_UnknownKey(t *testing.T) {
+  privateKey, _ := testKeys(t)
+  socket, stop := startAgent(t, privateKey, &policy{})
+  defer stop()
+  
+  _, err := crypto.NewAgentPrivateKey(socket, "0000000000000000")
+  if err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "error")
+  }
+}
+
+func TestAgent_CallerNotAllowed(t *testing.T) {
+  privateKey, _ := testKeys(t)
+  otherUID := uint32(os.Getuid()) + 1
+  p := &policy{Keys: map[string]keyPolicy{
+    privateKey.KeyID(): {AllowUIDs: []uint32{otherUID}},
+  }}
+  socket, stop := startAgent(t, privateKey, p)
+  defer stop()
+  
+  _, err := crypto.NewAgentPrivateKey(socket, privateKey.KeyID())
+  if err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "error")
+  }
+}
+
+func TestKeyPolicy_Allows(t *testing.T) {
+  c := caller{UID: 1000, Executable: "/usr/bin/client"}
+  tests := []struct {
+    policy   keyPolicy
+    expected bool
+  }{
+    {keyPolicy{}, true},
+    {keyPolicy{AllowUIDs: []uint32{0}}, false},
+    {keyPolicy{AllowExecutables: []string{"/usr/bin/client"}}, true},
+    {keyPolicy{AllowExecutables: []string{"/usr/bin/other"}}, false},
+  }
+  for _, test := range tests {
+    actual := test.policy.allows(c, 1000)
+    if actual != test.expected {
+      t.Errorf("%+v: Got: %v, wanted: %v\n", test.policy, actual, test.expected)
+    }
+  }
+}
+
+func TestConfirm(t *testing.T) {
+  c := caller{UID: 1000}
+  if confirm("", "key", c) {
+    t.Errorf("Got: %v, wanted: %v\n", true, false)
+  }
+  if !confirm("true", "key", c) {
+    t.Errorf("Got: %v, wanted: %v\n", false, true)
+  }
+  if confirm("false", "key", c) {
+    t.Errorf("Got: %v, wanted: %v\n", true, false)
+  }
+}
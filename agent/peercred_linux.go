@@ -0,0 +1,34 @@
+// +build linux
+
+package main
+
+import (
+  "fmt"
+  "net"
+  "os"
+  "syscall"
+)
+
+// peerCaller reads the credentials of the process on the other end
+// of the socket from the kernel, so they cannot be forged
+func peerCaller(conn *net.UnixConn) (caller, error) {
+  raw, err := conn.SyscallConn()
+  if err != nil {
+    return caller{}, err
+  }
+  
+  var cred *syscall.Ucred
+  var credErr error
+  err = raw.Control(func(fd uintptr) {
+    cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+  })
+  if err != nil {
+    return caller{}, err
+  }
+  if credErr != nil {
+    return caller{}, credErr
+  }
+  
+  exe, _ := os.Readlink(fmt.Sprintf("/proc/%d/exe", cred.Pid))
+  return caller{UID: cred.Uid, GID: cred.Gid, PID: cred.Pid, Executable: exe}, nil
+}
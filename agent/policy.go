@@ -0,0 +1,101 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "os/exec"
+)
+
+// caller is the process that connected to the agent
+type caller struct {
+  UID        uint32
+  GID        uint32
+  PID        int32
+  Executable string
+}
+
+func (c caller) String() string {
+  return fmt.Sprintf("uid=%d pid=%d exe=%s", c.UID, c.PID, c.Executable)
+}
+
+// keyPolicy decides which callers may use a key; an empty
+// allow list of uids only allows the user running the agent
+type keyPolicy struct {
+  AllowUIDs        []uint32 `json:"allow_uids,omitempty"`
+  AllowExecutables []string `json:"allow_executables,omitempty"`
+  Confirm          bool     `json:"confirm,omitempty"`
+}
+
+// policy holds a keyPolicy per key ID and a default for the rest
+type policy struct {
+  Default keyPolicy            `json:"default"`
+  Keys    map[string]keyPolicy `json:"keys,omitempty"`
+}
+
+// loadPolicy reads the policy file; a missing file gives the
+// default policy of allowing the agent's own user without confirmation
+func loadPolicy(path string) (*policy, error) {
+  p := &policy{}
+  content, err := ioutil.ReadFile(path)
+  if os.IsNotExist(err) {
+    return p, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  if err := json.Unmarshal(content, p); err != nil {
+    return nil, fmt.Errorf("failed to parse agent policy %s: %v", path, err)
+  }
+  return p, nil
+}
+
+func (p *policy) forKey(keyID string) keyPolicy {
+  if keyPolicy, ok := p.Keys[keyID]; ok {
+    return keyPolicy
+  }
+  return p.Default
+}
+
+// allows checks the caller against the allow lists of the key
+func (k keyPolicy) allows(c caller, agentUID uint32) bool {
+  uids := k.AllowUIDs
+  if len(uids) == 0 {
+    uids = []uint32{agentUID}
+  }
+  if !containsUID(uids, c.UID) {
+    return false
+  }
+  
+  if len(k.AllowExecutables) == 0 {
+    return true
+  }
+  for _, exe := range k.AllowExecutables {
+    if exe == c.Executable {
+      return true
+    }
+  }
+  return false
+}
+
+func containsUID(uids []uint32, uid uint32) bool {
+  for _, u := range uids {
+    if u == uid {
+      return true
+    }
+  }
+  return false
+}
+
+// confirm runs the confirmation command, in the style of ssh-askpass,
+// and treats a zero exit status as the user approving the request
+func confirm(command, keyID string, c caller) bool {
+  if command == "" {
+    return false
+  }
+  prompt := fmt.Sprintf("Allow %s to sign with key %s?", c, keyID)
+  cmd := exec.Command(command, prompt)
+  cmd.Env = append(os.Environ(), "SSH_ASKPASS_PROMPT=confirm")
+  return cmd.Run() == nil
+}
@@ -0,0 +1,51 @@
+package atomicfile
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+)
+
+// Write replaces the file with the content. The content is written and
+// synced to a temporary file in the same directory, which is renamed over
+// the file, and the directory is synced so the rename is on disk as well.
+// Readers see the old file or the new one, and so does the file system
+// after a crash, never a partial file
+func Write(path string, content []byte, perm os.FileMode) error {
+  dir := filepath.Dir(path)
+  tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path))
+  if err != nil {
+    return err
+  }
+  defer os.Remove(tmp.Name())
+  
+  if _, err := tmp.Write(content); err != nil {
+    tmp.Close()
+    return err
+  }
+  if err := tmp.Chmod(perm); err != nil {
+    tmp.Close()
+    return err
+  }
+  if err := tmp.Sync(); err != nil {
+    tmp.Close()
+    return err
+  }
+  if err := tmp.Close(); err != nil {
+    return err
+  }
+  if err := os.Rename(tmp.Name(), path); err != nil {
+    return err
+  }
+  return syncDir(dir)
+}
+
+// syncDir syncs the directory's entries, like a file renamed into it
+func syncDir(dir string) error {
+  d, err := os.Open(dir)
+  if err != nil {
+    return err
+  }
+  defer d.Close()
+  return d.Sync()
+}
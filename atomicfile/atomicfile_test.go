@@ -0,0 +1,31 @@
+package atomicfile
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func TestWrite(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "atomicfile")
+  defer os.RemoveAll(dir)
+  path := filepath.Join(dir, "state.json")
+  
+  for _, content := range []string{"first", "second"} {
+    if err := Write(path, []byte(content), 0600); err != nil {
+      t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+    if actual, _ := ioutil.ReadFile(path); string(actual) != content {
+      t.Errorf("Got: %s, wanted: %s\n", actual, content)
+    }
+  }
+  if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+    t.Errorf("Got: %v, wanted: %v\n", info.Mode().Perm(), os.FileMode(0600))
+  }
+  
+  // no temporary files are left behind
+  if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+    t.Errorf("Got: %d, wanted: %d\n", len(files), 1)
+  }
+}
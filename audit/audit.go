@@ -0,0 +1,193 @@
+package audit
+
+import (
+  "bufio"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io"
+  "os"
+  "sync"
+  "time"
+)
+
+// outcomes of a number
+const (
+  Accepted = "accepted"
+  Rejected = "rejected"
+  Failed   = "failed"
+)
+
+// Entry records one number the server handled. Every entry commits
+// to the one before it through Prev, so changing, removing or
+// reordering entries breaks the chain from that entry on
+type Entry struct {
+  Index uint64 `json:"index"`
+  // Time is when the entry was written, in Unix nanoseconds
+  Time      int64  `json:"time"`
+  Session   string `json:"session"`
+  Identity  string `json:"identity,omitempty"`
+  Stream    uint64 `json:"stream"`
+  Sequence  uint64 `json:"sequence"`
+  Number    int64  `json:"number"`
+  Signature []byte `json:"signature"`
+  KeyID     string `json:"key_id,omitempty"`
+  // VerifiedBy is the ID of the key the server verified the
+  // signature with, empty when it didn't verify it
+  VerifiedBy string `json:"verified_by,omitempty"`
+  Outcome    string `json:"outcome"`
+  Stage      string `json:"stage,omitempty"`
+  Code       string `json:"code,omitempty"`
+  Reason     string `json:"reason,omitempty"`
+  // Max is the session's maximum after the number
+  Max  int64  `json:"max"`
+  Prev string `json:"prev"`
+  Hash string `json:"hash,omitempty"`
+}
+
+// digest hashes every field of the entry but the hash itself
+func (e Entry) digest() (string, error) {
+  e.Hash = ""
+  content, err := json.Marshal(e)
+  if err != nil {
+    return "", err
+  }
+  sum := sha256.Sum256(content)
+  return hex.EncodeToString(sum[:]), nil
+}
+
+// Log is an append-only file of entries, one JSON object per line
+type Log struct {
+  mu   sync.Mutex
+  file *os.File
+  size int64
+  last Entry
+}
+
+// Open checks the chain of the log at the given path and appends
+// to it; a missing file starts a new chain
+func Open(path string) (*Log, error) {
+  file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+  if err != nil {
+    return nil, err
+  }
+  last, size, partial, err := readLog(file, nil)
+  if err != nil {
+    file.Close()
+    return nil, fmt.Errorf("failed to read audit log %s: %v", path, err)
+  }
+  if partial {
+    // a partial last line is an append that never finished,
+    // and was never acknowledged
+    if err := file.Truncate(size); err != nil {
+      file.Close()
+      return nil, err
+    }
+    if err := file.Sync(); err != nil {
+      file.Close()
+      return nil, err
+    }
+  }
+  return &Log{file: file, size: size, last: last}, nil
+}
+
+// Append chains the entry to the last one, writes it and syncs it to
+// disk, so an entry that was handed out survives a crash. A failed write
+// is cut off again so the next entry doesn't follow a partial line
+func (l *Log) Append(entry Entry) (Entry, error) {
+  l.mu.Lock()
+  defer l.mu.Unlock()
+  entry.Index = l.last.Index + 1
+  entry.Prev = l.last.Hash
+  if entry.Time == 0 {
+    entry.Time = time.Now().UnixNano()
+  }
+  hash, err := entry.digest()
+  if err != nil {
+    return Entry{}, err
+  }
+  entry.Hash = hash
+  line, err := json.Marshal(entry)
+  if err != nil {
+    return Entry{}, err
+  }
+  
+  written, err := l.file.Write(append(line, '\n'))
+  if err == nil {
+    err = l.file.Sync()
+  }
+  if err != nil {
+    if written > 0 {
+      l.file.Truncate(l.size)
+    }
+    return Entry{}, err
+  }
+  l.size += int64(written)
+  l.last = entry
+  return entry, nil
+}
+
+// Close flushes the log to disk and closes it
+func (l *Log) Close() error {
+  l.mu.Lock()
+  defer l.mu.Unlock()
+  if err := l.file.Sync(); err != nil {
+    l.file.Close()
+    return err
+  }
+  return l.file.Close()
+}
+
+// Read checks every entry of the log hashes to its recorded hash and
+// follows the one before it, handing each to handle if it isn't nil.
+// It returns the last entry, which is empty for an empty log
+func Read(r io.Reader, handle func(Entry) error) (Entry, error) {
+  last, _, partial, err := readLog(r, handle)
+  if err == nil && partial {
+    err = fmt.Errorf("entry %d is not terminated", last.Index+1)
+  }
+  return last, err
+}
+
+// readLog reads the log like Read, and also returns the size of its
+// complete entries and whether a partial line follows them
+func readLog(r io.Reader, handle func(Entry) error) (Entry, int64, bool, error) {
+  reader := bufio.NewReader(r)
+  var last Entry
+  var size int64
+  for {
+    line, err := reader.ReadBytes('\n')
+    if err == io.EOF {
+      return last, size, len(line) > 0, nil
+    }
+    if err != nil {
+      return last, size, false, err
+    }
+    
+    var entry Entry
+    if err := json.Unmarshal(line, &entry); err != nil {
+      return last, size, false, fmt.Errorf("failed to parse entry %d: %v", last.Index+1, err)
+    }
+    if entry.Index != last.Index+1 {
+      return last, size, false, fmt.Errorf("entry %d follows entry %d", entry.Index, last.Index)
+    }
+    if entry.Prev != last.Hash {
+      return last, size, false, fmt.Errorf("entry %d does not follow the hash of entry %d", entry.Index, last.Index)
+    }
+    hash, err := entry.digest()
+    if err != nil {
+      return last, size, false, err
+    }
+    if hash != entry.Hash {
+      return last, size, false, fmt.Errorf("entry %d does not match its hash", entry.Index)
+    }
+    if handle != nil {
+      if err := handle(entry); err != nil {
+        return last, size, false, fmt.Errorf("entry %d: %v", entry.Index, err)
+      }
+    }
+    size += int64(len(line))
+    last = entry
+  }
+}
@@ -0,0 +1,100 @@
+package audit
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestLog_AppendOpen(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "audit")
+  defer os.RemoveAll(dir)
+  path := filepath.Join(dir, "audit.jsonl")
+  
+  log, err := Open(path)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  first, _ := log.Append(Entry{Session: "rebels", Number: 7, Outcome: Accepted, Max: 7})
+  second, _ := log.Append(Entry{Session: "rebels", Number: 150, Outcome: Rejected, Stage: "range", Max: 7})
+  if err := log.Close(); err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  if first.Index != 1 || first.Prev != "" || second.Index != 2 || second.Prev != first.Hash {
+    t.Errorf("Got: %+v %+v, wanted: entry 2 following entry 1\n", first, second)
+  }
+  
+  // a reopened log carries on the chain
+  log, err = Open(path)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  third, _ := log.Append(Entry{Session: "rebels", Number: 9, Outcome: Accepted, Max: 9})
+  log.Close()
+  if third.Index != 3 || third.Prev != second.Hash {
+    t.Errorf("Got: %+v, wanted: index %d following %s\n", third, 3, second.Hash)
+  }
+  file, _ := os.Open(path)
+  defer file.Close()
+  var numbers []int64
+  last, err := Read(file, func(entry Entry) error {
+    numbers = append(numbers, entry.Number)
+    return nil
+  })
+  if err != nil || last.Hash != third.Hash || len(numbers) != 3 {
+    t.Errorf("Got: %v %v, wanted: %d entries\n", numbers, err, 3)
+  }
+}
+
+func TestRead_Tampered(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "audit")
+  defer os.RemoveAll(dir)
+  path := filepath.Join(dir, "audit.jsonl")
+  auditLog, _ := Open(path)
+  for _, number := range []int64{3, 12, 8} {
+    auditLog.Append(Entry{Session: "rebels", Number: number, Outcome: Accepted})
+  }
+  auditLog.Close()
+  content, _ := ioutil.ReadFile(path)
+  log := string(content)
+  lines := strings.SplitAfter(log, "\n")
+  
+  tests := []struct {
+    name    string
+    content string
+    wanted  string
+  }{
+    {"untouched", log, ""},
+    {"changed number", strings.Replace(log, `"number":12`, `"number":99`, 1), "entry 2 does not match its hash"},
+    {"removed entry", lines[0] + lines[2], "entry 3 follows entry 1"},
+    {"reordered entries", lines[1] + lines[0], "entry 2 follows entry 0"},
+    {"partial entry", log + `{"index":4`, "entry 4 is not terminated"},
+  }
+  for _, test := range tests {
+    _, err := Read(strings.NewReader(test.content), nil)
+    if (err == nil && test.wanted != "") || (err != nil && !strings.Contains(err.Error(), test.wanted)) {
+      t.Errorf("%s: Got: %v, wanted: %v\n", test.name, err, test.wanted)
+    }
+  }
+  
+  // a partial last entry, an append that never finished, is cut off
+  ioutil.WriteFile(path, []byte(log+`{"index":4`), 0600)
+  auditLog, err := Open(path)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  fourth, _ := auditLog.Append(Entry{Session: "rebels", Number: 20, Outcome: Accepted})
+  auditLog.Close()
+  content, _ = ioutil.ReadFile(path)
+  if last, err := Read(strings.NewReader(string(content)), nil); err != nil || last.Index != 4 || last.Hash != fourth.Hash {
+    t.Errorf("Got: %+v %v, wanted: entry %d\n", last, err, 4)
+  }
+  
+  // the server refuses to append to a broken chain
+  ioutil.WriteFile(path, []byte(lines[0]+lines[2]), 0600)
+  if _, err := Open(path); err == nil {
+    t.Errorf("Got: %v, wanted: an error\n", err)
+  }
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+  "fmt"
+  "strings"
+  
+  "golang.org/x/net/context"
+  "google.golang.org/grpc/metadata"
+)
+
+// Authenticator checks a bearer token and returns the subject it was issued to
+type Authenticator interface {
+  Authenticate(token string) (string, error)
+}
+
+// Authenticators tries each authenticator in turn and
+// accepts the token if any of them does
+type Authenticators []Authenticator
+
+func (a Authenticators) Authenticate(token string) (string, error) {
+  err := fmt.Errorf("no authenticators")
+  for _, authenticator := range a {
+    var subject string
+    if subject, err = authenticator.Authenticate(token); err == nil {
+      return subject, nil
+    }
+  }
+  return "", err
+}
+
+type subjectKey struct{}
+
+// NewContext returns a context carrying the authenticated subject
+func NewContext(ctx context.Context, subject string) context.Context {
+  return context.WithValue(ctx, subjectKey{}, subject)
+}
+
+// SubjectFromContext returns the authenticated subject of the caller, if any
+func SubjectFromContext(ctx context.Context) (string, bool) {
+  subject, ok := ctx.Value(subjectKey{}).(string)
+  return subject, ok
+}
+
+// BearerTokenFromContext reads the token from the
+// "authorization: Bearer <token>" metadata of an incoming RPC
+func BearerTokenFromContext(ctx context.Context) (string, error) {
+  md, _ := metadata.FromIncomingContext(ctx)
+  values := md.Get("authorization")
+  if len(values) == 0 {
+    return "", fmt.Errorf("no authorization metadata")
+  }
+  
+  const prefix = "bearer "
+  if len(values[0]) <= len(prefix) || strings.ToLower(values[0][:len(prefix)]) != prefix {
+    return "", fmt.Errorf("authorization metadata is not a bearer token")
+  }
+  return values[0][len(prefix):], nil
+}
+
+// BearerToken is per-RPC credentials that send the token in the
+// authorization metadata. Tokens are only ever sent over TLS
+type BearerToken string
+
+func (b BearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+  return map[string]string{"authorization": "Bearer " + string(b)}, nil
+}
+
+func (b BearerToken) RequireTransportSecurity() bool {
+  return true
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+  "crypto/hmac"
+  "crypto/sha256"
+  "crypto/sha512"
+  "encoding/base64"
+  "encoding/json"
+  "fmt"
+  "hash"
+  "strings"
+  "time"
+)
+
+// hmacAlgorithms are the JWT algorithms the server accepts;
+// "none" and public key algorithms are never accepted
+var hmacAlgorithms = map[string]func() hash.Hash{
+  "HS256": sha256.New,
+  "HS384": sha512.New384,
+  "HS512": sha512.New,
+}
+
+// Claims are the registered JWT claims the server checks
+type Claims struct {
+  Subject   string   `json:"sub"`
+  Issuer    string   `json:"iss,omitempty"`
+  Audience  Audience `json:"aud,omitempty"`
+  ExpiresAt int64    `json:"exp,omitempty"`
+  NotBefore int64    `json:"nbf,omitempty"`
+  IssuedAt  int64    `json:"iat,omitempty"`
+}
+
+// Audience is the aud claim, which is either a string or an array of strings
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+  if len(a) == 1 {
+    return json.Marshal(a[0])
+  }
+  return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+  var single string
+  if err := json.Unmarshal(data, &single); err == nil {
+    *a = Audience{single}
+    return nil
+  }
+  var many []string
+  if err := json.Unmarshal(data, &many); err != nil {
+    return fmt.Errorf("aud must be a string or an array of strings")
+  }
+  *a = many
+  return nil
+}
+
+func (a Audience) contains(audience string) bool {
+  for _, value := range a {
+    if value == audience {
+      return true
+    }
+  }
+  return false
+}
+
+type jwtHeader struct {
+  Algorithm string `json:"alg"`
+  Type      string `json:"typ,omitempty"`
+}
+
+func encodeSegment(value interface{}) (string, error) {
+  content, err := json.Marshal(value)
+  if err != nil {
+    return "", err
+  }
+  return base64.RawURLEncoding.EncodeToString(content), nil
+}
+
+func decodeSegment(segment string, value interface{}) error {
+  content, err := base64.RawURLEncoding.DecodeString(segment)
+  if err != nil {
+    return err
+  }
+  return json.Unmarshal(content, value)
+}
+
+func mac(algorithm string, secret []byte, signingInput string) ([]byte, error) {
+  newHash, ok := hmacAlgorithms[algorithm]
+  if !ok {
+    return nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+  }
+  h := hmac.New(newHash, secret)
+  h.Write([]byte(signingInput))
+  return h.Sum(nil), nil
+}
+
+// SignJWT issues a JWT with the claims, signed with the secret
+// using one of HS256, HS384 or HS512
+func SignJWT(algorithm string, secret []byte, claims Claims) (string, error) {
+  header, err := encodeSegment(jwtHeader{Algorithm: algorithm, Type: "JWT"})
+  if err != nil {
+    return "", err
+  }
+  payload, err := encodeSegment(claims)
+  if err != nil {
+    return "", err
+  }
+  
+  signingInput := header + "." + payload
+  signature, err := mac(algorithm, secret, signingInput)
+  if err != nil {
+    return "", err
+  }
+  return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// JWTVerifier authenticates HMAC signed JWTs. Issuer and Audience
+// are only checked when set; Leeway allows for clock skew
+type JWTVerifier struct {
+  Secret   []byte
+  Issuer   string
+  Audience string
+  Leeway   time.Duration
+  Now      func() time.Time
+}
+
+// Authenticate checks the token's signature and claims
+// and returns its subject
+func (v *JWTVerifier) Authenticate(token string) (string, error) {
+  parts := strings.Split(token, ".")
+  if len(parts) != 3 {
+    return "", fmt.Errorf("token is not a JWT")
+  }
+  
+  var header jwtHeader
+  if err := decodeSegment(parts[0], &header); err != nil {
+    return "", fmt.Errorf("failed to parse JWT header: %v", err)
+  }
+  expected, err := mac(header.Algorithm, v.Secret, parts[0]+"."+parts[1])
+  if err != nil {
+    return "", err
+  }
+  signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+  if err != nil || !hmac.Equal(signature, expected) {
+    return "", fmt.Errorf("JWT signature is not valid")
+  }
+  
+  var claims Claims
+  if err := decodeSegment(parts[1], &claims); err != nil {
+    return "", fmt.Errorf("failed to parse JWT claims: %v", err)
+  }
+  return claims.Subject, v.check(claims)
+}
+
+func (v *JWTVerifier) check(claims Claims) error {
+  now := time.Now()
+  if v.Now != nil {
+    now = v.Now()
+  }
+  
+  if claims.ExpiresAt != 0 && now.Add(-v.Leeway).Unix() >= claims.ExpiresAt {
+    return fmt.Errorf("JWT expired at %s", time.Unix(claims.ExpiresAt, 0).UTC())
+  }
+  if claims.NotBefore != 0 && now.Add(v.Leeway).Unix() < claims.NotBefore {
+    return fmt.Errorf("JWT is not valid before %s", time.Unix(claims.NotBefore, 0).UTC())
+  }
+  if v.Issuer != "" && claims.Issuer != v.Issuer {
+    return fmt.Errorf("JWT issuer %q is not %q", claims.Issuer, v.Issuer)
+  }
+  if v.Audience != "" && !claims.Audience.contains(v.Audience) {
+    return fmt.Errorf("JWT audience %v does not include %q", []string(claims.Audience), v.Audience)
+  }
+  if claims.Subject == "" {
+    return fmt.Errorf("JWT has no subject")
+  }
+  return nil
+}
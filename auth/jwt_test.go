@@ -0,0 +1,84 @@
+package auth
+
+import (
+  "encoding/base64"
+  "strings"
+  "testing"
+  "time"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestJWT_RoundTrip(t *testing.T) {
+  for _, algorithm := range []string{"HS256", "HS384", "HS512"} {
+    token, err := SignJWT(algorithm, testSecret, Claims{Subject: "luke"})
+    if err != nil {
+      t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+    verifier := &JWTVerifier{Secret: testSecret}
+    subject, err := verifier.Authenticate(token)
+    if err != nil || subject != "luke" {
+      t.Errorf("Got: %s %v, wanted: %s %v\n", subject, err, "luke", nil)
+    }
+  }
+}
+
+func TestJWT_Rejected(t *testing.T) {
+  now := time.Unix(1000000, 0)
+  verifier := &JWTVerifier{
+    Secret:   testSecret,
+    Issuer:   "maxnumber",
+    Audience: "server",
+    Leeway:   time.Minute,
+    Now:      func() time.Time { return now },
+  }
+  valid := Claims{Subject: "luke", Issuer: "maxnumber", Audience: Audience{"server"}}
+  sign := func(secret []byte, update func(*Claims)) string {
+    claims := valid
+    update(&claims)
+    token, _ := SignJWT("HS256", secret, claims)
+    return token
+  }
+  
+  tests := map[string]string{
+    "valid":          sign(testSecret, func(c *Claims) {}),
+    "wrong secret":   sign([]byte("another secret of at least 32 bytes"), func(c *Claims) {}),
+    "expired":        sign(testSecret, func(c *Claims) { c.ExpiresAt = now.Add(-2 * time.Minute).Unix() }),
+    "in leeway":      sign(testSecret, func(c *Claims) { c.ExpiresAt = now.Add(-30 * time.Second).Unix() }),
+    "not yet valid":  sign(testSecret, func(c *Claims) { c.NotBefore = now.Add(2 * time.Minute).Unix() }),
+    "wrong issuer":   sign(testSecret, func(c *Claims) { c.Issuer = "someone" }),
+    "wrong audience": sign(testSecret, func(c *Claims) { c.Audience = Audience{"client", "agent"} }),
+    "no subject":     sign(testSecret, func(c *Claims) { c.Subject = "" }),
+    // an unsigned token must never be accepted
+    "alg none":  "eyJhbGciOiJub25lIn0." + strings.Split(sign(testSecret, func(c *Claims) {}), ".")[1] + ".",
+    "not a jwt": "opaque",
+  }
+  accepted := map[string]bool{"valid": true, "in leeway": true}
+  
+  for name, token := range tests {
+    _, err := verifier.Authenticate(token)
+    if (err == nil) != accepted[name] {
+      t.Errorf("%s: Got: %v, wanted accepted: %v\n", name, err, accepted[name])
+    }
+  }
+}
+
+func TestAudience_ArrayOrString(t *testing.T) {
+  var claims Claims
+  if err := decodeSegment(segment(`{"sub":"luke","aud":["client","server"]}`), &claims); err != nil {
+    t.Fatal(err)
+  }
+  if !claims.Audience.contains("server") {
+    t.Errorf("Got: %v, wanted: %s\n", claims.Audience, "server")
+  }
+  if err := decodeSegment(segment(`{"sub":"luke","aud":"server"}`), &claims); err != nil {
+    t.Fatal(err)
+  }
+  if len(claims.Audience) != 1 || claims.Audience[0] != "server" {
+    t.Errorf("Got: %v, wanted: %v\n", claims.Audience, []string{"server"})
+  }
+}
+
+func segment(content string) string {
+  return base64.RawURLEncoding.EncodeToString([]byte(content))
+}
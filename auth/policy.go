@@ -0,0 +1,95 @@
+package auth
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "path"
+)
+
+// Rule allows the identities it lists, and callers with the roles it
+// lists, to call the methods on the sessions. Every field takes path
+// patterns such as "*" or "/simple.Simple/*"
+type Rule struct {
+  Identities []string `json:"identities,omitempty"`
+  Roles      []string `json:"roles,omitempty"`
+  Methods    []string `json:"methods"`
+  Sessions   []string `json:"sessions"`
+}
+
+// Policy decides which callers may call which RPC methods on which
+// sessions. Roles maps identities to their roles, "*" gives roles to
+// every caller. Anything no rule allows is denied
+type Policy struct {
+  Roles map[string][]string `json:"roles"`
+  Rules []Rule              `json:"rules"`
+}
+
+// LoadPolicy reads and checks the JSON policy at the given path
+func LoadPolicy(path string) (*Policy, error) {
+  content, err := ioutil.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+  
+  var policy Policy
+  if err := json.Unmarshal(content, &policy); err != nil {
+    return nil, fmt.Errorf("failed to parse policy %s: %v", path, err)
+  }
+  for i, rule := range policy.Rules {
+    if err := rule.check(); err != nil {
+      return nil, fmt.Errorf("rule %d of policy %s: %v", i, path, err)
+    }
+  }
+  return &policy, nil
+}
+
+func (r Rule) check() error {
+  if len(r.Identities) == 0 && len(r.Roles) == 0 {
+    return fmt.Errorf("rule applies to no identities or roles")
+  }
+  if len(r.Methods) == 0 || len(r.Sessions) == 0 {
+    return fmt.Errorf("rule needs methods and sessions, use \"*\" for all")
+  }
+  for _, patterns := range [][]string{r.Identities, r.Roles, r.Methods, r.Sessions} {
+    for _, pattern := range patterns {
+      if _, err := path.Match(pattern, ""); err != nil {
+        return fmt.Errorf("bad pattern %q: %v", pattern, err)
+      }
+    }
+  }
+  return nil
+}
+
+// matchAny reports whether any of the patterns matches one of the
+// values; "*" matches everything, even values with a "/"
+func matchAny(patterns []string, values ...string) bool {
+  for _, pattern := range patterns {
+    for _, value := range values {
+      if matched, _ := path.Match(pattern, value); matched || pattern == "*" {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+// RolesOf returns the roles of the identity, including the roles every caller has
+func (p *Policy) RolesOf(identity string) []string {
+  return append(append([]string{}, p.Roles["*"]...), p.Roles[identity]...)
+}
+
+// Authorize returns nil if a rule allows the identity to call the
+// method on the session, or the reason it may not
+func (p *Policy) Authorize(identity, method, session string) error {
+  roles := p.RolesOf(identity)
+  for _, rule := range p.Rules {
+    if !matchAny(rule.Identities, identity) && !matchAny(rule.Roles, roles...) {
+      continue
+    }
+    if matchAny(rule.Methods, method) && matchAny(rule.Sessions, session) {
+      return nil
+    }
+  }
+  return fmt.Errorf("%q with roles %v may not call %s on session %q", identity, roles, method, session)
+}
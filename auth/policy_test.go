@@ -0,0 +1,72 @@
+package auth
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+const testPolicy = `{
+  "roles": {"luke": ["admin"], "*": ["player"]},
+  "rules": [
+    {"roles": ["admin"], "methods": ["*"], "sessions": ["*"]},
+    {"roles": ["player"], "methods": ["/simple.Simple/FindMaxNumber"], "sessions": ["default"]},
+    {"identities": ["leia", "CN=han,*"], "methods": ["/simple.Simple/*"], "sessions": ["rebels-*"]}
+  ]
+}`
+
+func writePolicy(t *testing.T, content string) (string, func()) {
+  dir, _ := ioutil.TempDir("", "policy")
+  path := filepath.Join(dir, "policy.json")
+  if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+    t.Fatal(err)
+  }
+  return path, func() { os.RemoveAll(dir) }
+}
+
+func TestPolicy_Authorize(t *testing.T) {
+  path, remove := writePolicy(t, testPolicy)
+  defer remove()
+  policy, err := LoadPolicy(path)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  
+  find, reset := "/simple.Simple/FindMaxNumber", "/simple.Admin/ResetMax"
+  tests := []struct {
+    identity, method, session string
+    allowed                   bool
+  }{
+    {"luke", reset, "empire", true},
+    {"vader", find, "default", true},
+    {"", find, "default", true},
+    {"vader", find, "rebels-1", false},
+    {"vader", reset, "default", false},
+    {"leia", find, "rebels-1", true},
+    {"leia", reset, "rebels-1", false},
+    {"CN=han,O=rebels", find, "rebels-2", true},
+  }
+  for _, test := range tests {
+    err := policy.Authorize(test.identity, test.method, test.session)
+    if (err == nil) != test.allowed {
+      t.Errorf("Got: %v, wanted allowed: %v for %+v\n", err, test.allowed, test)
+    }
+  }
+}
+
+func TestLoadPolicy_BadRules(t *testing.T) {
+  for _, content := range []string{
+    `{"rules": [{"methods": ["*"], "sessions": ["*"]}]}`,
+    `{"rules": [{"roles": ["admin"], "sessions": ["*"]}]}`,
+    `{"rules": [{"roles": ["admin"], "methods": ["*"]}]}`,
+    `{"rules": [{"roles": ["[admin"], "methods": ["*"], "sessions": ["*"]}]}`,
+    `not json`,
+  } {
+    path, remove := writePolicy(t, content)
+    if _, err := LoadPolicy(path); err == nil {
+      t.Errorf("Got: %v, wanted: an error for %s\n", nil, content)
+    }
+    remove()
+  }
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+  "fmt"
+  "regexp"
+  
+  "golang.org/x/net/context"
+  "google.golang.org/grpc/metadata"
+)
+
+const (
+  // SessionMetadataKey names the session an RPC works on
+  SessionMetadataKey = "session"
+  // DefaultSession is the session of RPCs that don't name one
+  DefaultSession = "default"
+)
+
+var sessionName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// SessionFromContext returns the session named in the metadata of an
+// incoming RPC, or the default session when it names none
+func SessionFromContext(ctx context.Context) (string, error) {
+  md, _ := metadata.FromIncomingContext(ctx)
+  values := md.Get(SessionMetadataKey)
+  if len(values) == 0 {
+    return DefaultSession, nil
+  }
+  if !sessionName.MatchString(values[0]) {
+    return "", fmt.Errorf("session name %q is not valid", values[0])
+  }
+  return values[0], nil
+}
+
+// WithSession names the session of outgoing RPCs made with the context
+func WithSession(ctx context.Context, session string) context.Context {
+  return metadata.AppendToOutgoingContext(ctx, SessionMetadataKey, session)
+}
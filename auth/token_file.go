@@ -0,0 +1,115 @@
+package auth
+
+import (
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "sort"
+  
+  "github.com/salman-ahmad/grpc-streaming/atomicfile"
+)
+
+// Token is an opaque bearer token issued to a subject. Only the
+// sha256 digest of the token is stored, never the token itself
+type Token struct {
+  Subject string `json:"subject"`
+  SHA256  string `json:"sha256"`
+}
+
+// TokenFile is a JSON file of opaque tokens indexed by digest
+type TokenFile struct {
+  path   string
+  tokens map[string]Token
+}
+
+func digest(token string) string {
+  sum := sha256.Sum256([]byte(token))
+  return hex.EncodeToString(sum[:])
+}
+
+// LoadTokenFile reads the token file at the given path;
+// a missing file is treated as an empty token file
+func LoadTokenFile(path string) (*TokenFile, error) {
+  file := &TokenFile{path: path, tokens: make(map[string]Token)}
+  content, err := ioutil.ReadFile(path)
+  if os.IsNotExist(err) {
+    return file, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  
+  var tokens []Token
+  if err := json.Unmarshal(content, &tokens); err != nil {
+    return nil, fmt.Errorf("failed to parse token file %s: %v", path, err)
+  }
+  for _, token := range tokens {
+    file.tokens[token.SHA256] = token
+  }
+  return file, nil
+}
+
+// Add issues a new random token for the subject and returns it.
+// The token can't be recovered from the file later
+func (f *TokenFile) Add(subject string) (string, error) {
+  if subject == "" {
+    return "", fmt.Errorf("token has no subject")
+  }
+  random := make([]byte, 32)
+  if _, err := rand.Read(random); err != nil {
+    return "", err
+  }
+  
+  token := base64.RawURLEncoding.EncodeToString(random)
+  f.tokens[digest(token)] = Token{Subject: subject, SHA256: digest(token)}
+  return token, nil
+}
+
+// Remove revokes every token of the subject and returns how many there were
+func (f *TokenFile) Remove(subject string) int {
+  removed := 0
+  for sum, token := range f.tokens {
+    if token.Subject == subject {
+      delete(f.tokens, sum)
+      removed++
+    }
+  }
+  return removed
+}
+
+// Tokens returns the tokens sorted by subject
+func (f *TokenFile) Tokens() []Token {
+  tokens := make([]Token, 0, len(f.tokens))
+  for _, token := range f.tokens {
+    tokens = append(tokens, token)
+  }
+  sort.Slice(tokens, func(i, j int) bool {
+    if tokens[i].Subject != tokens[j].Subject {
+      return tokens[i].Subject < tokens[j].Subject
+    }
+    return tokens[i].SHA256 < tokens[j].SHA256
+  })
+  return tokens
+}
+
+// Authenticate returns the subject of a known token
+func (f *TokenFile) Authenticate(token string) (string, error) {
+  if known, ok := f.tokens[digest(token)]; ok {
+    return known.Subject, nil
+  }
+  return "", fmt.Errorf("token is not known")
+}
+
+// Save replaces the token file, readable only by its owner
+func (f *TokenFile) Save() error {
+  content, err := json.MarshalIndent(f.Tokens(), "", "  ")
+  if err != nil {
+    return err
+  }
+  return atomicfile.Write(f.path, content, 0600)
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestTokenFile_AddSaveLoad(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "tokens")
+  defer os.RemoveAll(dir)
+  path := filepath.Join(dir, "tokens.json")
+  
+  tokens, err := LoadTokenFile(path)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  token, _ := tokens.Add("luke")
+  tokens.Add("leia")
+  if err := tokens.Save(); err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  
+  // only digests are written to the file, and only the owner may read it
+  content, _ := ioutil.ReadFile(path)
+  if strings.Contains(string(content), token) {
+    t.Errorf("Got: token in %s, wanted: only its digest\n", content)
+  }
+  info, _ := os.Stat(path)
+  if info.Mode().Perm() != 0600 {
+    t.Errorf("Got: %v, wanted: %v\n", info.Mode().Perm(), os.FileMode(0600))
+  }
+  
+  loaded, _ := LoadTokenFile(path)
+  if subject, err := loaded.Authenticate(token); err != nil || subject != "luke" {
+    t.Errorf("Got: %s %v, wanted: %s %v\n", subject, err, "luke", nil)
+  }
+  if _, err := loaded.Authenticate("guess"); err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "token is not known")
+  }
+  
+  if removed := loaded.Remove("luke"); removed != 1 {
+    t.Errorf("Got: %d, wanted: %d\n", removed, 1)
+  }
+  if _, err := loaded.Authenticate(token); err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "token is not known")
+  }
+}
+
+func TestAuthenticators_FirstMatch(t *testing.T) {
+  tokens, _ := LoadTokenFile(filepath.Join(os.TempDir(), "missing_tokens.json"))
+  opaque, _ := tokens.Add("leia")
+  jwt, _ := SignJWT("HS256", testSecret, Claims{Subject: "luke"})
+  authenticators := Authenticators{&JWTVerifier{Secret: testSecret}, tokens}
+  
+  for token, expected := range map[string]string{jwt: "luke", opaque: "leia"} {
+    if subject, err := authenticators.Authenticate(token); err != nil || subject != expected {
+      t.Errorf("Got: %s %v, wanted: %s %v\n", subject, err, expected, nil)
+    }
+  }
+  if _, err := authenticators.Authenticate("guess"); err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "token is not known")
+  }
+}
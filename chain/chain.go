@@ -0,0 +1,242 @@
+package chain
+
+import (
+  "fmt"
+  "math"
+  "sort"
+  "sync"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc/codes"
+)
+
+// Stream is the state shared by the requests of one stream.
+// Stages that run concurrently must not modify it
+type Stream struct {
+  ID   uint64
+  Peer string
+  // Identity is who the client authenticated as, if it did
+  Identity string
+  // Session is the session the client named, or the default one
+  Session string
+  // Max is the stream's maximum. A stream starts at 0, while
+  // the stream of a SubmitNumber starts at the session's
+  // maximum, or at NoMax when the session has none
+  Max    int64
+  values map[string]interface{}
+}
+
+// NoMax is the maximum of a SubmitNumber on a
+// session no number was accepted for
+const NoMax = math.MinInt64
+
+func NewStream(id uint64, peer string) *Stream {
+  return &Stream{ID: id, Peer: peer, values: make(map[string]interface{})}
+}
+
+// Value returns state a stage stored for this stream
+func (s *Stream) Value(key string) interface{} {
+  return s.values[key]
+}
+
+func (s *Stream) SetValue(key string, value interface{}) {
+  s.values[key] = value
+}
+
+// Request is a signed number moving through the chain.
+// Stages may change it before passing it on
+type Request struct {
+  Stream      *Stream
+  Sequence    uint64
+  Number      int64
+  Signature   []byte
+  KeyID       string
+  Annotations map[string]string
+  // Traceparent is the W3C trace context of the number
+  Traceparent string
+  
+  // Updated is set when the request raised the stream's maximum
+  Updated bool
+}
+
+// Annotate attaches a note for later stages and the logs
+func (r *Request) Annotate(key, value string) {
+  if r.Annotations == nil {
+    r.Annotations = make(map[string]string)
+  }
+  r.Annotations[key] = value
+}
+
+// Rejection drops a request without ending the stream;
+// any other error returned by a stage ends the stream
+type Rejection struct {
+  Stage  string
+  Code   codes.Code
+  Reason string
+}
+
+func Reject(stage string, code codes.Code, format string, args ...interface{}) *Rejection {
+  return &Rejection{Stage: stage, Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (r *Rejection) Error() string {
+  return fmt.Sprintf("%s rejected request: %s", r.Stage, r.Reason)
+}
+
+// IsRejection reports whether the error is a Rejection
+func IsRejection(err error) (*Rejection, bool) {
+  rejection, ok := err.(*Rejection)
+  return rejection, ok
+}
+
+// Next passes the request to the rest of the chain
+type Next func(ctx context.Context, request *Request) error
+
+// Stage is a link in the chain. It accepts a request by calling next,
+// rejects it by returning a Rejection, and may transform or annotate
+// the request before passing it on
+type Stage interface {
+  Name() string
+  Handle(ctx context.Context, request *Request, next Next) error
+}
+
+// Concurrent is implemented by stages that only read the request,
+// so requests can go through them in parallel and out of order
+type Concurrent interface {
+  Concurrent() bool
+}
+
+// VerifiedKeys is implemented by stages that tell clients apart by the
+// key ID the verify stage checked. Ahead of it the key ID is only what
+// the client claims, so such stages must follow the verify stage
+type VerifiedKeys interface {
+  VerifiedKeys() bool
+}
+
+func isConcurrent(stage Stage) bool {
+  c, ok := stage.(Concurrent)
+  return ok && c.Concurrent()
+}
+
+// Env is what the server offers stages when building them
+type Env struct {
+  Config *config.Config
+  // Keys finds the public keys that may verify a request signed
+  // with the given key ID, or by the given identity when the
+  // request has no key ID
+  Keys func(keyID, identity string) ([]crypto.PublicKey, error)
+  // Owner returns the identity a trusted key belongs to, or
+  // "" for keys that belong to no one
+  Owner func(keyID string) string
+  // OnVerify, if set, is told how long each signature check took
+  // and whether the signature was valid
+  OnVerify func(elapsed time.Duration, verified bool)
+}
+
+// Factory builds a stage for a deployment
+type Factory func(env Env) (Stage, error)
+
+var (
+  registryMu sync.RWMutex
+  registry   = make(map[string]Factory)
+)
+
+// Register makes a stage available to chains by name. Custom stages
+// register from an init function of a package the server imports
+func Register(name string, factory Factory) {
+  registryMu.Lock()
+  defer registryMu.Unlock()
+  if _, ok := registry[name]; ok {
+    panic("chain: stage registered twice: " + name)
+  }
+  registry[name] = factory
+}
+
+// Registered lists the names of all registered stages
+func Registered() []string {
+  registryMu.RLock()
+  defer registryMu.RUnlock()
+  names := make([]string, 0, len(registry))
+  for name := range registry {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return names
+}
+
+// Chain runs requests through its stages in order
+type Chain struct {
+  stages []Stage
+}
+
+// New builds the stages with the given names, in that order
+func New(names []string, env Env) (*Chain, error) {
+  registryMu.RLock()
+  defer registryMu.RUnlock()
+  
+  stages := make([]Stage, 0, len(names))
+  for _, name := range names {
+    factory, ok := registry[name]
+    if !ok {
+      return nil, fmt.Errorf("unknown stage %q, registered stages are %v", name, Registered())
+    }
+    stage, err := factory(env)
+    if err != nil {
+      return nil, fmt.Errorf("failed to build stage %s: %v", name, err)
+    }
+    stages = append(stages, stage)
+  }
+  verified := false
+  for _, stage := range stages {
+    if stage.Name() == "verify" {
+      verified = true
+    }
+    if v, ok := stage.(VerifiedKeys); ok && v.VerifiedKeys() && !verified {
+      return nil, fmt.Errorf("stage %s uses verified key IDs, so it must follow the verify stage", stage.Name())
+    }
+  }
+  return Of(stages...), nil
+}
+
+// Of chains the given stages
+func Of(stages ...Stage) *Chain {
+  return &Chain{stages: stages}
+}
+
+func (c *Chain) Names() []string {
+  names := make([]string, len(c.stages))
+  for i, stage := range c.stages {
+    names[i] = stage.Name()
+  }
+  return names
+}
+
+// Handle runs the request through every stage until
+// one of them stops passing it on
+func (c *Chain) Handle(ctx context.Context, request *Request) error {
+  return c.next(0)(ctx, request)
+}
+
+func (c *Chain) next(i int) Next {
+  if i == len(c.stages) {
+    return func(ctx context.Context, request *Request) error {
+      return nil
+    }
+  }
+  return func(ctx context.Context, request *Request) error {
+    return c.stages[i].Handle(ctx, request, c.next(i+1))
+  }
+}
+
+// Split separates the leading concurrent stages, which may run on a
+// worker pool, from the rest, which must see requests in order
+func (c *Chain) Split() (concurrent *Chain, sequential *Chain) {
+  i := 0
+  for i < len(c.stages) && isConcurrent(c.stages[i]) {
+    i++
+  }
+  return Of(c.stages[:i]...), Of(c.stages[i:]...)
+}
@@ -0,0 +1,147 @@
+package chain
+
+import (
+  "errors"
+  "reflect"
+  "testing"
+  
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc/codes"
+)
+
+// recordStage appends its name to the request's annotations
+type recordStage struct {
+  name       string
+  concurrent bool
+}
+
+func (r recordStage) Name() string     { return r.name }
+func (r recordStage) Concurrent() bool { return r.concurrent }
+
+func (r recordStage) Handle(ctx context.Context, request *Request, next Next) error {
+  request.Annotate("path", request.Annotations["path"]+r.name)
+  return next(ctx, request)
+}
+
+// funcStage adapts a function to a stage
+type funcStage func(ctx context.Context, request *Request, next Next) error
+
+func (f funcStage) Name() string { return "func" }
+
+func (f funcStage) Handle(ctx context.Context, request *Request, next Next) error {
+  return f(ctx, request, next)
+}
+
+func newRequest(number int64) *Request {
+  return &Request{Stream: NewStream(1, "test"), Number: number}
+}
+
+func TestChain_RunsStagesInOrder(t *testing.T) {
+  c := Of(recordStage{name: "a"}, recordStage{name: "b"}, recordStage{name: "c"})
+  request := newRequest(1)
+  if err := c.Handle(context.Background(), request); err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+  expected := "abc"
+  if request.Annotations["path"] != expected {
+    t.Errorf("Got: %s, wanted: %s\n", request.Annotations["path"], expected)
+  }
+}
+
+func TestChain_RejectStopsChain(t *testing.T) {
+  reject := funcStage(func(ctx context.Context, request *Request, next Next) error {
+    return Reject("func", codes.InvalidArgument, "no")
+  })
+  c := Of(recordStage{name: "a"}, reject, recordStage{name: "b"})
+  request := newRequest(1)
+  
+  err := c.Handle(context.Background(), request)
+  if _, ok := IsRejection(err); !ok {
+    t.Errorf("Got: %v, wanted: %s\n", err, "rejection")
+  }
+  if request.Annotations["path"] != "a" {
+    t.Errorf("Got: %s, wanted: %s\n", request.Annotations["path"], "a")
+  }
+}
+
+func TestChain_TransformRequest(t *testing.T) {
+  double := funcStage(func(ctx context.Context, request *Request, next Next) error {
+    request.Number *= 2
+    return next(ctx, request)
+  })
+  c := Of(double, maxStage{})
+  request := newRequest(21)
+  c.Handle(context.Background(), request)
+  if request.Stream.Max != 42 {
+    t.Errorf("Got: %d, wanted: %d\n", request.Stream.Max, 42)
+  }
+}
+
+func TestChain_ErrorIsNotRejection(t *testing.T) {
+  fail := funcStage(func(ctx context.Context, request *Request, next Next) error {
+    return errors.New("broken")
+  })
+  err := Of(fail).Handle(context.Background(), newRequest(1))
+  if _, ok := IsRejection(err); ok || err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", err, "plain error")
+  }
+}
+
+func TestChain_Split(t *testing.T) {
+  c := Of(
+    recordStage{name: "a", concurrent: true},
+    recordStage{name: "b", concurrent: true},
+    recordStage{name: "c"},
+    recordStage{name: "d", concurrent: true},
+  )
+  concurrent, sequential := c.Split()
+  if !reflect.DeepEqual(concurrent.Names(), []string{"a", "b"}) {
+    t.Errorf("Got: %v, wanted: %v\n", concurrent.Names(), []string{"a", "b"})
+  }
+  if !reflect.DeepEqual(sequential.Names(), []string{"c", "d"}) {
+    t.Errorf("Got: %v, wanted: %v\n", sequential.Names(), []string{"c", "d"})
+  }
+}
+
+func TestNew_CustomStage(t *testing.T) {
+  Register("test-custom", func(env Env) (Stage, error) {
+    return recordStage{name: "custom"}, nil
+  })
+  c, err := New([]string{"test-custom", "max"}, Env{})
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  expected := []string{"custom", "max"}
+  if !reflect.DeepEqual(c.Names(), expected) {
+    t.Errorf("Got: %v, wanted: %v\n", c.Names(), expected)
+  }
+}
+
+func TestNew_UnknownStage(t *testing.T) {
+  _, err := New([]string{"does-not-exist"}, Env{})
+  if err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "error")
+  }
+}
+
+func TestNew_RateLimitBeforeVerify(t *testing.T) {
+  env := Env{Config: testConfig(), Keys: func(keyID, identity string) ([]crypto.PublicKey, error) {
+    return nil, nil
+  }}
+  
+  // a limit by key ID ahead of verify would trust the key ID the client claims
+  for _, by := range []string{"key", "identity"} {
+    env.Config.RateLimitBy = by
+    if _, err := New([]string{"ratelimit", "verify", "max"}, env); err == nil {
+      t.Errorf("%s: Got: %v, wanted: %s\n", by, nil, "error")
+    }
+    if _, err := New([]string{"verify", "ratelimit", "max"}, env); err != nil {
+      t.Errorf("%s: Got: %v, wanted: %v\n", by, err, nil)
+    }
+  }
+  env.Config.RateLimitBy = "peer"
+  if _, err := New([]string{"ratelimit", "verify", "max"}, env); err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+}
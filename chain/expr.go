@@ -0,0 +1,264 @@
+package chain
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+  "unicode"
+)
+
+// expr is a parsed integer expression such as "n % 2 == 0 && n < max + 100".
+// Comparisons and logical operators evaluate to 1 or 0, and an expression
+// holds when it evaluates to anything other than 0
+type expr interface {
+  eval(vars map[string]int64) (int64, error)
+}
+
+type literal int64
+
+func (l literal) eval(vars map[string]int64) (int64, error) {
+  return int64(l), nil
+}
+
+type variable string
+
+func (v variable) eval(vars map[string]int64) (int64, error) {
+  value, ok := vars[string(v)]
+  if !ok {
+    return 0, fmt.Errorf("unknown variable %s", string(v))
+  }
+  return value, nil
+}
+
+type unary struct {
+  op      string
+  operand expr
+}
+
+func (u unary) eval(vars map[string]int64) (int64, error) {
+  value, err := u.operand.eval(vars)
+  if err != nil {
+    return 0, err
+  }
+  if u.op == "-" {
+    return -value, nil
+  }
+  return boolToInt(value == 0), nil
+}
+
+type binary struct {
+  op          string
+  left, right expr
+}
+
+func (b binary) eval(vars map[string]int64) (int64, error) {
+  left, err := b.left.eval(vars)
+  if err != nil {
+    return 0, err
+  }
+  // short circuit the logical operators
+  if b.op == "&&" && left == 0 {
+    return 0, nil
+  }
+  if b.op == "||" && left != 0 {
+    return 1, nil
+  }
+  right, err := b.right.eval(vars)
+  if err != nil {
+    return 0, err
+  }
+  
+  switch b.op {
+  case "&&", "||":
+    return boolToInt(right != 0), nil
+  case "==":
+    return boolToInt(left == right), nil
+  case "!=":
+    return boolToInt(left != right), nil
+  case "<":
+    return boolToInt(left < right), nil
+  case "<=":
+    return boolToInt(left <= right), nil
+  case ">":
+    return boolToInt(left > right), nil
+  case ">=":
+    return boolToInt(left >= right), nil
+  case "+":
+    return left + right, nil
+  case "-":
+    return left - right, nil
+  case "*":
+    return left * right, nil
+  case "/", "%":
+    if right == 0 {
+      return 0, fmt.Errorf("division by zero")
+    }
+    if b.op == "/" {
+      return left / right, nil
+    }
+    return left % right, nil
+  }
+  return 0, fmt.Errorf("unknown operator %s", b.op)
+}
+
+func boolToInt(b bool) int64 {
+  if b {
+    return 1
+  }
+  return 0
+}
+
+// binary operators from the lowest precedence to the highest
+var precedence = [][]string{
+  {"||"},
+  {"&&"},
+  {"==", "!=", "<=", ">=", "<", ">"},
+  {"+", "-"},
+  {"*", "/", "%"},
+}
+
+type parser struct {
+  tokens []string
+  pos    int
+}
+
+// parseExpr parses an expression over the given variables
+func parseExpr(source string, vars ...string) (expr, error) {
+  tokens, err := tokenize(source)
+  if err != nil {
+    return nil, err
+  }
+  p := &parser{tokens: tokens}
+  e, err := p.parseBinary(0)
+  if err != nil {
+    return nil, err
+  }
+  if p.pos != len(p.tokens) {
+    return nil, fmt.Errorf("unexpected %q in %q", p.tokens[p.pos], source)
+  }
+  
+  if err := checkVariables(e, vars); err != nil {
+    return nil, fmt.Errorf("%v in %q", err, source)
+  }
+  return e, nil
+}
+
+// checkVariables fails on variables that will never be set,
+// so typos show up when the expression is parsed
+func checkVariables(e expr, vars []string) error {
+  switch node := e.(type) {
+  case variable:
+    if !contains(vars, string(node)) {
+      return fmt.Errorf("unknown variable %s", string(node))
+    }
+  case unary:
+    return checkVariables(node.operand, vars)
+  case binary:
+    if err := checkVariables(node.left, vars); err != nil {
+      return err
+    }
+    return checkVariables(node.right, vars)
+  }
+  return nil
+}
+
+func (p *parser) peek() string {
+  if p.pos < len(p.tokens) {
+    return p.tokens[p.pos]
+  }
+  return ""
+}
+
+func (p *parser) parseBinary(level int) (expr, error) {
+  if level == len(precedence) {
+    return p.parseUnary()
+  }
+  left, err := p.parseBinary(level + 1)
+  if err != nil {
+    return nil, err
+  }
+  for contains(precedence[level], p.peek()) {
+    op := p.tokens[p.pos]
+    p.pos++
+    right, err := p.parseBinary(level + 1)
+    if err != nil {
+      return nil, err
+    }
+    left = binary{op: op, left: left, right: right}
+  }
+  return left, nil
+}
+
+func (p *parser) parseUnary() (expr, error) {
+  switch token := p.peek(); token {
+  case "-", "!":
+    p.pos++
+    operand, err := p.parseUnary()
+    if err != nil {
+      return nil, err
+    }
+    return unary{op: token, operand: operand}, nil
+  case "(":
+    p.pos++
+    e, err := p.parseBinary(0)
+    if err != nil {
+      return nil, err
+    }
+    if p.peek() != ")" {
+      return nil, fmt.Errorf("missing )")
+    }
+    p.pos++
+    return e, nil
+  case "":
+    return nil, fmt.Errorf("unexpected end of expression")
+  default:
+    p.pos++
+    if unicode.IsDigit(rune(token[0])) {
+      value, err := strconv.ParseInt(token, 10, 64)
+      if err != nil {
+        return nil, err
+      }
+      return literal(value), nil
+    }
+    if unicode.IsLetter(rune(token[0])) {
+      return variable(token), nil
+    }
+    return nil, fmt.Errorf("unexpected %q", token)
+  }
+}
+
+func contains(values []string, value string) bool {
+  for _, v := range values {
+    if v == value {
+      return true
+    }
+  }
+  return false
+}
+
+func tokenize(source string) ([]string, error) {
+  var tokens []string
+  runes := []rune(source)
+  for i := 0; i < len(runes); {
+    r := runes[i]
+    switch {
+    case unicode.IsSpace(r):
+      i++
+    case unicode.IsDigit(r) || unicode.IsLetter(r) || r == '_':
+      start := i
+      for i < len(runes) && (unicode.IsDigit(runes[i]) || unicode.IsLetter(runes[i]) || runes[i] == '_') {
+        i++
+      }
+      tokens = append(tokens, string(runes[start:i]))
+    case i+1 < len(runes) && contains([]string{"||", "&&", "==", "!=", "<=", ">="}, string(runes[i:i+2])):
+      tokens = append(tokens, string(runes[i:i+2]))
+      i += 2
+    case strings.ContainsRune("+-*/%<>!()", r):
+      tokens = append(tokens, string(r))
+      i++
+    default:
+      return nil, fmt.Errorf("unexpected character %q", r)
+    }
+  }
+  return tokens, nil
+}
@@ -0,0 +1,48 @@
+package chain
+
+import "testing"
+
+func TestParseExpr_Eval(t *testing.T) {
+  vars := map[string]int64{"n": 42, "max": 40}
+  tests := map[string]int64{
+    "n":                   42,
+    "n + 1 * 2":           44,
+    "(n + 1) * 2":         86,
+    "-n":                  -42,
+    "n % 5":               2,
+    "n / 4":               10,
+    "n > max":             1,
+    "n <= max":            0,
+    "n % 2 == 0 && n > 0": 1,
+    "n < 0 || n == 42":    1,
+    "!(n == 42)":          0,
+    "n != max":            1,
+    "max - n >= -2":       1,
+  }
+  for source, expected := range tests {
+    e, err := parseExpr(source, "n", "max")
+    if err != nil {
+      t.Errorf("%s: Got: %v, wanted: %v\n", source, err, nil)
+      continue
+    }
+    actual, err := e.eval(vars)
+    if err != nil || actual != expected {
+      t.Errorf("%s: Got: %d (%v), wanted: %d\n", source, actual, err, expected)
+    }
+  }
+}
+
+func TestParseExpr_Errors(t *testing.T) {
+  for _, source := range []string{"", "n +", "(n", "n $ 2", "x > 1", "1 || x", "n n"} {
+    if _, err := parseExpr(source, "n"); err == nil {
+      t.Errorf("%q: Got: %v, wanted: %s\n", source, nil, "error")
+    }
+  }
+}
+
+func TestExpr_DivisionByZero(t *testing.T) {
+  e, _ := parseExpr("n / max", "n", "max")
+  if _, err := e.eval(map[string]int64{"n": 1, "max": 0}); err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "division by zero")
+  }
+}
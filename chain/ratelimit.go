@@ -0,0 +1,247 @@
+package chain
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net"
+  "sync"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc/codes"
+)
+
+// limits of one client; zero values in the limits file fall back
+// to the defaults, and a zero daily quota means no quota
+type limits struct {
+  Rate       float64 `json:"rate"`
+  Burst      int     `json:"burst"`
+  DailyQuota int64   `json:"daily_quota"`
+}
+
+// tokenBucket allows rate requests per second with bursts up to burst
+type tokenBucket struct {
+  tokens float64
+  last   time.Time
+}
+
+// reserve takes a token and returns 0, or returns how long
+// to wait until a token will be available
+func (b *tokenBucket) reserve(now time.Time, rate float64, burst int) time.Duration {
+  if b.last.IsZero() {
+    b.tokens = float64(burst)
+  } else {
+    b.tokens += now.Sub(b.last).Seconds() * rate
+    if b.tokens > float64(burst) {
+      b.tokens = float64(burst)
+    }
+  }
+  b.last = now
+  
+  if b.tokens < 1 {
+    return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+  }
+  b.tokens--
+  return 0
+}
+
+// usage is what one client consumed
+type usage struct {
+  bucket   tokenBucket
+  day      string
+  used     int64
+  lastSeen time.Time
+}
+
+// rateLimitStage limits how many numbers each client may send per second
+// and per day. Clients are told apart by key ID, with limits per key ID,
+// by peer address or by authenticated identity. Over the limit,
+// numbers are rejected with ResourceExhausted, or in throttle mode
+// held back until a token is free, which stops the server reading the
+// stream and pushes back on the client. Daily quotas always reject
+type rateLimitStage struct {
+  defaults limits
+  perKey   map[string]limits
+  by       string
+  throttle bool
+  now      func() time.Time
+  
+  mu        sync.Mutex
+  clients   map[string]*usage
+  lastSweep time.Time
+}
+
+func newRateLimitStage(env Env) (Stage, error) {
+  conf := env.Config
+  r := &rateLimitStage{
+    defaults: limits{Rate: conf.RateLimit, Burst: conf.RateBurst, DailyQuota: conf.DailyQuota},
+    by:       conf.RateLimitBy,
+    now:      time.Now,
+    clients:  make(map[string]*usage),
+  }
+  if r.defaults.Rate <= 0 || r.defaults.Burst <= 0 {
+    return nil, fmt.Errorf("rate limit and burst must be positive")
+  }
+  if r.by != "key" && r.by != "peer" && r.by != "identity" {
+    return nil, fmt.Errorf("rate limit by must be key, peer or identity, got %q", r.by)
+  }
+  switch conf.RateLimitMode {
+  case "reject":
+  case "throttle":
+    r.throttle = true
+  default:
+    return nil, fmt.Errorf("rate limit mode must be reject or throttle, got %q", conf.RateLimitMode)
+  }
+  
+  perKey, err := loadLimits(conf.RateLimits)
+  if err != nil {
+    return nil, err
+  }
+  r.perKey = perKey
+  return r, nil
+}
+
+// loadLimits reads the limits per key ID from a JSON file
+func loadLimits(path string) (map[string]limits, error) {
+  if path == "" {
+    return nil, nil
+  }
+  absPath, err := config.AbsolutePath(path)
+  if err != nil {
+    return nil, err
+  }
+  content, err := ioutil.ReadFile(absPath)
+  if err != nil {
+    return nil, err
+  }
+  perKey := make(map[string]limits)
+  if err := json.Unmarshal(content, &perKey); err != nil {
+    return nil, fmt.Errorf("failed to parse rate limits %s: %v", absPath, err)
+  }
+  return perKey, nil
+}
+
+func (r *rateLimitStage) Name() string { return "ratelimit" }
+
+// Concurrent lets rejecting limits run on the worker pool ahead of
+// signature verification; throttling would stall the shared workers
+func (r *rateLimitStage) Concurrent() bool { return !r.throttle }
+
+// VerifiedKeys keeps the stage from running before the verify stage
+// when it limits by key ID, or by identity, which falls back to the
+// key ID, or has limits per key ID. A client could otherwise claim
+// another client's key ID and use up its limits
+func (r *rateLimitStage) VerifiedKeys() bool {
+  return r.by != "peer" || len(r.perKey) > 0
+}
+
+// client identifies who sent the request. Streams without an
+// authenticated identity are limited by key ID
+func (r *rateLimitStage) client(request *Request) string {
+  if r.by == "identity" && request.Stream.Identity != "" {
+    return "identity/" + request.Stream.Identity
+  }
+  if r.by == "peer" {
+    host, _, err := net.SplitHostPort(request.Stream.Peer)
+    if err != nil {
+      return "peer/" + request.Stream.Peer
+    }
+    return "peer/" + host
+  }
+  return "key/" + keyIDOf(request)
+}
+
+// keyIDOf prefers the key the verify stage checked
+// over the one the client claims
+func keyIDOf(request *Request) string {
+  if keyID := request.Annotations["key_id"]; keyID != "" {
+    return keyID
+  }
+  return request.KeyID
+}
+
+func (r *rateLimitStage) limitsFor(keyID string) limits {
+  l := r.defaults
+  if override, ok := r.perKey[keyID]; ok {
+    if override.Rate > 0 {
+      l.Rate = override.Rate
+    }
+    if override.Burst > 0 {
+      l.Burst = override.Burst
+    }
+    if override.DailyQuota != 0 {
+      l.DailyQuota = override.DailyQuota
+    }
+  }
+  return l
+}
+
+func (r *rateLimitStage) Handle(ctx context.Context, request *Request, next Next) error {
+  client := r.client(request)
+  l := r.limitsFor(keyIDOf(request))
+  
+  for {
+    wait, err := r.take(client, l)
+    if err != nil {
+      return err
+    }
+    if wait == 0 {
+      break
+    }
+    if !r.throttle {
+      return Reject(r.Name(), codes.ResourceExhausted, "%s exceeded %.2f numbers per second", client, l.Rate)
+    }
+    select {
+    case <-time.After(wait):
+    case <-ctx.Done():
+      return ctx.Err()
+    }
+  }
+  return next(ctx, request)
+}
+
+// take uses up one number of the client's quota and a token,
+// or says how long until the next token
+func (r *rateLimitStage) take(client string, l limits) (time.Duration, error) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  
+  now := r.now()
+  r.sweep(now)
+  u, ok := r.clients[client]
+  if !ok {
+    u = &usage{}
+    r.clients[client] = u
+  }
+  u.lastSeen = now
+  
+  today := now.UTC().Format("2006-01-02")
+  if u.day != today {
+    u.day, u.used = today, 0
+  }
+  if l.DailyQuota > 0 && u.used >= l.DailyQuota {
+    return 0, Reject(r.Name(), codes.ResourceExhausted, "%s used up its daily quota of %d numbers", client, l.DailyQuota)
+  }
+  
+  wait := u.bucket.reserve(now, l.Rate, l.Burst)
+  if wait == 0 {
+    u.used++
+  }
+  return wait, nil
+}
+
+// sweep forgets clients idle for a day, whose buckets are
+// full again and whose quotas have reset anyway
+func (r *rateLimitStage) sweep(now time.Time) {
+  if now.Sub(r.lastSweep) < time.Hour {
+    return
+  }
+  r.lastSweep = now
+  for client, u := range r.clients {
+    if now.Sub(u.lastSeen) > 24*time.Hour {
+      delete(r.clients, client)
+    }
+  }
+}
@@ -0,0 +1,184 @@
+package chain
+
+import (
+  "io/ioutil"
+  "os"
+  "testing"
+  "time"
+  
+  "golang.org/x/net/context"
+  "google.golang.org/grpc/codes"
+)
+
+// testRateLimit builds a limiter of one number per second with
+// bursts of two, on a clock the test moves by hand
+func testRateLimit(t *testing.T, by, mode string) (*rateLimitStage, *time.Time) {
+  conf := testConfig()
+  conf.RateLimit, conf.RateBurst = 1, 2
+  conf.RateLimitBy, conf.RateLimitMode = by, mode
+  stage, err := newRateLimitStage(Env{Config: conf})
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  
+  now := time.Unix(0, 0)
+  r := stage.(*rateLimitStage)
+  r.now = func() time.Time { return now }
+  return r, &now
+}
+
+func limitRequest(keyID, peer string) *Request {
+  return &Request{Stream: NewStream(1, peer), KeyID: keyID}
+}
+
+func TestRateLimitStage_Reject(t *testing.T) {
+  r, now := testRateLimit(t, "key", "reject")
+  c := Of(r)
+  send := func() error {
+    return c.Handle(context.Background(), limitRequest("a", "10.0.0.1:1000"))
+  }
+  
+  for i := 0; i < 2; i++ {
+    if err := send(); err != nil {
+      t.Errorf("Got: %v, wanted: %v\n", err, nil)
+    }
+  }
+  if code := rejectionCode(send()); code != codes.ResourceExhausted {
+    t.Errorf("Got: %v, wanted: %v\n", code, codes.ResourceExhausted)
+  }
+  
+  *now = now.Add(time.Second)
+  if err := send(); err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+}
+
+func TestRateLimitStage_ByKey(t *testing.T) {
+  r, _ := testRateLimit(t, "key", "reject")
+  c := Of(r)
+  for i := 0; i < 2; i++ {
+    c.Handle(context.Background(), limitRequest("a", "10.0.0.1:1000"))
+  }
+  // same peer, different key
+  if err := c.Handle(context.Background(), limitRequest("b", "10.0.0.1:1000")); err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+}
+
+func TestRateLimitStage_ByPeer(t *testing.T) {
+  r, _ := testRateLimit(t, "peer", "reject")
+  c := Of(r)
+  for i := 0; i < 2; i++ {
+    c.Handle(context.Background(), limitRequest("a", "10.0.0.1:1000"))
+  }
+  // same host on another port, with another key
+  err := c.Handle(context.Background(), limitRequest("b", "10.0.0.1:2000"))
+  if code := rejectionCode(err); code != codes.ResourceExhausted {
+    t.Errorf("Got: %v, wanted: %v\n", code, codes.ResourceExhausted)
+  }
+}
+
+func TestRateLimitStage_ByIdentity(t *testing.T) {
+  r, _ := testRateLimit(t, "identity", "reject")
+  c := Of(r)
+  send := func(keyID, identity string) error {
+    request := limitRequest(keyID, "10.0.0.1:1000")
+    request.Stream.Identity = identity
+    return c.Handle(context.Background(), request)
+  }
+  for i := 0; i < 2; i++ {
+    send("a", "leia")
+  }
+  // another key of the same identity shares the limit
+  if code := rejectionCode(send("b", "leia")); code != codes.ResourceExhausted {
+    t.Errorf("Got: %v, wanted: %v\n", code, codes.ResourceExhausted)
+  }
+  if err := send("a", "han"); err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+}
+
+func TestRateLimitStage_DailyQuota(t *testing.T) {
+  r, now := testRateLimit(t, "key", "reject")
+  r.defaults.DailyQuota = 3
+  c := Of(r)
+  send := func() error {
+    *now = now.Add(time.Minute)
+    return c.Handle(context.Background(), limitRequest("a", "10.0.0.1:1000"))
+  }
+  
+  for i := 0; i < 3; i++ {
+    send()
+  }
+  if code := rejectionCode(send()); code != codes.ResourceExhausted {
+    t.Errorf("Got: %v, wanted: %v\n", code, codes.ResourceExhausted)
+  }
+  
+  *now = now.Add(24 * time.Hour)
+  if err := send(); err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+}
+
+func TestRateLimitStage_PerKeyLimits(t *testing.T) {
+  file, _ := ioutil.TempFile("", "rate_limits")
+  defer os.Remove(file.Name())
+  file.WriteString(`{"vip": {"burst": 5}}`)
+  file.Close()
+  
+  conf := testConfig()
+  conf.RateLimit, conf.RateBurst, conf.RateLimits = 1, 1, file.Name()
+  stage, err := newRateLimitStage(Env{Config: conf})
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  r := stage.(*rateLimitStage)
+  r.now = func() time.Time { return time.Unix(0, 0) }
+  
+  c := Of(r)
+  for i := 0; i < 5; i++ {
+    if err := c.Handle(context.Background(), limitRequest("vip", "10.0.0.1:1000")); err != nil {
+      t.Errorf("Got: %v, wanted: %v\n", err, nil)
+    }
+  }
+  c.Handle(context.Background(), limitRequest("other", "10.0.0.1:1000"))
+  err = c.Handle(context.Background(), limitRequest("other", "10.0.0.1:1000"))
+  if code := rejectionCode(err); code != codes.ResourceExhausted {
+    t.Errorf("Got: %v, wanted: %v\n", code, codes.ResourceExhausted)
+  }
+}
+
+func TestRateLimitStage_Throttle(t *testing.T) {
+  conf := testConfig()
+  conf.RateLimit, conf.RateBurst, conf.RateLimitMode = 20, 1, "throttle"
+  stage, _ := newRateLimitStage(Env{Config: conf})
+  if stage.(Concurrent).Concurrent() {
+    t.Errorf("Got: %v, wanted: %v\n", true, false)
+  }
+  
+  c := Of(stage)
+  start := time.Now()
+  for i := 0; i < 3; i++ {
+    if err := c.Handle(context.Background(), limitRequest("a", "10.0.0.1:1000")); err != nil {
+      t.Errorf("Got: %v, wanted: %v\n", err, nil)
+    }
+  }
+  // the burst covers the first number, the other two wait 50ms each
+  if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+    t.Errorf("Got: %v, wanted: at least %v\n", elapsed, 90*time.Millisecond)
+  }
+}
+
+func TestRateLimitStage_ThrottleCancelled(t *testing.T) {
+  r, _ := testRateLimit(t, "key", "throttle")
+  c := Of(r)
+  for i := 0; i < 2; i++ {
+    c.Handle(context.Background(), limitRequest("a", "10.0.0.1:1000"))
+  }
+  
+  ctx, cancel := context.WithCancel(context.Background())
+  cancel()
+  if err := c.Handle(ctx, limitRequest("a", "10.0.0.1:1000")); err != context.Canceled {
+    t.Errorf("Got: %v, wanted: %v\n", err, context.Canceled)
+  }
+}
@@ -0,0 +1,165 @@
+package chain
+
+import (
+  "crypto/sha256"
+  "fmt"
+  "sync"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc/codes"
+)
+
+func init() {
+  Register("verify", newVerifyStage)
+  Register("replay", newReplayStage)
+  Register("range", newRangeStage)
+  Register("ratelimit", newRateLimitStage)
+  Register("max", newMaxStage)
+}
+
+// verifyStage checks the request was signed by a trusted key;
+// a bad signature ends the stream. On streams from an authenticated
+// peer the key must also belong to the peer's identity, so a stolen
+// key can't be used over another client's connection
+type verifyStage struct {
+  keys     func(keyID, identity string) ([]crypto.PublicKey, error)
+  owner    func(keyID string) string
+  onVerify func(elapsed time.Duration, verified bool)
+}
+
+func newVerifyStage(env Env) (Stage, error) {
+  if env.Keys == nil {
+    return nil, fmt.Errorf("no public keys to verify with")
+  }
+  return &verifyStage{keys: env.Keys, owner: env.Owner, onVerify: env.OnVerify}, nil
+}
+
+func (v *verifyStage) Name() string     { return "verify" }
+func (v *verifyStage) Concurrent() bool { return true }
+
+func (v *verifyStage) Handle(ctx context.Context, request *Request, next Next) error {
+  start := time.Now()
+  publicKeys, err := v.keys(request.KeyID, request.Stream.Identity)
+  if err != nil {
+    v.observe(start, false)
+    return err
+  }
+  
+  numberBytes := crypto.Int64ToBytes(request.Number)
+  for _, publicKey := range publicKeys {
+    verified, verifyErr := publicKey.Verify(numberBytes, request.Signature)
+    if verifyErr == nil && verified {
+      v.observe(start, true)
+      request.Annotate("key_id", publicKey.KeyID())
+      if err := v.bind(request, publicKey.KeyID()); err != nil {
+        return err
+      }
+      return next(ctx, request)
+    }
+    err = verifyErr
+  }
+  v.observe(start, false)
+  if err != nil {
+    return err
+  }
+  return fmt.Errorf("signature of number %d is not valid", request.Number)
+}
+
+func (v *verifyStage) observe(start time.Time, verified bool) {
+  if v.onVerify != nil {
+    v.onVerify(time.Since(start), verified)
+  }
+}
+
+// bind rejects requests from an authenticated peer signed
+// with a key that belongs to someone else
+func (v *verifyStage) bind(request *Request, keyID string) error {
+  identity := request.Stream.Identity
+  if identity == "" || v.owner == nil {
+    return nil
+  }
+  if owner := v.owner(keyID); owner != identity {
+    return Reject(v.Name(), codes.PermissionDenied,
+      "key %s of %q does not belong to peer %q", keyID, owner, identity)
+  }
+  return nil
+}
+
+// replayStage rejects signatures it has already seen on any stream.
+// It remembers the most recent signatures, up to the cache size
+type replayStage struct {
+  mu    sync.Mutex
+  seen  map[[sha256.Size]byte]bool
+  order [][sha256.Size]byte
+  size  int
+}
+
+func newReplayStage(env Env) (Stage, error) {
+  size := env.Config.ReplayCacheSize
+  if size <= 0 {
+    return nil, fmt.Errorf("replay cache size must be positive, got %d", size)
+  }
+  return &replayStage{seen: make(map[[sha256.Size]byte]bool), size: size}, nil
+}
+
+func (r *replayStage) Name() string { return "replay" }
+
+func (r *replayStage) Handle(ctx context.Context, request *Request, next Next) error {
+  digest := sha256.Sum256(request.Signature)
+  
+  r.mu.Lock()
+  if r.seen[digest] {
+    r.mu.Unlock()
+    return Reject(r.Name(), codes.AlreadyExists, "signature of number %d was already used", request.Number)
+  }
+  r.seen[digest] = true
+  r.order = append(r.order, digest)
+  if len(r.order) > r.size {
+    delete(r.seen, r.order[0])
+    r.order = r.order[1:]
+  }
+  r.mu.Unlock()
+  
+  return next(ctx, request)
+}
+
+// rangeStage rejects numbers outside the configured bounds
+type rangeStage struct {
+  min, max int64
+}
+
+func newRangeStage(env Env) (Stage, error) {
+  if env.Config.RangeMin > env.Config.RangeMax {
+    return nil, fmt.Errorf("range min %d is above max %d", env.Config.RangeMin, env.Config.RangeMax)
+  }
+  return &rangeStage{min: env.Config.RangeMin, max: env.Config.RangeMax}, nil
+}
+
+func (r *rangeStage) Name() string     { return "range" }
+func (r *rangeStage) Concurrent() bool { return true }
+
+func (r *rangeStage) Handle(ctx context.Context, request *Request, next Next) error {
+  if request.Number < r.min || request.Number > r.max {
+    return Reject(r.Name(), codes.OutOfRange, "number %d is outside [%d, %d]", request.Number, r.min, r.max)
+  }
+  return next(ctx, request)
+}
+
+// maxStage keeps the largest number of the stream
+type maxStage struct{}
+
+func newMaxStage(env Env) (Stage, error) {
+  return maxStage{}, nil
+}
+
+func (maxStage) Name() string { return "max" }
+
+func (m maxStage) Handle(ctx context.Context, request *Request, next Next) error {
+  if crypto.IsNewInt64Max(request.Stream.Max, request.Number) {
+    request.Stream.Max = request.Number
+    request.Updated = true
+  }
+  return next(ctx, request)
+}
@@ -0,0 +1,185 @@
+package chain
+
+import (
+  "crypto/rand"
+  "crypto/rsa"
+  "crypto/x509"
+  "encoding/pem"
+  "fmt"
+  "testing"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc/codes"
+)
+
+func testConfig() *config.Config {
+  conf, _ := config.LoadConfig()
+  return conf
+}
+
+func testKeys(t *testing.T) (crypto.PrivateKey, crypto.PublicKey) {
+  rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+  if err != nil {
+    t.Fatal(err)
+  }
+  privatePEM := pem.EncodeToMemory(&pem.Block{
+    Type:  "RSA PRIVATE KEY",
+    Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+  })
+  publicDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+  publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+  
+  privateKey, _ := crypto.NewPrivateKey(privatePEM)
+  publicKey, _ := crypto.NewPublicKey(publicPEM)
+  return privateKey, publicKey
+}
+
+func rejectionCode(err error) codes.Code {
+  if rejection, ok := IsRejection(err); ok {
+    return rejection.Code
+  }
+  return codes.Unknown
+}
+
+func TestVerifyStage(t *testing.T) {
+  privateKey, publicKey := testKeys(t)
+  keys := func(keyID, identity string) ([]crypto.PublicKey, error) {
+    if keyID != publicKey.KeyID() && identity != "luke" {
+      return nil, fmt.Errorf("key %s is not trusted", keyID)
+    }
+    return []crypto.PublicKey{publicKey}, nil
+  }
+  var outcomes []bool
+  onVerify := func(elapsed time.Duration, verified bool) {
+    outcomes = append(outcomes, verified)
+  }
+  stage, _ := newVerifyStage(Env{Keys: keys, OnVerify: onVerify})
+  c := Of(stage)
+  
+  request := newRequest(7)
+  request.KeyID = privateKey.KeyID()
+  request.Signature, _ = privateKey.Sign(crypto.Int64ToBytes(7))
+  if err := c.Handle(context.Background(), request); err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+  
+  request.Number = 8
+  if err := c.Handle(context.Background(), request); err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "verification error")
+  }
+  if len(outcomes) != 2 || !outcomes[0] || outcomes[1] {
+    t.Errorf("Got: %v, wanted: %v\n", outcomes, []bool{true, false})
+  }
+}
+
+func TestVerifyStage_ByIdentity(t *testing.T) {
+  privateKey, publicKey := testKeys(t)
+  _, otherKey := testKeys(t)
+  keys := func(keyID, identity string) ([]crypto.PublicKey, error) {
+    return []crypto.PublicKey{otherKey, publicKey}, nil
+  }
+  stage, _ := newVerifyStage(Env{Keys: keys})
+  
+  request := newRequest(7)
+  request.Stream.Identity = "luke"
+  request.Signature, _ = privateKey.Sign(crypto.Int64ToBytes(7))
+  if err := Of(stage).Handle(context.Background(), request); err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+  if request.Annotations["key_id"] != publicKey.KeyID() {
+    t.Errorf("Got: %s, wanted: %s\n", request.Annotations["key_id"], publicKey.KeyID())
+  }
+}
+
+func TestVerifyStage_BoundToPeer(t *testing.T) {
+  privateKey, publicKey := testKeys(t)
+  keys := func(keyID, identity string) ([]crypto.PublicKey, error) {
+    return []crypto.PublicKey{publicKey}, nil
+  }
+  owner := func(keyID string) string { return "han" }
+  stage, _ := newVerifyStage(Env{Keys: keys, Owner: owner})
+  
+  send := func(identity string) error {
+    request := newRequest(7)
+    request.Stream.Identity = identity
+    request.Signature, _ = privateKey.Sign(crypto.Int64ToBytes(7))
+    return Of(stage).Handle(context.Background(), request)
+  }
+  if err := send("han"); err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+  if code := rejectionCode(send("leia")); code != codes.PermissionDenied {
+    t.Errorf("Got: %v, wanted: %v\n", code, codes.PermissionDenied)
+  }
+  // streams without an authenticated peer aren't bound
+  if err := send(""); err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+}
+
+func TestReplayStage(t *testing.T) {
+  conf := testConfig()
+  conf.ReplayCacheSize = 2
+  stage, _ := newReplayStage(Env{Config: conf})
+  c := Of(stage)
+  
+  send := func(signature string) error {
+    request := newRequest(1)
+    request.Signature = []byte(signature)
+    return c.Handle(context.Background(), request)
+  }
+  if err := send("a"); err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+  if code := rejectionCode(send("a")); code != codes.AlreadyExists {
+    t.Errorf("Got: %v, wanted: %v\n", code, codes.AlreadyExists)
+  }
+  
+  // "a" falls out of the cache once two newer signatures are seen
+  send("b")
+  send("c")
+  if err := send("a"); err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+}
+
+func TestRangeStage(t *testing.T) {
+  conf := testConfig()
+  conf.RangeMin, conf.RangeMax = 0, 100
+  stage, _ := newRangeStage(Env{Config: conf})
+  c := Of(stage)
+  
+  tests := map[int64]codes.Code{-1: codes.OutOfRange, 0: codes.OK, 100: codes.OK, 101: codes.OutOfRange}
+  for number, expected := range tests {
+    actual := codes.OK
+    if err := c.Handle(context.Background(), newRequest(number)); err != nil {
+      actual = rejectionCode(err)
+    }
+    if actual != expected {
+      t.Errorf("%d: Got: %v, wanted: %v\n", number, actual, expected)
+    }
+  }
+}
+
+func TestMaxStage(t *testing.T) {
+  c := Of(maxStage{})
+  stream := NewStream(1, "test")
+  tests := []struct {
+    number  int64
+    updated bool
+  }{{5, true}, {3, false}, {5, false}, {9, true}}
+  
+  for _, test := range tests {
+    request := &Request{Stream: stream, Number: test.number}
+    c.Handle(context.Background(), request)
+    if request.Updated != test.updated {
+      t.Errorf("%d: Got: %v, wanted: %v\n", test.number, request.Updated, test.updated)
+    }
+  }
+  if stream.Max != 9 {
+    t.Errorf("Got: %d, wanted: %d\n", stream.Max, 9)
+  }
+}
@@ -0,0 +1,115 @@
+package chain
+
+import (
+  "fmt"
+  "math"
+  "strings"
+  
+  "golang.org/x/net/context"
+  "google.golang.org/grpc/codes"
+)
+
+func init() {
+  Register("monotonic", newMonotonicStage)
+  Register("jump", newJumpStage)
+  Register("allow", newAllowStage)
+}
+
+// monotonicStage rejects numbers smaller than the last
+// number the stream got accepted
+type monotonicStage struct{}
+
+func newMonotonicStage(env Env) (Stage, error) {
+  return monotonicStage{}, nil
+}
+
+func (monotonicStage) Name() string { return "monotonic" }
+
+func (m monotonicStage) Handle(ctx context.Context, request *Request, next Next) error {
+  last, seen := request.Stream.Value(m.Name()).(int64)
+  if seen && request.Number < last {
+    return Reject(m.Name(), codes.FailedPrecondition,
+      "number %d is smaller than the previous number %d", request.Number, last)
+  }
+  
+  // only remember numbers the rest of the chain accepted
+  if err := next(ctx, request); err != nil {
+    return err
+  }
+  request.Stream.SetValue(m.Name(), request.Number)
+  return nil
+}
+
+// jumpStage rejects numbers that are more than the configured amount
+// above the stream's current maximum. A stream starts at 0, so its first
+// number is at most the amount; a SubmitNumber on a session without a
+// maximum starts at NoMax and accepts any number
+type jumpStage struct {
+  maxJump int64
+}
+
+func newJumpStage(env Env) (Stage, error) {
+  if env.Config.MaxJump <= 0 {
+    return nil, fmt.Errorf("max jump must be positive, got %d", env.Config.MaxJump)
+  }
+  return &jumpStage{maxJump: env.Config.MaxJump}, nil
+}
+
+func (j *jumpStage) Name() string { return "jump" }
+
+func (j *jumpStage) Handle(ctx context.Context, request *Request, next Next) error {
+  // number - max > maxJump, written as number - maxJump > max, which
+  // only overflows for numbers less than maxJump above MinInt64; those
+  // are never more than maxJump above any maximum
+  max := request.Stream.Max
+  if max != NoMax && request.Number >= math.MinInt64+j.maxJump && request.Number-j.maxJump > max {
+    return Reject(j.Name(), codes.OutOfRange, "number %d is more than %d above the maximum %d",
+      request.Number, j.maxJump, max)
+  }
+  return next(ctx, request)
+}
+
+// allowStage accepts only numbers that satisfy at least one of the
+// configured expressions. Expressions can use the number n, the
+// stream's current maximum max and the request's sequence seq
+type allowStage struct {
+  sources     []string
+  expressions []expr
+}
+
+var allowVariables = []string{"n", "max", "seq"}
+
+func newAllowStage(env Env) (Stage, error) {
+  if len(env.Config.AllowExpressions) == 0 {
+    return nil, fmt.Errorf("no allow expressions configured")
+  }
+  stage := &allowStage{}
+  for _, source := range env.Config.AllowExpressions {
+    e, err := parseExpr(source, allowVariables...)
+    if err != nil {
+      return nil, err
+    }
+    stage.sources = append(stage.sources, source)
+    stage.expressions = append(stage.expressions, e)
+  }
+  return stage, nil
+}
+
+func (a *allowStage) Name() string { return "allow" }
+
+func (a *allowStage) Handle(ctx context.Context, request *Request, next Next) error {
+  vars := map[string]int64{
+    "n":   request.Number,
+    "max": request.Stream.Max,
+    "seq": int64(request.Sequence),
+  }
+  for i, e := range a.expressions {
+    value, err := e.eval(vars)
+    if err == nil && value != 0 {
+      request.Annotate("allowed_by", a.sources[i])
+      return next(ctx, request)
+    }
+  }
+  return Reject(a.Name(), codes.InvalidArgument, "number %d matches none of %s",
+    request.Number, strings.Join(a.sources, "; "))
+}
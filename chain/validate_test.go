@@ -0,0 +1,105 @@
+package chain
+
+import (
+  "testing"
+  
+  "golang.org/x/net/context"
+  "google.golang.org/grpc/codes"
+)
+
+// sendAll runs the numbers through the chain on one
+// stream and returns the rejection code of each
+func sendAll(c *Chain, numbers ...int64) []codes.Code {
+  stream := NewStream(1, "test")
+  actual := make([]codes.Code, len(numbers))
+  for i, number := range numbers {
+    request := &Request{Stream: stream, Sequence: uint64(i + 1), Number: number}
+    if err := c.Handle(context.Background(), request); err != nil {
+      actual[i] = rejectionCode(err)
+    }
+  }
+  return actual
+}
+
+func assertCodes(t *testing.T, actual, expected []codes.Code) {
+  for i := range expected {
+    if actual[i] != expected[i] {
+      t.Errorf("request %d: Got: %v, wanted: %v\n", i+1, actual[i], expected[i])
+    }
+  }
+}
+
+func TestMonotonicStage(t *testing.T) {
+  stage, _ := newMonotonicStage(Env{})
+  actual := sendAll(Of(stage, maxStage{}), 1, 5, 5, 3, 8)
+  assertCodes(t, actual, []codes.Code{
+    codes.OK, codes.OK, codes.OK, codes.FailedPrecondition, codes.OK,
+  })
+}
+
+func TestMonotonicStage_IgnoresRejectedNumbers(t *testing.T) {
+  conf := testConfig()
+  conf.RangeMin, conf.RangeMax = 0, 10
+  monotonic, _ := newMonotonicStage(Env{})
+  bounds, _ := newRangeStage(Env{Config: conf})
+  
+  // 50 is rejected by range, so 7 is still above the last accepted number
+  actual := sendAll(Of(monotonic, bounds, maxStage{}), 5, 50, 7)
+  assertCodes(t, actual, []codes.Code{codes.OK, codes.OutOfRange, codes.OK})
+}
+
+func TestJumpStage(t *testing.T) {
+  conf := testConfig()
+  conf.MaxJump = 10
+  stage, _ := newJumpStage(Env{Config: conf})
+  actual := sendAll(Of(stage, maxStage{}), 10, 25, 20, -100, 9223372036854775807)
+  assertCodes(t, actual, []codes.Code{
+    codes.OK, codes.OutOfRange, codes.OK, codes.OK, codes.OutOfRange,
+  })
+}
+
+func TestJumpStage_Edges(t *testing.T) {
+  conf := testConfig()
+  conf.MaxJump = 10
+  stage, _ := newJumpStage(Env{Config: conf})
+  tests := []struct {
+    max    int64
+    number int64
+    code   codes.Code
+  }{
+    {NoMax, 500, codes.OK},
+    {NoMax + 1, NoMax + 5, codes.OK},
+    {NoMax + 1, NoMax + 12, codes.OutOfRange},
+    {-5, 9223372036854775807, codes.OutOfRange},
+  }
+  for _, test := range tests {
+    stream := NewStream(1, "test")
+    stream.Max = test.max
+    var code codes.Code
+    if err := Of(stage).Handle(context.Background(), &Request{Stream: stream, Number: test.number}); err != nil {
+      code = rejectionCode(err)
+    }
+    if code != test.code {
+      t.Errorf("max %d number %d: Got: %v, wanted: %v\n", test.max, test.number, code, test.code)
+    }
+  }
+}
+
+func TestAllowStage(t *testing.T) {
+  conf := testConfig()
+  conf.AllowExpressions = []string{"n % 2 == 0", "seq == 1"}
+  stage, err := newAllowStage(Env{Config: conf})
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  actual := sendAll(Of(stage, maxStage{}), 3, 4, 5)
+  assertCodes(t, actual, []codes.Code{codes.OK, codes.OK, codes.InvalidArgument})
+}
+
+func TestAllowStage_InvalidExpression(t *testing.T) {
+  conf := testConfig()
+  conf.AllowExpressions = []string{"number > 0"}
+  if _, err := newAllowStage(Env{Config: conf}); err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "error")
+  }
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+  "fmt"
+  
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "golang.org/x/net/context"
+)
+
+// logLevel sets the server's log level, or only reads it when
+// level is empty, and returns the level the server now logs at
+func logLevel(ctx context.Context, client pb.AdminClient, level string) (string, error) {
+  logging.Debug("logLevel()")
+  var response *pb.LogLevel
+  var err error
+  if level == "" {
+    response, err = client.GetLogLevel(ctx, &pb.GetLogLevelRequest{})
+  } else {
+    response, err = client.SetLogLevel(ctx, &pb.LogLevel{Level: level})
+  }
+  if err != nil {
+    return "", fmt.Errorf("failed to call admin service: %v", err)
+  }
+  return response.Level, nil
+}
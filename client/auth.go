@@ -0,0 +1,23 @@
+package main
+
+import (
+  "fmt"
+  
+  "github.com/salman-ahmad/grpc-streaming/auth"
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  "google.golang.org/grpc"
+)
+
+// authOptions sends the configured bearer token with every RPC.
+// Tokens are only sent over TLS, so dialing fails without it
+func authOptions(conf *config.Config) ([]grpc.DialOption, error) {
+  logging.Debug("authOptions()")
+  if conf.AuthToken == "" {
+    return nil, nil
+  }
+  if !conf.TLS {
+    return nil, fmt.Errorf("failed to configure authentication: bearer tokens need TLS")
+  }
+  return []grpc.DialOption{grpc.WithPerRPCCredentials(auth.BearerToken(conf.AuthToken))}, nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+  "fmt"
+  
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/balancer/roundrobin"
+)
+
+// balancerOption picks the server of each call: pick_first sticks to the
+// first server it can reach and moves to the next when it goes away,
+// round_robin spreads the calls over every server that is serving
+func balancerOption(name string) (grpc.DialOption, error) {
+  switch name {
+  case "pick_first", roundrobin.Name:
+    return grpc.WithBalancerName(name), nil
+  }
+  return nil, fmt.Errorf("unknown balancer %q, wanted pick_first or %s", name, roundrobin.Name)
+}
@@ -1,23 +1,135 @@
 package main
 
 import (
+  "flag"
+  "fmt"
   "io"
-  "log"
   "math/rand"
+  "os"
+  "os/signal"
+  "strconv"
+  "syscall"
   "time"
   
+  "github.com/salman-ahmad/grpc-streaming/auth"
   "github.com/salman-ahmad/grpc-streaming/config"
   "github.com/salman-ahmad/grpc-streaming/crypto"
+  "github.com/salman-ahmad/grpc-streaming/endpoint"
+  "github.com/salman-ahmad/grpc-streaming/logging"
   pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "github.com/salman-ahmad/grpc-streaming/trace"
   "golang.org/x/net/context"
   "google.golang.org/grpc"
+  "google.golang.org/grpc/codes"
+  healthpb "google.golang.org/grpc/health/grpc_health_v1"
+  "google.golang.org/grpc/status"
+)
+
+var (
+  getLogLevel = flag.Bool("get-log-level", false, "print the server's log level and exit")
+  setLogLevel = flag.String("set-log-level", "", "set the server's log level to debug, info, warn or error and exit")
+  healthCheck = flag.Bool("check-health", false, "print the server's health and exit, with status 1 unless it is serving")
+  watch       = flag.Bool("watch-max", false, "log the session's maximum and every change of it until interrupted")
+  since       = flag.Uint64("since-version", 0, "with -watch-max, the version of the maximum already seen")
+  submit      = flag.String("submit", "", "sign and submit the number on its own, print the session's maximum and exit")
+  printMax    = flag.Bool("get-max", false, "print the session's maximum and exit")
+  printHist   = flag.Bool("get-history", false, "print every change of the session's maximum, newest first, and exit")
 )
 
 func main() {
   
-  conf := loadConfig()
-  conn := startClient(conf.Port)
+  flag.Parse()
+  if err := run(); err != nil {
+    logging.Error("client failed", "err", err)
+    os.Exit(1)
+  }
+}
+
+// run sends the numbers to the server, or runs the
+// admin command given on the command line instead
+func run() error {
+  conf, err := loadConfig()
+  if err != nil {
+    return err
+  }
+  if err := startLogging(conf); err != nil {
+    return fmt.Errorf("failed to configure logging: %v", err)
+  }
+  tracer, err := startTracing(conf)
+  if err != nil {
+    return err
+  }
+  defer tracer.Shutdown()
+  conn, err := startClient(conf)
+  if err != nil {
+    return err
+  }
   defer stopClient(conn)
+  ctx := auth.WithSession(context.Background(), conf.Session)
+  
+  if *healthCheck {
+    servingStatus, err := checkHealth(ctx, healthpb.NewHealthClient(conn))
+    fmt.Println(servingStatus)
+    return err
+  }
+  if *getLogLevel || *setLogLevel != "" {
+    level, err := logLevel(ctx, pb.NewAdminClient(conn), *setLogLevel)
+    if err != nil {
+      return err
+    }
+    fmt.Println(level)
+    return nil
+  }
+  if *watch {
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+    go func() {
+      <-signals
+      cancel()
+    }()
+    return watchMax(ctx, pb.NewSimpleClient(conn), *since, logSessionMax)
+  }
+  client := pb.NewSimpleClient(conn)
+  if *printMax {
+    sessionMax, err := getMax(ctx, client)
+    if err != nil {
+      return err
+    }
+    logSessionMax(sessionMax)
+    fmt.Println(sessionMax.Max)
+    return nil
+  }
+  if *printHist {
+    return maxHistory(ctx, client, func(change *pb.SessionMax) {
+      logSessionMax(change)
+      fmt.Println(change.Max)
+    })
+  }
+  
+  var privateKey crypto.PrivateKey
+  if conf.UseAgent {
+    privateKey, err = agentPrivateKey(conf.AgentSocket, conf.AgentKeyID)
+  } else {
+    privateKey, err = rsaPrivateKey(conf.PrivateKey)
+  }
+  if err != nil {
+    return err
+  }
+  if *submit != "" {
+    number, err := strconv.ParseInt(*submit, 10, 64)
+    if err != nil {
+      return fmt.Errorf("failed to parse number to submit: %v", err)
+    }
+    sessionMax, err := submitNumber(ctx, client, privateKey, number)
+    if err != nil {
+      return err
+    }
+    logSessionMax(sessionMax)
+    fmt.Println(sessionMax.Max)
+    return nil
+  }
   
   // generate random numbers between 0 and
   // conf.NumbersToSend * conf.NumberMultiplier
@@ -25,131 +137,329 @@ func main() {
   for i := 0; i < conf.NumbersToSend; i++ {
     numbers[i] = int64(rand.Intn((i + 1) * conf.NumberMultiplier))
   }
-  
-  client := pb.NewSimpleClient(conn)
-  rsaPrivateKey := rsaPrivateKey(conf.PrivateKey)
-  maxNumber := findMaxNumber(client, rsaPrivateKey, numbers)
-  log.Printf("finished with maxNumber %d\n", maxNumber)
+  maxNumber, err := findMaxNumber(ctx, client, privateKey, numbers)
+  if err != nil {
+    return err
+  }
+  logging.Info("finished", "max", maxNumber)
+  return nil
 }
 
-func loadConfig() *config.Config {
-  log.Println("loadConfig()")
+func loadConfig() (*config.Config, error) {
+  logging.Debug("loadConfig()")
   conf, err := config.LoadConfig()
   if err != nil {
-    log.Fatalf("failed to read configuration :%v\n", err)
+    return nil, fmt.Errorf("failed to read configuration: %v", err)
   }
-  log.Println("processed configuration")
-  return conf
+  logging.Debug("processed configuration")
+  return conf, nil
 }
 
-func startClient(port string) *grpc.ClientConn {
-  log.Println("startClient()")
-  conn, err := grpc.Dial("localhost:"+port, grpc.WithInsecure())
+func startClient(conf *config.Config) (*grpc.ClientConn, error) {
+  logging.Debug("startClient()")
+  transport, err := transportOption(conf)
+  if err != nil {
+    return nil, err
+  }
+  authOptions, err := authOptions(conf)
+  if err != nil {
+    return nil, err
+  }
+  balancer, err := balancerOption(conf.Balancer)
   if err != nil {
-    log.Fatalf("failed to connect to server: %v", err)
+    return nil, err
   }
-  log.Printf("connected to server localhost:%s\n", port)
-  return conn
+  options := append([]grpc.DialOption{transport, balancer, grpc.WithDialer(endpoint.Dial)}, authOptions...)
+  options = append(options, traceOptions()...)
+  target, err := endpoint.ServerTarget(conf)
+  if err != nil {
+    return nil, fmt.Errorf("failed to find server: %v", err)
+  }
+  conn, err := grpc.Dial(target, options...)
+  if err != nil {
+    return nil, fmt.Errorf("failed to connect to server: %v", err)
+  }
+  logging.Info("connected to server", "target", target, "balancer", conf.Balancer)
+  return conn, nil
 }
 
 func stopClient(conn *grpc.ClientConn) {
-  log.Println("stopClient()")
+  logging.Debug("stopClient()")
   if err := conn.Close(); err != nil {
-    log.Fatalf("failed to stop client: %v\n", err)
+    logging.Warn("failed to stop client", "err", err)
   }
 }
 
-func rsaPrivateKey(key string) crypto.PrivateKey {
-  log.Println("rsaPrivateKey()")
+func rsaPrivateKey(key string) (crypto.PrivateKey, error) {
+  logging.Debug("rsaPrivateKey()")
   privKeyPath, err := config.AbsolutePath(key)
   if err != nil {
-    log.Fatalf("failed to calculate private key's absloute path :%v\n", err)
+    return nil, fmt.Errorf("failed to calculate private key's absolute path: %v", err)
   }
   
   privateKey, err := crypto.NewFileKey(privKeyPath)
   if err != nil {
-    log.Fatalf("failed to load private key: %v\n", err)
+    return nil, fmt.Errorf("failed to load private key: %v", err)
   }
-  log.Printf("using private key %s\n", privKeyPath)
+  logging.Info("using private key", "path", privKeyPath)
   
   rsaPrivateKey, err := crypto.NewRSAPrivateKey(privateKey.Bytes())
   if err != nil {
-    log.Fatalf("failed to read private key: %v\n", err)
+    return nil, fmt.Errorf("failed to read private key: %v", err)
   }
-  log.Println("parsed RSA private key")
-  return rsaPrivateKey
+  logging.Debug("parsed RSA private key")
+  return rsaPrivateKey, nil
 }
 
-// invoke server to find the maximum number
+// agentPrivateKey signs through the signing agent
+// instead of reading the private key from disk
+func agentPrivateKey(socket, keyID string) (crypto.PrivateKey, error) {
+  logging.Debug("agentPrivateKey()")
+  socketPath, err := config.AbsolutePath(socket)
+  if err != nil {
+    return nil, fmt.Errorf("failed to calculate agent socket's absolute path: %v", err)
+  }
+  
+  agentKey, err := crypto.NewAgentPrivateKey(socketPath, keyID)
+  if err != nil {
+    return nil, fmt.Errorf("failed to connect to signing agent: %v", err)
+  }
+  logging.Info("using agent key", "key_id", agentKey.KeyID(), "socket", socketPath)
+  return agentKey, nil
+}
+
+// maxFailovers bounds how many times in a row a stream is reopened
+// without the server acknowledging any number in between
+const maxFailovers = 5
+
+// failoverDelay is the wait before a failed stream is reopened, so
+// the connection can move to another server
+const failoverDelay = 500 * time.Millisecond
+
+// serverGoneError is a stream that failed because its server went away
+// or is shutting down; another server can take over the numbers the
+// stream didn't acknowledge
+type serverGoneError struct {
+  reason string
+}
+
+func (e serverGoneError) Error() string {
+  return e.reason
+}
+
+// invoke server to find the maximum number. When the stream's server
+// goes away the stream is reopened, on another server when there are
+// several, and the numbers it didn't acknowledge are sent again. The
+// server may have accepted some of those, so a replay stage rejecting
+// them again isn't a rejection of the number
 func findMaxNumber(
+  ctx context.Context,
+  client pb.SimpleClient,
+  privateKey crypto.PrivateKey,
+  numbers []int64) (int64, error) {
+  
+  logging.Debug("findMaxNumber()")
+  var maxNumber int64
+  received := false
+  // sent is how many numbers any stream sent; those past
+  // handled were sent before and are sent again
+  handled, sent, failovers := 0, 0, 0
+  for {
+    resent := 0
+    if sent > handled {
+      resent = sent - handled
+    }
+    acked, streamSent, err := streamNumbers(ctx, client, privateKey, numbers[handled:], resent, func(num int64) {
+      // a new stream starts from scratch, so only its numbers
+      // above the ones of the streams before it count
+      if !received || crypto.IsNewInt64Max(maxNumber, num) {
+        maxNumber, received = num, true
+        logging.Info("received new maxNumber", "max", maxNumber)
+      }
+    })
+    if handled+streamSent > sent {
+      sent = handled + streamSent
+    }
+    handled += acked
+    _, gone := err.(serverGoneError)
+    if err == nil || (gone && handled == len(numbers)) {
+      return maxNumber, nil
+    }
+    if acked > 0 {
+      failovers = 0
+    }
+    if !gone || failovers == maxFailovers {
+      return maxNumber, err
+    }
+    failovers++
+    logging.Warn("stream failed, sending unacknowledged numbers again", "err", err,
+      "handled", handled, "resend", len(numbers)-handled)
+    select {
+    case <-time.After(failoverDelay):
+    case <-ctx.Done():
+      return maxNumber, err
+    }
+  }
+}
+
+// streamNumbers sends the numbers over one stream and returns how many
+// of them the server acknowledged and how many were sent. The server
+// only answers numbers that raise the maximum or that it rejects, and
+// tells a closing stream the last number it handled, so all of them are
+// acknowledged when the stream ends cleanly or the server closes it, and
+// up to the last one it answered when it fails. The first resent numbers
+// were sent to a server that went away
+func streamNumbers(
+  ctx context.Context,
   client pb.SimpleClient,
   privateKey crypto.PrivateKey,
-  numbers []int64) int64 {
+  numbers []int64,
+  resent int,
+  onMax func(int64)) (int, int, error) {
   
-  log.Println("findMaxNumber()")
-  stream, err := client.FindMaxNumber(context.Background())
+  logging.Debug("streamNumbers()")
+  ctx, cancel := context.WithCancel(ctx)
+  defer cancel()
+  stream, err := client.FindMaxNumber(ctx)
+  if status.Code(err) == codes.Unavailable {
+    return 0, 0, serverGoneError{fmt.Sprintf("failed to open stream: %v", err)}
+  }
   if err != nil {
-    log.Fatalf("failed to open stream: %v", err)
+    return 0, 0, fmt.Errorf("failed to open stream: %v", err)
   }
   
-  // go routine to stream numbers to server
-  go sendNumbers(stream, privateKey, numbers)
+  // go routine to stream numbers to server; the stream
+  // is cancelled when sending fails, which ends receiving
+  sendErr := make(chan error, 1)
+  var sent int
+  go func() {
+    err := sendNumbers(stream, privateKey, numbers, &sent)
+    if err != nil {
+      cancel()
+    }
+    sendErr <- err
+  }()
   
   // go routine to receive maximum number from server
   maxNumReceiver := make(chan int64)
-  go getMaxNumber(stream, maxNumReceiver)
+  receiveErr := make(chan error, 1)
+  var acked uint64
+  go func() {
+    receiveErr <- getMaxNumber(stream, maxNumReceiver, &acked, uint64(resent))
+  }()
   
-  var maxNumber int64
   for num := range maxNumReceiver {
-    maxNumber = num
-    log.Printf("received new maxNumber %d\n", maxNumber)
+    onMax(num)
+  }
+  // sending stops once receiving failed. A send fails with io.EOF
+  // when the server ended the stream, and receiving then tells why
+  // it did, unless receiving failed because sending did
+  err = <-receiveErr
+  if err != nil {
+    cancel()
+  }
+  if sendErr := <-sendErr; sendErr != nil && sendErr != io.EOF && (err == nil || status.Code(err) == codes.Canceled) {
+    return int(acked), sent, sendErr
   }
-  return maxNumber
+  if err != nil {
+    return int(acked), sent, err
+  }
+  return len(numbers), sent, nil
 }
 
-// send the given numbers and sleep between each send
+// send the given numbers and sleep between each send,
+// counting the numbers sent
 func sendNumbers(
   stream pb.Simple_FindMaxNumberClient,
   privateKey crypto.PrivateKey,
-  numbers []int64) {
+  numbers []int64,
+  sent *int) error {
   
-  log.Println("sendNumbers()")
-  for _, number := range numbers {
+  logging.Debug("sendNumbers()")
+  for i, number := range numbers {
+    // one span per number, which the server's spans for
+    // the number are children of
+    ctx, span := trace.Start(stream.Context(), "send")
+    span.SetAttribute("sequence", i+1)
+    span.SetAttribute("number", number)
+    
+    _, signSpan := trace.Start(ctx, "sign")
     signature, err := privateKey.Sign(crypto.Int64ToBytes(number))
+    signSpan.SetError(err)
+    signSpan.End()
     if err != nil {
-      log.Fatalf("failed to sign the request: %v\n", err)
+      span.End()
+      return fmt.Errorf("failed to sign the request: %v", err)
     }
     
-    request := &pb.MaxNumberRequest{Number: number, Signature: signature}
-    if err := stream.Send(request); err != nil {
-      log.Fatalf("failed to send the request: %v\n", err)
+    request := &pb.MaxNumberRequest{
+      Number:      number,
+      Signature:   signature,
+      KeyId:       privateKey.KeyID(),
+      Traceparent: span.Context().Traceparent(),
     }
-    log.Printf("sent new number %d\n", request.Number)
+    err = stream.Send(request)
+    span.SetError(err)
+    span.End()
+    if err == io.EOF {
+      return err
+    }
+    if err != nil {
+      return fmt.Errorf("failed to send the request: %v", err)
+    }
+    *sent = i + 1
+    logging.Debug("sent new number", "sequence", i+1, "number", request.Number, "key_id", request.KeyId)
     time.Sleep(time.Millisecond * 200)
   }
   
   if err := stream.CloseSend(); err != nil {
-    log.Fatalf("failed to close the stream: %v\n", err)
+    return fmt.Errorf("failed to close the stream: %v", err)
   }
+  return nil
 }
 
-// receive max number from server and
-// close the channel when stream is finished
+// receive max number from server, log rejected numbers and close the
+// channel when stream is finished. acked is set to the sequence of
+// the last number the server answered; as it answers in order, every
+// number up to it was handled. The numbers up to resent were sent
+// to another server before, which may have accepted them
 func getMaxNumber(
   stream pb.Simple_FindMaxNumberClient,
-  maxNumReceiver chan int64) {
+  maxNumReceiver chan int64,
+  acked *uint64,
+  resent uint64) error {
   
-  log.Println("getMaxNumber()")
+  logging.Debug("getMaxNumber()")
+  defer close(maxNumReceiver)
   for {
     response, err := stream.Recv()
     if err == io.EOF {
-      close(maxNumReceiver)
-      return
+      return nil
+    }
+    if status.Code(err) == codes.Unavailable {
+      return serverGoneError{fmt.Sprintf("failed to receive stream response: %v", err)}
     }
     if err != nil {
-      log.Fatalf("failed to receive stream response: %v\n", err)
+      return fmt.Errorf("failed to receive stream response: %v", err)
+    }
+    if response.Sequence > *acked {
+      *acked = response.Sequence
     }
     
+    if response.Closing {
+      logging.Warn("server is closing the stream", "max", response.Number, "sequence", response.Sequence)
+      return serverGoneError{"server closed the stream"}
+    }
+    if rejection := response.Rejection; rejection != nil {
+      if response.Sequence <= resent && codes.Code(rejection.Code) == codes.AlreadyExists {
+        logging.Debug("number was already handled", "sequence", response.Sequence, "number", rejection.Number,
+          "stage", rejection.Stage)
+        continue
+      }
+      logging.Info("number rejected", "sequence", response.Sequence, "number", rejection.Number,
+        "stage", rejection.Stage, "reason", rejection.Reason)
+      continue
+    }
     maxNumReceiver <- response.Number
   }
 }
@@ -1,38 +1,49 @@
 package main
 
 import (
+  "bytes"
+  "context"
+  "io"
   "log"
   "net"
   "os"
   "os/exec"
+  "reflect"
+  "syscall"
   "testing"
   "time"
   
+  "github.com/salman-ahmad/grpc-streaming/auth"
   "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/logging"
   pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "google.golang.org/grpc/codes"
+  healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 var simpleClient pb.SimpleClient
+var adminClient pb.AdminClient
+var healthClient healthpb.HealthClient
 var conf *config.Config
 
+// always rebuild so the tests never run a stale server; go build
+// only recompiles what changed since the last run
 func buildServer() {
   log.Println("buildServer()")
-  if _, err := os.Stat("../server/server"); os.IsNotExist(err) {
-    log.Println("file does not exist; build it")
-    cmd := exec.Command("go", "build", "-o", "server/server", "server/server.go")
-    cmd.Dir = ".."
-    err := cmd.Start()
-    if err != nil {
-      log.Fatalf("Server file failed to build: %v\n", err)
-    }
+  cmd := exec.Command("go", "build", "-o", "server/server", "./server")
+  cmd.Dir = ".."
+  if out, err := cmd.CombinedOutput(); err != nil {
+    log.Fatalf("Server file failed to build: %v\n%s", err, out)
   }
 }
 
-func startServer() *exec.Cmd {
+func startServer(env ...string) *exec.Cmd {
   log.Println("startServer()")
   cmdStr := "server/server"
   serverCmd := exec.Command(cmdStr)
   serverCmd.Dir = ".."
+  // keep the state of test servers out of the home directory
+  serverCmd.Env = append(append(os.Environ(), "GRPC_STATE_FILE="), env...)
   
   err := serverCmd.Start()
   if err != nil {
@@ -50,18 +61,41 @@ func startServer() *exec.Cmd {
   return serverCmd
 }
 
+// stopServer asks the server to shut down gracefully and waits
+// for it, killing it if it doesn't exit in time
 func stopServer(serverCmd *exec.Cmd) {
   log.Println("stopServer()")
-  if err := serverCmd.Process.Kill(); err != nil {
-    log.Fatalf("failed to kill process: %v\n", err)
+  if err := serverCmd.Process.Signal(syscall.SIGTERM); err != nil {
+    log.Fatalf("failed to stop process: %v\n", err)
+  }
+  
+  exited := make(chan error, 1)
+  go func() { exited <- serverCmd.Wait() }()
+  select {
+  case err := <-exited:
+    if err != nil {
+      log.Printf("server exited: %v\n", err)
+    }
+  case <-time.After(15 * time.Second):
+    log.Println("server didn't stop, killing it")
+    serverCmd.Process.Kill()
+    <-exited
   }
 }
 
 func TestMain(m *testing.M) {
-  conf = loadConfig()
+  var err error
+  if conf, err = loadConfig(); err != nil {
+    log.Fatalf("failed to read configuration: %v\n", err)
+  }
   buildServer()
   serverCmd := startServer()
-  clientConn := startClient(conf.Port)
+  clientConn, err := startClient(conf)
+  if err != nil {
+    log.Fatalf("failed to start client: %v\n", err)
+  }
+  adminClient = pb.NewAdminClient(clientConn)
+  healthClient = healthpb.NewHealthClient(clientConn)
   simpleClient = pb.NewSimpleClient(clientConn)
   
   returnCode := m.Run()
@@ -74,9 +108,211 @@ func TestMain(m *testing.M) {
 func TestRunFindMaxNumber(t *testing.T) {
   numbersToSend := []int64{-100, 1, 4, 100, 30, 50, 203, 1111, 1301, 2004}
   expectedMaxNumber := int64(2004)
-  privateKey := rsaPrivateKey(conf.PrivateKey)
-  actualMaxNumber := findMaxNumber(simpleClient, privateKey, numbersToSend)
+  privateKey, err := rsaPrivateKey(conf.PrivateKey)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  ctx := auth.WithSession(context.Background(), conf.Session)
+  actualMaxNumber, err := findMaxNumber(ctx, simpleClient, privateKey, numbersToSend)
+  if err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
   if actualMaxNumber != expectedMaxNumber {
     t.Errorf("Got: %d, wanted: %d\n", actualMaxNumber, expectedMaxNumber)
   }
 }
+
+func TestFindMaxNumber_Failover(t *testing.T) {
+  first := startServer("GRPC_PORT=7024")
+  second := startServer("GRPC_PORT=7025")
+  defer stopServer(second)
+  failoverConf := *conf
+  failoverConf.Servers = []string{"localhost:7024", "localhost:7025"}
+  conn, err := startClient(&failoverConf)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  defer stopClient(conn)
+  client := pb.NewSimpleClient(conn)
+  privateKey, err := rsaPrivateKey(conf.PrivateKey)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  
+  // the first server shuts down while the numbers are sent, and
+  // the stream moves to the second with the numbers left
+  stopped := make(chan struct{})
+  go func() {
+    time.Sleep(700 * time.Millisecond)
+    stopServer(first)
+    close(stopped)
+  }()
+  ctx := auth.WithSession(context.Background(), "failover")
+  maxNumber, err := findMaxNumber(ctx, client, privateKey, []int64{5, 40, 3, 12, 80, 7, 9, 100, 2, 60})
+  <-stopped
+  if err != nil || maxNumber != 100 {
+    t.Errorf("Got: %d %v, wanted: %d\n", maxNumber, err, 100)
+  }
+  sessionMax, err := getMax(ctx, client)
+  if err != nil || sessionMax.Max != 100 {
+    t.Errorf("Got: %v %v, wanted: max %d\n", sessionMax, err, 100)
+  }
+}
+
+// replyStream is a stream whose server sends the responses given
+type replyStream struct {
+  pb.Simple_FindMaxNumberClient
+  responses []*pb.MaxNumberResponse
+}
+
+func (s *replyStream) Recv() (*pb.MaxNumberResponse, error) {
+  if len(s.responses) == 0 {
+    return nil, io.EOF
+  }
+  response := s.responses[0]
+  s.responses = s.responses[1:]
+  return response, nil
+}
+
+func TestGetMaxNumber_Resent(t *testing.T) {
+  replayed := &pb.Rejection{Stage: "replay", Code: int32(codes.AlreadyExists), Number: 40}
+  stream := &replyStream{responses: []*pb.MaxNumberResponse{
+    {Sequence: 1, Rejection: replayed},
+    {Sequence: 2, Number: 50},
+    {Sequence: 3, Rejection: replayed},
+  }}
+  
+  var logs bytes.Buffer
+  logger, _ := logging.New(&logs, "logfmt", logging.InfoLevel)
+  defer logging.SetDefault(logging.Default())
+  logging.SetDefault(logger)
+  
+  // numbers sent again after a failover may already have been
+  // handled, so only the rejection of a number sent once is logged
+  maxNumReceiver := make(chan int64, 3)
+  var acked uint64
+  if err := getMaxNumber(stream, maxNumReceiver, &acked, 2); err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  var received []int64
+  for num := range maxNumReceiver {
+    received = append(received, num)
+  }
+  if !reflect.DeepEqual(received, []int64{50}) || acked != 3 {
+    t.Errorf("Got: %v acked %d, wanted: %v acked %d\n", received, acked, []int64{50}, 3)
+  }
+  if rejected := bytes.Count(logs.Bytes(), []byte("number rejected")); rejected != 1 {
+    t.Errorf("Got: %d, wanted: %d\n%s", rejected, 1, logs.String())
+  }
+}
+
+func TestLogLevel(t *testing.T) {
+  ctx := context.Background()
+  tests := []struct {
+    set      string
+    expected string
+  }{{"", "info"}, {"debug", "debug"}, {"", "debug"}, {"info", "info"}}
+  for _, test := range tests {
+    level, err := logLevel(ctx, adminClient, test.set)
+    if err != nil || level != test.expected {
+      t.Errorf("%q: Got: %s %v, wanted: %s\n", test.set, level, err, test.expected)
+    }
+  }
+  if _, err := logLevel(ctx, adminClient, "loud"); err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "invalid level error")
+  }
+}
+
+func TestCheckHealth(t *testing.T) {
+  servingStatus, err := checkHealth(context.Background(), healthClient)
+  if err != nil || servingStatus != healthpb.HealthCheckResponse_SERVING {
+    t.Errorf("Got: %v %v, wanted: %v\n", servingStatus, err, healthpb.HealthCheckResponse_SERVING)
+  }
+}
+
+func TestWatchMax(t *testing.T) {
+  privateKey, err := rsaPrivateKey(conf.PrivateKey)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  ctx := auth.WithSession(context.Background(), "watched")
+  watchCtx, cancel := context.WithCancel(ctx)
+  updates := make(chan *pb.SessionMax, 10)
+  watchErr := make(chan error, 1)
+  go func() {
+    watchErr <- watchMax(watchCtx, simpleClient, 0, func(update *pb.SessionMax) {
+      updates <- update
+    })
+  }()
+  
+  // the watcher is told the session has no maximum yet, then its changes
+  if update := <-updates; update.Version != 0 || update.Session != "watched" {
+    t.Errorf("Got: %v, wanted: version %d\n", update, 0)
+  }
+  if _, err := findMaxNumber(ctx, simpleClient, privateKey, []int64{5, 3, 9}); err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  var last *pb.SessionMax
+  for last == nil || last.Version < 2 {
+    select {
+    case last = <-updates:
+    case <-time.After(5 * time.Second):
+      t.Fatalf("Got: %v, wanted: max %d\n", last, 9)
+    }
+  }
+  if last.Max != 9 || last.Version != 2 || last.UpdatedAt == 0 {
+    t.Errorf("Got: %v, wanted: max %d version %d\n", last, 9, 2)
+  }
+  cancel()
+  if err := <-watchErr; err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+  
+  // a watcher that saw the current version waits for the next change
+  watchCtx, cancel = context.WithTimeout(ctx, 300*time.Millisecond)
+  defer cancel()
+  err = watchMax(watchCtx, simpleClient, last.Version, func(update *pb.SessionMax) {
+    t.Errorf("Got: %v, wanted: %s\n", update, "no update")
+  })
+  if err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+}
+
+func TestSubmitNumber(t *testing.T) {
+  privateKey, err := rsaPrivateKey(conf.PrivateKey)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  ctx := auth.WithSession(context.Background(), "submitted")
+  for _, number := range []int64{12, 5} {
+    sessionMax, err := submitNumber(ctx, simpleClient, privateKey, number)
+    if err != nil || sessionMax.Max != 12 {
+      t.Errorf("Got: %v %v, wanted: %d\n", sessionMax, err, 12)
+    }
+  }
+  sessionMax, err := getMax(ctx, simpleClient)
+  if err != nil || sessionMax.Max != 12 || sessionMax.Version != 1 {
+    t.Errorf("Got: %v %v, wanted: max %d version %d\n", sessionMax, err, 12, 1)
+  }
+}
+
+func TestMaxHistory(t *testing.T) {
+  privateKey, err := rsaPrivateKey(conf.PrivateKey)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  ctx := auth.WithSession(context.Background(), "history")
+  for _, number := range []int64{3, 9, 4, 11} {
+    if _, err := submitNumber(ctx, simpleClient, privateKey, number); err != nil {
+      t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+  }
+  var maxes []int64
+  err = maxHistory(ctx, simpleClient, func(change *pb.SessionMax) {
+    maxes = append(maxes, change.Max)
+  })
+  if err != nil || !reflect.DeepEqual(maxes, []int64{11, 9, 3}) {
+    t.Errorf("Got: %v %v, wanted: %v\n", maxes, err, []int64{11, 9, 3})
+  }
+}
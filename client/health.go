@@ -0,0 +1,30 @@
+package main
+
+import (
+  "fmt"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  "golang.org/x/net/context"
+  healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthCheckTimeout bounds a health check, so probes of
+// an unreachable server fail instead of hanging
+const healthCheckTimeout = 5 * time.Second
+
+// checkHealth asks the server whether it is serving, and fails
+// when it can't be reached or reports that it isn't
+func checkHealth(ctx context.Context, client healthpb.HealthClient) (healthpb.HealthCheckResponse_ServingStatus, error) {
+  logging.Debug("checkHealth()")
+  ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+  defer cancel()
+  response, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+  if err != nil {
+    return healthpb.HealthCheckResponse_UNKNOWN, fmt.Errorf("failed to check health: %v", err)
+  }
+  if response.Status != healthpb.HealthCheckResponse_SERVING {
+    return response.Status, fmt.Errorf("server is %v", response.Status)
+  }
+  return response.Status, nil
+}
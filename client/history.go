@@ -0,0 +1,32 @@
+package main
+
+import (
+  "fmt"
+  
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "golang.org/x/net/context"
+)
+
+// historyPageSize is the number of changes requested per page
+const historyPageSize = 100
+
+// maxHistory pages through the changes of the session's maximum,
+// newest first, handing each to handle
+func maxHistory(ctx context.Context, client pb.SimpleClient, handle func(*pb.SessionMax)) error {
+  logging.Debug("maxHistory()")
+  request := &pb.GetMaxHistoryRequest{PageSize: historyPageSize}
+  for {
+    history, err := client.GetMaxHistory(ctx, request)
+    if err != nil {
+      return fmt.Errorf("failed to get max history: %v", err)
+    }
+    for _, change := range history.Changes {
+      handle(change)
+    }
+    if history.NextPageToken == "" {
+      return nil
+    }
+    request.PageToken = history.NextPageToken
+  }
+}
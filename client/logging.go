@@ -0,0 +1,23 @@
+package main
+
+import (
+  "os"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+)
+
+// startLogging makes a logger with the configured format and
+// level the default logger of the client
+func startLogging(conf *config.Config) error {
+  level, err := logging.ParseLevel(conf.LogLevel)
+  if err != nil {
+    return err
+  }
+  logger, err := logging.New(os.Stderr, conf.LogFormat, level)
+  if err != nil {
+    return err
+  }
+  logging.SetDefault(logger)
+  return nil
+}
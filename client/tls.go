@@ -0,0 +1,25 @@
+package main
+
+import (
+  "fmt"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/endpoint"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/credentials"
+)
+
+func transportOption(conf *config.Config) (grpc.DialOption, error) {
+  logging.Debug("transportOption()")
+  if !conf.TLS {
+    return grpc.WithInsecure(), nil
+  }
+  
+  tlsConfig, err := endpoint.ClientTLSConfig(conf)
+  if err != nil {
+    return nil, fmt.Errorf("failed to configure TLS: %v", err)
+  }
+  logging.Info("TLS is on", "client_certificate", len(tlsConfig.Certificates) > 0)
+  return grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)), nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+  "fmt"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  "github.com/salman-ahmad/grpc-streaming/trace"
+  "google.golang.org/grpc"
+)
+
+// startTracing makes the configured exporter the destination
+// of the client's spans
+func startTracing(conf *config.Config) (*trace.Tracer, error) {
+  logging.Debug("startTracing()")
+  traceFile, err := config.AbsolutePath(conf.TraceFile)
+  if err != nil {
+    return nil, fmt.Errorf("failed to calculate trace file's absolute path: %v", err)
+  }
+  exporter, err := trace.NewExporter(conf.TraceExporter, conf.TraceEndpoint, traceFile)
+  if err != nil {
+    return nil, fmt.Errorf("failed to start tracing: %v", err)
+  }
+  
+  tracer := trace.NewTracer("maxnumber-client", exporter)
+  trace.SetDefault(tracer)
+  return tracer, nil
+}
+
+// traceOptions trace every RPC and send its trace context to the server
+func traceOptions() []grpc.DialOption {
+  return []grpc.DialOption{
+    grpc.WithUnaryInterceptor(trace.UnaryClientInterceptor()),
+    grpc.WithStreamInterceptor(trace.StreamClientInterceptor()),
+  }
+}
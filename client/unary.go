@@ -0,0 +1,42 @@
+package main
+
+import (
+  "fmt"
+  
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "golang.org/x/net/context"
+)
+
+// submitNumber signs the number and submits it on its own,
+// returning the session's maximum after it
+func submitNumber(
+  ctx context.Context,
+  client pb.SimpleClient,
+  privateKey crypto.PrivateKey,
+  number int64) (*pb.SessionMax, error) {
+  
+  logging.Debug("submitNumber()")
+  signature, err := privateKey.Sign(crypto.Int64ToBytes(number))
+  if err != nil {
+    return nil, fmt.Errorf("failed to sign the request: %v", err)
+  }
+  request := &pb.MaxNumberRequest{Number: number, Signature: signature, KeyId: privateKey.KeyID()}
+  response, err := client.SubmitNumber(ctx, request)
+  if err != nil {
+    return nil, fmt.Errorf("failed to submit number: %v", err)
+  }
+  logging.Info("submitted number", "number", number, "raised", response.Raised)
+  return response.Max, nil
+}
+
+// getMax returns the session's maximum
+func getMax(ctx context.Context, client pb.SimpleClient) (*pb.SessionMax, error) {
+  logging.Debug("getMax()")
+  sessionMax, err := client.GetMax(ctx, &pb.GetMaxRequest{})
+  if err != nil {
+    return nil, fmt.Errorf("failed to get max: %v", err)
+  }
+  return sessionMax, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+  "fmt"
+  "io"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+// rewatchDelay is how long to wait before watching
+// again when the server ended the watch
+const rewatchDelay = time.Second
+
+// watchMax hands the maximum of the session and every change of it to
+// the callback until the context is done. When the server goes away it
+// watches again from the last version it saw, so no change is missed
+func watchMax(
+  ctx context.Context,
+  client pb.SimpleClient,
+  sinceVersion uint64,
+  onUpdate func(*pb.SessionMax)) error {
+  
+  logging.Debug("watchMax()")
+  for {
+    err := watchOnce(ctx, client, &sinceVersion, onUpdate)
+    if ctx.Err() != nil {
+      return nil
+    }
+    if status.Code(err) != codes.Unavailable {
+      return fmt.Errorf("failed to watch max: %v", err)
+    }
+    logging.Warn("watch ended, watching again", "since_version", sinceVersion, "err", err)
+    select {
+    case <-ctx.Done():
+      return nil
+    case <-time.After(rewatchDelay):
+    }
+  }
+}
+
+// watchOnce runs one WatchMax stream, keeping track of the last version
+func watchOnce(
+  ctx context.Context,
+  client pb.SimpleClient,
+  sinceVersion *uint64,
+  onUpdate func(*pb.SessionMax)) error {
+  
+  stream, err := client.WatchMax(ctx, &pb.WatchMaxRequest{SinceVersion: *sinceVersion})
+  if err != nil {
+    return err
+  }
+  for {
+    update, err := stream.Recv()
+    if err == io.EOF {
+      return status.Error(codes.Unavailable, "server ended the watch")
+    }
+    if err != nil {
+      return err
+    }
+    *sinceVersion = update.Version
+    onUpdate(update)
+  }
+}
+
+func logSessionMax(update *pb.SessionMax) {
+  logging.Info("max of session", "session", update.Session, "max", update.Max, "version", update.Version,
+    "updated_by", update.UpdatedBy, "updated_at", time.Unix(0, update.UpdatedAt).UTC().Format(time.RFC3339Nano))
+}
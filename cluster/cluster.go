@@ -0,0 +1,391 @@
+package cluster
+
+import (
+  "errors"
+  "fmt"
+  "math/rand"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc"
+)
+
+// default timing of a node, for nodes on the same network
+const (
+  DefaultHeartbeat       = 100 * time.Millisecond
+  DefaultElectionTimeout = time.Second
+)
+
+// maxEntries bounds the entries sent to a follower in one append
+const maxEntries = 256
+
+// DefaultSnapshotEntries is how many applied entries a node's log
+// keeps before it replaces them with a snapshot
+const DefaultSnapshotEntries = 1024
+
+var (
+  // ErrNoLeader is returned by Propose while the cluster has no leader
+  ErrNoLeader = errors.New("cluster has no leader")
+  // ErrLeadershipLost is returned by Propose when the leader lost its
+  // leadership before the command was committed. The command may still
+  // be committed by the next leader
+  ErrLeadershipLost = errors.New("leader lost its leadership")
+  // ErrStopped is returned by Propose once the node is stopped
+  ErrStopped = errors.New("node is stopped")
+)
+
+// roles of a node
+const (
+  follower = iota
+  candidate
+  leader
+)
+
+var roleNames = []string{"follower", "candidate", "leader"}
+
+// Config describes a node and the cluster it belongs to
+type Config struct {
+  // ID names the node in Nodes
+  ID string
+  // Nodes maps every node of the cluster, this one included,
+  // to the address it serves the Raft service on
+  Nodes map[string]string
+  // Dir keeps the node's term, vote and log; empty keeps them in memory
+  Dir             string
+  Heartbeat       time.Duration
+  ElectionTimeout time.Duration
+  // Dial connects to another node
+  Dial func(addr string) (*grpc.ClientConn, error)
+  // Apply runs a committed command against the node's state and
+  // returns the result the proposer gets. Every node applies the
+  // same commands in the same order
+  Apply func(command []byte) []byte
+  // Snapshot returns the state the applied commands led to, and Restore
+  // replaces the state with a snapshot. Without them the log keeps every
+  // command, and grows with every command
+  Snapshot func() ([]byte, error)
+  Restore  func(snapshot []byte) error
+  // SnapshotEntries is how many applied entries the log keeps before
+  // they're replaced with a snapshot, DefaultSnapshotEntries if 0
+  SnapshotEntries int
+}
+
+// ParseNodes reads nodes given as id=address
+func ParseNodes(nodes []string) (map[string]string, error) {
+  parsed := make(map[string]string, len(nodes))
+  for _, node := range nodes {
+    parts := strings.SplitN(node, "=", 2)
+    if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+      return nil, fmt.Errorf("node %q is not id=address", node)
+    }
+    if _, ok := parsed[parts[0]]; ok {
+      return nil, fmt.Errorf("node %s is listed twice", parts[0])
+    }
+    parsed[parts[0]] = parts[1]
+  }
+  return parsed, nil
+}
+
+// proposal is a command the leader waits to apply
+type proposal struct {
+  term uint64
+  done chan result
+}
+
+type result struct {
+  value []byte
+  err   error
+}
+
+// Node is a member of a cluster that replicates a log of commands
+// with Raft: one node is elected leader, appends every command to its
+// log and replicates it to the others, and a command is applied once
+// a majority of the nodes has it
+type Node struct {
+  mu      sync.Mutex
+  id      string
+  peers   map[string]pb.RaftClient
+  conns   []*grpc.ClientConn
+  storage *storage
+  apply   func([]byte) []byte
+  logger  *logging.Logger
+  
+  snapshot        func() ([]byte, error)
+  restore         func([]byte) error
+  snapshotEntries uint64
+  
+  heartbeat       time.Duration
+  electionTimeout time.Duration
+  // electionDeadline is when a follower that hears nothing
+  // from a leader stands for election
+  electionDeadline time.Time
+  // lastContact is when the node last heard from the leader
+  lastContact  time.Time
+  preVoteRound uint64
+  
+  role        int
+  leaderID    string
+  commitIndex uint64
+  lastApplied uint64
+  nextIndex   map[string]uint64
+  matchIndex  map[string]uint64
+  inFlight    map[string]bool
+  pending     map[uint64]*proposal
+  
+  replicate chan struct{}
+  stop      chan struct{}
+  stopped   sync.WaitGroup
+}
+
+// New recovers the node's state and connects it to the other nodes.
+// It takes part in the cluster once it is started
+func New(conf Config) (*Node, error) {
+  if _, ok := conf.Nodes[conf.ID]; !ok {
+    return nil, fmt.Errorf("node %s is not one of the nodes of the cluster", conf.ID)
+  }
+  if conf.Heartbeat <= 0 {
+    conf.Heartbeat = DefaultHeartbeat
+  }
+  if conf.ElectionTimeout <= conf.Heartbeat {
+    return nil, fmt.Errorf("election timeout %s must be longer than the heartbeat %s", conf.ElectionTimeout, conf.Heartbeat)
+  }
+  if (conf.Snapshot == nil) != (conf.Restore == nil) {
+    return nil, errors.New("snapshots need both Snapshot and Restore")
+  }
+  if conf.SnapshotEntries <= 0 {
+    conf.SnapshotEntries = DefaultSnapshotEntries
+  }
+  storage, err := openStorage(conf.Dir)
+  if err != nil {
+    return nil, fmt.Errorf("failed to recover raft state: %v", err)
+  }
+  
+  n := &Node{
+    id:              conf.ID,
+    peers:           make(map[string]pb.RaftClient),
+    storage:         storage,
+    apply:           conf.Apply,
+    logger:          logging.With("node", conf.ID),
+    snapshot:        conf.Snapshot,
+    restore:         conf.Restore,
+    snapshotEntries: uint64(conf.SnapshotEntries),
+    heartbeat:       conf.Heartbeat,
+    electionTimeout: conf.ElectionTimeout,
+    nextIndex:       make(map[string]uint64),
+    matchIndex:      make(map[string]uint64),
+    inFlight:        make(map[string]bool),
+    pending:         make(map[uint64]*proposal),
+    replicate:       make(chan struct{}, 1),
+    stop:            make(chan struct{}),
+  }
+  // the snapshot holds only committed entries, which
+  // the node applies again from there
+  if index := storage.snapshotIndex(); index > 0 {
+    if n.restore != nil {
+      if err := n.restore(storage.snapshot); err != nil {
+        storage.close()
+        return nil, fmt.Errorf("failed to restore snapshot: %v", err)
+      }
+    }
+    n.commitIndex, n.lastApplied = index, index
+  }
+  for id, addr := range conf.Nodes {
+    if id == conf.ID {
+      continue
+    }
+    conn, err := conf.Dial(addr)
+    if err != nil {
+      n.closeConns()
+      storage.close()
+      return nil, fmt.Errorf("failed to connect to node %s: %v", id, err)
+    }
+    n.conns = append(n.conns, conn)
+    n.peers[id] = pb.NewRaftClient(conn)
+  }
+  return n, nil
+}
+
+// Register serves the node's Raft service on the server
+func (n *Node) Register(server *grpc.Server) {
+  pb.RegisterRaftServer(server, raftServer{n})
+}
+
+// Start runs the node's timers: followers stand for election
+// when they hear nothing from a leader, and the leader sends
+// heartbeats and new entries to the followers
+func (n *Node) Start() {
+  n.mu.Lock()
+  n.resetElectionDeadline()
+  n.mu.Unlock()
+  n.logger.Info("starting raft node", "term", n.storage.state.Term, "entries", n.storage.lastIndex(),
+    "nodes", len(n.peers)+1)
+  n.stopped.Add(1)
+  go n.run()
+}
+
+// Stop stops the node's timers, fails the proposals waiting
+// for it and closes its connections and storage
+func (n *Node) Stop() error {
+  close(n.stop)
+  n.stopped.Wait()
+  n.mu.Lock()
+  defer n.mu.Unlock()
+  for index, p := range n.pending {
+    p.done <- result{err: ErrStopped}
+    delete(n.pending, index)
+  }
+  n.closeConns()
+  return n.storage.close()
+}
+
+func (n *Node) closeConns() {
+  for _, conn := range n.conns {
+    conn.Close()
+  }
+}
+
+// Status returns the node's term, role and the leader it follows
+func (n *Node) Status() (term uint64, role string, leader string) {
+  n.mu.Lock()
+  defer n.mu.Unlock()
+  return n.storage.state.Term, roleNames[n.role], n.leaderID
+}
+
+// Propose replicates the command and returns the result of applying
+// it once it is committed. Followers forward it to the leader, waiting
+// for one to be elected if there is none yet
+func (n *Node) Propose(ctx context.Context, command []byte) ([]byte, error) {
+  for {
+    n.mu.Lock()
+    role, leaderID := n.role, n.leaderID
+    n.mu.Unlock()
+    switch {
+    case role == leader:
+      return n.proposeLocal(ctx, command)
+    case leaderID != "":
+      response, err := n.peers[leaderID].Forward(ctx, &pb.ForwardRequest{Command: command})
+      if err != nil {
+        return nil, fmt.Errorf("failed to forward command to leader %s: %v", leaderID, err)
+      }
+      return response.Result, nil
+    }
+    select {
+    case <-time.After(n.heartbeat):
+    case <-ctx.Done():
+      return nil, ErrNoLeader
+    case <-n.stop:
+      return nil, ErrStopped
+    }
+  }
+}
+
+// proposeLocal appends the command to the leader's log and
+// waits until it is applied
+func (n *Node) proposeLocal(ctx context.Context, command []byte) ([]byte, error) {
+  n.mu.Lock()
+  if n.role != leader {
+    n.mu.Unlock()
+    return nil, ErrLeadershipLost
+  }
+  entry := &pb.RaftEntry{Index: n.storage.lastIndex() + 1, Term: n.storage.state.Term, Command: command}
+  if err := n.storage.append(entry); err != nil {
+    n.mu.Unlock()
+    return nil, fmt.Errorf("failed to append command: %v", err)
+  }
+  p := &proposal{term: entry.Term, done: make(chan result, 1)}
+  n.pending[entry.Index] = p
+  n.advanceCommit()
+  n.mu.Unlock()
+  n.triggerReplication()
+  
+  select {
+  case r := <-p.done:
+    return r.value, r.err
+  case <-ctx.Done():
+    n.mu.Lock()
+    delete(n.pending, entry.Index)
+    n.mu.Unlock()
+    return nil, ctx.Err()
+  }
+}
+
+// run ticks every heartbeat until the node is stopped
+func (n *Node) run() {
+  defer n.stopped.Done()
+  ticker := time.NewTicker(n.heartbeat)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-n.stop:
+      return
+    case <-ticker.C:
+      n.tick()
+    case <-n.replicate:
+      n.mu.Lock()
+      if n.role == leader {
+        n.broadcast()
+      }
+      n.mu.Unlock()
+    }
+  }
+}
+
+func (n *Node) tick() {
+  n.mu.Lock()
+  defer n.mu.Unlock()
+  if n.role == leader {
+    n.broadcast()
+    return
+  }
+  if time.Now().After(n.electionDeadline) {
+    n.startPreVote()
+  }
+}
+
+// isStopped reports whether the node was stopped, for
+// responses that arrive after it was
+func (n *Node) isStopped() bool {
+  select {
+  case <-n.stop:
+    return true
+  default:
+    return false
+  }
+}
+
+// triggerReplication has the leader send new entries right away
+// instead of with the next heartbeat
+func (n *Node) triggerReplication() {
+  select {
+  case n.replicate <- struct{}{}:
+  default:
+  }
+}
+
+// resetElectionDeadline picks a random timeout between one and two
+// election timeouts, so nodes rarely stand for election at once.
+// It runs with n.mu held
+func (n *Node) resetElectionDeadline() {
+  timeout := n.electionTimeout + time.Duration(rand.Int63n(int64(n.electionTimeout)))
+  n.electionDeadline = time.Now().Add(timeout)
+}
+
+// quorum is the majority of the nodes
+func (n *Node) quorum() int {
+  return (len(n.peers)+1)/2 + 1
+}
+
+// peerIDs returns the other nodes in a stable order
+func (n *Node) peerIDs() []string {
+  ids := make([]string, 0, len(n.peers))
+  for id := range n.peers {
+    ids = append(ids, id)
+  }
+  sort.Strings(ids)
+  return ids
+}
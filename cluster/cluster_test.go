@@ -0,0 +1,288 @@
+package cluster
+
+import (
+  "context"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net"
+  "os"
+  "path/filepath"
+  "reflect"
+  "sync"
+  "testing"
+  "time"
+  
+  "google.golang.org/grpc"
+)
+
+// testNode is a node with its Raft server and the commands it applied
+type testNode struct {
+  *Node
+  server  *grpc.Server
+  mu      sync.Mutex
+  applied []string
+}
+
+func (n *testNode) commands() []string {
+  n.mu.Lock()
+  defer n.mu.Unlock()
+  return append([]string(nil), n.applied...)
+}
+
+// testCluster runs nodes in-process on localhost ports
+type testCluster struct {
+  t     *testing.T
+  dir   string
+  addrs map[string]string
+  nodes map[string]*testNode
+  // snapshotEntries has the nodes snapshot the commands they
+  // applied, every that many entries; 0 keeps every entry
+  snapshotEntries int
+}
+
+func newTestCluster(t *testing.T, ids ...string) *testCluster {
+  return newSnapshotCluster(t, 0, ids...)
+}
+
+func newSnapshotCluster(t *testing.T, snapshotEntries int, ids ...string) *testCluster {
+  dir, _ := ioutil.TempDir("", "cluster")
+  c := &testCluster{t: t, dir: dir, addrs: make(map[string]string), nodes: make(map[string]*testNode),
+    snapshotEntries: snapshotEntries}
+  listeners := make(map[string]net.Listener)
+  for _, id := range ids {
+    lis, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+      t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+    listeners[id] = lis
+    c.addrs[id] = lis.Addr().String()
+  }
+  for _, id := range ids {
+    c.start(id, listeners[id])
+  }
+  return c
+}
+
+// start runs the node on the listener, or on its address when there is none
+func (c *testCluster) start(id string, lis net.Listener) {
+  if lis == nil {
+    var err error
+    if lis, err = net.Listen("tcp", c.addrs[id]); err != nil {
+      c.t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+  }
+  node := &testNode{server: grpc.NewServer()}
+  conf := Config{
+    ID:              id,
+    Nodes:           c.addrs,
+    Dir:             filepath.Join(c.dir, id),
+    Heartbeat:       10 * time.Millisecond,
+    ElectionTimeout: 100 * time.Millisecond,
+    Dial: func(addr string) (*grpc.ClientConn, error) {
+      return grpc.Dial(addr, grpc.WithInsecure())
+    },
+    Apply: func(command []byte) []byte {
+      node.mu.Lock()
+      defer node.mu.Unlock()
+      node.applied = append(node.applied, string(command))
+      return []byte(fmt.Sprintf("%s applied %s", id, command))
+    },
+  }
+  if c.snapshotEntries > 0 {
+    conf.SnapshotEntries = c.snapshotEntries
+    conf.Snapshot = func() ([]byte, error) {
+      return json.Marshal(node.commands())
+    }
+    conf.Restore = func(snapshot []byte) error {
+      node.mu.Lock()
+      defer node.mu.Unlock()
+      node.applied = nil
+      return json.Unmarshal(snapshot, &node.applied)
+    }
+  }
+  var err error
+  node.Node, err = New(conf)
+  if err != nil {
+    c.t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  node.Register(node.server)
+  go node.server.Serve(lis)
+  node.Start()
+  c.nodes[id] = node
+}
+
+func (c *testCluster) stop(id string) {
+  c.nodes[id].server.Stop()
+  c.nodes[id].Stop()
+  delete(c.nodes, id)
+}
+
+func (c *testCluster) close() {
+  for id := range c.nodes {
+    c.stop(id)
+  }
+  os.RemoveAll(c.dir)
+}
+
+// waitForLeader waits until the running nodes agree on one leader
+func (c *testCluster) waitForLeader() string {
+  deadline := time.Now().Add(5 * time.Second)
+  for time.Now().Before(deadline) {
+    leaders := make(map[string]bool)
+    for _, node := range c.nodes {
+      _, _, leader := node.Status()
+      leaders[leader] = true
+    }
+    for leader := range leaders {
+      if _, running := c.nodes[leader]; running && len(leaders) == 1 {
+        return leader
+      }
+    }
+    time.Sleep(10 * time.Millisecond)
+  }
+  c.t.Fatalf("Got: %v, wanted: %s\n", "no leader", "one leader")
+  return ""
+}
+
+// waitForCommands waits until every running node applied the commands
+func (c *testCluster) waitForCommands(wanted []string) {
+  deadline := time.Now().Add(5 * time.Second)
+  for id, node := range c.nodes {
+    for !reflect.DeepEqual(node.commands(), wanted) && time.Now().Before(deadline) {
+      time.Sleep(10 * time.Millisecond)
+    }
+    if commands := node.commands(); !reflect.DeepEqual(commands, wanted) {
+      c.t.Errorf("%s: Got: %v, wanted: %v\n", id, commands, wanted)
+    }
+  }
+}
+
+func TestParseNodes(t *testing.T) {
+  nodes, err := ParseNodes([]string{"a=localhost:7100", "b=[::1]:7101"})
+  wanted := map[string]string{"a": "localhost:7100", "b": "[::1]:7101"}
+  if err != nil || !reflect.DeepEqual(nodes, wanted) {
+    t.Errorf("Got: %v %v, wanted: %v\n", nodes, err, wanted)
+  }
+  for _, invalid := range [][]string{{"a"}, {"=localhost:7100"}, {"a=x:1", "a=y:2"}} {
+    if _, err := ParseNodes(invalid); err == nil {
+      t.Errorf("Got: %v, wanted: an error for %v\n", err, invalid)
+    }
+  }
+}
+
+func TestNode_Propose(t *testing.T) {
+  c := newTestCluster(t, "a", "b", "c")
+  defer c.close()
+  leader := c.waitForLeader()
+  ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+  defer cancel()
+  
+  // every node takes commands; followers forward them to the leader
+  var wanted []string
+  for _, id := range []string{"a", "b", "c"} {
+    command := "from " + id
+    value, err := c.nodes[id].Propose(ctx, []byte(command))
+    if err != nil || string(value) != leader+" applied "+command {
+      t.Errorf("Got: %s %v, wanted: %s applied %s\n", value, err, leader, command)
+    }
+    wanted = append(wanted, command)
+  }
+  c.waitForCommands(wanted)
+}
+
+func TestNode_Failover(t *testing.T) {
+  c := newTestCluster(t, "a", "b", "c")
+  defer c.close()
+  ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+  defer cancel()
+  first := c.waitForLeader()
+  if _, err := c.nodes[first].Propose(ctx, []byte("one")); err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  
+  // the others elect a new leader and carry on without the old one
+  c.stop(first)
+  second := c.waitForLeader()
+  if second == first {
+    t.Fatalf("Got: %s, wanted: a new leader\n", second)
+  }
+  for id := range c.nodes {
+    if _, err := c.nodes[id].Propose(ctx, []byte("two from "+id)); err != nil {
+      t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+  }
+  applied := c.nodes[second].commands()
+  if len(applied) != 3 || applied[0] != "one" {
+    t.Fatalf("Got: %v, wanted: %s and a command from each node\n", applied, "one")
+  }
+  
+  // the old leader comes back from its directory as a follower and
+  // catches up, applying every command again in the same order
+  c.start(first, nil)
+  c.waitForCommands(applied)
+  if leader := c.waitForLeader(); leader != second {
+    t.Errorf("Got: %s, wanted: %s\n", leader, second)
+  }
+}
+
+func TestNode_NoQuorum(t *testing.T) {
+  c := newTestCluster(t, "a", "b", "c")
+  defer c.close()
+  leader := c.waitForLeader()
+  for id := range c.nodes {
+    if id != leader {
+      c.stop(id)
+    }
+  }
+  
+  // a leader cut off from the majority can't commit
+  ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+  defer cancel()
+  if _, err := c.nodes[leader].Propose(ctx, []byte("lost")); err == nil {
+    t.Errorf("Got: %v, wanted: an error\n", err)
+  }
+  if commands := c.nodes[leader].commands(); len(commands) != 0 {
+    t.Errorf("Got: %v, wanted: %v\n", commands, nil)
+  }
+}
+
+func TestNode_Snapshot(t *testing.T) {
+  c := newSnapshotCluster(t, 4, "a", "b", "c")
+  defer c.close()
+  ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+  defer cancel()
+  leader := c.waitForLeader()
+  var lagging string
+  for id := range c.nodes {
+    if id != leader {
+      lagging = id
+    }
+  }
+  c.stop(lagging)
+  
+  // the leader compacts its log while a follower is down
+  var wanted []string
+  for i := 0; i < 10; i++ {
+    command := fmt.Sprintf("command %d", i)
+    if _, err := c.nodes[leader].Propose(ctx, []byte(command)); err != nil {
+      t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+    wanted = append(wanted, command)
+  }
+  c.nodes[leader].Node.mu.Lock()
+  index, entries := c.nodes[leader].storage.snapshotIndex(), len(c.nodes[leader].storage.entries)
+  c.nodes[leader].Node.mu.Unlock()
+  if index == 0 || entries > 5 {
+    t.Errorf("Got: snapshot at %d and %d entries, wanted: %s\n", index, entries, "a compacted log")
+  }
+  
+  // the follower catches up from the leader's snapshot, and
+  // a node restarts from its snapshot and the entries after it
+  c.start(lagging, nil)
+  c.waitForCommands(wanted)
+  c.stop(leader)
+  c.start(leader, nil)
+  c.waitForLeader()
+  c.waitForCommands(wanted)
+}
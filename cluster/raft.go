@@ -0,0 +1,523 @@
+package cluster
+
+import (
+  "time"
+  
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+// raftServer serves the Raft RPCs of the other nodes
+type raftServer struct {
+  node *Node
+}
+
+// RequestVote grants the candidate the node's vote if the node hasn't
+// voted for another candidate in the term and the candidate's log is
+// at least as up to date as its own
+func (s raftServer) RequestVote(ctx context.Context, request *pb.VoteRequest) (*pb.VoteResponse, error) {
+  n := s.node
+  n.mu.Lock()
+  defer n.mu.Unlock()
+  if request.PreVote {
+    // nodes that still hear from a leader won't help replace it
+    granted := request.Term > n.storage.state.Term && !n.followsLeader() &&
+      n.logUpToDate(request.LastLogIndex, request.LastLogTerm)
+    return &pb.VoteResponse{Term: n.storage.state.Term, Granted: granted}, nil
+  }
+  if request.Term > n.storage.state.Term {
+    if err := n.becomeFollower(request.Term, ""); err != nil {
+      return nil, err
+    }
+  }
+  state := n.storage.state
+  response := &pb.VoteResponse{Term: state.Term}
+  if request.Term < state.Term || (state.VotedFor != "" && state.VotedFor != request.Candidate) {
+    return response, nil
+  }
+  if !n.logUpToDate(request.LastLogIndex, request.LastLogTerm) {
+    return response, nil
+  }
+  if err := n.storage.setState(state.Term, request.Candidate); err != nil {
+    return nil, status.Errorf(codes.Internal, "failed to record vote: %v", err)
+  }
+  n.resetElectionDeadline()
+  n.logger.Debug("voted", "term", state.Term, "candidate", request.Candidate)
+  response.Granted = true
+  return response, nil
+}
+
+// AppendEntries adds the leader's entries to the node's log, replacing
+// entries of older terms that conflict with them, and applies the
+// entries the leader committed
+func (s raftServer) AppendEntries(ctx context.Context, request *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error) {
+  n := s.node
+  n.mu.Lock()
+  defer n.mu.Unlock()
+  if request.Term < n.storage.state.Term {
+    return &pb.AppendEntriesResponse{Term: n.storage.state.Term}, nil
+  }
+  if err := n.followLeader(request.Term, request.Leader); err != nil {
+    return nil, err
+  }
+  response := &pb.AppendEntriesResponse{Term: n.storage.state.Term}
+  
+  // the entries must follow an entry both logs have; otherwise
+  // the leader retries from the first index of the conflicting term
+  if request.PrevLogIndex > n.storage.lastIndex() {
+    response.ConflictIndex = n.storage.lastIndex() + 1
+    return response, nil
+  }
+  if request.PrevLogIndex < n.storage.snapshotIndex() {
+    // the snapshot replaced the entries, which were committed
+    // and so match the leader's; it needs to send what follows
+    response.ConflictIndex = n.storage.snapshotIndex() + 1
+    return response, nil
+  }
+  if conflictTerm := n.storage.term(request.PrevLogIndex); conflictTerm != request.PrevLogTerm {
+    index := request.PrevLogIndex
+    for index > n.commitIndex+1 && n.storage.term(index-1) == conflictTerm {
+      index--
+    }
+    response.ConflictIndex = index
+    return response, nil
+  }
+  
+  for i, entry := range request.Entries {
+    if entry.Index > n.storage.lastIndex() {
+      if err := n.storage.append(request.Entries[i:]...); err != nil {
+        return nil, status.Errorf(codes.Internal, "failed to append entries: %v", err)
+      }
+      break
+    }
+    if n.storage.term(entry.Index) != entry.Term {
+      if err := n.storage.truncate(entry.Index); err != nil {
+        return nil, status.Errorf(codes.Internal, "failed to truncate log: %v", err)
+      }
+      if err := n.storage.append(request.Entries[i:]...); err != nil {
+        return nil, status.Errorf(codes.Internal, "failed to append entries: %v", err)
+      }
+      break
+    }
+  }
+  lastNew := request.PrevLogIndex + uint64(len(request.Entries))
+  if request.LeaderCommit > n.commitIndex {
+    n.commitIndex = request.LeaderCommit
+    if lastNew < n.commitIndex {
+      n.commitIndex = lastNew
+    }
+    n.applyCommitted()
+  }
+  response.Success = true
+  return response, nil
+}
+
+// InstallSnapshot replaces the node's state with the leader's snapshot,
+// which the leader sends once it compacted the entries the node misses.
+// Entries that follow the snapshot are kept if the node has them
+func (s raftServer) InstallSnapshot(ctx context.Context, request *pb.InstallSnapshotRequest) (*pb.InstallSnapshotResponse, error) {
+  n := s.node
+  n.mu.Lock()
+  defer n.mu.Unlock()
+  if request.Term < n.storage.state.Term {
+    return &pb.InstallSnapshotResponse{Term: n.storage.state.Term}, nil
+  }
+  if err := n.followLeader(request.Term, request.Leader); err != nil {
+    return nil, err
+  }
+  response := &pb.InstallSnapshotResponse{Term: n.storage.state.Term}
+  index := request.LastIncludedIndex
+  if index <= n.commitIndex {
+    // the node already applied every entry the snapshot replaced
+    return response, nil
+  }
+  if n.restore != nil {
+    if err := n.restore(request.Data); err != nil {
+      return nil, status.Errorf(codes.Internal, "failed to restore snapshot: %v", err)
+    }
+  }
+  if err := n.storage.compact(index, request.LastIncludedTerm, request.Data); err != nil {
+    return nil, status.Errorf(codes.Internal, "failed to save snapshot: %v", err)
+  }
+  n.commitIndex, n.lastApplied = index, index
+  // proposals of an old leadership the snapshot replaced
+  // won't be applied one by one
+  for i, p := range n.pending {
+    if i <= index {
+      delete(n.pending, i)
+      p.done <- result{err: ErrLeadershipLost}
+    }
+  }
+  n.logger.Info("installed snapshot", "index", index, "term", request.LastIncludedTerm)
+  return response, nil
+}
+
+// Forward proposes a follower's command on the leader
+func (s raftServer) Forward(ctx context.Context, request *pb.ForwardRequest) (*pb.ForwardResponse, error) {
+  value, err := s.node.proposeLocal(ctx, request.Command)
+  switch err {
+  case nil:
+    return &pb.ForwardResponse{Result: value}, nil
+  case ErrLeadershipLost, ErrStopped:
+    return nil, status.Error(codes.Unavailable, err.Error())
+  default:
+    return nil, err
+  }
+}
+
+// followLeader has the node follow the leader of the term, which it
+// just heard from. It runs with n.mu held
+func (n *Node) followLeader(term uint64, leaderID string) error {
+  if term > n.storage.state.Term || n.role != follower {
+    if err := n.becomeFollower(term, leaderID); err != nil {
+      return err
+    }
+  }
+  if n.leaderID != leaderID {
+    n.leaderID = leaderID
+    n.logger.Info("following leader", "term", term, "leader", leaderID)
+  }
+  n.lastContact = time.Now()
+  n.resetElectionDeadline()
+  return nil
+}
+
+// becomeFollower moves the node to the term as a follower of the
+// leader, if it is known. It runs with n.mu held
+func (n *Node) becomeFollower(term uint64, leaderID string) error {
+  votedFor := n.storage.state.VotedFor
+  if term > n.storage.state.Term {
+    votedFor = ""
+  }
+  if err := n.storage.setState(term, votedFor); err != nil {
+    return status.Errorf(codes.Internal, "failed to record term: %v", err)
+  }
+  // a follower only waits again for a leader it hears from or a vote
+  // it grants, so candidates with stale logs can't hold elections off
+  if n.role != follower {
+    n.logger.Info("stepping down", "term", term, "role", roleNames[n.role])
+    n.resetElectionDeadline()
+  }
+  n.role = follower
+  n.leaderID = leaderID
+  return nil
+}
+
+// logUpToDate reports whether a log ending with the given entry is at
+// least as up to date as the node's. It runs with n.mu held
+func (n *Node) logUpToDate(lastIndex, lastTerm uint64) bool {
+  if lastTerm != n.storage.lastTerm() {
+    return lastTerm > n.storage.lastTerm()
+  }
+  return lastIndex >= n.storage.lastIndex()
+}
+
+// followsLeader reports whether the node is the leader or heard from
+// one within the election timeout. It runs with n.mu held
+func (n *Node) followsLeader() bool {
+  if n.role == leader {
+    return true
+  }
+  return n.leaderID != "" && time.Since(n.lastContact) < n.electionTimeout
+}
+
+// startPreVote asks the other nodes whether they would vote for the
+// node in the next term, and only stands for election once a majority
+// would. A node that was cut off from the cluster can't make the leader
+// the others still follow step down by moving to a new term. It runs
+// with n.mu held
+func (n *Node) startPreVote() {
+  n.resetElectionDeadline()
+  if n.quorum() == 1 {
+    n.startElection()
+    return
+  }
+  n.preVoteRound++
+  round, term := n.preVoteRound, n.storage.state.Term
+  request := &pb.VoteRequest{
+    Term:         term + 1,
+    Candidate:    n.id,
+    LastLogIndex: n.storage.lastIndex(),
+    LastLogTerm:  n.storage.lastTerm(),
+    PreVote:      true,
+  }
+  n.requestVotes(request, func() bool {
+    return n.preVoteRound == round && n.role != leader && n.storage.state.Term == term
+  }, n.startElection)
+}
+
+// startElection stands for leader in the next term and asks every
+// other node for its vote. It runs with n.mu held
+func (n *Node) startElection() {
+  term := n.storage.state.Term + 1
+  if err := n.storage.setState(term, n.id); err != nil {
+    n.logger.Error("failed to stand for election", "term", term, "err", err)
+    return
+  }
+  n.role = candidate
+  n.leaderID = ""
+  n.resetElectionDeadline()
+  n.logger.Info("standing for election", "term", term)
+  if n.quorum() == 1 {
+    n.becomeLeader()
+    return
+  }
+  
+  request := &pb.VoteRequest{
+    Term:         term,
+    Candidate:    n.id,
+    LastLogIndex: n.storage.lastIndex(),
+    LastLogTerm:  n.storage.lastTerm(),
+  }
+  n.requestVotes(request, func() bool {
+    return n.role == candidate && n.storage.state.Term == term
+  }, n.becomeLeader)
+}
+
+// requestVotes asks every other node for its vote and calls elected
+// once a majority granted it, as long as the vote is still valid.
+// Both run with n.mu held
+func (n *Node) requestVotes(request *pb.VoteRequest, valid func() bool, elected func()) {
+  votes := 1
+  for _, id := range n.peerIDs() {
+    go func(id string, peer pb.RaftClient) {
+      ctx, cancel := context.WithTimeout(context.Background(), n.electionTimeout)
+      defer cancel()
+      response, err := peer.RequestVote(ctx, request)
+      if err != nil {
+        n.logger.Debug("failed to request vote", "term", request.Term, "peer", id, "err", err)
+        return
+      }
+      n.mu.Lock()
+      defer n.mu.Unlock()
+      if n.isStopped() {
+        return
+      }
+      if response.Term > n.storage.state.Term {
+        n.becomeFollower(response.Term, "")
+        return
+      }
+      if !response.Granted || !valid() {
+        return
+      }
+      votes++
+      if votes == n.quorum() {
+        elected()
+      }
+    }(id, n.peers[id])
+  }
+}
+
+// becomeLeader takes over the cluster and starts its term with an
+// empty entry, which commits the entries of earlier terms once the
+// followers have it. It runs with n.mu held
+func (n *Node) becomeLeader() {
+  n.role = leader
+  n.leaderID = n.id
+  for id := range n.peers {
+    n.nextIndex[id] = n.storage.lastIndex() + 1
+    n.matchIndex[id] = 0
+  }
+  n.logger.Info("elected leader", "term", n.storage.state.Term)
+  entry := &pb.RaftEntry{Index: n.storage.lastIndex() + 1, Term: n.storage.state.Term}
+  if err := n.storage.append(entry); err != nil {
+    n.logger.Error("failed to start term", "term", entry.Term, "err", err)
+    n.becomeFollower(n.storage.state.Term, "")
+    return
+  }
+  n.advanceCommit()
+  n.broadcast()
+}
+
+// broadcast sends every follower the entries it is missing, or a
+// heartbeat, unless an append to it is still in flight. It runs with n.mu held
+func (n *Node) broadcast() {
+  for id := range n.peers {
+    if n.inFlight[id] {
+      continue
+    }
+    n.inFlight[id] = true
+    go n.replicateTo(id)
+  }
+}
+
+// replicateTo sends one append to the follower and
+// updates what the leader knows it has
+func (n *Node) replicateTo(id string) {
+  n.mu.Lock()
+  if n.role != leader {
+    n.inFlight[id] = false
+    n.mu.Unlock()
+    return
+  }
+  if n.nextIndex[id] <= n.storage.snapshotIndex() {
+    n.mu.Unlock()
+    n.installSnapshot(id)
+    return
+  }
+  term := n.storage.state.Term
+  prevIndex := n.nextIndex[id] - 1
+  request := &pb.AppendEntriesRequest{
+    Term:         term,
+    Leader:       n.id,
+    PrevLogIndex: prevIndex,
+    PrevLogTerm:  n.storage.term(prevIndex),
+    Entries:      n.storage.from(prevIndex+1, maxEntries),
+    LeaderCommit: n.commitIndex,
+  }
+  peer := n.peers[id]
+  n.mu.Unlock()
+  
+  ctx, cancel := context.WithTimeout(context.Background(), n.electionTimeout)
+  response, err := peer.AppendEntries(ctx, request)
+  cancel()
+  
+  n.mu.Lock()
+  defer n.mu.Unlock()
+  n.inFlight[id] = false
+  if n.isStopped() {
+    return
+  }
+  if err != nil {
+    n.logger.Debug("failed to append entries", "term", term, "peer", id, "err", err)
+    return
+  }
+  if response.Term > n.storage.state.Term {
+    n.becomeFollower(response.Term, "")
+    return
+  }
+  if n.role != leader || n.storage.state.Term != term {
+    return
+  }
+  if !response.Success {
+    n.nextIndex[id] = response.ConflictIndex
+    if n.nextIndex[id] < 1 {
+      n.nextIndex[id] = 1
+    }
+    n.triggerReplication()
+    return
+  }
+  if match := prevIndex + uint64(len(request.Entries)); match > n.matchIndex[id] {
+    n.matchIndex[id] = match
+  }
+  n.nextIndex[id] = n.matchIndex[id] + 1
+  n.advanceCommit()
+  if n.nextIndex[id] <= n.storage.lastIndex() {
+    n.triggerReplication()
+  }
+}
+
+// installSnapshot sends the follower the snapshot, as the leader
+// compacted the entries it misses, and updates what the leader knows it has
+func (n *Node) installSnapshot(id string) {
+  n.mu.Lock()
+  if n.role != leader {
+    n.inFlight[id] = false
+    n.mu.Unlock()
+    return
+  }
+  term := n.storage.state.Term
+  request := &pb.InstallSnapshotRequest{
+    Term:              term,
+    Leader:            n.id,
+    LastIncludedIndex: n.storage.snapshotIndex(),
+    LastIncludedTerm:  n.storage.term(n.storage.snapshotIndex()),
+    Data:              n.storage.snapshot,
+  }
+  peer := n.peers[id]
+  n.mu.Unlock()
+  
+  ctx, cancel := context.WithTimeout(context.Background(), n.electionTimeout)
+  response, err := peer.InstallSnapshot(ctx, request)
+  cancel()
+  
+  n.mu.Lock()
+  defer n.mu.Unlock()
+  n.inFlight[id] = false
+  if n.isStopped() {
+    return
+  }
+  if err != nil {
+    n.logger.Debug("failed to install snapshot", "term", term, "peer", id, "err", err)
+    return
+  }
+  if response.Term > n.storage.state.Term {
+    n.becomeFollower(response.Term, "")
+    return
+  }
+  if n.role != leader || n.storage.state.Term != term {
+    return
+  }
+  if request.LastIncludedIndex > n.matchIndex[id] {
+    n.matchIndex[id] = request.LastIncludedIndex
+  }
+  n.nextIndex[id] = n.matchIndex[id] + 1
+  n.advanceCommit()
+  n.triggerReplication()
+}
+
+// advanceCommit commits the newest entry of the leader's term that a
+// majority of the nodes has, and everything before it. It runs with n.mu held
+func (n *Node) advanceCommit() {
+  for index := n.storage.lastIndex(); index > n.commitIndex; index-- {
+    if n.storage.term(index) != n.storage.state.Term {
+      break
+    }
+    replicas := 1
+    for id := range n.peers {
+      if n.matchIndex[id] >= index {
+        replicas++
+      }
+    }
+    if replicas >= n.quorum() {
+      n.commitIndex = index
+      n.applyCommitted()
+      return
+    }
+  }
+}
+
+// applyCommitted applies the committed entries in order, hands the
+// results to the proposals waiting for them and compacts the log.
+// It runs with n.mu held
+func (n *Node) applyCommitted() {
+  for n.lastApplied < n.commitIndex {
+    n.lastApplied++
+    entry := n.storage.entry(n.lastApplied)
+    var value []byte
+    if len(entry.Command) > 0 && n.apply != nil {
+      value = n.apply(entry.Command)
+    }
+    p, ok := n.pending[entry.Index]
+    if !ok {
+      continue
+    }
+    delete(n.pending, entry.Index)
+    if p.term == entry.Term {
+      p.done <- result{value: value}
+    } else {
+      p.done <- result{err: ErrLeadershipLost}
+    }
+  }
+  n.compactLog()
+}
+
+// compactLog replaces the applied entries with a snapshot once the
+// log keeps enough of them. It runs with n.mu held
+func (n *Node) compactLog() {
+  if n.snapshot == nil || n.lastApplied-n.storage.snapshotIndex() < n.snapshotEntries {
+    return
+  }
+  index, term := n.lastApplied, n.storage.term(n.lastApplied)
+  snapshot, err := n.snapshot()
+  if err == nil {
+    err = n.storage.compact(index, term, snapshot)
+  }
+  if err != nil {
+    n.logger.Error("failed to compact log", "index", index, "err", err)
+    return
+  }
+  n.logger.Debug("compacted log", "index", index, "term", term)
+}
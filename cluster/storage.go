@@ -0,0 +1,298 @@
+package cluster
+
+import (
+  "bufio"
+  "encoding/json"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  
+  "github.com/salman-ahmad/grpc-streaming/atomicfile"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+)
+
+// files of a node's directory
+const (
+  stateFile    = "raft_state.json"
+  logFile      = "raft_log.jsonl"
+  snapshotFile = "raft_snapshot.json"
+)
+
+// persistentState is what a node must remember before it answers
+// a vote or an append, so it can't vote twice or forget entries
+type persistentState struct {
+  Term     uint64 `json:"term"`
+  VotedFor string `json:"voted_for,omitempty"`
+}
+
+// savedSnapshot is the state the entries up to the index led to
+type savedSnapshot struct {
+  Index uint64 `json:"index"`
+  Term  uint64 `json:"term"`
+  Data  []byte `json:"data"`
+}
+
+// storage keeps a node's term, vote, snapshot and log, in its directory
+// when it has one. entries[0] stands for the last entry the snapshot
+// replaced, or is a sentinel of index 0 without a snapshot
+type storage struct {
+  dir      string
+  state    persistentState
+  snapshot []byte
+  entries  []*pb.RaftEntry
+  // offsets[i] is where entries[i] starts in the log file,
+  // and size is where the file ends
+  offsets []int64
+  size    int64
+  log     *os.File
+}
+
+// openStorage recovers the state kept in the directory;
+// an empty directory keeps everything in memory
+func openStorage(dir string) (*storage, error) {
+  s := &storage{dir: dir, entries: []*pb.RaftEntry{{}}, offsets: []int64{0}}
+  if dir == "" {
+    return s, nil
+  }
+  if err := os.MkdirAll(dir, 0700); err != nil {
+    return nil, err
+  }
+  content, err := ioutil.ReadFile(filepath.Join(dir, stateFile))
+  if err != nil && !os.IsNotExist(err) {
+    return nil, err
+  }
+  if err == nil {
+    if err := json.Unmarshal(content, &s.state); err != nil {
+      return nil, fmt.Errorf("failed to parse %s: %v", stateFile, err)
+    }
+  }
+  content, err = ioutil.ReadFile(filepath.Join(dir, snapshotFile))
+  if err != nil && !os.IsNotExist(err) {
+    return nil, err
+  }
+  if err == nil {
+    var snapshot savedSnapshot
+    if err := json.Unmarshal(content, &snapshot); err != nil {
+      return nil, fmt.Errorf("failed to parse %s: %v", snapshotFile, err)
+    }
+    s.entries[0] = &pb.RaftEntry{Index: snapshot.Index, Term: snapshot.Term}
+    s.snapshot = snapshot.Data
+  }
+  
+  s.log, err = os.OpenFile(filepath.Join(dir, logFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+  if err != nil {
+    return nil, err
+  }
+  reader := bufio.NewReader(s.log)
+  for {
+    line, err := reader.ReadBytes('\n')
+    if err == io.EOF && len(line) == 0 {
+      return s, nil
+    }
+    if err == io.EOF {
+      // a partial last line is an append that never finished,
+      // and was never acknowledged
+      if err := s.cutLog(s.size); err != nil {
+        s.log.Close()
+        return nil, err
+      }
+      return s, nil
+    }
+    if err != nil {
+      s.log.Close()
+      return nil, err
+    }
+    entry := &pb.RaftEntry{}
+    if err := json.Unmarshal(line, entry); err != nil {
+      s.log.Close()
+      return nil, fmt.Errorf("failed to parse entry %d of %s: %v", s.lastIndex()+1, logFile, err)
+    }
+    offset := s.size
+    s.size += int64(len(line))
+    // the log is rewritten after the snapshot, so a crash
+    // in between leaves entries the snapshot replaced
+    if entry.Index <= s.snapshotIndex() {
+      continue
+    }
+    if entry.Index != s.lastIndex()+1 {
+      s.log.Close()
+      return nil, fmt.Errorf("entry %d of %s follows entry %d", entry.Index, logFile, s.lastIndex())
+    }
+    s.entries = append(s.entries, entry)
+    s.offsets = append(s.offsets, offset)
+  }
+}
+
+// snapshotIndex is the last index the snapshot replaced, 0 without one
+func (s *storage) snapshotIndex() uint64 {
+  return s.entries[0].Index
+}
+
+func (s *storage) lastIndex() uint64 {
+  return s.snapshotIndex() + uint64(len(s.entries)-1)
+}
+
+func (s *storage) lastTerm() uint64 {
+  return s.entries[len(s.entries)-1].Term
+}
+
+// term returns the term of the entry at the index,
+// 0 before the snapshot or past the end
+func (s *storage) term(index uint64) uint64 {
+  if index < s.snapshotIndex() || index > s.lastIndex() {
+    return 0
+  }
+  return s.entries[index-s.snapshotIndex()].Term
+}
+
+// entry returns the entry at the index, which must follow the snapshot
+func (s *storage) entry(index uint64) *pb.RaftEntry {
+  return s.entries[index-s.snapshotIndex()]
+}
+
+// from returns up to limit entries starting at the index,
+// none if the snapshot replaced it
+func (s *storage) from(index uint64, limit int) []*pb.RaftEntry {
+  if index <= s.snapshotIndex() || index > s.lastIndex() {
+    return nil
+  }
+  entries := s.entries[index-s.snapshotIndex():]
+  if len(entries) > limit {
+    entries = entries[:limit]
+  }
+  return append([]*pb.RaftEntry(nil), entries...)
+}
+
+// setState records the term and vote, replacing the file so a
+// crash leaves either the old state or the new one
+func (s *storage) setState(term uint64, votedFor string) error {
+  if s.state.Term == term && s.state.VotedFor == votedFor {
+    return nil
+  }
+  s.state = persistentState{Term: term, VotedFor: votedFor}
+  if s.dir == "" {
+    return nil
+  }
+  content, err := json.Marshal(s.state)
+  if err != nil {
+    return err
+  }
+  return atomicfile.Write(filepath.Join(s.dir, stateFile), content, 0600)
+}
+
+// append adds the entries to the end of the log and syncs them
+func (s *storage) append(entries ...*pb.RaftEntry) error {
+  content, offsets, err := s.encode(entries)
+  if err != nil {
+    return err
+  }
+  if s.dir != "" {
+    if _, err := s.log.Write(content); err != nil {
+      return err
+    }
+    if err := s.log.Sync(); err != nil {
+      return err
+    }
+  }
+  s.size += int64(len(content))
+  s.entries = append(s.entries, entries...)
+  s.offsets = append(s.offsets, offsets...)
+  return nil
+}
+
+// encode returns the lines of the entries and where each would start
+// if appended to the log file. Without a directory there are no lines
+func (s *storage) encode(entries []*pb.RaftEntry) ([]byte, []int64, error) {
+  var content []byte
+  offsets := make([]int64, len(entries))
+  for i, entry := range entries {
+    offsets[i] = s.size + int64(len(content))
+    if s.dir == "" {
+      continue
+    }
+    line, err := json.Marshal(entry)
+    if err != nil {
+      return nil, nil, err
+    }
+    content = append(append(content, line...), '\n')
+  }
+  return content, offsets, nil
+}
+
+// truncate drops the entries from the index on, which a new leader's
+// log has replaced, cutting them off the end of the log file
+func (s *storage) truncate(index uint64) error {
+  i := index - s.snapshotIndex()
+  offset := s.offsets[i]
+  s.entries, s.offsets = s.entries[:i], s.offsets[:i]
+  if s.dir == "" {
+    s.size = offset
+    return nil
+  }
+  return s.cutLog(offset)
+}
+
+// cutLog drops the end of the log file from the offset on
+func (s *storage) cutLog(offset int64) error {
+  if err := s.log.Truncate(offset); err != nil {
+    return err
+  }
+  s.size = offset
+  return s.log.Sync()
+}
+
+// compact replaces the entries up to the index, which has the term, with
+// the snapshot of the state they led to. The entries that follow are kept
+// if the log has the same entry at the index, and dropped otherwise
+func (s *storage) compact(index, term uint64, snapshot []byte) error {
+  if s.dir != "" {
+    content, err := json.Marshal(savedSnapshot{Index: index, Term: term, Data: snapshot})
+    if err != nil {
+      return err
+    }
+    if err := atomicfile.Write(filepath.Join(s.dir, snapshotFile), content, 0600); err != nil {
+      return err
+    }
+  }
+  var kept []*pb.RaftEntry
+  if index >= s.snapshotIndex() && s.term(index) == term {
+    kept = s.entries[index-s.snapshotIndex()+1:]
+  }
+  s.snapshot = snapshot
+  s.entries = append([]*pb.RaftEntry{{Index: index, Term: term}}, kept...)
+  return s.rewrite()
+}
+
+// rewrite replaces the log file with the entries in memory
+func (s *storage) rewrite() error {
+  s.size = 0
+  content, offsets, err := s.encode(s.entries[1:])
+  if err != nil {
+    return err
+  }
+  s.size = int64(len(content))
+  s.offsets = append([]int64{0}, offsets...)
+  if s.dir == "" {
+    return nil
+  }
+  path := filepath.Join(s.dir, logFile)
+  if err := atomicfile.Write(path, content, 0600); err != nil {
+    return err
+  }
+  log, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+  if err != nil {
+    return err
+  }
+  s.log.Close()
+  s.log = log
+  return nil
+}
+
+func (s *storage) close() error {
+  if s.log == nil {
+    return nil
+  }
+  return s.log.Close()
+}
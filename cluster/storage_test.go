@@ -0,0 +1,88 @@
+package cluster
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+)
+
+func TestStorage_Reopen(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "raft")
+  defer os.RemoveAll(dir)
+  
+  s, err := openStorage(dir)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  s.setState(3, "b")
+  s.append(&pb.RaftEntry{Index: 1, Term: 1}, &pb.RaftEntry{Index: 2, Term: 2, Command: []byte("x")})
+  s.append(&pb.RaftEntry{Index: 3, Term: 2, Command: []byte("y")})
+  s.truncate(3)
+  s.append(&pb.RaftEntry{Index: 3, Term: 3, Command: []byte("z")})
+  s.close()
+  
+  // an append cut off by a crash is dropped
+  log, _ := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND, 0600)
+  log.WriteString(`{"index":4,"te`)
+  log.Close()
+  
+  s, err = openStorage(dir)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  defer s.close()
+  if s.state.Term != 3 || s.state.VotedFor != "b" {
+    t.Errorf("Got: %+v, wanted: term %d voted for %s\n", s.state, 3, "b")
+  }
+  if s.lastIndex() != 3 || s.lastTerm() != 3 || string(s.entries[3].Command) != "z" || s.term(2) != 2 {
+    t.Errorf("Got: %v, wanted: %d entries ending with %s\n", s.entries, 3, "z")
+  }
+  s.append(&pb.RaftEntry{Index: 4, Term: 3})
+  if content, _ := ioutil.ReadFile(filepath.Join(dir, logFile)); len(content) == 0 || content[len(content)-1] != '\n' {
+    t.Errorf("Got: %q, wanted: %s\n", content, "whole lines")
+  }
+}
+
+func TestStorage_Compact(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "raft")
+  defer os.RemoveAll(dir)
+  
+  s, _ := openStorage(dir)
+  for index := uint64(1); index <= 5; index++ {
+    s.append(&pb.RaftEntry{Index: index, Term: 1})
+  }
+  if err := s.compact(3, 1, []byte("state")); err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  s.close()
+  
+  // the snapshot replaces the entries up to its index, and the log keeps the rest
+  s, err := openStorage(dir)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  defer s.close()
+  if s.snapshotIndex() != 3 || string(s.snapshot) != "state" || s.lastIndex() != 5 {
+    t.Errorf("Got: snapshot %q at %d and %d entries, wanted: %s\n", s.snapshot, s.snapshotIndex(), s.lastIndex(), "state at 3 and 5")
+  }
+  if s.term(2) != 0 || s.term(3) != 1 || len(s.from(3, 10)) != 0 || len(s.from(4, 10)) != 2 {
+    t.Errorf("Got: %v, wanted: %s\n", s.entries, "entries 4 and 5")
+  }
+  s.truncate(5)
+  s.append(&pb.RaftEntry{Index: 5, Term: 2})
+  if s.lastTerm() != 2 || s.entry(4).Index != 4 {
+    t.Errorf("Got: %v, wanted: %s\n", s.entries, "entry 5 of term 2")
+  }
+  
+  // a snapshot past the log replaces all of it
+  s.compact(7, 3, []byte("newer"))
+  if s.lastIndex() != 7 || s.lastTerm() != 3 || len(s.entries) != 1 {
+    t.Errorf("Got: %v, wanted: %s\n", s.entries, "only the snapshot")
+  }
+  if content, _ := ioutil.ReadFile(filepath.Join(dir, logFile)); len(content) != 0 {
+    t.Errorf("Got: %q, wanted: %s\n", content, "an empty log")
+  }
+}
@@ -3,16 +3,81 @@ package config
 import (
   "os/user"
   "strings"
+  "time"
   
   "github.com/kelseyhightower/envconfig"
 )
 
 type Config struct {
-  Port             string `envconfig:"PORT" default:"7000"`
-  PrivateKey       string `envconfig:"PRIVATE_KEY" default:"~/.ssh/maxnumber_rsa_private.pem"`
-  PublicKey        string `envconfig:"PUBLIC_KEY" default:"~/.ssh/maxnumber_rsa_public.pem"`
-  NumbersToSend    int    `envconfig:"TOTAL_NUMBERS" default:"15"`
-  NumberMultiplier int    `envconfig:"NUMBER_MULTIPLIER" default:"100"`
+  Port             string        `envconfig:"PORT" default:"7000"`
+  Listen           []string      `envconfig:"LISTEN"`
+  Servers          []string      `envconfig:"SERVERS"`
+  Balancer         string        `envconfig:"BALANCER" default:"pick_first"`
+  PrivateKey       string        `envconfig:"PRIVATE_KEY" default:"~/.ssh/maxnumber_rsa_private.pem"`
+  PublicKey        string        `envconfig:"PUBLIC_KEY" default:"~/.ssh/maxnumber_rsa_public.pem"`
+  TLS              bool          `envconfig:"TLS" default:"false"`
+  TLSCert          string        `envconfig:"TLS_CERT" default:"~/.ssh/maxnumber_server.crt"`
+  TLSKey           string        `envconfig:"TLS_KEY" default:"~/.ssh/maxnumber_server.key"`
+  TLSCA            string        `envconfig:"TLS_CA"`
+  TLSClientAuth    bool          `envconfig:"TLS_CLIENT_AUTH" default:"false"`
+  TLSClientCert    string        `envconfig:"TLS_CLIENT_CERT"`
+  TLSClientKey     string        `envconfig:"TLS_CLIENT_KEY"`
+  TLSServerName    string        `envconfig:"TLS_SERVER_NAME"`
+  TLSIdentity      string        `envconfig:"TLS_IDENTITY" default:"cn"`
+  TrustStore       string        `envconfig:"TRUST_STORE" default:"~/.ssh/maxnumber_trust_store.json"`
+  StateFile        string        `envconfig:"STATE_FILE" default:"~/.ssh/maxnumber_state.json"`
+  StateSaveDelay   time.Duration `envconfig:"STATE_SAVE_DELAY" default:"1s"`
+  HistorySize      int           `envconfig:"HISTORY_SIZE" default:"1000"`
+  AuditLog         string        `envconfig:"AUDIT_LOG"`
+  ClusterNode      string        `envconfig:"CLUSTER_NODE"`
+  ClusterNodes     []string      `envconfig:"CLUSTER_NODES"`
+  RaftDir          string        `envconfig:"RAFT_DIR"`
+  RaftHeartbeat    time.Duration `envconfig:"RAFT_HEARTBEAT" default:"100ms"`
+  RaftElection     time.Duration `envconfig:"RAFT_ELECTION_TIMEOUT" default:"1s"`
+  RaftSnapshot     int           `envconfig:"RAFT_SNAPSHOT_ENTRIES" default:"1024"`
+  GossipAddr       string        `envconfig:"GOSSIP_ADDR"`
+  GossipPeers      []string      `envconfig:"GOSSIP_PEERS"`
+  GossipInterval   time.Duration `envconfig:"GOSSIP_INTERVAL" default:"1s"`
+  TraceExporter    string        `envconfig:"TRACE_EXPORTER"`
+  TraceEndpoint    string        `envconfig:"TRACE_ENDPOINT" default:"http://localhost:4318/v1/traces"`
+  TraceFile        string        `envconfig:"TRACE_FILE" default:"maxnumber_traces.jsonl"`
+  MetricsAddr      string        `envconfig:"METRICS_ADDR"`
+  GatewayAddr      string        `envconfig:"GATEWAY_ADDR"`
+  GatewayPing      time.Duration `envconfig:"GATEWAY_PING_INTERVAL" default:"30s"`
+  GatewayOrigins   []string      `envconfig:"GATEWAY_ORIGINS"`
+  LogLevel         string        `envconfig:"LOG_LEVEL" default:"info"`
+  LogFormat        string        `envconfig:"LOG_FORMAT" default:"logfmt"`
+  ShutdownTimeout  time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"10s"`
+  Auth             []string      `envconfig:"AUTH"`
+  AuthJWTSecret    string        `envconfig:"AUTH_JWT_SECRET" default:"~/.ssh/maxnumber_jwt_secret"`
+  AuthJWTIssuer    string        `envconfig:"AUTH_JWT_ISSUER"`
+  AuthJWTAudience  string        `envconfig:"AUTH_JWT_AUDIENCE"`
+  AuthTokens       string        `envconfig:"AUTH_TOKENS" default:"~/.ssh/maxnumber_tokens.json"`
+  AuthToken        string        `envconfig:"AUTH_TOKEN"`
+  Policy           string        `envconfig:"POLICY"`
+  Session          string        `envconfig:"SESSION" default:"default"`
+  UseAgent         bool          `envconfig:"USE_AGENT" default:"false"`
+  AgentSocket      string        `envconfig:"AGENT_SOCKET" default:"~/.ssh/maxnumber_agent.sock"`
+  AgentKeyID       string        `envconfig:"AGENT_KEY_ID"`
+  AgentKeys        []string      `envconfig:"AGENT_KEYS" default:"~/.ssh/maxnumber_rsa_private.pem"`
+  AgentPolicy      string        `envconfig:"AGENT_POLICY" default:"~/.ssh/maxnumber_agent_policy.json"`
+  AgentConfirm     string        `envconfig:"AGENT_CONFIRM"`
+  VerifyWorkers    int           `envconfig:"VERIFY_WORKERS" default:"0"`
+  VerifyWindow     int           `envconfig:"VERIFY_WINDOW" default:"64"`
+  Chain            []string      `envconfig:"CHAIN" default:"verify,max"`
+  ReplayCacheSize  int           `envconfig:"REPLAY_CACHE_SIZE" default:"10000"`
+  RangeMin         int64         `envconfig:"RANGE_MIN" default:"-9223372036854775808"`
+  RangeMax         int64         `envconfig:"RANGE_MAX" default:"9223372036854775807"`
+  MaxJump          int64         `envconfig:"MAX_JUMP" default:"1000000"`
+  AllowExpressions []string      `envconfig:"ALLOW_EXPRESSIONS"`
+  RateLimit        float64       `envconfig:"RATE_LIMIT" default:"10"`
+  RateBurst        int           `envconfig:"RATE_BURST" default:"20"`
+  RateLimitBy      string        `envconfig:"RATE_LIMIT_BY" default:"key"`
+  RateLimitMode    string        `envconfig:"RATE_LIMIT_MODE" default:"reject"`
+  DailyQuota       int64         `envconfig:"DAILY_QUOTA" default:"0"`
+  RateLimits       string        `envconfig:"RATE_LIMITS"`
+  NumbersToSend    int           `envconfig:"TOTAL_NUMBERS" default:"15"`
+  NumberMultiplier int           `envconfig:"NUMBER_MULTIPLIER" default:"100"`
 }
 
 func LoadConfig() (*Config, error) {
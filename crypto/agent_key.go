@@ -0,0 +1,102 @@
+package crypto
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net"
+  "time"
+)
+
+const (
+  AgentOpList = "list"
+  AgentOpSign = "sign"
+)
+
+// AgentRequest is sent by clients to the signing agent,
+// one JSON object per connection
+type AgentRequest struct {
+  Op    string `json:"op"`
+  KeyID string `json:"key_id,omitempty"`
+  Data  []byte `json:"data,omitempty"`
+}
+
+// AgentResponse is the agent's reply to an AgentRequest
+type AgentResponse struct {
+  KeyIDs    []string `json:"key_ids,omitempty"`
+  Signature []byte   `json:"signature,omitempty"`
+  Error     string   `json:"error,omitempty"`
+}
+
+// AgentPrivateKey forwards signing to an agent listening on a Unix
+// socket, so the process using it never holds the private key
+type AgentPrivateKey struct {
+  socket  string
+  id      string
+  timeout time.Duration
+}
+
+// NewAgentPrivateKey connects to the agent and checks it holds the key;
+// an empty key ID selects the first key the agent offers
+func NewAgentPrivateKey(socket, keyID string) (PrivateKey, error) {
+  key := &AgentPrivateKey{socket: socket, id: keyID, timeout: time.Minute}
+  response, err := key.call(AgentRequest{Op: AgentOpList})
+  if err != nil {
+    return nil, err
+  }
+  if len(response.KeyIDs) == 0 {
+    return nil, errors.New("agent has no keys")
+  }
+  
+  if keyID == "" {
+    key.id = response.KeyIDs[0]
+    return key, nil
+  }
+  for _, id := range response.KeyIDs {
+    if id == keyID {
+      return key, nil
+    }
+  }
+  return nil, fmt.Errorf("agent does not hold key %s", keyID)
+}
+
+// call sends one request to the agent; the timeout is generous
+// because the agent may be waiting for the user to confirm
+func (a AgentPrivateKey) call(request AgentRequest) (*AgentResponse, error) {
+  conn, err := net.Dial("unix", a.socket)
+  if err != nil {
+    return nil, err
+  }
+  defer conn.Close()
+  if err := conn.SetDeadline(time.Now().Add(a.timeout)); err != nil {
+    return nil, err
+  }
+  
+  if err := json.NewEncoder(conn).Encode(request); err != nil {
+    return nil, err
+  }
+  response := &AgentResponse{}
+  if err := json.NewDecoder(conn).Decode(response); err != nil {
+    return nil, err
+  }
+  if response.Error != "" {
+    return nil, fmt.Errorf("agent: %s", response.Error)
+  }
+  return response, nil
+}
+
+func (a AgentPrivateKey) KeyID() string {
+  return a.id
+}
+
+func (a AgentPrivateKey) Sign(data []byte) ([]byte, error) {
+  response, err := a.call(AgentRequest{Op: AgentOpSign, KeyID: a.id, Data: data})
+  if err != nil {
+    return nil, err
+  }
+  return response.Signature, nil
+}
+
+func (a AgentPrivateKey) Decrypt(data []byte) ([]byte, error) {
+  return nil, errors.New("agent keys do not support decryption")
+}
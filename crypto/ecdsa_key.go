@@ -0,0 +1,128 @@
+package crypto
+
+import (
+  "crypto/ecdsa"
+  "crypto/rand"
+  "crypto/sha256"
+  "crypto/x509"
+  "encoding/asn1"
+  "encoding/pem"
+  "errors"
+  "fmt"
+  "math/big"
+)
+
+func parseECDSAKey(key []byte) (interface{}, error) {
+  block, _ := pem.Decode(key)
+  if block == nil {
+    return nil, errors.New("key not found")
+  }
+  
+  switch block.Type {
+  case "PUBLIC KEY":
+    return x509.ParsePKIXPublicKey(block.Bytes)
+  case "EC PRIVATE KEY":
+    return x509.ParseECPrivateKey(block.Bytes)
+  default:
+    return nil, fmt.Errorf("unsupported key type %s", block.Type)
+  }
+}
+
+type ecdsaSignature struct {
+  R, S *big.Int
+}
+
+type ECDSAPrivateKey struct {
+  *ecdsa.PrivateKey
+  id string
+}
+
+func NewECDSAPrivateKey(key []byte) (PrivateKey, error) {
+  rawKey, err := parseECDSAKey(key)
+  if err != nil {
+    return nil, err
+  }
+  
+  rawKey, err = verifyKeyType(rawKey, &ecdsa.PrivateKey{})
+  if err != nil {
+    return nil, err
+  }
+  
+  ecdsaKey := rawKey.(*ecdsa.PrivateKey)
+  id, err := KeyID(ecdsaKey.Public())
+  if err != nil {
+    return nil, err
+  }
+  return &ECDSAPrivateKey{ecdsaKey, id}, nil
+}
+
+func (e ECDSAPrivateKey) KeyID() string {
+  return e.id
+}
+
+// Sign returns an ASN.1 DER encoded signature of the sha256 digest
+func (e ECDSAPrivateKey) Sign(data []byte) ([]byte, error) {
+  d := sha256.Sum256(data)
+  r, s, err := ecdsa.Sign(rand.Reader, e.PrivateKey, d[:])
+  if err != nil {
+    return nil, err
+  }
+  return asn1.Marshal(ecdsaSignature{r, s})
+}
+
+func (e ECDSAPrivateKey) Decrypt(data []byte) ([]byte, error) {
+  return nil, errors.New("ecdsa keys do not support decryption")
+}
+
+type ECDSAPublicKey struct {
+  *ecdsa.PublicKey
+  id string
+}
+
+func NewECDSAPublicKey(key []byte) (PublicKey, error) {
+  rawKey, err := parseECDSAKey(key)
+  if err != nil {
+    return nil, err
+  }
+  
+  rawKey, err = verifyKeyType(rawKey, &ecdsa.PublicKey{})
+  if err != nil {
+    return nil, err
+  }
+  
+  ecdsaKey := rawKey.(*ecdsa.PublicKey)
+  id, err := KeyID(ecdsaKey)
+  if err != nil {
+    return nil, err
+  }
+  return &ECDSAPublicKey{ecdsaKey, id}, nil
+}
+
+func (e ECDSAPublicKey) KeyID() string {
+  return e.id
+}
+
+func (e ECDSAPublicKey) Verify(data, signature []byte) (bool, error) {
+  var sig ecdsaSignature
+  rest, err := asn1.Unmarshal(signature, &sig)
+  if err != nil {
+    return false, err
+  }
+  if len(rest) != 0 || sig.R == nil || sig.S == nil {
+    return false, errors.New("crypto/ecdsa: malformed signature")
+  }
+  
+  d := sha256.Sum256(data)
+  if ecdsa.Verify(e.PublicKey, d[:], sig.R, sig.S) {
+    return true, nil
+  }
+  return false, errors.New("crypto/ecdsa: verification error")
+}
+
+func (e ECDSAPublicKey) VerifyString(data []byte, signature string) (bool, error) {
+  return e.Verify(data, []byte(signature))
+}
+
+func (e ECDSAPublicKey) Encrypt(data []byte) ([]byte, error) {
+  return nil, errors.New("ecdsa keys do not support encryption")
+}
@@ -1,17 +1,61 @@
 package crypto
 
+import (
+  "crypto/ecdsa"
+  "crypto/rsa"
+  "encoding/pem"
+  "errors"
+  "fmt"
+)
+
 type Key interface {
   Bytes() []byte
   String() string
 }
 
 type PrivateKey interface {
+  KeyID() string
   Sign(data []byte) ([]byte, error)
   Decrypt(data []byte) ([]byte, error)
 }
 
 type PublicKey interface {
+  KeyID() string
   Verify(data, signature []byte) (bool, error)
   VerifyString(data []byte, signature string) (bool, error)
   Encrypt(data []byte) ([]byte, error)
 }
+
+// NewPublicKey reads a PEM encoded public key of any supported algorithm
+func NewPublicKey(key []byte) (PublicKey, error) {
+  rawKey, err := parseRSAKey(key)
+  if err != nil {
+    return nil, err
+  }
+  
+  switch rawKey.(type) {
+  case *rsa.PublicKey:
+    return NewRSAPublicKey(key)
+  case *ecdsa.PublicKey:
+    return NewECDSAPublicKey(key)
+  default:
+    return nil, fmt.Errorf("unsupported key type %T", rawKey)
+  }
+}
+
+// NewPrivateKey reads a PEM encoded private key of any supported algorithm
+func NewPrivateKey(key []byte) (PrivateKey, error) {
+  block, _ := pem.Decode(key)
+  if block == nil {
+    return nil, errors.New("key not found")
+  }
+  
+  switch block.Type {
+  case "RSA PRIVATE KEY":
+    return NewRSAPrivateKey(key)
+  case "EC PRIVATE KEY":
+    return NewECDSAPrivateKey(key)
+  default:
+    return nil, fmt.Errorf("unsupported key type %s", block.Type)
+  }
+}
@@ -0,0 +1,30 @@
+package crypto
+
+import (
+  "crypto/sha256"
+  "crypto/x509"
+  "encoding/base64"
+  "encoding/hex"
+)
+
+// KeyID identifies a public key by the first 8 bytes of the
+// sha256 digest of its DER encoded PKIX form
+func KeyID(publicKey interface{}) (string, error) {
+  der, err := x509.MarshalPKIXPublicKey(publicKey)
+  if err != nil {
+    return "", err
+  }
+  digest := sha256.Sum256(der)
+  return hex.EncodeToString(digest[:8]), nil
+}
+
+// Fingerprint returns the full sha256 digest of the DER encoded
+// PKIX form of a public key, formatted like SHA256:<base64>
+func Fingerprint(publicKey interface{}) (string, error) {
+  der, err := x509.MarshalPKIXPublicKey(publicKey)
+  if err != nil {
+    return "", err
+  }
+  digest := sha256.Sum256(der)
+  return "SHA256:" + base64.RawStdEncoding.EncodeToString(digest[:]), nil
+}
@@ -0,0 +1,64 @@
+package crypto
+
+import (
+  "strings"
+  "testing"
+)
+
+func TestKeyID_MatchesPrivateKey(t *testing.T) {
+  privateKey, _ := NewRSAPrivateKey([]byte(mockPrivateKey()))
+  publicKey, _ := NewRSAPublicKey([]byte(mockPublicKey()))
+  if privateKey.KeyID() != publicKey.KeyID() {
+    t.Errorf("Got: %s, wanted: %s\n", privateKey.KeyID(), publicKey.KeyID())
+  }
+}
+
+func TestKeyID_DiffersBetweenKeys(t *testing.T) {
+  publicKey, _ := NewRSAPublicKey([]byte(mockPublicKey()))
+  wrongPublicKey, _ := NewRSAPublicKey([]byte(mockWrongPublicKey()))
+  if publicKey.KeyID() == wrongPublicKey.KeyID() {
+    t.Errorf("Got: %s for both keys, wanted different ids\n", publicKey.KeyID())
+  }
+}
+
+func TestKeyID_Length(t *testing.T) {
+  publicKey, _ := NewRSAPublicKey([]byte(mockPublicKey()))
+  expected := 16
+  actual := len(publicKey.KeyID())
+  if actual != expected {
+    t.Errorf("Got: %d, wanted: %d\n", actual, expected)
+  }
+}
+
+func TestFingerprint(t *testing.T) {
+  publicKey, _ := NewRSAPublicKey([]byte(mockPublicKey()))
+  actual, err := Fingerprint(publicKey.(*RSAPublicKey).PublicKey)
+  if err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+  if !strings.HasPrefix(actual, "SHA256:") {
+    t.Errorf("Got: %s, wanted: %s\n", actual, "SHA256:<digest>")
+  }
+}
+
+func TestNewPublicKey_DetectsAlgorithm(t *testing.T) {
+  rsaKey, err := NewPublicKey([]byte(mockPublicKey()))
+  if _, ok := rsaKey.(*RSAPublicKey); !ok || err != nil {
+    t.Errorf("Got: %T, wanted: %s\n", rsaKey, "*RSAPublicKey")
+  }
+  ecdsaKey, err := NewPublicKey([]byte(mockECDSAPublicKey()))
+  if _, ok := ecdsaKey.(*ECDSAPublicKey); !ok || err != nil {
+    t.Errorf("Got: %T, wanted: %s\n", ecdsaKey, "*ECDSAPublicKey")
+  }
+}
+
+func TestNewPrivateKey_DetectsAlgorithm(t *testing.T) {
+  rsaKey, err := NewPrivateKey([]byte(mockPrivateKey()))
+  if _, ok := rsaKey.(*RSAPrivateKey); !ok || err != nil {
+    t.Errorf("Got: %T, wanted: %s\n", rsaKey, "*RSAPrivateKey")
+  }
+  ecdsaKey, err := NewPrivateKey([]byte(mockECDSAPrivateKey()))
+  if _, ok := ecdsaKey.(*ECDSAPrivateKey); !ok || err != nil {
+    t.Errorf("Got: %T, wanted: %s\n", ecdsaKey, "*ECDSAPrivateKey")
+  }
+}
@@ -37,6 +37,7 @@ func verifyKeyType(actual, expected interface{}) (interface{}, error) {
 
 type RSAPrivateKey struct {
   *rsa.PrivateKey
+  id string
 }
 
 func NewRSAPrivateKey(key []byte) (PrivateKey, error) {
@@ -51,7 +52,15 @@ func NewRSAPrivateKey(key []byte) (PrivateKey, error) {
   }
   
   rsaKey := rawKey.(*rsa.PrivateKey)
-  return &RSAPrivateKey{rsaKey}, nil
+  id, err := KeyID(rsaKey.Public())
+  if err != nil {
+    return nil, err
+  }
+  return &RSAPrivateKey{rsaKey, id}, nil
+}
+
+func (r RSAPrivateKey) KeyID() string {
+  return r.id
 }
 
 func (r RSAPrivateKey) Sign(data []byte) ([]byte, error) {
@@ -68,6 +77,7 @@ func (r RSAPrivateKey) Decrypt(data []byte) ([]byte, error) {
 
 type RSAPublicKey struct {
   *rsa.PublicKey
+  id string
 }
 
 func NewRSAPublicKey(key []byte) (PublicKey, error) {
@@ -82,7 +92,15 @@ func NewRSAPublicKey(key []byte) (PublicKey, error) {
   }
   
   rsaKey := rawKey.(*rsa.PublicKey)
-  return &RSAPublicKey{rsaKey}, nil
+  id, err := KeyID(rsaKey)
+  if err != nil {
+    return nil, err
+  }
+  return &RSAPublicKey{rsaKey, id}, nil
+}
+
+func (r RSAPublicKey) KeyID() string {
+  return r.id
 }
 
 func (r RSAPublicKey) Verify(data, signature []byte) (bool, error) {
@@ -0,0 +1,105 @@
+package crypto
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "sort"
+  
+  "github.com/salman-ahmad/grpc-streaming/atomicfile"
+)
+
+// TrustedKey is a public key the server accepts signatures from
+type TrustedKey struct {
+  ID        string `json:"id"`
+  Identity  string `json:"identity,omitempty"`
+  PublicKey string `json:"public_key"`
+}
+
+// TrustStore is a JSON file of trusted public keys indexed by key ID
+type TrustStore struct {
+  path string
+  keys map[string]TrustedKey
+}
+
+// LoadTrustStore reads the trust store at the given path;
+// a missing file is treated as an empty trust store
+func LoadTrustStore(path string) (*TrustStore, error) {
+  store := &TrustStore{path: path, keys: make(map[string]TrustedKey)}
+  content, err := ioutil.ReadFile(path)
+  if os.IsNotExist(err) {
+    return store, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  
+  var keys []TrustedKey
+  if err := json.Unmarshal(content, &keys); err != nil {
+    return nil, fmt.Errorf("failed to parse trust store %s: %v", path, err)
+  }
+  for _, key := range keys {
+    store.keys[key.ID] = key
+  }
+  return store, nil
+}
+
+// Add parses the PEM encoded public key and trusts it for the given identity
+func (t *TrustStore) Add(identity string, publicKey []byte) (TrustedKey, error) {
+  key, err := NewPublicKey(publicKey)
+  if err != nil {
+    return TrustedKey{}, err
+  }
+  
+  trusted := TrustedKey{ID: key.KeyID(), Identity: identity, PublicKey: string(publicKey)}
+  t.keys[trusted.ID] = trusted
+  return trusted, nil
+}
+
+// Remove deletes the key with the given ID and reports whether it existed
+func (t *TrustStore) Remove(id string) bool {
+  _, ok := t.keys[id]
+  delete(t.keys, id)
+  return ok
+}
+
+func (t *TrustStore) Get(id string) (TrustedKey, bool) {
+  key, ok := t.keys[id]
+  return key, ok
+}
+
+// Keys returns the trusted keys sorted by key ID
+func (t *TrustStore) Keys() []TrustedKey {
+  keys := make([]TrustedKey, 0, len(t.keys))
+  for _, key := range t.keys {
+    keys = append(keys, key)
+  }
+  sort.Slice(keys, func(i, j int) bool {
+    return keys[i].ID < keys[j].ID
+  })
+  return keys
+}
+
+// PublicKeys parses every trusted key and indexes it by key ID
+func (t *TrustStore) PublicKeys() (map[string]PublicKey, error) {
+  publicKeys := make(map[string]PublicKey, len(t.keys))
+  for id, trusted := range t.keys {
+    key, err := NewPublicKey([]byte(trusted.PublicKey))
+    if err != nil {
+      return nil, fmt.Errorf("failed to parse trusted key %s: %v", id, err)
+    }
+    publicKeys[id] = key
+  }
+  return publicKeys, nil
+}
+
+// Save replaces the trust store, which holds only public keys
+// and may be read by anyone
+func (t *TrustStore) Save() error {
+  content, err := json.MarshalIndent(t.Keys(), "", "  ")
+  if err != nil {
+    return err
+  }
+  return atomicfile.Write(t.path, content, 0644)
+}
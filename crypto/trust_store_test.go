@@ -0,0 +1,81 @@
+package crypto
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func tempTrustStore(t *testing.T) (string, func()) {
+  dir, err := ioutil.TempDir("", "trust_store")
+  if err != nil {
+    t.Fatal(err)
+  }
+  return filepath.Join(dir, "trust_store.json"), func() { os.RemoveAll(dir) }
+}
+
+func TestLoadTrustStore_Missing(t *testing.T) {
+  path, cleanup := tempTrustStore(t)
+  defer cleanup()
+  
+  store, err := LoadTrustStore(path)
+  if err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+  if len(store.Keys()) != 0 {
+    t.Errorf("Got: %d keys, wanted: %d\n", len(store.Keys()), 0)
+  }
+}
+
+func TestTrustStore_SaveAndLoad(t *testing.T) {
+  path, cleanup := tempTrustStore(t)
+  defer cleanup()
+  
+  store, _ := LoadTrustStore(path)
+  rsaKey, _ := store.Add("han", []byte(mockPublicKey()))
+  ecdsaKey, _ := store.Add("chewie", []byte(mockECDSAPublicKey()))
+  if err := store.Save(); err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  
+  loaded, _ := LoadTrustStore(path)
+  publicKeys, err := loaded.PublicKeys()
+  if err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+  for _, expected := range []TrustedKey{rsaKey, ecdsaKey} {
+    actual, ok := loaded.Get(expected.ID)
+    if !ok || actual.Identity != expected.Identity {
+      t.Errorf("Got: %v, wanted: %v\n", actual, expected)
+    }
+    if publicKeys[expected.ID] == nil {
+      t.Errorf("Got: %v, wanted: public key %s\n", nil, expected.ID)
+    }
+  }
+}
+
+func TestTrustStore_Remove(t *testing.T) {
+  path, cleanup := tempTrustStore(t)
+  defer cleanup()
+  
+  store, _ := LoadTrustStore(path)
+  trusted, _ := store.Add("han", []byte(mockPublicKey()))
+  if !store.Remove(trusted.ID) {
+    t.Errorf("Got: %v, wanted: %v\n", false, true)
+  }
+  if store.Remove(trusted.ID) {
+    t.Errorf("Got: %v, wanted: %v\n", true, false)
+  }
+}
+
+func TestTrustStore_AddInvalidKey(t *testing.T) {
+  path, cleanup := tempTrustStore(t)
+  defer cleanup()
+  
+  store, _ := LoadTrustStore(path)
+  _, err := store.Add("vader", []byte(mockPrivateKey()))
+  if err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "error")
+  }
+}
@@ -0,0 +1,182 @@
+package endpoint
+
+import (
+  "fmt"
+  "io/ioutil"
+  "net"
+  "os"
+  "path/filepath"
+  "strings"
+  "syscall"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+)
+
+// Endpoint is an address a server listens on: a TCP host and port,
+// or a Unix domain socket
+type Endpoint struct {
+  // Network is tcp or unix
+  Network string
+  // Address is the host and port, or the socket's path
+  Address string
+  // Plaintext serves the endpoint without TLS even when TLS is on,
+  // e.g. for sidecars on a local socket
+  Plaintext bool
+  // Admin serves the Admin service on the endpoint, and only there
+  Admin bool
+}
+
+// Parse reads an endpoint given as an address, :7000, 127.0.0.1:7000,
+// [::1]:7000 or unix:///run/maxnumber.sock, optionally followed by
+// ?plaintext, ?admin or ?plaintext&admin
+func Parse(spec string) (Endpoint, error) {
+  address, options := spec, ""
+  if i := strings.Index(spec, "?"); i >= 0 {
+    address, options = spec[:i], spec[i+1:]
+  }
+  e := Endpoint{Network: "tcp", Address: address}
+  if path, ok := unixPath(address); ok {
+    if path == "" {
+      return Endpoint{}, fmt.Errorf("endpoint %q has no socket path", spec)
+    }
+    absolutePath, err := config.AbsolutePath(path)
+    if err != nil {
+      return Endpoint{}, fmt.Errorf("failed to calculate socket's absolute path: %v", err)
+    }
+    e.Network, e.Address = "unix", absolutePath
+  } else if _, port, err := net.SplitHostPort(address); err != nil || port == "" {
+    return Endpoint{}, fmt.Errorf("endpoint %q is neither host:port nor unix:///path", spec)
+  }
+  
+  for _, option := range strings.Split(options, "&") {
+    switch option {
+    case "":
+    case "plaintext":
+      e.Plaintext = true
+    case "admin":
+      e.Admin = true
+    default:
+      return Endpoint{}, fmt.Errorf("endpoint %q has unknown option %q", spec, option)
+    }
+  }
+  return e, nil
+}
+
+// unixPath returns the socket path of a unix:///path or unix:path address
+func unixPath(address string) (string, bool) {
+  for _, prefix := range []string{"unix://", "unix:"} {
+    if strings.HasPrefix(address, prefix) {
+      return address[len(prefix):], true
+    }
+  }
+  return "", false
+}
+
+func (e Endpoint) String() string {
+  if e.Network == "unix" {
+    return "unix://" + e.Address
+  }
+  return e.Address
+}
+
+// Target is the address a client on the same host dials
+// to reach the endpoint
+func (e Endpoint) Target() string {
+  if e.Network == "unix" {
+    return e.String()
+  }
+  host, port, _ := net.SplitHostPort(e.Address)
+  if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+    host = "localhost"
+  }
+  return net.JoinHostPort(host, port)
+}
+
+// Listen listens on the endpoint. A socket is created so that only
+// its owner and group can connect
+func (e Endpoint) Listen() (net.Listener, error) {
+  if e.Network != "unix" {
+    return net.Listen(e.Network, e.Address)
+  }
+  return ListenUnix(e.Address, 0660)
+}
+
+// UnixListener listens on a socket that was moved into place;
+// closing it removes the socket
+type UnixListener struct {
+  *net.UnixListener
+  path string
+}
+
+// Addr is the socket's path, not the one it was created at
+func (l *UnixListener) Addr() net.Addr {
+  return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+func (l *UnixListener) Close() error {
+  err := l.UnixListener.Close()
+  os.Remove(l.path)
+  return err
+}
+
+// ListenUnix listens on a socket at the path with the given mode. The
+// umask is shared by the whole process, so the socket is created in a
+// private directory and only moved to the path once restricted, leaving
+// no moment in which others can connect. It replaces the socket of a
+// process that didn't shut down, but not the socket of one that still runs
+func ListenUnix(path string, mode os.FileMode) (*UnixListener, error) {
+  if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+    conn, err := net.DialTimeout("unix", path, time.Second)
+    if err == nil {
+      conn.Close()
+      return nil, fmt.Errorf("socket %s is in use by another process", path)
+    }
+    if !refused(err) {
+      return nil, fmt.Errorf("failed to check socket %s: %v", path, err)
+    }
+    if err := os.Remove(path); err != nil {
+      return nil, fmt.Errorf("failed to remove stale socket: %v", err)
+    }
+  }
+  
+  dir, err := ioutil.TempDir(filepath.Dir(path), ".socket")
+  if err != nil {
+    return nil, fmt.Errorf("failed to create private directory for socket: %v", err)
+  }
+  defer os.RemoveAll(dir)
+  private := filepath.Join(dir, filepath.Base(path))
+  lis, err := net.ListenUnix("unix", &net.UnixAddr{Name: private, Net: "unix"})
+  if err != nil {
+    return nil, err
+  }
+  lis.SetUnlinkOnClose(false)
+  if err := os.Chmod(private, mode); err != nil {
+    lis.Close()
+    return nil, fmt.Errorf("failed to restrict socket: %v", err)
+  }
+  if err := os.Rename(private, path); err != nil {
+    lis.Close()
+    return nil, fmt.Errorf("failed to move socket into place: %v", err)
+  }
+  return &UnixListener{UnixListener: lis, path: path}, nil
+}
+
+// refused reports whether dialing failed because nothing listens on the socket
+func refused(err error) bool {
+  if opErr, ok := err.(*net.OpError); ok {
+    if sysErr, ok := opErr.Err.(*os.SyscallError); ok {
+      return sysErr.Err == syscall.ECONNREFUSED
+    }
+  }
+  return false
+}
+
+// Dial connects to an address a client was given, a host and port
+// or unix:///path, for grpc.WithDialer
+func Dial(address string, timeout time.Duration) (net.Conn, error) {
+  if path, ok := unixPath(address); ok {
+    return net.DialTimeout("unix", path, timeout)
+  }
+  return net.DialTimeout("tcp", address, timeout)
+}
@@ -0,0 +1,104 @@
+package endpoint
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+)
+
+func TestParse(t *testing.T) {
+  tests := []struct {
+    spec     string
+    expected Endpoint
+    target   string
+  }{
+    {":7000", Endpoint{Network: "tcp", Address: ":7000"}, "localhost:7000"},
+    {"0.0.0.0:7000?admin", Endpoint{Network: "tcp", Address: "0.0.0.0:7000", Admin: true}, "localhost:7000"},
+    {"[::1]:7000", Endpoint{Network: "tcp", Address: "[::1]:7000"}, "[::1]:7000"},
+    {"[::]:7000", Endpoint{Network: "tcp", Address: "[::]:7000"}, "localhost:7000"},
+    {"unix:///run/max.sock?plaintext&admin", Endpoint{Network: "unix", Address: "/run/max.sock", Plaintext: true,
+      Admin: true}, "unix:///run/max.sock"},
+  }
+  for _, test := range tests {
+    e, err := Parse(test.spec)
+    if err != nil || e != test.expected || e.Target() != test.target {
+      t.Errorf("%s: Got: %+v %s %v, wanted: %+v %s\n", test.spec, e, e.Target(), err, test.expected, test.target)
+    }
+  }
+  for _, invalid := range []string{"7000", "localhost", "unix://", ":7000?public"} {
+    if _, err := Parse(invalid); err == nil {
+      t.Errorf("Got: %v, wanted: an error for %s\n", err, invalid)
+    }
+  }
+}
+
+func TestListenDial(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "endpoint")
+  defer os.RemoveAll(dir)
+  e, err := Parse("unix://" + filepath.Join(dir, "max.sock"))
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  
+  // a socket left behind by a server that didn't shut down is replaced
+  stale, err := e.Listen()
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  stale.(*UnixListener).UnixListener.Close()
+  lis, err := e.Listen()
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  defer lis.Close()
+  if lis.Addr().String() != e.Address {
+    t.Errorf("Got: %s, wanted: %s\n", lis.Addr(), e.Address)
+  }
+  if info, _ := os.Stat(e.Address); info.Mode().Perm() != 0660 {
+    t.Errorf("Got: %v, wanted: %v\n", info.Mode().Perm(), os.FileMode(0660))
+  }
+  conn, err := Dial(e.Target(), time.Second)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  conn.Close()
+  
+  // the socket of a server that still runs is kept
+  if _, err := e.Listen(); err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "error")
+  }
+  if conn, err := Dial(e.Target(), time.Second); err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  } else {
+    conn.Close()
+  }
+  
+  // the socket is removed when the listener closes, with its private directory
+  lis.Close()
+  if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+    t.Errorf("Got: %d files, wanted: %d\n", len(files), 0)
+  }
+}
+
+func TestServerTarget(t *testing.T) {
+  tests := []struct {
+    conf     config.Config
+    expected string
+  }{
+    {config.Config{Port: "7000"}, "localhost:7000"},
+    {config.Config{Port: "7000", Listen: []string{"127.0.0.1:7001?admin", "unix:///run/max.sock?plaintext"}},
+      "unix:///run/max.sock"},
+    {config.Config{Port: "7000", Servers: []string{"10.0.0.1:7000"}, Listen: []string{":7001"}}, "10.0.0.1:7000"},
+    {config.Config{Servers: []string{"10.0.0.1:7000", "10.0.0.2:7000"}}, "static:///10.0.0.1:7000,10.0.0.2:7000"},
+  }
+  for _, test := range tests {
+    target, err := ServerTarget(&test.conf)
+    if err != nil || target != test.expected {
+      t.Errorf("Got: %s %v, wanted: %s\n", target, err, test.expected)
+    }
+  }
+}
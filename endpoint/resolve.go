@@ -0,0 +1,110 @@
+package endpoint
+
+import (
+  "fmt"
+  "strings"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+  // registers the health checks balancers run on their servers
+  _ "google.golang.org/grpc/health"
+  "google.golang.org/grpc/resolver"
+  "google.golang.org/grpc/resolver/dns"
+)
+
+// staticScheme resolves a comma separated list of server
+// addresses, e.g. static:///10.0.0.1:7000,10.0.0.2:7000
+const staticScheme = "static"
+
+// healthCheckConfig has balancers that check health, like round_robin,
+// only pick servers that report serving, so servers shutting down
+// stop getting new calls
+const healthCheckConfig = `{"healthCheckConfig": {"serviceName": ""}}`
+
+func init() {
+  resolver.Register(staticBuilder{})
+  resolver.Register(dnsBuilder{dns.NewBuilder()})
+}
+
+// ServerTarget is what clients of the server dial: the one server
+// configured, which may be a name like dns:///host:port that resolves
+// to several, every server configured, or else the local server on the
+// first address it listens on that isn't for admin, or on the port
+func ServerTarget(conf *config.Config) (string, error) {
+  switch len(conf.Servers) {
+  case 0:
+  case 1:
+    return conf.Servers[0], nil
+  default:
+    return staticScheme + ":///" + strings.Join(conf.Servers, ","), nil
+  }
+  for _, spec := range conf.Listen {
+    e, err := Parse(spec)
+    if err != nil {
+      return "", err
+    }
+    if !e.Admin {
+      return e.Target(), nil
+    }
+  }
+  return "localhost:" + conf.Port, nil
+}
+
+type staticBuilder struct{}
+
+func (staticBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOption) (resolver.Resolver, error) {
+  var addrs []resolver.Address
+  for _, addr := range strings.Split(target.Endpoint, ",") {
+    if addr = strings.TrimSpace(addr); addr != "" {
+      addrs = append(addrs, resolver.Address{Addr: addr})
+    }
+  }
+  if len(addrs) == 0 {
+    return nil, fmt.Errorf("no server addresses in %q", target.Endpoint)
+  }
+  // the health checks must be configured before
+  // the balancer connects to the servers
+  if !opts.DisableServiceConfig {
+    cc.NewServiceConfig(healthCheckConfig)
+  }
+  cc.NewAddress(addrs)
+  return staticResolver{}, nil
+}
+
+func (staticBuilder) Scheme() string {
+  return staticScheme
+}
+
+// staticResolver never changes its addresses
+type staticResolver struct{}
+
+func (staticResolver) ResolveNow(resolver.ResolveNowOption) {}
+
+func (staticResolver) Close() {}
+
+// dnsBuilder is gRPC's dns resolver with the health checks of
+// healthCheckConfig, unless the name's TXT record has a service config
+type dnsBuilder struct {
+  resolver.Builder
+}
+
+func (b dnsBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOption) (resolver.Resolver, error) {
+  if opts.DisableServiceConfig {
+    return b.Builder.Build(target, cc, opts)
+  }
+  // names that are addresses never get a service config from the resolver
+  cc.NewServiceConfig(healthCheckConfig)
+  return b.Builder.Build(target, healthCheckClientConn{cc}, opts)
+}
+
+// healthCheckClientConn replaces the empty service config
+// of a name without a TXT record with healthCheckConfig
+type healthCheckClientConn struct {
+  resolver.ClientConn
+}
+
+func (cc healthCheckClientConn) NewServiceConfig(serviceConfig string) {
+  if serviceConfig == "" {
+    serviceConfig = healthCheckConfig
+  }
+  cc.ClientConn.NewServiceConfig(serviceConfig)
+}
@@ -0,0 +1,49 @@
+package endpoint
+
+import (
+  "crypto/tls"
+  "crypto/x509"
+  "fmt"
+  "io/ioutil"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+)
+
+// ClientTLSConfig is the client side of TLS: it trusts the configured
+// CA, or the system roots without one, and presents the client
+// certificate when one is configured
+func ClientTLSConfig(conf *config.Config) (*tls.Config, error) {
+  tlsConfig := &tls.Config{ServerName: conf.TLSServerName, MinVersion: tls.VersionTLS12}
+  
+  if conf.TLSCA != "" {
+    caPath, err := config.AbsolutePath(conf.TLSCA)
+    if err != nil {
+      return nil, err
+    }
+    content, err := ioutil.ReadFile(caPath)
+    if err != nil {
+      return nil, err
+    }
+    tlsConfig.RootCAs = x509.NewCertPool()
+    if !tlsConfig.RootCAs.AppendCertsFromPEM(content) {
+      return nil, fmt.Errorf("no certificates found in %s", caPath)
+    }
+  }
+  
+  if conf.TLSClientCert != "" {
+    certPath, err := config.AbsolutePath(conf.TLSClientCert)
+    if err != nil {
+      return nil, err
+    }
+    keyPath, err := config.AbsolutePath(conf.TLSClientKey)
+    if err != nil {
+      return nil, err
+    }
+    cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+    if err != nil {
+      return nil, err
+    }
+    tlsConfig.Certificates = []tls.Certificate{cert}
+  }
+  return tlsConfig, nil
+}
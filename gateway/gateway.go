@@ -0,0 +1,126 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "os"
+  "os/signal"
+  "syscall"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/endpoint"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "github.com/salman-ahmad/grpc-streaming/rest"
+  "github.com/salman-ahmad/grpc-streaming/trace"
+  "golang.org/x/net/context"
+)
+
+// defaultAddr is where the gateway listens without GRPC_GATEWAY_ADDR
+const defaultAddr = ":8080"
+
+func main() {
+  
+  if err := run(); err != nil {
+    logging.Error("gateway failed", "err", err)
+    os.Exit(1)
+  }
+}
+
+// run serves the REST gateway for the server until it is told to stop
+func run() error {
+  conf, err := loadConfig()
+  if err != nil {
+    return err
+  }
+  if err := startLogging(conf); err != nil {
+    return fmt.Errorf("failed to configure logging: %v", err)
+  }
+  tracer, err := startTracing(conf)
+  if err != nil {
+    return err
+  }
+  defer tracer.Shutdown()
+  
+  target, err := endpoint.ServerTarget(conf)
+  if err != nil {
+    return fmt.Errorf("failed to find server: %v", err)
+  }
+  conn, err := rest.Dial(target, conf)
+  if err != nil {
+    return fmt.Errorf("failed to connect to server: %v", err)
+  }
+  defer conn.Close()
+  addr := conf.GatewayAddr
+  if addr == "" {
+    addr = defaultAddr
+  }
+  lis, scheme, err := rest.Listen(addr, conf)
+  if err != nil {
+    return fmt.Errorf("failed to listen: %v", err)
+  }
+  gateway := rest.New(pb.NewSimpleClient(conn))
+  gateway.PingInterval = conf.GatewayPing
+  gateway.Origins = conf.GatewayOrigins
+  gatewayServer := &http.Server{Handler: gateway}
+  serveErr := make(chan error, 1)
+  go func() {
+    serveErr <- gatewayServer.Serve(lis)
+  }()
+  logging.Info("serving REST gateway", "url", scheme+"://"+lis.Addr().String()+rest.MaxPath, "server", target)
+  
+  signals := make(chan os.Signal, 1)
+  signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+  select {
+  case err := <-serveErr:
+    return fmt.Errorf("failed to serve: %v", err)
+  case sig := <-signals:
+    logging.Info("shutting down", "signal", sig)
+  }
+  ctx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+  defer cancel()
+  return gatewayServer.Shutdown(ctx)
+}
+
+func loadConfig() (*config.Config, error) {
+  logging.Debug("loadConfig()")
+  conf, err := config.LoadConfig()
+  if err != nil {
+    return nil, fmt.Errorf("failed to read configuration: %v", err)
+  }
+  logging.Debug("processed configuration")
+  return conf, nil
+}
+
+// startLogging makes a logger with the configured format and
+// level the default logger of the gateway
+func startLogging(conf *config.Config) error {
+  level, err := logging.ParseLevel(conf.LogLevel)
+  if err != nil {
+    return err
+  }
+  logger, err := logging.New(os.Stderr, conf.LogFormat, level)
+  if err != nil {
+    return err
+  }
+  logging.SetDefault(logger)
+  return nil
+}
+
+// startTracing makes the configured exporter the destination
+// of the gateway's spans
+func startTracing(conf *config.Config) (*trace.Tracer, error) {
+  logging.Debug("startTracing()")
+  traceFile, err := config.AbsolutePath(conf.TraceFile)
+  if err != nil {
+    return nil, fmt.Errorf("failed to calculate trace file's absolute path: %v", err)
+  }
+  exporter, err := trace.NewExporter(conf.TraceExporter, conf.TraceEndpoint, traceFile)
+  if err != nil {
+    return nil, fmt.Errorf("failed to start tracing: %v", err)
+  }
+  
+  tracer := trace.NewTracer("maxnumber-gateway", exporter)
+  trace.SetDefault(tracer)
+  return tracer, nil
+}
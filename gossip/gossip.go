@@ -0,0 +1,203 @@
+package gossip
+
+import (
+  "fmt"
+  "sync"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "github.com/salman-ahmad/grpc-streaming/state"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc"
+)
+
+// DefaultInterval is the time between two rounds of anti-entropy
+const DefaultInterval = time.Second
+
+// Config describes a server and the peers it exchanges maxima with
+type Config struct {
+  // ID names the server in its peers' logs
+  ID string
+  // Peers are the addresses the other servers serve PeerSync on
+  Peers    []string
+  Interval time.Duration
+  // Dial connects to a peer
+  Dial func(addr string) (*grpc.ClientConn, error)
+  // Sessions keeps the maxima the server exchanges and merges
+  Sessions *state.Store
+}
+
+// Node exchanges the maxima of a server's sessions with its peers. A
+// session's maximum only ever moves up the order of state.Supersedes,
+// so servers accept numbers on their own and merge what their peers
+// have in any order, and every server that hears from the others,
+// directly or through a peer, ends up with the same maxima
+type Node struct {
+  id       string
+  peers    map[string]pb.PeerSyncClient
+  conns    []*grpc.ClientConn
+  sessions *state.Store
+  interval time.Duration
+  logger   *logging.Logger
+  
+  mu sync.Mutex
+  // unreachable are the peers the last round failed to reach
+  unreachable map[string]bool
+  
+  stop    chan struct{}
+  stopped sync.WaitGroup
+}
+
+// New connects the server to its peers. It exchanges maxima with
+// them once it is started
+func New(conf Config) (*Node, error) {
+  if conf.Interval <= 0 {
+    conf.Interval = DefaultInterval
+  }
+  n := &Node{
+    id:          conf.ID,
+    peers:       make(map[string]pb.PeerSyncClient),
+    sessions:    conf.Sessions,
+    interval:    conf.Interval,
+    logger:      logging.With("node", conf.ID),
+    unreachable: make(map[string]bool),
+    stop:        make(chan struct{}),
+  }
+  for _, addr := range conf.Peers {
+    if _, ok := n.peers[addr]; ok {
+      n.closeConns()
+      return nil, fmt.Errorf("peer %s is listed twice", addr)
+    }
+    conn, err := conf.Dial(addr)
+    if err != nil {
+      n.closeConns()
+      return nil, fmt.Errorf("failed to connect to peer %s: %v", addr, err)
+    }
+    n.conns = append(n.conns, conn)
+    n.peers[addr] = pb.NewPeerSyncClient(conn)
+  }
+  return n, nil
+}
+
+// Register serves the node's PeerSync service on the server
+func (n *Node) Register(server *grpc.Server) {
+  pb.RegisterPeerSyncServer(server, syncServer{n})
+}
+
+// Start runs a round of anti-entropy with every peer each interval
+func (n *Node) Start() {
+  n.logger.Info("starting gossip", "peers", len(n.peers), "interval", n.interval)
+  n.stopped.Add(1)
+  go n.run()
+}
+
+// Stop ends the rounds and closes the connections to the peers
+func (n *Node) Stop() error {
+  close(n.stop)
+  n.stopped.Wait()
+  n.closeConns()
+  return nil
+}
+
+func (n *Node) closeConns() {
+  for _, conn := range n.conns {
+    conn.Close()
+  }
+}
+
+func (n *Node) run() {
+  defer n.stopped.Done()
+  ticker := time.NewTicker(n.interval)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-n.stop:
+      return
+    case <-ticker.C:
+      n.round()
+    }
+  }
+}
+
+// round sends the server's maxima to every peer and merges the
+// ones the peer answers with, so after a round the server and every
+// peer it reached have the larger of their maxima
+func (n *Node) round() {
+  ctx, cancel := context.WithTimeout(context.Background(), n.interval)
+  defer cancel()
+  request := &pb.SyncRequest{Node: n.id, Maxima: toPeerMaxima(n.sessions.Sessions())}
+  var wg sync.WaitGroup
+  for addr, peer := range n.peers {
+    wg.Add(1)
+    go func(addr string, peer pb.PeerSyncClient) {
+      defer wg.Done()
+      response, err := peer.Sync(ctx, request)
+      n.reached(addr, err)
+      if err != nil {
+        return
+      }
+      if merged := n.merge(response.Maxima); merged > 0 {
+        n.logger.Debug("merged peer's maxima", "peer", response.Node, "addr", addr, "merged", merged)
+      }
+    }(addr, peer)
+  }
+  wg.Wait()
+}
+
+// reached logs when a peer becomes unreachable and when it is reached
+// again, rather than every round it stays that way
+func (n *Node) reached(addr string, err error) {
+  n.mu.Lock()
+  defer n.mu.Unlock()
+  switch {
+  case err != nil && !n.unreachable[addr]:
+    n.unreachable[addr] = true
+    n.logger.Warn("failed to sync with peer", "addr", addr, "err", err)
+  case err == nil && n.unreachable[addr]:
+    delete(n.unreachable, addr)
+    n.logger.Info("synced with peer again", "addr", addr)
+  }
+}
+
+// merge merges the maxima into the sessions and returns
+// how many of them superseded the server's
+func (n *Node) merge(maxima []*pb.PeerMax) int {
+  merged := 0
+  for _, max := range maxima {
+    if max.Session == "" {
+      continue
+    }
+    if _, ok := n.sessions.Merge(fromPeerMax(max)); ok {
+      merged++
+    }
+  }
+  return merged
+}
+
+func toPeerMaxima(sessions []state.Session) []*pb.PeerMax {
+  maxima := make([]*pb.PeerMax, 0, len(sessions))
+  for _, session := range sessions {
+    if session.Version == 0 {
+      continue
+    }
+    maxima = append(maxima, &pb.PeerMax{
+      Session:   session.Name,
+      Max:       session.Max,
+      UpdatedBy: session.UpdatedBy,
+      Sequence:  session.Sequence,
+      UpdatedAt: session.UpdatedAt.UnixNano(),
+    })
+  }
+  return maxima
+}
+
+func fromPeerMax(max *pb.PeerMax) state.Session {
+  return state.Session{
+    Name:      max.Session,
+    Max:       max.Max,
+    UpdatedBy: max.UpdatedBy,
+    Sequence:  max.Sequence,
+    UpdatedAt: time.Unix(0, max.UpdatedAt).UTC(),
+  }
+}
@@ -0,0 +1,183 @@
+package gossip
+
+import (
+  "context"
+  "net"
+  "reflect"
+  "sync"
+  "testing"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/state"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+// testNode is a node with its store and server
+type testNode struct {
+  *Node
+  sessions *state.Store
+  server   *grpc.Server
+}
+
+// testNetwork runs nodes in-process on localhost ports and
+// cuts the links between the nodes it partitions
+type testNetwork struct {
+  t     *testing.T
+  mu    sync.Mutex
+  ids   map[string]string
+  cut   map[[2]string]bool
+  nodes map[string]*testNode
+}
+
+func newTestNetwork(t *testing.T, ids ...string) *testNetwork {
+  network := &testNetwork{t: t, ids: make(map[string]string), cut: make(map[[2]string]bool),
+    nodes: make(map[string]*testNode)}
+  listeners := make(map[string]net.Listener)
+  for _, id := range ids {
+    lis, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+      t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+    listeners[id] = lis
+    network.ids[lis.Addr().String()] = id
+  }
+  for _, id := range ids {
+    network.start(id, listeners[id])
+  }
+  return network
+}
+
+func (network *testNetwork) start(id string, lis net.Listener) {
+  var peers []string
+  for addr, peer := range network.ids {
+    if peer != id {
+      peers = append(peers, addr)
+    }
+  }
+  sessions, _ := state.Open("")
+  node, err := New(Config{
+    ID:       id,
+    Peers:    peers,
+    Interval: 10 * time.Millisecond,
+    Sessions: sessions,
+    Dial: func(addr string) (*grpc.ClientConn, error) {
+      return grpc.Dial(addr, grpc.WithInsecure(), grpc.WithUnaryInterceptor(network.link(id, network.ids[addr])))
+    },
+  })
+  if err != nil {
+    network.t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  server := grpc.NewServer()
+  node.Register(server)
+  go server.Serve(lis)
+  node.Start()
+  network.nodes[id] = &testNode{Node: node, sessions: sessions, server: server}
+}
+
+// link fails the calls from one node to another while they are partitioned
+func (network *testNetwork) link(from, to string) grpc.UnaryClientInterceptor {
+  return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+    invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+    network.mu.Lock()
+    cut := network.cut[[2]string{from, to}]
+    network.mu.Unlock()
+    if cut {
+      return status.Errorf(codes.Unavailable, "%s is partitioned from %s", from, to)
+    }
+    return invoker(ctx, method, req, reply, cc, opts...)
+  }
+}
+
+// partition cuts the links between the groups of nodes; no groups heal the network
+func (network *testNetwork) partition(groups ...[]string) {
+  network.mu.Lock()
+  defer network.mu.Unlock()
+  network.cut = make(map[[2]string]bool)
+  for i, group := range groups {
+    for j, other := range groups {
+      if i == j {
+        continue
+      }
+      for _, from := range group {
+        for _, to := range other {
+          network.cut[[2]string{from, to}] = true
+        }
+      }
+    }
+  }
+}
+
+func (network *testNetwork) close() {
+  for _, node := range network.nodes {
+    node.server.Stop()
+    node.Stop()
+  }
+}
+
+// maxima returns the sessions of a node without their versions,
+// which every node counts for itself
+func maxima(node *testNode) []state.Session {
+  sessions := node.sessions.Sessions()
+  for i := range sessions {
+    sessions[i].Version = 0
+  }
+  return sessions
+}
+
+// waitForMaxima waits until each of the nodes has the maxima
+func (network *testNetwork) waitForMaxima(wanted []state.Session, ids ...string) {
+  deadline := time.Now().Add(5 * time.Second)
+  for _, id := range ids {
+    node := network.nodes[id]
+    for !reflect.DeepEqual(maxima(node), wanted) && time.Now().Before(deadline) {
+      time.Sleep(10 * time.Millisecond)
+    }
+    if got := maxima(node); !reflect.DeepEqual(got, wanted) {
+      network.t.Errorf("%s: Got: %+v, wanted: %+v\n", id, got, wanted)
+    }
+  }
+}
+
+func TestNode_Converge(t *testing.T) {
+  network := newTestNetwork(t, "a", "b", "c")
+  defer network.close()
+  
+  // every node takes numbers on its own
+  empire, _ := network.nodes["a"].sessions.Offer("empire", 3, "vader", 1)
+  network.nodes["b"].sessions.Offer("rebels", 5, "luke", 1)
+  rebels, _ := network.nodes["c"].sessions.Offer("rebels", 9, "leia", 2)
+  empire.Version, rebels.Version = 0, 0
+  network.waitForMaxima([]state.Session{empire, rebels}, "a", "b", "c")
+  
+  // merged maxima count as updates, and smaller ones are ignored
+  if session, _ := network.nodes["c"].sessions.Get("rebels"); session.Version != 1 {
+    t.Errorf("Got: %d, wanted: %d\n", session.Version, 1)
+  }
+  if session, _ := network.nodes["b"].sessions.Get("rebels"); session.Version != 2 {
+    t.Errorf("Got: %d, wanted: %d\n", session.Version, 2)
+  }
+}
+
+func TestNode_PartitionHeal(t *testing.T) {
+  network := newTestNetwork(t, "a", "b", "c")
+  defer network.close()
+  network.partition([]string{"a"}, []string{"b", "c"})
+  
+  // both sides keep taking numbers and converge among themselves
+  empire, _ := network.nodes["a"].sessions.Offer("empire", 3, "vader", 1)
+  network.nodes["a"].sessions.Offer("rebels", 10, "han", 1)
+  rebels, _ := network.nodes["b"].sessions.Offer("rebels", 20, "luke", 1)
+  network.nodes["c"].sessions.Offer("rebels", 20, "leia", 1)
+  empire.Version, rebels.Version = 0, 0
+  network.waitForMaxima([]state.Session{rebels}, "b", "c")
+  if session, _ := network.nodes["a"].sessions.Get("rebels"); session.Max != 10 {
+    t.Errorf("Got: %d, wanted: %d\n", session.Max, 10)
+  }
+  
+  // once healed, every node has the larger maxima, and of the equal
+  // ones the one set first
+  network.partition()
+  network.waitForMaxima([]state.Session{empire, rebels}, "a", "b", "c")
+}
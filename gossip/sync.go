@@ -0,0 +1,32 @@
+package gossip
+
+import (
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "github.com/salman-ahmad/grpc-streaming/state"
+  "golang.org/x/net/context"
+)
+
+// syncServer serves a node's PeerSync service
+type syncServer struct {
+  node *Node
+}
+
+// Sync merges the peer's maxima, then answers with the sessions the
+// peer doesn't have and the ones whose maximum supersedes the peer's
+func (s syncServer) Sync(ctx context.Context, request *pb.SyncRequest) (*pb.SyncResponse, error) {
+  n := s.node
+  if merged := n.merge(request.Maxima); merged > 0 {
+    n.logger.Debug("merged peer's maxima", "peer", request.Node, "merged", merged)
+  }
+  theirs := make(map[string]state.Session, len(request.Maxima))
+  for _, max := range request.Maxima {
+    theirs[max.Session] = fromPeerMax(max)
+  }
+  var newer []state.Session
+  for _, session := range n.sessions.Sessions() {
+    if other, ok := theirs[session.Name]; !ok || state.Supersedes(session, other) {
+      newer = append(newer, session)
+    }
+  }
+  return &pb.SyncResponse{Node: n.id, Maxima: toPeerMaxima(newer)}, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  
+  "github.com/salman-ahmad/grpc-streaming/audit"
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+)
+
+const verifyAuditUsage = "Usage: keytool verify-audit [-log <file>] [-store <file>] [-public-key <file>]"
+
+// auditSummary counts what verify-audit checked
+type auditSummary struct {
+  entries    int
+  signatures int
+  last       audit.Entry
+}
+
+func runVerifyAudit(args []string) error {
+  conf, err := config.LoadConfig()
+  if err != nil {
+    return err
+  }
+  flags := flag.NewFlagSet("verify-audit", flag.ExitOnError)
+  flags.Usage = func() {
+    fmt.Fprintln(os.Stderr, verifyAuditUsage)
+    flags.PrintDefaults()
+  }
+  logPath := flags.String("log", conf.AuditLog, "audit log file")
+  storePath := flags.String("store", conf.TrustStore, "trust store file")
+  publicKeyPath := flags.String("public-key", conf.PublicKey, "the server's default public key, if it has one")
+  flags.Parse(args)
+  if *logPath == "" {
+    flags.Usage()
+    os.Exit(2)
+  }
+  
+  keys, err := auditKeys(*storePath, *publicKeyPath)
+  if err != nil {
+    return err
+  }
+  path, err := config.AbsolutePath(*logPath)
+  if err != nil {
+    return err
+  }
+  file, err := os.Open(path)
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+  summary, err := verifyAudit(file, keys)
+  if err != nil {
+    return err
+  }
+  fmt.Printf("verified %d entries of %s, %d signatures\n", summary.entries, path, summary.signatures)
+  if summary.entries > 0 {
+    fmt.Printf("last entry:  %d\n", summary.last.Index)
+    fmt.Printf("last hash:   %s\n", summary.last.Hash)
+  }
+  return nil
+}
+
+// auditKeys indexes the keys the server verifies signatures with by
+// key ID: the trusted keys, and the default public key if it exists
+func auditKeys(storePath, publicKeyPath string) (map[string]crypto.PublicKey, error) {
+  path, err := config.AbsolutePath(storePath)
+  if err != nil {
+    return nil, err
+  }
+  store, err := crypto.LoadTrustStore(path)
+  if err != nil {
+    return nil, err
+  }
+  keys, err := store.PublicKeys()
+  if err != nil {
+    return nil, err
+  }
+  if publicKeyPath == "" {
+    return keys, nil
+  }
+  path, err = config.AbsolutePath(publicKeyPath)
+  if err != nil {
+    return nil, err
+  }
+  content, err := ioutil.ReadFile(path)
+  if os.IsNotExist(err) {
+    return keys, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  publicKey, err := crypto.NewPublicKey(content)
+  if err != nil {
+    return nil, fmt.Errorf("failed to read public key %s: %v", path, err)
+  }
+  keys[publicKey.KeyID()] = publicKey
+  return keys, nil
+}
+
+// verifyAudit recomputes the hash chain of the audit log and checks
+// every signature the server verified again with the same key
+func verifyAudit(r io.Reader, keys map[string]crypto.PublicKey) (auditSummary, error) {
+  var summary auditSummary
+  last, err := audit.Read(r, func(entry audit.Entry) error {
+    summary.entries++
+    if entry.VerifiedBy == "" {
+      return nil
+    }
+    publicKey, ok := keys[entry.VerifiedBy]
+    if !ok {
+      return fmt.Errorf("key %s is not trusted", entry.VerifiedBy)
+    }
+    verified, err := publicKey.Verify(crypto.Int64ToBytes(entry.Number), entry.Signature)
+    if err != nil || !verified {
+      return fmt.Errorf("signature of number %d does not verify with key %s", entry.Number, entry.VerifiedBy)
+    }
+    summary.signatures++
+    return nil
+  })
+  summary.last = last
+  return summary, err
+}
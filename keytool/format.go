@@ -0,0 +1,298 @@
+package main
+
+import (
+  "bytes"
+  "crypto/ecdsa"
+  "crypto/elliptic"
+  "crypto/rsa"
+  "crypto/x509"
+  "encoding/base64"
+  "encoding/json"
+  "encoding/pem"
+  "errors"
+  "fmt"
+  "math/big"
+  
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  "golang.org/x/crypto/ssh"
+)
+
+var formats = []string{"pem", "der", "openssh", "jwk"}
+
+// jwk holds the RFC 7517 members used by RSA and EC keys
+type jwk struct {
+  Kty string `json:"kty"`
+  Kid string `json:"kid,omitempty"`
+  Crv string `json:"crv,omitempty"`
+  X   string `json:"x,omitempty"`
+  Y   string `json:"y,omitempty"`
+  N   string `json:"n,omitempty"`
+  E   string `json:"e,omitempty"`
+  D   string `json:"d,omitempty"`
+  P   string `json:"p,omitempty"`
+  Q   string `json:"q,omitempty"`
+  Dp  string `json:"dp,omitempty"`
+  Dq  string `json:"dq,omitempty"`
+  Qi  string `json:"qi,omitempty"`
+}
+
+// publicKeyOf returns the public half of a private key,
+// or the key itself when it is already public
+func publicKeyOf(key interface{}) (interface{}, error) {
+  switch k := key.(type) {
+  case *rsa.PrivateKey:
+    return &k.PublicKey, nil
+  case *ecdsa.PrivateKey:
+    return &k.PublicKey, nil
+  case *rsa.PublicKey, *ecdsa.PublicKey:
+    return k, nil
+  default:
+    return nil, fmt.Errorf("unsupported key type %T", key)
+  }
+}
+
+func isPrivateKey(key interface{}) bool {
+  switch key.(type) {
+  case *rsa.PrivateKey, *ecdsa.PrivateKey:
+    return true
+  }
+  return false
+}
+
+// decodeKey detects the format of the given key and parses it
+func decodeKey(data []byte) (interface{}, error) {
+  trimmed := bytes.TrimSpace(data)
+  if block, _ := pem.Decode(trimmed); block != nil {
+    return decodeDER(block.Bytes)
+  }
+  if bytes.HasPrefix(trimmed, []byte("{")) {
+    return decodeJWK(trimmed)
+  }
+  if sshKey, _, _, _, err := ssh.ParseAuthorizedKey(trimmed); err == nil {
+    cryptoKey, ok := sshKey.(ssh.CryptoPublicKey)
+    if !ok {
+      return nil, fmt.Errorf("unsupported openssh key type %s", sshKey.Type())
+    }
+    return cryptoKey.CryptoPublicKey(), nil
+  }
+  return decodeDER(data)
+}
+
+func decodeDER(der []byte) (interface{}, error) {
+  if key, err := x509.ParsePKIXPublicKey(der); err == nil {
+    return key, nil
+  }
+  if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+    return key, nil
+  }
+  if key, err := x509.ParseECPrivateKey(der); err == nil {
+    return key, nil
+  }
+  if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+    return key, nil
+  }
+  return nil, errors.New("unrecognised key format")
+}
+
+// encodeKey writes the key in the given format
+func encodeKey(key interface{}, format string) ([]byte, error) {
+  switch format {
+  case "pem":
+    block, err := pemBlock(key)
+    if err != nil {
+      return nil, err
+    }
+    return pem.EncodeToMemory(block), nil
+  case "der":
+    block, err := pemBlock(key)
+    if err != nil {
+      return nil, err
+    }
+    return block.Bytes, nil
+  case "openssh":
+    if isPrivateKey(key) {
+      return nil, errors.New("openssh format is only supported for public keys")
+    }
+    sshKey, err := ssh.NewPublicKey(key)
+    if err != nil {
+      return nil, err
+    }
+    return ssh.MarshalAuthorizedKey(sshKey), nil
+  case "jwk":
+    return encodeJWK(key)
+  default:
+    return nil, fmt.Errorf("unsupported format %s", format)
+  }
+}
+
+func pemBlock(key interface{}) (*pem.Block, error) {
+  switch k := key.(type) {
+  case *rsa.PrivateKey:
+    return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
+  case *ecdsa.PrivateKey:
+    der, err := x509.MarshalECPrivateKey(k)
+    if err != nil {
+      return nil, err
+    }
+    return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, nil
+  default:
+    der, err := x509.MarshalPKIXPublicKey(key)
+    if err != nil {
+      return nil, err
+    }
+    return &pem.Block{Type: "PUBLIC KEY", Bytes: der}, nil
+  }
+}
+
+func b64(n *big.Int) string {
+  return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+// b64Fixed pads the value to the curve size as required by RFC 7518
+func b64Fixed(n *big.Int, size int) string {
+  buf := make([]byte, size)
+  n.FillBytes(buf)
+  return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func unb64(value string) (*big.Int, error) {
+  raw, err := base64.RawURLEncoding.DecodeString(value)
+  if err != nil {
+    return nil, err
+  }
+  return new(big.Int).SetBytes(raw), nil
+}
+
+func encodeJWK(key interface{}) ([]byte, error) {
+  publicKey, err := publicKeyOf(key)
+  if err != nil {
+    return nil, err
+  }
+  id, err := crypto.KeyID(publicKey)
+  if err != nil {
+    return nil, err
+  }
+  
+  j := jwk{Kid: id}
+  switch k := publicKey.(type) {
+  case *rsa.PublicKey:
+    j.Kty = "RSA"
+    j.N = b64(k.N)
+    j.E = b64(big.NewInt(int64(k.E)))
+  case *ecdsa.PublicKey:
+    size := (k.Curve.Params().BitSize + 7) / 8
+    j.Kty = "EC"
+    j.Crv = k.Curve.Params().Name
+    j.X = b64Fixed(k.X, size)
+    j.Y = b64Fixed(k.Y, size)
+  }
+  
+  switch k := key.(type) {
+  case *rsa.PrivateKey:
+    k.Precompute()
+    j.D = b64(k.D)
+    j.P = b64(k.Primes[0])
+    j.Q = b64(k.Primes[1])
+    j.Dp = b64(k.Precomputed.Dp)
+    j.Dq = b64(k.Precomputed.Dq)
+    j.Qi = b64(k.Precomputed.Qinv)
+  case *ecdsa.PrivateKey:
+    j.D = b64Fixed(k.D, (k.Curve.Params().BitSize+7)/8)
+  }
+  content, err := json.MarshalIndent(j, "", "  ")
+  if err != nil {
+    return nil, err
+  }
+  return append(content, '\n'), nil
+}
+
+func decodeJWK(data []byte) (interface{}, error) {
+  var j jwk
+  if err := json.Unmarshal(data, &j); err != nil {
+    return nil, err
+  }
+  
+  switch j.Kty {
+  case "RSA":
+    return decodeRSAJWK(j)
+  case "EC":
+    return decodeECJWK(j)
+  default:
+    return nil, fmt.Errorf("unsupported jwk key type %q", j.Kty)
+  }
+}
+
+func decodeRSAJWK(j jwk) (interface{}, error) {
+  n, err := unb64(j.N)
+  if err != nil {
+    return nil, err
+  }
+  e, err := unb64(j.E)
+  if err != nil {
+    return nil, err
+  }
+  publicKey := rsa.PublicKey{N: n, E: int(e.Int64())}
+  if j.D == "" {
+    return &publicKey, nil
+  }
+  
+  values := make([]*big.Int, 3)
+  for i, value := range []string{j.D, j.P, j.Q} {
+    if values[i], err = unb64(value); err != nil {
+      return nil, err
+    }
+  }
+  privateKey := &rsa.PrivateKey{
+    PublicKey: publicKey,
+    D:         values[0],
+    Primes:    []*big.Int{values[1], values[2]},
+  }
+  if err := privateKey.Validate(); err != nil {
+    return nil, err
+  }
+  privateKey.Precompute()
+  return privateKey, nil
+}
+
+func decodeECJWK(j jwk) (interface{}, error) {
+  var curve elliptic.Curve
+  switch j.Crv {
+  case "P-256":
+    curve = elliptic.P256()
+  case "P-384":
+    curve = elliptic.P384()
+  case "P-521":
+    curve = elliptic.P521()
+  default:
+    return nil, fmt.Errorf("unsupported jwk curve %q", j.Crv)
+  }
+  
+  x, err := unb64(j.X)
+  if err != nil {
+    return nil, err
+  }
+  y, err := unb64(j.Y)
+  if err != nil {
+    return nil, err
+  }
+  publicKey := ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+  if !curve.IsOnCurve(x, y) {
+    return nil, errors.New("jwk point is not on the curve")
+  }
+  if j.D == "" {
+    return &publicKey, nil
+  }
+  
+  d, err := unb64(j.D)
+  if err != nil {
+    return nil, err
+  }
+  // the private key must be in [1, N-1] and be the public key's
+  if d.Sign() <= 0 || d.Cmp(curve.Params().N) >= 0 {
+    return nil, errors.New("jwk private key is out of range")
+  }
+  if px, py := curve.ScalarBaseMult(d.Bytes()); px.Cmp(x) != 0 || py.Cmp(y) != 0 {
+    return nil, errors.New("jwk private key doesn't match the public key")
+  }
+  return &ecdsa.PrivateKey{PublicKey: publicKey, D: d}, nil
+}
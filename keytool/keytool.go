@@ -0,0 +1,385 @@
+package main
+
+import (
+  "crypto/ecdsa"
+  "crypto/elliptic"
+  "crypto/rand"
+  "crypto/rsa"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "log"
+  "os"
+  "path/filepath"
+  "strings"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+)
+
+const usage = `Usage: keytool <command> [options]
+
+Commands:
+  generate      Generate a new key pair
+  fingerprint   Print the algorithm, key ID and fingerprint of a key
+  convert       Convert a key between pem, der, openssh and jwk formats
+  trust         Add, remove or list keys in the server's trust store
+  token         Issue JWTs and opaque bearer tokens for token authentication
+  verify-audit  Check the hash chain and signatures of the server's audit log
+
+Run "keytool <command> -h" to see the options of a command
+`
+
+// default key sizes, matching ssh-keygen
+var defaultBits = map[string]int{
+  "rsa":   2048,
+  "ecdsa": 256,
+}
+
+func main() {
+  log.SetFlags(0)
+  if len(os.Args) < 2 {
+    fmt.Fprint(os.Stderr, usage)
+    os.Exit(2)
+  }
+  
+  var err error
+  args := os.Args[2:]
+  switch os.Args[1] {
+  case "generate":
+    err = runGenerate(args)
+  case "fingerprint":
+    err = runFingerprint(args)
+  case "convert":
+    err = runConvert(args)
+  case "trust":
+    err = runTrust(args)
+  case "token":
+    err = runToken(args)
+  case "verify-audit":
+    err = runVerifyAudit(args)
+  default:
+    fmt.Fprint(os.Stderr, usage)
+    os.Exit(2)
+  }
+  if err != nil {
+    log.Fatalf("keytool %s: %v\n", os.Args[1], err)
+  }
+}
+
+// generateKey creates a private key of the given algorithm;
+// for ecdsa the bits select the curve
+func generateKey(algorithm string, bits int) (interface{}, error) {
+  if bits == 0 {
+    bits = defaultBits[algorithm]
+  }
+  
+  switch algorithm {
+  case "rsa":
+    if bits < 2048 {
+      return nil, fmt.Errorf("rsa keys must be at least 2048 bits, got %d", bits)
+    }
+    return rsa.GenerateKey(rand.Reader, bits)
+  case "ecdsa":
+    var curve elliptic.Curve
+    switch bits {
+    case 256:
+      curve = elliptic.P256()
+    case 384:
+      curve = elliptic.P384()
+    case 521:
+      curve = elliptic.P521()
+    default:
+      return nil, fmt.Errorf("ecdsa keys must be 256, 384 or 521 bits, got %d", bits)
+    }
+    return ecdsa.GenerateKey(curve, rand.Reader)
+  default:
+    return nil, fmt.Errorf("unsupported algorithm %s", algorithm)
+  }
+}
+
+// writeKeyFile creates the file with the given permissions and refuses
+// to replace an existing file unless force is set
+func writeKeyFile(path string, content []byte, perm os.FileMode, force bool) error {
+  if force {
+    if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+      return err
+    }
+  }
+  file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+  if err != nil {
+    return err
+  }
+  if _, err := file.Write(content); err != nil {
+    file.Close()
+    return err
+  }
+  // the umask may have removed bits we asked for, or the file
+  // may have been created by another process with wider ones
+  if err := file.Chmod(perm); err != nil {
+    file.Close()
+    return err
+  }
+  return file.Close()
+}
+
+// writeKeyPair writes <prefix>_private.pem readable by the owner only
+// and <prefix>_public.pem readable by everyone
+func writeKeyPair(prefix string, privateKey interface{}, force bool) (string, string, error) {
+  if err := os.MkdirAll(filepath.Dir(prefix), 0700); err != nil {
+    return "", "", err
+  }
+  
+  privatePEM, err := encodeKey(privateKey, "pem")
+  if err != nil {
+    return "", "", err
+  }
+  publicKey, err := publicKeyOf(privateKey)
+  if err != nil {
+    return "", "", err
+  }
+  publicPEM, err := encodeKey(publicKey, "pem")
+  if err != nil {
+    return "", "", err
+  }
+  
+  privatePath := prefix + "_private.pem"
+  publicPath := prefix + "_public.pem"
+  if err := writeKeyFile(privatePath, privatePEM, 0600, force); err != nil {
+    return "", "", err
+  }
+  if err := writeKeyFile(publicPath, publicPEM, 0644, force); err != nil {
+    return "", "", err
+  }
+  return privatePath, publicPath, nil
+}
+
+type keyInfo struct {
+  Algorithm   string
+  Bits        int
+  Private     bool
+  KeyID       string
+  Fingerprint string
+}
+
+func describeKey(key interface{}) (keyInfo, error) {
+  publicKey, err := publicKeyOf(key)
+  if err != nil {
+    return keyInfo{}, err
+  }
+  
+  info := keyInfo{Private: isPrivateKey(key)}
+  switch k := publicKey.(type) {
+  case *rsa.PublicKey:
+    info.Algorithm = "RSA"
+    info.Bits = k.N.BitLen()
+  case *ecdsa.PublicKey:
+    info.Algorithm = "ECDSA " + k.Curve.Params().Name
+    info.Bits = k.Curve.Params().BitSize
+  }
+  if info.KeyID, err = crypto.KeyID(publicKey); err != nil {
+    return keyInfo{}, err
+  }
+  if info.Fingerprint, err = crypto.Fingerprint(publicKey); err != nil {
+    return keyInfo{}, err
+  }
+  return info, nil
+}
+
+func printKeyInfo(info keyInfo) {
+  fmt.Printf("algorithm:   %s (%d bits)\n", info.Algorithm, info.Bits)
+  fmt.Printf("key id:      %s\n", info.KeyID)
+  fmt.Printf("fingerprint: %s\n", info.Fingerprint)
+}
+
+func readKey(path string) (interface{}, error) {
+  absPath, err := config.AbsolutePath(path)
+  if err != nil {
+    return nil, err
+  }
+  content, err := ioutil.ReadFile(absPath)
+  if err != nil {
+    return nil, err
+  }
+  return decodeKey(content)
+}
+
+func runGenerate(args []string) error {
+  flags := flag.NewFlagSet("generate", flag.ExitOnError)
+  algorithm := flags.String("algorithm", "rsa", "key algorithm: rsa or ecdsa")
+  bits := flags.Int("bits", 0, "key size; rsa defaults to 2048, ecdsa to 256 (P-256)")
+  out := flags.String("out", "", "file prefix, defaults to ~/.ssh/maxnumber_<algorithm>")
+  force := flags.Bool("force", false, "overwrite existing key files")
+  flags.Parse(args)
+  
+  if *out == "" {
+    *out = "~/.ssh/maxnumber_" + *algorithm
+  }
+  prefix, err := config.AbsolutePath(*out)
+  if err != nil {
+    return err
+  }
+  
+  privateKey, err := generateKey(strings.ToLower(*algorithm), *bits)
+  if err != nil {
+    return err
+  }
+  privatePath, publicPath, err := writeKeyPair(prefix, privateKey, *force)
+  if err != nil {
+    return err
+  }
+  
+  info, err := describeKey(privateKey)
+  if err != nil {
+    return err
+  }
+  fmt.Printf("private key: %s\n", privatePath)
+  fmt.Printf("public key:  %s\n", publicPath)
+  printKeyInfo(info)
+  return nil
+}
+
+func runFingerprint(args []string) error {
+  flags := flag.NewFlagSet("fingerprint", flag.ExitOnError)
+  flags.Usage = func() {
+    fmt.Fprintln(os.Stderr, "Usage: keytool fingerprint <key file>...")
+  }
+  flags.Parse(args)
+  if flags.NArg() == 0 {
+    flags.Usage()
+    os.Exit(2)
+  }
+  
+  for i, path := range flags.Args() {
+    key, err := readKey(path)
+    if err != nil {
+      return fmt.Errorf("%s: %v", path, err)
+    }
+    info, err := describeKey(key)
+    if err != nil {
+      return fmt.Errorf("%s: %v", path, err)
+    }
+    if i > 0 {
+      fmt.Println()
+    }
+    fmt.Printf("file:        %s\n", path)
+    printKeyInfo(info)
+  }
+  return nil
+}
+
+func runConvert(args []string) error {
+  flags := flag.NewFlagSet("convert", flag.ExitOnError)
+  in := flags.String("in", "", "key file to convert; the format is detected")
+  to := flags.String("to", "pem", "output format: "+strings.Join(formats, ", "))
+  out := flags.String("out", "", "output file, defaults to stdout")
+  public := flags.Bool("public", false, "convert only the public half of a private key")
+  force := flags.Bool("force", false, "overwrite an existing output file")
+  flags.Parse(args)
+  if *in == "" {
+    flags.Usage()
+    os.Exit(2)
+  }
+  
+  key, err := readKey(*in)
+  if err != nil {
+    return err
+  }
+  if *public {
+    if key, err = publicKeyOf(key); err != nil {
+      return err
+    }
+  }
+  content, err := encodeKey(key, *to)
+  if err != nil {
+    return err
+  }
+  
+  if *out == "" {
+    _, err = os.Stdout.Write(content)
+    return err
+  }
+  outPath, err := config.AbsolutePath(*out)
+  if err != nil {
+    return err
+  }
+  var perm os.FileMode = 0644
+  if isPrivateKey(key) {
+    perm = 0600
+  }
+  return writeKeyFile(outPath, content, perm, *force)
+}
+
+func runTrust(args []string) error {
+  trustUsage := "Usage: keytool trust add -identity <name> <public key file>\n" +
+    "       keytool trust remove <key id>\n" +
+    "       keytool trust list"
+  if len(args) == 0 {
+    fmt.Fprintln(os.Stderr, trustUsage)
+    os.Exit(2)
+  }
+  
+  conf, err := config.LoadConfig()
+  if err != nil {
+    return err
+  }
+  flags := flag.NewFlagSet("trust "+args[0], flag.ExitOnError)
+  storePath := flags.String("store", conf.TrustStore, "trust store file")
+  identity := flags.String("identity", "", "identity the key belongs to")
+  flags.Parse(args[1:])
+  
+  path, err := config.AbsolutePath(*storePath)
+  if err != nil {
+    return err
+  }
+  store, err := crypto.LoadTrustStore(path)
+  if err != nil {
+    return err
+  }
+  
+  switch args[0] {
+  case "add":
+    if flags.NArg() != 1 {
+      return fmt.Errorf("expected one public key file\n%s", trustUsage)
+    }
+    key, err := readKey(flags.Arg(0))
+    if err != nil {
+      return err
+    }
+    // only the public half ever goes into the trust store
+    publicKey, err := publicKeyOf(key)
+    if err != nil {
+      return err
+    }
+    publicPEM, err := encodeKey(publicKey, "pem")
+    if err != nil {
+      return err
+    }
+    trusted, err := store.Add(*identity, publicPEM)
+    if err != nil {
+      return err
+    }
+    if err := store.Save(); err != nil {
+      return err
+    }
+    fmt.Printf("trusted key %s for %q in %s\n", trusted.ID, trusted.Identity, path)
+  case "remove":
+    if flags.NArg() != 1 {
+      return fmt.Errorf("expected one key id\n%s", trustUsage)
+    }
+    if !store.Remove(flags.Arg(0)) {
+      return fmt.Errorf("key %s is not in %s", flags.Arg(0), path)
+    }
+    if err := store.Save(); err != nil {
+      return err
+    }
+    fmt.Printf("removed key %s from %s\n", flags.Arg(0), path)
+  case "list":
+    for _, trusted := range store.Keys() {
+      fmt.Printf("%s  %s\n", trusted.ID, trusted.Identity)
+    }
+  default:
+    return fmt.Errorf("unknown trust command %s\n%s", args[0], trustUsage)
+  }
+  return nil
+}
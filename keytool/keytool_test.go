@@ -0,0 +1,159 @@
+package main
+
+import (
+  "bytes"
+  "crypto/ecdsa"
+  "crypto/elliptic"
+  "encoding/json"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "reflect"
+  "testing"
+  
+  "github.com/salman-ahmad/grpc-streaming/audit"
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+)
+
+func TestGenerateKey_Algorithms(t *testing.T) {
+  for _, algorithm := range []string{"rsa", "ecdsa"} {
+    key, err := generateKey(algorithm, 0)
+    if err != nil {
+      t.Errorf("Got: %v, wanted: %v\n", err, nil)
+    }
+    if !isPrivateKey(key) {
+      t.Errorf("Got: %T, wanted: %s\n", key, "private key")
+    }
+  }
+}
+
+func TestGenerateKey_WeakRSA(t *testing.T) {
+  _, err := generateKey("rsa", 1024)
+  if err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "error")
+  }
+}
+
+func TestWriteKeyPair_Permissions(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "keytool")
+  defer os.RemoveAll(dir)
+  
+  key, _ := generateKey("ecdsa", 256)
+  privatePath, publicPath, err := writeKeyPair(filepath.Join(dir, "test"), key, false)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  
+  expected := map[string]os.FileMode{privatePath: 0600, publicPath: 0644}
+  for path, perm := range expected {
+    info, _ := os.Stat(path)
+    if info.Mode().Perm() != perm {
+      t.Errorf("Got: %v, wanted: %v\n", info.Mode().Perm(), perm)
+    }
+  }
+}
+
+func TestWriteKeyPair_NoOverwrite(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "keytool")
+  defer os.RemoveAll(dir)
+  
+  key, _ := generateKey("ecdsa", 256)
+  prefix := filepath.Join(dir, "test")
+  writeKeyPair(prefix, key, false)
+  if _, _, err := writeKeyPair(prefix, key, false); err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "error")
+  }
+  if _, _, err := writeKeyPair(prefix, key, true); err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+}
+
+func TestConvert_RoundTrip(t *testing.T) {
+  for _, algorithm := range []string{"rsa", "ecdsa"} {
+    privateKey, _ := generateKey(algorithm, 0)
+    publicKey, _ := publicKeyOf(privateKey)
+    
+    for _, format := range formats {
+      for _, key := range []interface{}{privateKey, publicKey} {
+        if format == "openssh" && isPrivateKey(key) {
+          continue
+        }
+        encoded, err := encodeKey(key, format)
+        if err != nil {
+          t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+        }
+        decoded, err := decodeKey(encoded)
+        if err != nil {
+          t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+        }
+        
+        expected, _ := describeKey(key)
+        actual, _ := describeKey(decoded)
+        if !reflect.DeepEqual(actual, expected) {
+          t.Errorf("%s %s: Got: %v, wanted: %v\n", algorithm, format, actual, expected)
+        }
+      }
+    }
+  }
+}
+
+func TestConvert_OpenSSHPrivateKey(t *testing.T) {
+  key, _ := generateKey("ecdsa", 256)
+  _, err := encodeKey(key, "openssh")
+  if err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "error")
+  }
+}
+
+func TestConvert_ECJWKMismatch(t *testing.T) {
+  key, _ := generateKey("ecdsa", 256)
+  other, _ := generateKey("ecdsa", 256)
+  var j jwk
+  encoded, _ := encodeKey(key, "jwk")
+  json.Unmarshal(encoded, &j)
+  
+  // a private key of another key pair or out of range is refused
+  otherD := b64(other.(*ecdsa.PrivateKey).D)
+  n := b64(elliptic.P256().Params().N)
+  for _, d := range []string{otherD, n, "AA"} {
+    j.D = d
+    if _, err := decodeECJWK(j); err == nil {
+      t.Errorf("Got: %v, wanted: %s\n", nil, "error")
+    }
+  }
+}
+
+func TestVerifyAudit(t *testing.T) {
+  key, _ := generateKey("ecdsa", 256)
+  privatePEM, _ := encodeKey(key, "pem")
+  privateKey, _ := crypto.NewPrivateKey(privatePEM)
+  public, _ := publicKeyOf(key)
+  publicPEM, _ := encodeKey(public, "pem")
+  publicKey, _ := crypto.NewPublicKey(publicPEM)
+  keys := map[string]crypto.PublicKey{publicKey.KeyID(): publicKey}
+  
+  dir, _ := ioutil.TempDir("", "audit")
+  defer os.RemoveAll(dir)
+  path := filepath.Join(dir, "audit.jsonl")
+  log, _ := audit.Open(path)
+  signature, _ := privateKey.Sign(crypto.Int64ToBytes(7))
+  log.Append(audit.Entry{Number: 7, Signature: signature, VerifiedBy: publicKey.KeyID(), Outcome: audit.Accepted})
+  log.Append(audit.Entry{Number: 8, Signature: signature, Outcome: audit.Failed})
+  content, _ := ioutil.ReadFile(path)
+  
+  summary, err := verifyAudit(bytes.NewReader(content), keys)
+  if err != nil || summary.entries != 2 || summary.signatures != 1 {
+    t.Errorf("Got: %+v %v, wanted: %d entries %d signatures\n", summary, err, 2, 1)
+  }
+  if _, err := verifyAudit(bytes.NewReader(content), map[string]crypto.PublicKey{}); err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "error")
+  }
+  
+  // a signature the server didn't verify can't be passed off as verified
+  log.Append(audit.Entry{Number: 8, Signature: signature, VerifiedBy: publicKey.KeyID(), Outcome: audit.Accepted})
+  log.Close()
+  content, _ = ioutil.ReadFile(path)
+  if _, err := verifyAudit(bytes.NewReader(content), keys); err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "error")
+  }
+}
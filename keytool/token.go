@@ -0,0 +1,126 @@
+package main
+
+import (
+  "crypto/rand"
+  "encoding/base64"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "strings"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/auth"
+  "github.com/salman-ahmad/grpc-streaming/config"
+)
+
+const tokenUsage = "Usage: keytool token secret [-secret <file>] [-force]\n" +
+  "       keytool token jwt -subject <name> [-ttl <duration>] [-secret <file>]\n" +
+  "       keytool token add -subject <name> [-tokens <file>]\n" +
+  "       keytool token remove -subject <name> [-tokens <file>]\n" +
+  "       keytool token list [-tokens <file>]"
+
+func runToken(args []string) error {
+  if len(args) == 0 {
+    fmt.Fprintln(os.Stderr, tokenUsage)
+    os.Exit(2)
+  }
+  
+  conf, err := config.LoadConfig()
+  if err != nil {
+    return err
+  }
+  flags := flag.NewFlagSet("token "+args[0], flag.ExitOnError)
+  secretFile := flags.String("secret", conf.AuthJWTSecret, "JWT secret file")
+  tokensFile := flags.String("tokens", conf.AuthTokens, "token file")
+  subject := flags.String("subject", "", "subject the token is issued to")
+  ttl := flags.Duration("ttl", 24*time.Hour, "how long the JWT is valid, 0 for ever")
+  issuer := flags.String("issuer", conf.AuthJWTIssuer, "JWT issuer")
+  audience := flags.String("audience", conf.AuthJWTAudience, "JWT audience")
+  algorithm := flags.String("alg", "HS256", "JWT algorithm, HS256, HS384 or HS512")
+  force := flags.Bool("force", false, "overwrite an existing secret")
+  flags.Parse(args[1:])
+  
+  secretPath, err := config.AbsolutePath(*secretFile)
+  if err != nil {
+    return err
+  }
+  tokensPath, err := config.AbsolutePath(*tokensFile)
+  if err != nil {
+    return err
+  }
+  
+  switch args[0] {
+  case "secret":
+    random := make([]byte, 32)
+    if _, err := rand.Read(random); err != nil {
+      return err
+    }
+    content := base64.RawURLEncoding.EncodeToString(random) + "\n"
+    if err := writeKeyFile(secretPath, []byte(content), 0600, *force); err != nil {
+      return err
+    }
+    fmt.Printf("wrote JWT secret to %s\n", secretPath)
+  case "jwt":
+    if *subject == "" {
+      return fmt.Errorf("expected a subject\n%s", tokenUsage)
+    }
+    secret, err := ioutil.ReadFile(secretPath)
+    if err != nil {
+      return err
+    }
+    now := time.Now()
+    claims := auth.Claims{Subject: *subject, Issuer: *issuer, IssuedAt: now.Unix()}
+    if *audience != "" {
+      claims.Audience = auth.Audience{*audience}
+    }
+    if *ttl > 0 {
+      claims.ExpiresAt = now.Add(*ttl).Unix()
+    }
+    token, err := auth.SignJWT(*algorithm, []byte(strings.TrimSpace(string(secret))), claims)
+    if err != nil {
+      return err
+    }
+    fmt.Println(token)
+  case "add", "remove", "list":
+    return runTokenFile(args[0], tokensPath, *subject)
+  default:
+    return fmt.Errorf("unknown token command %s\n%s", args[0], tokenUsage)
+  }
+  return nil
+}
+
+// runTokenFile issues, revokes or lists the opaque tokens in the token file
+func runTokenFile(command, path, subject string) error {
+  tokens, err := auth.LoadTokenFile(path)
+  if err != nil {
+    return err
+  }
+  
+  switch command {
+  case "add":
+    token, err := tokens.Add(subject)
+    if err != nil {
+      return err
+    }
+    if err := tokens.Save(); err != nil {
+      return err
+    }
+    // the token is only ever shown here, the file keeps its digest
+    fmt.Println(token)
+  case "remove":
+    removed := tokens.Remove(subject)
+    if removed == 0 {
+      return fmt.Errorf("no tokens for %q in %s", subject, path)
+    }
+    if err := tokens.Save(); err != nil {
+      return err
+    }
+    fmt.Printf("revoked %d tokens of %q in %s\n", removed, subject, path)
+  case "list":
+    for _, token := range tokens.Tokens() {
+      fmt.Printf("%s  %s\n", token.SHA256[:16], token.Subject)
+    }
+  }
+  return nil
+}
@@ -0,0 +1,213 @@
+package logging
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io"
+  "os"
+  "strconv"
+  "strings"
+  "sync"
+  "sync/atomic"
+  "time"
+)
+
+// Level is the severity of a log entry
+type Level int32
+
+const (
+  DebugLevel Level = iota
+  InfoLevel
+  WarnLevel
+  ErrorLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+  if l < DebugLevel || l > ErrorLevel {
+    return "level(" + strconv.Itoa(int(l)) + ")"
+  }
+  return levelNames[l]
+}
+
+// ParseLevel reads one of debug, info, warn or error
+func ParseLevel(name string) (Level, error) {
+  for i, levelName := range levelNames {
+    if strings.EqualFold(name, levelName) {
+      return Level(i), nil
+    }
+  }
+  return InfoLevel, fmt.Errorf("unknown log level %q, expected one of %v", name, levelNames)
+}
+
+// core is shared by a logger and every logger derived from it
+// with With, so changing the level changes it for all of them
+type core struct {
+  mu    sync.Mutex
+  out   io.Writer
+  json  bool
+  level int32
+  now   func() time.Time
+}
+
+// Logger writes leveled entries made of a message and key value
+// pairs, as JSON objects or logfmt lines
+type Logger struct {
+  core   *core
+  fields []interface{}
+}
+
+// New creates a logger writing entries at or above the level
+// to out, in the "json" or "logfmt" format
+func New(out io.Writer, format string, level Level) (*Logger, error) {
+  c := &core{out: out, level: int32(level), now: time.Now}
+  switch format {
+  case "json":
+    c.json = true
+  case "logfmt":
+  default:
+    return nil, fmt.Errorf("unknown log format %q, expected json or logfmt", format)
+  }
+  return &Logger{core: c}, nil
+}
+
+// With returns a logger that adds the key value pairs to every entry
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+  fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+  fields = append(append(fields, l.fields...), keyvals...)
+  return &Logger{core: l.core, fields: fields}
+}
+
+func (l *Logger) Level() Level {
+  return Level(atomic.LoadInt32(&l.core.level))
+}
+
+// SetLevel changes the level of the logger and every logger
+// derived from it, while they are in use
+func (l *Logger) SetLevel(level Level) {
+  atomic.StoreInt32(&l.core.level, int32(level))
+}
+
+func (l *Logger) Enabled(level Level) bool {
+  return level >= l.Level()
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) { l.log(DebugLevel, msg, keyvals) }
+func (l *Logger) Info(msg string, keyvals ...interface{})  { l.log(InfoLevel, msg, keyvals) }
+func (l *Logger) Warn(msg string, keyvals ...interface{})  { l.log(WarnLevel, msg, keyvals) }
+func (l *Logger) Error(msg string, keyvals ...interface{}) { l.log(ErrorLevel, msg, keyvals) }
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+  if !l.Enabled(level) {
+    return
+  }
+  entry := make([]interface{}, 0, 6+len(l.fields)+len(keyvals))
+  entry = append(entry, "time", l.core.now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg)
+  entry = append(append(entry, l.fields...), keyvals...)
+  if len(entry)%2 != 0 {
+    entry = append(entry, "(missing)")
+  }
+  
+  var buf bytes.Buffer
+  if l.core.json {
+    writeJSON(&buf, entry)
+  } else {
+    writeLogfmt(&buf, entry)
+  }
+  l.core.mu.Lock()
+  l.core.out.Write(buf.Bytes())
+  l.core.mu.Unlock()
+}
+
+// value turns errors and stringers into their text, which
+// is how they should show up in logs
+func value(v interface{}) interface{} {
+  switch v := v.(type) {
+  case error:
+    return v.Error()
+  case fmt.Stringer:
+    return v.String()
+  }
+  return v
+}
+
+func writeJSON(buf *bytes.Buffer, entry []interface{}) {
+  buf.WriteByte('{')
+  for i := 0; i < len(entry); i += 2 {
+    if i > 0 {
+      buf.WriteByte(',')
+    }
+    key, _ := json.Marshal(fmt.Sprint(entry[i]))
+    buf.Write(key)
+    buf.WriteByte(':')
+    encoded, err := json.Marshal(value(entry[i+1]))
+    if err != nil {
+      encoded, _ = json.Marshal(fmt.Sprint(entry[i+1]))
+    }
+    buf.Write(encoded)
+  }
+  buf.WriteString("}\n")
+}
+
+func writeLogfmt(buf *bytes.Buffer, entry []interface{}) {
+  for i := 0; i < len(entry); i += 2 {
+    if i > 0 {
+      buf.WriteByte(' ')
+    }
+    buf.WriteString(logfmtKey(fmt.Sprint(entry[i])))
+    buf.WriteByte('=')
+    v := value(entry[i+1])
+    if v == nil {
+      continue
+    }
+    buf.WriteString(logfmtValue(fmt.Sprint(v)))
+  }
+  buf.WriteByte('\n')
+}
+
+func logfmtKey(key string) string {
+  return strings.Map(func(r rune) rune {
+    if r <= ' ' || r == '=' || r == '"' {
+      return '_'
+    }
+    return r
+  }, key)
+}
+
+func logfmtValue(v string) string {
+  if v == "" || strings.ContainsAny(v, " =\"\\\n\t") {
+    return strconv.Quote(v)
+  }
+  return v
+}
+
+var (
+  defaultMu        sync.RWMutex
+  defaultLogger, _ = New(os.Stderr, "logfmt", InfoLevel)
+)
+
+// Default is the logger of code that isn't handed one
+func Default() *Logger {
+  defaultMu.RLock()
+  defer defaultMu.RUnlock()
+  return defaultLogger
+}
+
+func SetDefault(l *Logger) {
+  defaultMu.Lock()
+  defaultLogger = l
+  defaultMu.Unlock()
+}
+
+// With returns a logger that adds the key value pairs
+// to every entry of the default logger
+func With(keyvals ...interface{}) *Logger {
+  return Default().With(keyvals...)
+}
+
+func Debug(msg string, keyvals ...interface{}) { Default().log(DebugLevel, msg, keyvals) }
+func Info(msg string, keyvals ...interface{})  { Default().log(InfoLevel, msg, keyvals) }
+func Warn(msg string, keyvals ...interface{})  { Default().log(WarnLevel, msg, keyvals) }
+func Error(msg string, keyvals ...interface{}) { Default().log(ErrorLevel, msg, keyvals) }
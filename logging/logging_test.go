@@ -0,0 +1,90 @@
+package logging
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "testing"
+  "time"
+)
+
+func testLogger(t *testing.T, format string, level Level) (*Logger, *bytes.Buffer) {
+  var buf bytes.Buffer
+  logger, err := New(&buf, format, level)
+  if err != nil {
+    t.Fatal(err)
+  }
+  logger.core.now = func() time.Time { return time.Date(2019, 2, 1, 12, 0, 0, 0, time.UTC) }
+  return logger, &buf
+}
+
+func TestLogger_Logfmt(t *testing.T) {
+  logger, buf := testLogger(t, "logfmt", InfoLevel)
+  logger.With("stream", 3, "peer", "127.0.0.1:5000").Info("rejected number",
+    "number", int64(150), "reason", "out of range", "err", errors.New("too big"))
+  
+  expected := `time=2019-02-01T12:00:00Z level=info msg="rejected number" stream=3 peer=127.0.0.1:5000 ` +
+    `number=150 reason="out of range" err="too big"` + "\n"
+  if buf.String() != expected {
+    t.Errorf("Got: %s, wanted: %s\n", buf.String(), expected)
+  }
+}
+
+func TestLogger_JSON(t *testing.T) {
+  logger, buf := testLogger(t, "json", InfoLevel)
+  logger.Warn("closing stream", "stream", 3, "max", int64(2004), "from", DebugLevel)
+  
+  var entry map[string]interface{}
+  if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  expected := map[string]interface{}{
+    "time":   "2019-02-01T12:00:00Z",
+    "level":  "warn",
+    "msg":    "closing stream",
+    "stream": 3.0,
+    "max":    2004.0,
+    "from":   "debug",
+  }
+  for key, value := range expected {
+    if entry[key] != value {
+      t.Errorf("%s: Got: %v, wanted: %v\n", key, entry[key], value)
+    }
+  }
+}
+
+func TestLogger_Level(t *testing.T) {
+  logger, buf := testLogger(t, "logfmt", InfoLevel)
+  streamLogger := logger.With("stream", 1)
+  streamLogger.Debug("received number")
+  if buf.Len() != 0 {
+    t.Errorf("Got: %s, wanted: %s\n", buf.String(), "")
+  }
+  
+  // the level is shared with loggers derived before the change
+  logger.SetLevel(DebugLevel)
+  streamLogger.Debug("received number")
+  if buf.Len() == 0 {
+    t.Errorf("Got: %s, wanted: %s\n", "", "debug entry")
+  }
+  
+  buf.Reset()
+  logger.SetLevel(ErrorLevel)
+  streamLogger.Warn("failed to send stream response")
+  if buf.Len() != 0 {
+    t.Errorf("Got: %s, wanted: %s\n", buf.String(), "")
+  }
+}
+
+func TestParseLevel(t *testing.T) {
+  tests := map[string]Level{"debug": DebugLevel, "INFO": InfoLevel, "Warn": WarnLevel, "error": ErrorLevel}
+  for name, expected := range tests {
+    level, err := ParseLevel(name)
+    if err != nil || level != expected {
+      t.Errorf("%s: Got: %v %v, wanted: %v\n", name, level, err, expected)
+    }
+  }
+  if _, err := ParseLevel("loud"); err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "unknown log level error")
+  }
+}
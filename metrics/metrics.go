@@ -0,0 +1,276 @@
+package metrics
+
+import (
+  "bufio"
+  "fmt"
+  "io"
+  "math"
+  "net/http"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "sync/atomic"
+)
+
+// DefaultBuckets are the upper bounds of latency histograms, in seconds
+var DefaultBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+// collector is a metric family that writes itself in the
+// Prometheus text exposition format
+type collector interface {
+  write(w *bufio.Writer)
+}
+
+// Registry holds metrics and serves them over HTTP
+type Registry struct {
+  mu         sync.Mutex
+  collectors []collector
+  names      map[string]bool
+}
+
+func NewRegistry() *Registry {
+  return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, c collector) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  if r.names[name] {
+    panic(fmt.Sprintf("metrics: %s registered twice", name))
+  }
+  r.names[name] = true
+  r.collectors = append(r.collectors, c)
+}
+
+// WriteText writes every metric in the Prometheus text format
+func (r *Registry) WriteText(w io.Writer) error {
+  r.mu.Lock()
+  collectors := append([]collector{}, r.collectors...)
+  r.mu.Unlock()
+  
+  buffered := bufio.NewWriter(w)
+  for _, c := range collectors {
+    c.write(buffered)
+  }
+  return buffered.Flush()
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+  w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+  r.WriteText(w)
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+  fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+}
+
+func escapeHelp(help string) string {
+  return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func formatLabels(names, values []string) string {
+  if len(names) == 0 {
+    return ""
+  }
+  pairs := make([]string, len(names))
+  escape := strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+  for i, name := range names {
+    pairs[i] = name + `="` + escape.Replace(values[i]) + `"`
+  }
+  return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+  switch {
+  case math.IsInf(value, 1):
+    return "+Inf"
+  case math.IsInf(value, -1):
+    return "-Inf"
+  }
+  return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Counter only ever goes up
+type Counter struct {
+  value uint64
+}
+
+func (c *Counter) Inc() {
+  atomic.AddUint64(&c.value, 1)
+}
+
+func (c *Counter) Add(n uint64) {
+  atomic.AddUint64(&c.value, n)
+}
+
+func (c *Counter) Value() uint64 {
+  return atomic.LoadUint64(&c.value)
+}
+
+type counterFamily struct {
+  name, help string
+  counter    *Counter
+}
+
+func (f *counterFamily) write(w *bufio.Writer) {
+  writeHeader(w, f.name, f.help, "counter")
+  fmt.Fprintf(w, "%s %d\n", f.name, f.counter.Value())
+}
+
+// Counter registers a counter without labels
+func (r *Registry) Counter(name, help string) *Counter {
+  f := &counterFamily{name: name, help: help, counter: &Counter{}}
+  r.register(name, f)
+  return f.counter
+}
+
+// Gauge goes up and down
+type Gauge struct {
+  value int64
+}
+
+func (g *Gauge) Add(n int64) {
+  atomic.AddInt64(&g.value, n)
+}
+
+func (g *Gauge) Set(n int64) {
+  atomic.StoreInt64(&g.value, n)
+}
+
+func (g *Gauge) Value() int64 {
+  return atomic.LoadInt64(&g.value)
+}
+
+type gaugeFamily struct {
+  name, help string
+  gauge      *Gauge
+}
+
+func (f *gaugeFamily) write(w *bufio.Writer) {
+  writeHeader(w, f.name, f.help, "gauge")
+  fmt.Fprintf(w, "%s %d\n", f.name, f.gauge.Value())
+}
+
+// Gauge registers a gauge without labels
+func (r *Registry) Gauge(name, help string) *Gauge {
+  f := &gaugeFamily{name: name, help: help, gauge: &Gauge{}}
+  r.register(name, f)
+  return f.gauge
+}
+
+// CounterVec is a counter per combination of label values
+type CounterVec struct {
+  name, help string
+  labels     []string
+  mu         sync.RWMutex
+  counters   map[string]*Counter
+  values     map[string][]string
+}
+
+// CounterVec registers a counter with the given labels
+func (r *Registry) CounterVec(name, help string, labels ...string) *CounterVec {
+  v := &CounterVec{
+    name:     name,
+    help:     help,
+    labels:   labels,
+    counters: make(map[string]*Counter),
+    values:   make(map[string][]string),
+  }
+  r.register(name, v)
+  return v
+}
+
+// With returns the counter of the label values, given in the order
+// the labels were registered
+func (v *CounterVec) With(values ...string) *Counter {
+  if len(values) != len(v.labels) {
+    panic(fmt.Sprintf("metrics: %s takes %d labels, got %d", v.name, len(v.labels), len(values)))
+  }
+  key := strings.Join(values, "\xff")
+  v.mu.RLock()
+  counter, ok := v.counters[key]
+  v.mu.RUnlock()
+  if ok {
+    return counter
+  }
+  
+  v.mu.Lock()
+  defer v.mu.Unlock()
+  if counter, ok = v.counters[key]; !ok {
+    counter = &Counter{}
+    v.counters[key] = counter
+    v.values[key] = append([]string{}, values...)
+  }
+  return counter
+}
+
+// Delete drops the counter of the label values, e.g. of a closed stream
+func (v *CounterVec) Delete(values ...string) {
+  key := strings.Join(values, "\xff")
+  v.mu.Lock()
+  delete(v.counters, key)
+  delete(v.values, key)
+  v.mu.Unlock()
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+  writeHeader(w, v.name, v.help, "counter")
+  v.mu.RLock()
+  defer v.mu.RUnlock()
+  
+  keys := make([]string, 0, len(v.counters))
+  for key := range v.counters {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+  for _, key := range keys {
+    fmt.Fprintf(w, "%s%s %d\n", v.name, formatLabels(v.labels, v.values[key]), v.counters[key].Value())
+  }
+}
+
+// Histogram counts observations in buckets by upper bound
+type Histogram struct {
+  name, help string
+  buckets    []float64
+  mu         sync.Mutex
+  counts     []uint64
+  sum        float64
+  count      uint64
+}
+
+// Histogram registers a histogram with the given bucket upper bounds
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+  buckets = append([]float64{}, buckets...)
+  sort.Float64s(buckets)
+  h := &Histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+  r.register(name, h)
+  return h
+}
+
+func (h *Histogram) Observe(value float64) {
+  i := sort.SearchFloat64s(h.buckets, value)
+  h.mu.Lock()
+  if i < len(h.counts) {
+    h.counts[i]++
+  }
+  h.sum += value
+  h.count++
+  h.mu.Unlock()
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+  writeHeader(w, h.name, h.help, "histogram")
+  h.mu.Lock()
+  defer h.mu.Unlock()
+  
+  // buckets are cumulative in the text format
+  var cumulative uint64
+  for i, bound := range h.buckets {
+    cumulative += h.counts[i]
+    fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(bound), cumulative)
+  }
+  fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+  fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+  fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
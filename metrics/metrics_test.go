@@ -0,0 +1,73 @@
+package metrics
+
+import (
+  "bytes"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+  r := NewRegistry()
+  r.Counter("numbers_total", "Numbers received.").Add(3)
+  r.Gauge("streams_open", "Open streams.").Set(2)
+  vec := r.CounterVec("messages_total", "Messages per stream.", "stream", "direction")
+  vec.With("1", "received").Inc()
+  vec.With("1", "received").Inc()
+  vec.With("2", `say "hi"`).Inc()
+  vec.With("3", "sent").Inc()
+  vec.Delete("3", "sent")
+  h := r.Histogram("latency_seconds", "Latency.", []float64{0.5, 0.1})
+  h.Observe(0.05)
+  h.Observe(0.1)
+  h.Observe(2)
+  
+  var out bytes.Buffer
+  r.WriteText(&out)
+  expected := `# HELP numbers_total Numbers received.
+# TYPE numbers_total counter
+numbers_total 3
+# HELP streams_open Open streams.
+# TYPE streams_open gauge
+streams_open 2
+# HELP messages_total Messages per stream.
+# TYPE messages_total counter
+messages_total{stream="1",direction="received"} 2
+messages_total{stream="2",direction="say \"hi\""} 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="0.5"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 2.15
+latency_seconds_count 3
+`
+  if out.String() != expected {
+    t.Errorf("Got: %s, wanted: %s\n", out.String(), expected)
+  }
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+  r := NewRegistry()
+  r.Counter("up", "Always one.").Inc()
+  
+  recorder := httptest.NewRecorder()
+  r.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+  if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+    t.Errorf("Got: %s, wanted: %s\n", recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4")
+  }
+  if !strings.Contains(recorder.Body.String(), "\nup 1\n") {
+    t.Errorf("Got: %s, wanted: %s\n", recorder.Body.String(), "up 1")
+  }
+}
+
+func TestRegistry_DuplicateName(t *testing.T) {
+  defer func() {
+    if recover() == nil {
+      t.Errorf("Got: %v, wanted: %s\n", nil, "panic")
+    }
+  }()
+  r := NewRegistry()
+  r.Counter("up", "")
+  r.Gauge("up", "")
+}
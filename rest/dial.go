@@ -0,0 +1,35 @@
+package rest
+
+import (
+  "fmt"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/endpoint"
+  "github.com/salman-ahmad/grpc-streaming/trace"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/credentials"
+)
+
+// Dial connects the gateway to the server at the address the way the
+// client does: over TLS when it is on, trusting the configured CA, and
+// tracing every stream. It never presents a client certificate, so the
+// server knows gateway callers only by the tokens the gateway forwards.
+// The address may be a Unix domain socket, unix:///path
+func Dial(addr string, conf *config.Config) (*grpc.ClientConn, error) {
+  options := []grpc.DialOption{
+    grpc.WithDialer(endpoint.Dial),
+    grpc.WithUnaryInterceptor(trace.UnaryClientInterceptor()),
+    grpc.WithStreamInterceptor(trace.StreamClientInterceptor()),
+  }
+  if !conf.TLS {
+    return grpc.Dial(addr, append(options, grpc.WithInsecure())...)
+  }
+  
+  dialConf := *conf
+  dialConf.TLSClientCert, dialConf.TLSClientKey = "", ""
+  tlsConfig, err := endpoint.ClientTLSConfig(&dialConf)
+  if err != nil {
+    return nil, fmt.Errorf("failed to configure TLS: %v", err)
+  }
+  return grpc.Dial(addr, append(options, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))...)
+}
@@ -0,0 +1,322 @@
+package rest
+
+import (
+  "encoding/json"
+  "fmt"
+  "io"
+  "net/http"
+  "strings"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/auth"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "github.com/salman-ahmad/grpc-streaming/trace"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/metadata"
+  "google.golang.org/grpc/status"
+)
+
+// MaxPath is where numbers are posted
+const MaxPath = "/v1/max"
+
+// maxBodySize bounds the JSON body of a request
+const maxBodySize = 1 << 20
+
+// Number is a signed number, as posted on its own
+// or in the numbers of a batch
+type Number struct {
+  Number    int64  `json:"number"`
+  Signature []byte `json:"signature"`
+  KeyID     string `json:"key_id,omitempty"`
+}
+
+// Batch is the body of a request, either one number or a batch of them
+type Batch struct {
+  Number
+  Numbers []Number `json:"numbers,omitempty"`
+}
+
+// Rejection tells why a number was rejected
+type Rejection struct {
+  Stage  string `json:"stage"`
+  Code   string `json:"code"`
+  Reason string `json:"reason"`
+  Number int64  `json:"number"`
+}
+
+// Response is the JSON form of a MaxNumberResponse
+type Response struct {
+  Max       int64      `json:"max"`
+  Sequence  uint64     `json:"sequence"`
+  UpdatedBy string     `json:"updated_by,omitempty"`
+  UpdatedAt int64      `json:"updated_at,omitempty"`
+  Rejection *Rejection `json:"rejection,omitempty"`
+  Closing   bool       `json:"closing,omitempty"`
+}
+
+// Result is the response to a posted batch: the maximum of
+// the numbers the server accepted, and its response to each
+// number that raised the maximum or was rejected
+type Result struct {
+  Max       int64      `json:"max"`
+  Responses []Response `json:"responses"`
+}
+
+// Error is the body of a failed request
+type Error struct {
+  Code  string `json:"code"`
+  Error string `json:"error"`
+}
+
+// Gateway serves the Simple service as JSON over HTTP. Each request
+// is a FindMaxNumber stream of the posted numbers, so they are verified
+// and aggregated like the numbers of any other stream
+type Gateway struct {
+  client pb.SimpleClient
+  // PingInterval is how often WebSocket clients are pinged; one that
+  // sends nothing for two intervals is gone. Zero turns pings off
+  PingInterval time.Duration
+  // Origins are the origins, besides the gateway's own, whose
+  // browsers may open WebSockets; "*" allows any
+  Origins []string
+}
+
+func New(client pb.SimpleClient) *Gateway {
+  return &Gateway{client: client, PingInterval: 30 * time.Second}
+}
+
+// ServeHTTP answers a POST of numbers on MaxPath with a Result, or
+// with a Server-Sent Event per response when the caller accepts
+// text/event-stream, and bridges WebSockets on StreamPath. The bearer
+// token in the Authorization header or the access_token query parameter,
+// and the session query parameter are passed on to the server. The
+// query parameter ends up in logs and histories, so it is refused
+// over plain HTTP
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  if r.TLS == nil && r.URL.Query().Get("access_token") != "" {
+    writeError(w, http.StatusBadRequest, codes.InvalidArgument,
+      fmt.Errorf("access_token needs HTTPS, send the token in the Authorization header instead"))
+    return
+  }
+  switch r.URL.Path {
+  case MaxPath:
+    g.serveMax(w, r)
+  case StreamPath:
+    g.serveStream(w, r)
+  default:
+    writeError(w, http.StatusNotFound, codes.NotFound, fmt.Errorf("no such endpoint %s", r.URL.Path))
+  }
+}
+
+func (g *Gateway) serveMax(w http.ResponseWriter, r *http.Request) {
+  if r.Method != http.MethodPost {
+    w.Header().Set("Allow", http.MethodPost)
+    writeError(w, http.StatusMethodNotAllowed, codes.Unimplemented, fmt.Errorf("%s needs POST", MaxPath))
+    return
+  }
+  
+  var batch Batch
+  decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+  if err := decoder.Decode(&batch); err != nil {
+    writeError(w, http.StatusBadRequest, codes.InvalidArgument, fmt.Errorf("failed to parse numbers: %v", err))
+    return
+  }
+  numbers := batch.Numbers
+  if len(numbers) == 0 {
+    numbers = []Number{batch.Number}
+  }
+  
+  ctx := outgoingContext(r)
+  logger := logging.With("method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+  logger.Debug("gateway request", "numbers", len(numbers))
+  if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+    g.serveEvents(ctx, w, logger, numbers)
+    return
+  }
+  
+  result := Result{Responses: []Response{}}
+  err := g.findMaxNumber(ctx, numbers, func(response Response) error {
+    if !response.Closing {
+      result.Responses = append(result.Responses, response)
+    }
+    result.Max = response.Max
+    return nil
+  })
+  if err != nil {
+    logger.Info("gateway request failed", "err", err)
+    writeError(w, httpStatus(status.Code(err)), status.Code(err), err)
+    return
+  }
+  w.Header().Set("Content-Type", "application/json")
+  json.NewEncoder(w).Encode(result)
+}
+
+// serveEvents sends a "max", "rejection" or "closing" event per response
+// of the server, and an "end" or "error" event when the stream ends
+func (g *Gateway) serveEvents(ctx context.Context, w http.ResponseWriter, logger *logging.Logger, numbers []Number) {
+  flusher, ok := w.(http.Flusher)
+  if !ok {
+    writeError(w, http.StatusNotAcceptable, codes.Unimplemented, fmt.Errorf("streaming isn't supported"))
+    return
+  }
+  w.Header().Set("Content-Type", "text/event-stream")
+  w.Header().Set("Cache-Control", "no-cache")
+  w.WriteHeader(http.StatusOK)
+  flusher.Flush()
+  
+  var maxNumber int64
+  err := g.findMaxNumber(ctx, numbers, func(response Response) error {
+    event := "max"
+    switch {
+    case response.Closing:
+      event = "closing"
+    case response.Rejection != nil:
+      event = "rejection"
+    }
+    maxNumber = response.Max
+    if err := writeEvent(w, event, response); err != nil {
+      return err
+    }
+    flusher.Flush()
+    return nil
+  })
+  if err != nil {
+    logger.Info("gateway request failed", "err", err)
+    writeEvent(w, "error", Error{Code: status.Code(err).String(), Error: status.Convert(err).Message()})
+  } else {
+    writeEvent(w, "end", Result{Max: maxNumber})
+  }
+  flusher.Flush()
+}
+
+// findMaxNumber sends the numbers on a new stream and hands every
+// response to the callback until the server ends the stream
+func (g *Gateway) findMaxNumber(ctx context.Context, numbers []Number, respond func(Response) error) error {
+  ctx, cancel := context.WithCancel(ctx)
+  defer cancel()
+  stream, err := g.client.FindMaxNumber(ctx)
+  if err != nil {
+    return err
+  }
+  
+  // sending runs alongside receiving, so a large batch can't
+  // fill the flow control windows of both directions
+  sendErr := make(chan error, 1)
+  go func() {
+    sendErr <- sendNumbers(stream, numbers)
+  }()
+  
+  for {
+    response, err := stream.Recv()
+    if err == io.EOF {
+      break
+    }
+    if err != nil {
+      return err
+    }
+    if err := respond(newResponse(response)); err != nil {
+      return err
+    }
+  }
+  // a send fails with io.EOF when the server ended the stream early,
+  // which it told the stream about in its last response
+  if err := <-sendErr; err != nil && err != io.EOF {
+    return err
+  }
+  return nil
+}
+
+func sendNumbers(stream pb.Simple_FindMaxNumberClient, numbers []Number) error {
+  for _, number := range numbers {
+    request := &pb.MaxNumberRequest{Number: number.Number, Signature: number.Signature, KeyId: number.KeyID}
+    if err := stream.Send(request); err != nil {
+      return err
+    }
+  }
+  return stream.CloseSend()
+}
+
+func newResponse(response *pb.MaxNumberResponse) Response {
+  converted := Response{
+    Max:       response.Number,
+    Sequence:  response.Sequence,
+    UpdatedBy: response.UpdatedBy,
+    UpdatedAt: response.UpdatedAt,
+    Closing:   response.Closing,
+  }
+  if rejection := response.Rejection; rejection != nil {
+    converted.Rejection = &Rejection{
+      Stage:  rejection.Stage,
+      Code:   codes.Code(rejection.Code).String(),
+      Reason: rejection.Reason,
+      Number: rejection.Number,
+    }
+  }
+  return converted
+}
+
+// outgoingContext passes the caller's token, session and
+// trace context on to the server
+func outgoingContext(r *http.Request) context.Context {
+  ctx := r.Context()
+  authorization := r.Header.Get("Authorization")
+  // browsers can't set headers on a WebSocket handshake
+  if token := r.URL.Query().Get("access_token"); authorization == "" && token != "" {
+    authorization = "Bearer " + token
+  }
+  if authorization != "" {
+    ctx = metadata.AppendToOutgoingContext(ctx, "authorization", authorization)
+  }
+  if session := r.URL.Query().Get("session"); session != "" {
+    ctx = auth.WithSession(ctx, session)
+  }
+  if parent, err := trace.ParseTraceparent(r.Header.Get(trace.TraceparentKey)); err == nil {
+    ctx = trace.ContextWithRemoteParent(ctx, parent)
+  }
+  return ctx
+}
+
+func writeEvent(w io.Writer, event string, data interface{}) error {
+  encoded, err := json.Marshal(data)
+  if err != nil {
+    return err
+  }
+  _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded)
+  return err
+}
+
+func writeError(w http.ResponseWriter, httpStatus int, code codes.Code, err error) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(httpStatus)
+  json.NewEncoder(w).Encode(Error{Code: code.String(), Error: status.Convert(err).Message()})
+}
+
+// httpStatus maps the status of a failed stream to the HTTP status
+// of the request, the way the other gRPC gateways do
+func httpStatus(code codes.Code) int {
+  switch code {
+  case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition:
+    return http.StatusBadRequest
+  case codes.Unauthenticated:
+    return http.StatusUnauthorized
+  case codes.PermissionDenied:
+    return http.StatusForbidden
+  case codes.NotFound:
+    return http.StatusNotFound
+  case codes.AlreadyExists, codes.Aborted:
+    return http.StatusConflict
+  case codes.ResourceExhausted:
+    return http.StatusTooManyRequests
+  case codes.Canceled:
+    return 499
+  case codes.Unimplemented:
+    return http.StatusNotImplemented
+  case codes.Unavailable:
+    return http.StatusServiceUnavailable
+  case codes.DeadlineExceeded:
+    return http.StatusGatewayTimeout
+  }
+  return http.StatusInternalServerError
+}
@@ -0,0 +1,227 @@
+package rest
+
+import (
+  "bufio"
+  "bytes"
+  "encoding/json"
+  "io"
+  "net"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/metadata"
+  "google.golang.org/grpc/status"
+)
+
+// fakeSimple raises the maximum of numbers signed "good"
+// and rejects the others, for callers with the right token
+type fakeSimple struct{}
+
+func (fakeSimple) FindMaxNumber(stream pb.Simple_FindMaxNumberServer) error {
+  md, _ := metadata.FromIncomingContext(stream.Context())
+  if authorization := md.Get("authorization"); len(authorization) == 0 || authorization[0] != "Bearer luke" {
+    return status.Error(codes.Unauthenticated, "authentication failed")
+  }
+  var max int64
+  var sequence uint64
+  for {
+    request, err := stream.Recv()
+    if err == io.EOF {
+      return nil
+    }
+    if err != nil {
+      return err
+    }
+    sequence++
+    response := &pb.MaxNumberResponse{Number: max, Sequence: sequence}
+    switch {
+    case string(request.Signature) != "good":
+      response.Rejection = &pb.Rejection{
+        Stage:  "verify",
+        Code:   int32(codes.Unauthenticated),
+        Reason: "bad signature",
+        Number: request.Number,
+      }
+    case request.Number > max:
+      max = request.Number
+      response.Number = max
+    default:
+      continue
+    }
+    if err := stream.Send(response); err != nil {
+      return err
+    }
+  }
+}
+
+func (fakeSimple) WatchMax(*pb.WatchMaxRequest, pb.Simple_WatchMaxServer) error {
+  return status.Error(codes.Unimplemented, "not watched through the gateway")
+}
+
+func (fakeSimple) SubmitNumber(context.Context, *pb.MaxNumberRequest) (*pb.SubmitNumberResponse, error) {
+  return nil, status.Error(codes.Unimplemented, "not submitted through the gateway")
+}
+
+func (fakeSimple) GetMax(context.Context, *pb.GetMaxRequest) (*pb.SessionMax, error) {
+  return nil, status.Error(codes.Unimplemented, "not read through the gateway")
+}
+
+func (fakeSimple) GetMaxHistory(context.Context, *pb.GetMaxHistoryRequest) (*pb.MaxHistory, error) {
+  return nil, status.Error(codes.Unimplemented, "not read through the gateway")
+}
+
+func startGateway(t *testing.T, options ...func(*Gateway)) (*httptest.Server, func()) {
+  lis, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  grpcServer := grpc.NewServer()
+  pb.RegisterSimpleServer(grpcServer, fakeSimple{})
+  go grpcServer.Serve(lis)
+  conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+  if err != nil {
+    t.Fatal(err)
+  }
+  
+  gateway := New(pb.NewSimpleClient(conn))
+  for _, option := range options {
+    option(gateway)
+  }
+  httpServer := httptest.NewServer(gateway)
+  return httpServer, func() {
+    httpServer.Close()
+    conn.Close()
+    grpcServer.Stop()
+  }
+}
+
+func post(t *testing.T, url, body, accept string) *http.Response {
+  request, _ := http.NewRequest(http.MethodPost, url+MaxPath, strings.NewReader(body))
+  request.Header.Set("Authorization", "Bearer luke")
+  if accept != "" {
+    request.Header.Set("Accept", accept)
+  }
+  response, err := http.DefaultClient.Do(request)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  return response
+}
+
+// good and bad signatures, base64 encoded
+const good, bad = `"Z29vZA=="`, `"YmFk"`
+
+func TestGateway_Number(t *testing.T) {
+  httpServer, stop := startGateway(t)
+  defer stop()
+  
+  response := post(t, httpServer.URL, `{"number": 7, "signature": `+good+`}`, "")
+  defer response.Body.Close()
+  var result Result
+  json.NewDecoder(response.Body).Decode(&result)
+  if response.StatusCode != http.StatusOK || result.Max != 7 || len(result.Responses) != 1 {
+    t.Errorf("Got: %d %v, wanted: %d %v\n", response.StatusCode, result, http.StatusOK, 7)
+  }
+}
+
+func TestGateway_Batch(t *testing.T) {
+  httpServer, stop := startGateway(t)
+  defer stop()
+  
+  body := `{"numbers": [
+    {"number": 5, "signature": ` + good + `},
+    {"number": 150, "signature": ` + bad + `},
+    {"number": 3, "signature": ` + good + `},
+    {"number": 9, "signature": ` + good + `}
+  ]}`
+  response := post(t, httpServer.URL, body, "")
+  defer response.Body.Close()
+  var result Result
+  json.NewDecoder(response.Body).Decode(&result)
+  if result.Max != 9 || len(result.Responses) != 3 {
+    t.Fatalf("Got: %v, wanted: max %d in %d responses\n", result, 9, 3)
+  }
+  rejection := result.Responses[1].Rejection
+  if rejection == nil || rejection.Number != 150 || rejection.Code != "Unauthenticated" || result.Responses[1].Max != 5 {
+    t.Errorf("Got: %v, wanted: %s\n", result.Responses[1], "rejection of 150")
+  }
+}
+
+func TestGateway_Events(t *testing.T) {
+  httpServer, stop := startGateway(t)
+  defer stop()
+  
+  body := `{"numbers": [{"number": 5, "signature": ` + good + `}, {"number": 150, "signature": ` + bad + `}]}`
+  response := post(t, httpServer.URL, body, "text/event-stream")
+  defer response.Body.Close()
+  if response.Header.Get("Content-Type") != "text/event-stream" {
+    t.Errorf("Got: %s, wanted: %s\n", response.Header.Get("Content-Type"), "text/event-stream")
+  }
+  
+  var events []string
+  scanner := bufio.NewScanner(response.Body)
+  for scanner.Scan() {
+    if strings.HasPrefix(scanner.Text(), "event: ") {
+      events = append(events, strings.TrimPrefix(scanner.Text(), "event: "))
+    }
+  }
+  expected := []string{"max", "rejection", "end"}
+  if strings.Join(events, ",") != strings.Join(expected, ",") {
+    t.Errorf("Got: %v, wanted: %v\n", events, expected)
+  }
+}
+
+func TestGateway_Errors(t *testing.T) {
+  httpServer, stop := startGateway(t)
+  defer stop()
+  
+  request, _ := http.NewRequest(http.MethodPost, httpServer.URL+MaxPath, strings.NewReader(`{"number": 1}`))
+  unauthenticated, _ := http.DefaultClient.Do(request)
+  malformed := post(t, httpServer.URL, `{"number": "one"}`, "")
+  wrongMethod, _ := http.Get(httpServer.URL + MaxPath)
+  queryToken, _ := http.Post(httpServer.URL+MaxPath+"?access_token=luke", "application/json",
+    strings.NewReader(`{"number": 1, "signature": `+good+`}`))
+  tests := []struct {
+    response   *http.Response
+    statusCode int
+    code       string
+  }{
+    {unauthenticated, http.StatusUnauthorized, "Unauthenticated"},
+    {malformed, http.StatusBadRequest, "InvalidArgument"},
+    {wrongMethod, http.StatusMethodNotAllowed, "Unimplemented"},
+    {queryToken, http.StatusBadRequest, "InvalidArgument"},
+  }
+  for _, test := range tests {
+    var body bytes.Buffer
+    body.ReadFrom(test.response.Body)
+    test.response.Body.Close()
+    var gatewayErr Error
+    json.Unmarshal(body.Bytes(), &gatewayErr)
+    if test.response.StatusCode != test.statusCode || gatewayErr.Code != test.code {
+      t.Errorf("Got: %d %s, wanted: %d %s\n", test.response.StatusCode, body.String(), test.statusCode, test.code)
+    }
+  }
+}
+
+func TestGateway_QueryTokenOverHTTPS(t *testing.T) {
+  httpServer, stop := startGateway(t)
+  defer stop()
+  httpsServer := httptest.NewTLSServer(httpServer.Config.Handler)
+  defer httpsServer.Close()
+  
+  response, err := httpsServer.Client().Post(httpsServer.URL+MaxPath+"?access_token=luke", "application/json",
+    strings.NewReader(`{"number": 1, "signature": `+good+`}`))
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  response.Body.Close()
+  if response.StatusCode != http.StatusOK {
+    t.Errorf("Got: %d, wanted: %d\n", response.StatusCode, http.StatusOK)
+  }
+}
@@ -0,0 +1,37 @@
+package rest
+
+import (
+  "crypto/tls"
+  "fmt"
+  "net"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+)
+
+// Listen listens for the gateway's callers on the TCP address, over TLS
+// with the server's certificate when TLS is on, so the tokens they send
+// are never in the clear. It returns the URL scheme callers use
+func Listen(addr string, conf *config.Config) (net.Listener, string, error) {
+  lis, err := net.Listen("tcp", addr)
+  if err != nil || !conf.TLS {
+    return lis, "http", err
+  }
+  
+  certPath, err := config.AbsolutePath(conf.TLSCert)
+  if err != nil {
+    lis.Close()
+    return nil, "", err
+  }
+  keyPath, err := config.AbsolutePath(conf.TLSKey)
+  if err != nil {
+    lis.Close()
+    return nil, "", err
+  }
+  cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+  if err != nil {
+    lis.Close()
+    return nil, "", fmt.Errorf("failed to load the gateway's certificate: %v", err)
+  }
+  tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+  return tls.NewListener(lis, tlsConfig), "https", nil
+}
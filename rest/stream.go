@@ -0,0 +1,229 @@
+package rest
+
+import (
+  "bytes"
+  "io"
+  "net/http"
+  "time"
+  
+  "github.com/golang/protobuf/jsonpb"
+  "github.com/golang/protobuf/proto"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "github.com/salman-ahmad/grpc-streaming/websocket"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+// StreamPath is where a WebSocket is bridged to a FindMaxNumber stream
+const StreamPath = "/v1/stream"
+
+// Subprotocols of the bridge, which tell how frames are encoded. Without
+// one, frames are JSON
+const (
+  JSONProtocol  = "maxnumber.v1.json"
+  ProtoProtocol = "maxnumber.v1.proto"
+)
+
+// maxFrameSize bounds the frame of a request
+const maxFrameSize = 64 << 10
+
+// writeTimeout is how long a client may take to read a frame before
+// the bridge gives up on it
+const writeTimeout = 10 * time.Second
+
+// codec encodes the frames of a subprotocol
+type codec struct {
+  messageType int
+  marshal     func(proto.Message) ([]byte, error)
+  unmarshal   func([]byte, proto.Message) error
+}
+
+func codecFor(subprotocol string) codec {
+  if subprotocol == ProtoProtocol {
+    return codec{websocket.BinaryMessage, proto.Marshal, proto.Unmarshal}
+  }
+  marshaler := jsonpb.Marshaler{OrigName: true}
+  return codec{
+    messageType: websocket.TextMessage,
+    marshal: func(message proto.Message) ([]byte, error) {
+      var frame bytes.Buffer
+      err := marshaler.Marshal(&frame, message)
+      return frame.Bytes(), err
+    },
+    unmarshal: func(frame []byte, message proto.Message) error {
+      return jsonpb.Unmarshal(bytes.NewReader(frame), message)
+    },
+  }
+}
+
+// invalidFrameError is a request frame that doesn't decode
+type invalidFrameError struct {
+  err error
+}
+
+func (e invalidFrameError) Error() string {
+  return "invalid frame: " + e.err.Error()
+}
+
+// serveStream relays the MaxNumberRequest frames of a WebSocket to a new
+// FindMaxNumber stream, and the stream's responses back as frames. Each
+// direction holds one frame at a time, so a client that stops reading
+// stops the server's responses through gRPC flow control, and a server
+// that stops receiving stops reading the client's frames. An empty frame
+// half-closes the stream; the socket is closed once the stream ends
+func (g *Gateway) serveStream(w http.ResponseWriter, r *http.Request) {
+  conn, err := websocket.Upgrade(w, r, []string{JSONProtocol, ProtoProtocol}, g.Origins)
+  if err != nil {
+    logging.Debug("websocket handshake failed", "remote", r.RemoteAddr, "err", err)
+    return
+  }
+  defer conn.Close()
+  logger := logging.With("path", r.URL.Path, "remote", r.RemoteAddr, "subprotocol", conn.Subprotocol())
+  logger.Debug("opened websocket")
+  
+  ctx, cancel := context.WithCancel(outgoingContext(r))
+  defer cancel()
+  stream, err := g.client.FindMaxNumber(ctx)
+  if err != nil {
+    closeWebSocket(conn, logger, err)
+    return
+  }
+  codec := codecFor(conn.Subprotocol())
+  
+  // a failed request ends the stream, except when the server
+  // ended it first, which receiving then tells about
+  requestErr := make(chan error, 1)
+  go func() {
+    err := relayRequests(conn, stream, codec, 2*g.PingInterval)
+    requestErr <- err
+    if err != io.EOF {
+      cancel()
+    }
+  }()
+  done := make(chan struct{})
+  defer close(done)
+  if g.PingInterval > 0 {
+    go keepAlive(conn, g.PingInterval, done)
+  }
+  
+  err = relayResponses(conn, stream, codec)
+  if status.Code(err) == codes.Canceled {
+    select {
+    case err = <-requestErr:
+    default:
+    }
+  }
+  closeWebSocket(conn, logger, err)
+}
+
+// relayRequests sends the numbers of the client's frames until the client
+// goes away. Every frame or pong keeps the client alive for the timeout
+func relayRequests(conn *websocket.Conn, stream pb.Simple_FindMaxNumberClient, codec codec, timeout time.Duration) error {
+  alive := func() error {
+    if timeout <= 0 {
+      return nil
+    }
+    return conn.SetReadDeadline(time.Now().Add(timeout))
+  }
+  conn.SetReadLimit(maxFrameSize)
+  conn.SetPongHandler(alive)
+  alive()
+  
+  halfClosed := false
+  for {
+    _, frame, err := conn.ReadMessage()
+    if err != nil {
+      return err
+    }
+    alive()
+    if halfClosed {
+      return invalidFrameError{io.ErrUnexpectedEOF}
+    }
+    if len(frame) == 0 {
+      halfClosed = true
+      if err := stream.CloseSend(); err != nil {
+        return err
+      }
+      continue
+    }
+    
+    request := &pb.MaxNumberRequest{}
+    if err := codec.unmarshal(frame, request); err != nil {
+      return invalidFrameError{err}
+    }
+    if err := stream.Send(request); err != nil {
+      return err
+    }
+  }
+}
+
+// relayResponses writes a frame per response until the stream ends
+func relayResponses(conn *websocket.Conn, stream pb.Simple_FindMaxNumberClient, codec codec) error {
+  for {
+    response, err := stream.Recv()
+    if err == io.EOF {
+      return nil
+    }
+    if err != nil {
+      return err
+    }
+    frame, err := codec.marshal(response)
+    if err != nil {
+      return err
+    }
+    conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+    if err := conn.WriteMessage(codec.messageType, frame); err != nil {
+      return err
+    }
+  }
+}
+
+// keepAlive pings the client, whose pongs keep relayRequests reading
+func keepAlive(conn *websocket.Conn, interval time.Duration, done <-chan struct{}) {
+  ticker := time.NewTicker(interval)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-done:
+      return
+    case <-ticker.C:
+      conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+      if err := conn.WriteControl(websocket.PingMessage, nil); err != nil {
+        return
+      }
+    }
+  }
+}
+
+// closeWebSocket tells the client why its stream ended. Clients that
+// closed the socket themselves, or stopped answering, aren't told
+func closeWebSocket(conn *websocket.Conn, logger *logging.Logger, err error) {
+  if err == nil {
+    logger.Debug("closed websocket")
+    conn.WriteClose(websocket.CloseNormal, "")
+    return
+  }
+  if invalid, ok := err.(invalidFrameError); ok {
+    logger.Info("websocket stream failed", "err", err)
+    conn.WriteClose(websocket.CloseInvalidPayload, invalid.Error())
+    return
+  }
+  s, ok := status.FromError(err)
+  if !ok {
+    logger.Debug("closed websocket", "err", err)
+    return
+  }
+  logger.Info("websocket stream failed", "err", err)
+  code := websocket.CloseInternalError
+  switch s.Code() {
+  case codes.Unauthenticated, codes.PermissionDenied, codes.ResourceExhausted:
+    code = websocket.ClosePolicyViolation
+  case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition:
+    code = websocket.CloseInvalidPayload
+  case codes.Unavailable:
+    code = websocket.CloseGoingAway
+  }
+  conn.WriteClose(code, s.Code().String()+": "+s.Message())
+}
@@ -0,0 +1,158 @@
+package rest
+
+import (
+  "net/http"
+  "strings"
+  "testing"
+  "time"
+  
+  "github.com/golang/protobuf/proto"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "github.com/salman-ahmad/grpc-streaming/websocket"
+)
+
+// dialStream opens a WebSocket on the gateway with the bearer token, if any
+func dialStream(t *testing.T, url, token string, subprotocols ...string) *websocket.Conn {
+  header := http.Header{}
+  if token != "" {
+    header.Set("Authorization", "Bearer "+token)
+  }
+  conn, _, err := websocket.Dial(strings.Replace(url, "http://", "ws://", 1)+StreamPath, subprotocols, header)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+  return conn
+}
+
+// readFrames reads until the bridge closes the socket
+func readFrames(conn *websocket.Conn) ([][]byte, error) {
+  var frames [][]byte
+  for {
+    _, frame, err := conn.ReadMessage()
+    if err != nil {
+      return frames, err
+    }
+    frames = append(frames, frame)
+  }
+}
+
+func closeCode(err error) int {
+  if closeErr, ok := err.(*websocket.CloseError); ok {
+    return closeErr.Code
+  }
+  return 0
+}
+
+func TestStream_JSON(t *testing.T) {
+  httpServer, stop := startGateway(t)
+  defer stop()
+  conn := dialStream(t, httpServer.URL, "luke", JSONProtocol)
+  defer conn.Close()
+  if conn.Subprotocol() != JSONProtocol {
+    t.Errorf("Got: %s, wanted: %s\n", conn.Subprotocol(), JSONProtocol)
+  }
+  
+  conn.WriteMessage(websocket.TextMessage, []byte(`{"number": "5", "signature": `+good+`}`))
+  conn.WriteMessage(websocket.TextMessage, []byte(`{"number": 150, "signature": `+bad+`, "key_id": "k"}`))
+  conn.WriteMessage(websocket.TextMessage, nil)
+  frames, err := readFrames(conn)
+  if closeCode(err) != websocket.CloseNormal || len(frames) != 2 {
+    t.Fatalf("Got: %d frames %v, wanted: %d frames %d\n", len(frames), err, 2, websocket.CloseNormal)
+  }
+  expected := []string{
+    `{"number":"5","sequence":"1"}`,
+    `{"number":"5","sequence":"2","rejection":{"stage":"verify","code":16,"reason":"bad signature","number":"150"}}`,
+  }
+  for i, frame := range frames {
+    if string(frame) != expected[i] {
+      t.Errorf("Got: %s, wanted: %s\n", frame, expected[i])
+    }
+  }
+}
+
+func TestStream_Proto(t *testing.T) {
+  httpServer, stop := startGateway(t)
+  defer stop()
+  conn := dialStream(t, httpServer.URL, "luke", ProtoProtocol, JSONProtocol)
+  defer conn.Close()
+  if conn.Subprotocol() != ProtoProtocol {
+    t.Errorf("Got: %s, wanted: %s\n", conn.Subprotocol(), ProtoProtocol)
+  }
+  
+  for _, number := range []int64{3, 8, 6} {
+    frame, _ := proto.Marshal(&pb.MaxNumberRequest{Number: number, Signature: []byte("good")})
+    conn.WriteMessage(websocket.BinaryMessage, frame)
+  }
+  conn.WriteMessage(websocket.BinaryMessage, nil)
+  frames, err := readFrames(conn)
+  if closeCode(err) != websocket.CloseNormal || len(frames) != 2 {
+    t.Fatalf("Got: %d frames %v, wanted: %d frames %d\n", len(frames), err, 2, websocket.CloseNormal)
+  }
+  var response pb.MaxNumberResponse
+  if err := proto.Unmarshal(frames[1], &response); err != nil || response.Number != 8 || response.Sequence != 2 {
+    t.Errorf("Got: %v %v, wanted: %d\n", response, err, 8)
+  }
+}
+
+func TestStream_Errors(t *testing.T) {
+  httpServer, stop := startGateway(t)
+  defer stop()
+  
+  tests := []struct {
+    name  string
+    token string
+    frame string
+    code  int
+  }{
+    {"unauthenticated", "", `{"number": 1, "signature": ` + good + `}`, websocket.ClosePolicyViolation},
+    {"malformed", "luke", `{"number": "one"}`, websocket.CloseInvalidPayload},
+    {"unknown field", "luke", `{"numbers": [1]}`, websocket.CloseInvalidPayload},
+  }
+  for _, test := range tests {
+    conn := dialStream(t, httpServer.URL, test.token)
+    conn.WriteMessage(websocket.TextMessage, []byte(test.frame))
+    _, err := readFrames(conn)
+    if closeCode(err) != test.code {
+      t.Errorf("%s: Got: %v, wanted: %d\n", test.name, err, test.code)
+    }
+    conn.Close()
+  }
+}
+
+func TestStream_Keepalive(t *testing.T) {
+  pingInterval := func(g *Gateway) {
+    g.PingInterval = 20 * time.Millisecond
+  }
+  httpServer, stop := startGateway(t, pingInterval)
+  defer stop()
+  
+  // a client answering pings stays connected while it sends nothing
+  alive := dialStream(t, httpServer.URL, "luke")
+  defer alive.Close()
+  frames := make(chan []byte)
+  go func() {
+    defer close(frames)
+    for {
+      _, frame, err := alive.ReadMessage()
+      if err != nil {
+        return
+      }
+      frames <- frame
+    }
+  }()
+  time.Sleep(200 * time.Millisecond)
+  alive.WriteMessage(websocket.TextMessage, []byte(`{"number": 4, "signature": `+good+`}`))
+  if frame := <-frames; !strings.Contains(string(frame), `"number":"4"`) {
+    t.Errorf("Got: %s, wanted: %s\n", frame, "the maximum 4")
+  }
+  
+  // a client that doesn't answer is dropped without a close frame
+  silent := dialStream(t, httpServer.URL, "luke")
+  defer silent.Close()
+  time.Sleep(200 * time.Millisecond)
+  _, err := readFrames(silent)
+  if err == nil || closeCode(err) != 0 {
+    t.Errorf("Got: %v, wanted: %s\n", err, "a dropped connection")
+  }
+}
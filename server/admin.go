@@ -0,0 +1,33 @@
+package main
+
+import (
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+// adminServer lets operators change the running server
+type adminServer struct {
+  logger   *logging.Logger
+  identity string
+}
+
+func (a adminServer) GetLogLevel(ctx context.Context, req *pb.GetLogLevelRequest) (*pb.LogLevel, error) {
+  return &pb.LogLevel{Level: a.logger.Level().String()}, nil
+}
+
+// SetLogLevel changes the level of the server's logger, which every
+// stream's logger shares. The change is logged whatever the new level is
+func (a adminServer) SetLogLevel(ctx context.Context, req *pb.LogLevel) (*pb.LogLevel, error) {
+  level, err := logging.ParseLevel(req.Level)
+  if err != nil {
+    return nil, status.Error(codes.InvalidArgument, err.Error())
+  }
+  previous := a.logger.Level()
+  a.logger.SetLevel(level)
+  a.logger.Warn("changed log level", "from", previous, "to", level,
+    "peer", peerAddress(ctx), "identity", callerIdentity(ctx, a.identity))
+  return &pb.LogLevel{Level: level.String()}, nil
+}
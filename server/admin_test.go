@@ -0,0 +1,41 @@
+package main
+
+import (
+  "context"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+func TestAdmin_LogLevel(t *testing.T) {
+  pki := newTestPKI(t, "leia")
+  defer os.RemoveAll(pki.dir)
+  
+  // leia may read the log level but not change it
+  policyPath := filepath.Join(pki.dir, "policy.json")
+  ioutil.WriteFile(policyPath, []byte(`{
+    "rules": [{"identities": ["leia"], "methods": ["/simple.Admin/GetLogLevel"], "sessions": ["*"]}]
+  }`), 0644)
+  
+  port := "7010"
+  env := append(pki.serverEnv(port), "GRPC_POLICY="+policyPath, "GRPC_LOG_LEVEL=warn", "GRPC_LOG_FORMAT=json")
+  serverCmd := startServerWith(env...)
+  defer stopServer(serverCmd)
+  
+  conn := pki.dialTLS(t, port, true)
+  defer conn.Close()
+  admin := pb.NewAdminClient(conn)
+  level, err := admin.GetLogLevel(context.Background(), &pb.GetLogLevelRequest{})
+  if err != nil || level.Level != "warn" {
+    t.Errorf("Got: %v %v, wanted: %s\n", level, err, "warn")
+  }
+  _, err = admin.SetLogLevel(context.Background(), &pb.LogLevel{Level: "debug"})
+  if status.Code(err) != codes.PermissionDenied {
+    t.Errorf("Got: %v, wanted: %v\n", err, codes.PermissionDenied)
+  }
+}
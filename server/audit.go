@@ -0,0 +1,67 @@
+package main
+
+import (
+  "fmt"
+  
+  "github.com/salman-ahmad/grpc-streaming/audit"
+  "github.com/salman-ahmad/grpc-streaming/chain"
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+// openAudit opens the audit log the server appends every number to;
+// without one the server keeps no audit trail
+func openAudit(auditLog string) (*audit.Log, error) {
+  logging.Debug("openAudit()")
+  if auditLog == "" {
+    return nil, nil
+  }
+  auditPath, err := config.AbsolutePath(auditLog)
+  if err != nil {
+    return nil, fmt.Errorf("failed to calculate audit log's absolute path: %v", err)
+  }
+  log, err := audit.Open(auditPath)
+  if err != nil {
+    return nil, fmt.Errorf("failed to open audit log: %v", err)
+  }
+  logging.Info("appending to audit log", "path", auditPath)
+  return log, nil
+}
+
+// record appends the outcome of the request to the audit log, with the
+// session's maximum after it. err is the chain's, or that of the offer
+// to the session. A number that can't be recorded must not count, so
+// the error fails the stream or call
+func (s server) record(request *chain.Request, max int64, err error) error {
+  if s.audit == nil {
+    return nil
+  }
+  entry := audit.Entry{
+    Session:    request.Stream.Session,
+    Identity:   request.Stream.Identity,
+    Stream:     request.Stream.ID,
+    Sequence:   request.Sequence,
+    Number:     request.Number,
+    Signature:  request.Signature,
+    KeyID:      request.KeyID,
+    VerifiedBy: request.Annotations["key_id"],
+    Outcome:    audit.Accepted,
+    Max:        max,
+  }
+  if rejection, rejected := chain.IsRejection(err); rejected {
+    entry.Outcome = audit.Rejected
+    entry.Stage = rejection.Stage
+    entry.Code = rejection.Code.String()
+    entry.Reason = rejection.Reason
+  } else if err != nil {
+    entry.Outcome = audit.Failed
+    entry.Reason = err.Error()
+  }
+  if _, err := s.audit.Append(entry); err != nil {
+    logging.Error("failed to record number", "stream", request.Stream.ID, "sequence", request.Sequence, "err", err)
+    return status.Error(codes.Internal, "failed to record number in the audit log")
+  }
+  return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+  "context"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  
+  "github.com/salman-ahmad/grpc-streaming/audit"
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+)
+
+func TestFindMaxNumber_Audit(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "audit")
+  defer os.RemoveAll(dir)
+  auditPath := filepath.Join(dir, "audit.jsonl")
+  
+  port := "7018"
+  serverCmd := startServerWith("GRPC_PORT="+port, "GRPC_AUDIT_LOG="+auditPath,
+    "GRPC_CHAIN=verify,range,max", "GRPC_RANGE_MAX=100")
+  conn := startClient(port)
+  client := pb.NewSimpleClient(conn)
+  privateKey := rsaPrivateKey()
+  signed := func(number int64) *pb.MaxNumberRequest {
+    signature, _ := privateKey.Sign(crypto.Int64ToBytes(number))
+    return &pb.MaxNumberRequest{Number: number, Signature: signature}
+  }
+  
+  if _, err := client.SubmitNumber(context.Background(), signed(60)); err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  stream, err := client.FindMaxNumber(context.Background())
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  stream.Send(signed(40))
+  stream.Send(signed(90))
+  stream.Send(signed(150))
+  stream.Send(&pb.MaxNumberRequest{Number: 80, Signature: signed(40).Signature})
+  for {
+    if _, err := stream.Recv(); err != nil {
+      break
+    }
+  }
+  stopClient(conn)
+  stopServer(serverCmd)
+  
+  file, err := os.Open(auditPath)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  defer file.Close()
+  var entries []audit.Entry
+  _, err = audit.Read(file, func(entry audit.Entry) error {
+    entries = append(entries, entry)
+    return nil
+  })
+  if err != nil || len(entries) != 5 {
+    t.Fatalf("Got: %d entries %v, wanted: %d\n", len(entries), err, 5)
+  }
+  tests := []struct {
+    number   int64
+    outcome  string
+    max      int64
+    verified bool
+  }{
+    // entries have the session's maximum, which 40 doesn't raise
+    {60, audit.Accepted, 60, true},
+    {40, audit.Accepted, 60, true},
+    {90, audit.Accepted, 90, true},
+    {150, audit.Rejected, 90, true},
+    {80, audit.Failed, 90, false},
+  }
+  for i, test := range tests {
+    entry := entries[i]
+    if entry.Number != test.number || entry.Outcome != test.outcome || entry.Max != test.max ||
+      (entry.VerifiedBy != "") != test.verified || len(entry.Signature) == 0 {
+      t.Errorf("Got: %+v, wanted: %+v\n", entry, test)
+    }
+  }
+  if entries[3].Stage != "range" || entries[3].Code != "OutOfRange" {
+    t.Errorf("Got: %+v, wanted: stage %s code %s\n", entries[3], "range", "OutOfRange")
+  }
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+  "fmt"
+  "io/ioutil"
+  "strings"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/auth"
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+// jwtLeeway allows for clock skew between token issuers and the server
+const jwtLeeway = time.Minute
+
+// newAuthenticator builds the authenticators named in the configuration,
+// "jwt" and "token", tried in the order given
+func newAuthenticator(conf *config.Config) (auth.Authenticator, error) {
+  var authenticators auth.Authenticators
+  for _, name := range conf.Auth {
+    switch name {
+    case "jwt":
+      secretPath, err := config.AbsolutePath(conf.AuthJWTSecret)
+      if err != nil {
+        return nil, err
+      }
+      secret, err := ioutil.ReadFile(secretPath)
+      if err != nil {
+        return nil, err
+      }
+      secret = []byte(strings.TrimSpace(string(secret)))
+      if len(secret) < 32 {
+        return nil, fmt.Errorf("JWT secret in %s must be at least 32 bytes", secretPath)
+      }
+      authenticators = append(authenticators, &auth.JWTVerifier{
+        Secret:   secret,
+        Issuer:   conf.AuthJWTIssuer,
+        Audience: conf.AuthJWTAudience,
+        Leeway:   jwtLeeway,
+      })
+    case "token":
+      tokensPath, err := config.AbsolutePath(conf.AuthTokens)
+      if err != nil {
+        return nil, err
+      }
+      tokens, err := auth.LoadTokenFile(tokensPath)
+      if err != nil {
+        return nil, err
+      }
+      logging.Info("loaded tokens", "tokens", len(tokens.Tokens()), "path", tokensPath)
+      authenticators = append(authenticators, tokens)
+    default:
+      return nil, fmt.Errorf("unknown authentication %q, expected jwt or token", name)
+    }
+  }
+  return authenticators, nil
+}
+
+// authenticate checks the bearer token of an RPC and returns
+// a context carrying its subject. A caller with a verified client
+// certificate must present a token of the certificate's identity,
+// so a leaked token can't be used with a certificate of its own
+func authenticate(ctx context.Context, authenticator auth.Authenticator, field, method string) (context.Context, error) {
+  token, err := auth.BearerTokenFromContext(ctx)
+  if err == nil {
+    var subject string
+    if subject, err = authenticator.Authenticate(token); err == nil {
+      if identity := peerIdentity(ctx, field); identity != "" && identity != subject {
+        logging.Warn("denied call with token of another identity", "method", method, "peer", peerAddress(ctx),
+          "identity", identity, "subject", subject)
+        return nil, status.Errorf(codes.PermissionDenied, "token of %q presented by %q", subject, identity)
+      }
+      return auth.NewContext(ctx, subject), nil
+    }
+  }
+  logging.Warn("denied unauthenticated call", "method", method, "peer", peerAddress(ctx), "err", err)
+  return nil, status.Errorf(codes.Unauthenticated, "authentication failed: %v", err)
+}
+
+func unaryAuthInterceptor(authenticator auth.Authenticator, field string) grpc.UnaryServerInterceptor {
+  return func(
+    ctx context.Context,
+    req interface{},
+    info *grpc.UnaryServerInfo,
+    handler grpc.UnaryHandler) (interface{}, error) {
+    
+    ctx, err := authenticate(ctx, authenticator, field, info.FullMethod)
+    if err != nil {
+      return nil, err
+    }
+    return handler(ctx, req)
+  }
+}
+
+func streamAuthInterceptor(authenticator auth.Authenticator, field string) grpc.StreamServerInterceptor {
+  return func(
+    srv interface{},
+    stream grpc.ServerStream,
+    info *grpc.StreamServerInfo,
+    handler grpc.StreamHandler) error {
+    
+    ctx, err := authenticate(stream.Context(), authenticator, field, info.FullMethod)
+    if err != nil {
+      return err
+    }
+    return handler(srv, contextStream{ServerStream: stream, ctx: ctx})
+  }
+}
+
+// authInterceptors returns the token authentication interceptors,
+// or nil when authentication isn't configured
+func authInterceptors(conf *config.Config) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor, error) {
+  logging.Debug("authInterceptors()")
+  if len(conf.Auth) == 0 {
+    logging.Info("token authentication is off")
+    return nil, nil, nil
+  }
+  
+  authenticator, err := newAuthenticator(conf)
+  if err != nil {
+    return nil, nil, fmt.Errorf("failed to configure authentication: %v", err)
+  }
+  if !conf.TLS {
+    logging.Warn("token authentication without TLS, clients can't send tokens")
+  }
+  logging.Info("token authentication is on", "auth", conf.Auth)
+  return unaryAuthInterceptor(authenticator, conf.TLSIdentity), streamAuthInterceptor(authenticator, conf.TLSIdentity), nil
+}
+
+// callerIdentity is the identity of the caller's verified client
+// certificate, or else the subject of its token
+func callerIdentity(ctx context.Context, field string) string {
+  if identity := peerIdentity(ctx, field); identity != "" {
+    return identity
+  }
+  subject, _ := auth.SubjectFromContext(ctx)
+  return subject
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+  "context"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  
+  "github.com/salman-ahmad/grpc-streaming/auth"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+func TestFindMaxNumber_TokenAuth(t *testing.T) {
+  pki := newTestPKI(t, "leia")
+  defer os.RemoveAll(pki.dir)
+  trustStore, lukeKey := trustKey(t, pki.dir, "luke")
+  
+  secret := []byte("0123456789abcdef0123456789abcdef")
+  secretPath := filepath.Join(pki.dir, "jwt_secret")
+  ioutil.WriteFile(secretPath, secret, 0600)
+  jwt, _ := auth.SignJWT("HS256", secret, auth.Claims{Subject: "luke"})
+  
+  tokensPath := filepath.Join(pki.dir, "tokens.json")
+  tokens, _ := auth.LoadTokenFile(tokensPath)
+  opaque, _ := tokens.Add("luke")
+  tokens.Save()
+  
+  // TLS without client certificates, callers are known by their tokens
+  port := "7005"
+  serverCmd := startServerWith(
+    "GRPC_PORT="+port,
+    "GRPC_TLS=true",
+    "GRPC_TLS_CERT="+pki.serverCert,
+    "GRPC_TLS_KEY="+pki.serverKey,
+    "GRPC_TRUST_STORE="+trustStore,
+    "GRPC_AUTH=jwt,token",
+    "GRPC_AUTH_JWT_SECRET="+secretPath,
+    "GRPC_AUTH_TOKENS="+tokensPath,
+  )
+  defer stopServer(serverCmd)
+  
+  // no key ID: the server picks luke's key from the token's subject
+  for _, token := range []string{jwt, opaque} {
+    conn := pki.dialTLS(t, port, false, grpc.WithPerRPCCredentials(auth.BearerToken(token)))
+    response, err := sendSigned(context.Background(), conn, lukeKey, "", 8, 2)
+    conn.Close()
+    if err != nil {
+      t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+    if response.Number != 8 {
+      t.Errorf("Got: %d, wanted: %d\n", response.Number, 8)
+    }
+  }
+  
+  for _, options := range [][]grpc.DialOption{
+    nil,
+    {grpc.WithPerRPCCredentials(auth.BearerToken("guess"))},
+  } {
+    conn := pki.dialTLS(t, port, false, options...)
+    _, err := sendSigned(context.Background(), conn, lukeKey, "", 1)
+    conn.Close()
+    if status.Code(err) != codes.Unauthenticated {
+      t.Errorf("Got: %v, wanted: %v\n", err, codes.Unauthenticated)
+    }
+  }
+}
+
+func TestFindMaxNumber_TokenAuthOfOtherPeer(t *testing.T) {
+  pki := newTestPKI(t, "leia")
+  defer os.RemoveAll(pki.dir)
+  trustKey(t, pki.dir, "leia")
+  trustStore, hanKey := trustKey(t, pki.dir, "han")
+  tokensPath := filepath.Join(pki.dir, "tokens.json")
+  tokens, _ := auth.LoadTokenFile(tokensPath)
+  hanToken, _ := tokens.Add("han")
+  tokens.Save()
+  
+  port := "7014"
+  env := append(pki.serverEnv(port), "GRPC_TRUST_STORE="+trustStore, "GRPC_AUTH=token",
+    "GRPC_AUTH_TOKENS="+tokensPath)
+  serverCmd := startServerWith(env...)
+  defer stopServer(serverCmd)
+  
+  // han's leaked token doesn't make leia's certificate han's
+  conn := pki.dialTLS(t, port, true, grpc.WithPerRPCCredentials(auth.BearerToken(hanToken)))
+  defer conn.Close()
+  if _, err := sendSigned(context.Background(), conn, hanKey, "", 5); status.Code(err) != codes.PermissionDenied {
+    t.Errorf("Got: %v, wanted: %v\n", err, codes.PermissionDenied)
+  }
+}
+
+func TestFindMaxNumber_Policy(t *testing.T) {
+  pki := newTestPKI(t, "leia")
+  defer os.RemoveAll(pki.dir)
+  trustStore, leiaKey := trustKey(t, pki.dir, "leia")
+  
+  policyPath := filepath.Join(pki.dir, "policy.json")
+  ioutil.WriteFile(policyPath, []byte(`{
+    "rules": [{"identities": ["leia"], "methods": ["/simple.Simple/*"], "sessions": ["rebels-*"]}]
+  }`), 0644)
+  
+  port := "7006"
+  env := append(pki.serverEnv(port), "GRPC_TRUST_STORE="+trustStore, "GRPC_POLICY="+policyPath)
+  serverCmd := startServerWith(env...)
+  defer stopServer(serverCmd)
+  
+  conn := pki.dialTLS(t, port, true)
+  defer conn.Close()
+  tests := map[string]codes.Code{
+    "rebels-1": codes.OK,
+    "empire":   codes.PermissionDenied,
+    // RPCs without a session work on the default session
+    "":       codes.PermissionDenied,
+    "../etc": codes.InvalidArgument,
+  }
+  for session, expected := range tests {
+    ctx := context.Background()
+    if session != "" {
+      ctx = auth.WithSession(ctx, session)
+    }
+    _, err := sendSigned(ctx, conn, leiaKey, "", 4)
+    if status.Code(err) != expected {
+      t.Errorf("Got: %v, wanted: %v for session %q\n", err, expected, session)
+    }
+  }
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/cluster"
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  "github.com/salman-ahmad/grpc-streaming/state"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+// offerCommand is a number offered to a session, as the
+// nodes of a cluster replicate it
+type offerCommand struct {
+  Session  string `json:"session"`
+  Number   int64  `json:"number"`
+  By       string `json:"by,omitempty"`
+  Sequence uint64 `json:"sequence"`
+  // At is when the node that took the number offered it, in Unix
+  // nanoseconds, so every node records the same time
+  At int64 `json:"at"`
+}
+
+// offerResult is the session after the leader applied the offer
+type offerResult struct {
+  Session state.Session `json:"session"`
+  Raised  bool          `json:"raised"`
+}
+
+// clusterNode is the server's Raft node and the
+// server it serves the other nodes on
+type clusterNode struct {
+  *cluster.Node
+  addr   string
+  server *grpc.Server
+}
+
+// newClusterNode joins the cluster the configuration names, applying
+// the offers of every node to the sessions. Without cluster
+// nodes the server keeps its sessions to itself
+func newClusterNode(conf *config.Config, sessions *state.Store) (*clusterNode, error) {
+  logging.Debug("newClusterNode()")
+  if len(conf.ClusterNodes) == 0 {
+    return nil, nil
+  }
+  nodes, err := cluster.ParseNodes(conf.ClusterNodes)
+  if err != nil {
+    return nil, fmt.Errorf("failed to read cluster nodes: %v", err)
+  }
+  raftDir := ""
+  if conf.RaftDir != "" {
+    if raftDir, err = config.AbsolutePath(conf.RaftDir); err != nil {
+      return nil, fmt.Errorf("failed to calculate raft directory's absolute path: %v", err)
+    }
+  }
+  ids := make([]string, 0, len(nodes))
+  for id := range nodes {
+    ids = append(ids, id)
+  }
+  options, err := peerServerOptions(conf, "cluster nodes", ids)
+  if err != nil {
+    return nil, err
+  }
+  node, err := cluster.New(cluster.Config{
+    ID:              conf.ClusterNode,
+    Nodes:           nodes,
+    Dir:             raftDir,
+    Heartbeat:       conf.RaftHeartbeat,
+    ElectionTimeout: conf.RaftElection,
+    Dial: func(addr string) (*grpc.ClientConn, error) {
+      return dialPeer(addr, conf, conf.RaftElection)
+    },
+    Apply: func(command []byte) []byte {
+      return applyOffer(sessions, command)
+    },
+    Snapshot:        sessions.Snapshot,
+    Restore:         sessions.Restore,
+    SnapshotEntries: conf.RaftSnapshot,
+  })
+  if err != nil {
+    return nil, fmt.Errorf("failed to join cluster: %v", err)
+  }
+  server := grpc.NewServer(options...)
+  node.Register(server)
+  return &clusterNode{Node: node, addr: nodes[conf.ClusterNode], server: server}, nil
+}
+
+// start serves the Raft service to the other nodes on the node's
+// address. Only the nodes, known by their certificates, may call it
+func (c *clusterNode) start() error {
+  logging.Debug("start()")
+  lis, err := net.Listen("tcp", c.addr)
+  if err != nil {
+    return fmt.Errorf("failed to listen for cluster nodes: %v", err)
+  }
+  go func() {
+    if err := c.server.Serve(lis); err != nil {
+      logging.Warn("cluster listener stopped", "err", err)
+    }
+  }()
+  c.Start()
+  logging.Info("serving cluster nodes", "addr", lis.Addr().String())
+  return nil
+}
+
+// stop leaves the cluster
+func (c *clusterNode) stop() error {
+  c.server.Stop()
+  return c.Stop()
+}
+
+// applyOffer offers a replicated number to the sessions
+func applyOffer(sessions *state.Store, command []byte) []byte {
+  var offer offerCommand
+  if err := json.Unmarshal(command, &offer); err != nil {
+    logging.Error("failed to parse replicated offer", "err", err)
+    return nil
+  }
+  session, raised := sessions.OfferAt(offer.Session, offer.Number, offer.By, offer.Sequence, time.Unix(0, offer.At))
+  result, _ := json.Marshal(offerResult{Session: session, Raised: raised})
+  return result
+}
+
+// offer raises the session's maximum to the number if it is larger.
+// In a cluster the offer goes through the leader, which replicates
+// it to every node before it counts
+func (s server) offer(ctx context.Context, name string, number int64, by string, sequence uint64) (state.Session, bool, error) {
+  if s.cluster == nil {
+    session, raised := s.sessions.Offer(name, number, by, sequence)
+    return session, raised, nil
+  }
+  command, err := json.Marshal(offerCommand{
+    Session:  name,
+    Number:   number,
+    By:       by,
+    Sequence: sequence,
+    At:       time.Now().UnixNano(),
+  })
+  if err != nil {
+    return state.Session{}, false, err
+  }
+  value, err := s.cluster.Propose(ctx, command)
+  if err != nil {
+    return state.Session{}, false, status.Errorf(codes.Unavailable, "failed to replicate max: %v", err)
+  }
+  var result offerResult
+  if err := json.Unmarshal(value, &result); err != nil {
+    return state.Session{}, false, fmt.Errorf("failed to parse replicated max: %v", err)
+  }
+  return result.Session, result.Raised, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+  "context"
+  "os"
+  "testing"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/auth"
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+// waitForMax polls the node until the session's maximum has the version
+func waitForMax(ctx context.Context, client pb.SimpleClient, version uint64) *pb.SessionMax {
+  var sessionMax *pb.SessionMax
+  for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+    if response, err := client.GetMax(ctx, &pb.GetMaxRequest{}); err == nil {
+      if sessionMax = response; sessionMax.Version >= version {
+        break
+      }
+    }
+    time.Sleep(20 * time.Millisecond)
+  }
+  return sessionMax
+}
+
+func TestCluster(t *testing.T) {
+  pki := newTestPKI(t, "leia")
+  defer os.RemoveAll(pki.dir)
+  
+  // nodes are known to each other by their certificates
+  nodes := "GRPC_CLUSTER_NODES=a=localhost:7119,b=localhost:7120,c=localhost:7121"
+  ports := map[string]string{"a": "7019", "b": "7020", "c": "7021"}
+  clients := make(map[string]pb.SimpleClient)
+  for _, id := range []string{"a", "b", "c"} {
+    env := append(pki.peerEnv(t, ports[id], id), nodes, "GRPC_CLUSTER_NODE="+id,
+      "GRPC_RAFT_HEARTBEAT=20ms", "GRPC_RAFT_ELECTION_TIMEOUT=200ms")
+    serverCmd := startServerWith(env...)
+    defer stopServer(serverCmd)
+    conn := pki.dialTLS(t, ports[id], false)
+    defer stopClient(conn)
+    clients[id] = pb.NewSimpleClient(conn)
+  }
+  ctx := auth.WithSession(context.Background(), "cluster")
+  privateKey := rsaPrivateKey()
+  signed := func(number int64) *pb.MaxNumberRequest {
+    signature, _ := privateKey.Sign(crypto.Int64ToBytes(number))
+    return &pb.MaxNumberRequest{Number: number, Signature: signature}
+  }
+  
+  // any node takes numbers, and every node ends up with the same maximum
+  response, err := clients["b"].SubmitNumber(ctx, signed(40), grpc.FailFast(false))
+  if err != nil || response.Max.Max != 40 || response.Max.Version != 1 {
+    t.Fatalf("Got: %v %v, wanted: max %d version %d\n", response, err, 40, 1)
+  }
+  stream, err := clients["c"].FindMaxNumber(ctx)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  stream.Send(signed(70))
+  maxResponse, err := stream.Recv()
+  if err != nil || maxResponse.Number != 70 {
+    t.Errorf("Got: %v %v, wanted: %d\n", maxResponse, err, 70)
+  }
+  stream.CloseSend()
+  
+  for id, client := range clients {
+    sessionMax := waitForMax(ctx, client, 2)
+    if sessionMax == nil || sessionMax.Max != 70 || sessionMax.Version != 2 {
+      t.Errorf("%s: Got: %v, wanted: max %d version %d\n", id, sessionMax, 70, 2)
+    }
+  }
+  
+  // a client certificate of no node can't call the nodes' Raft service
+  leia := pki.dialTLS(t, "7119", true)
+  defer stopClient(leia)
+  forward := &pb.ForwardRequest{Command: []byte(`{"session":"cluster","number":9223372036854775807}`)}
+  if _, err := pb.NewRaftClient(leia).Forward(ctx, forward); status.Code(err) != codes.PermissionDenied {
+    t.Errorf("Got: %v, wanted: %v\n", err, codes.PermissionDenied)
+  }
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/endpoint"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "github.com/salman-ahmad/grpc-streaming/rest"
+)
+
+// startGateway serves the REST gateway next to the gRPC listener. It
+// calls the server over its own listener, so gateway requests pass the
+// same interceptors and chain as every other stream
+func startGateway(conf *config.Config, endpoints []endpoint.Endpoint) (*http.Server, error) {
+  logging.Debug("startGateway()")
+  if conf.GatewayAddr == "" {
+    logging.Info("REST gateway is off")
+    return nil, nil
+  }
+  
+  e, err := gatewayEndpoint(endpoints)
+  if err != nil {
+    return nil, err
+  }
+  dialConf := *conf
+  dialConf.TLS = conf.TLS && !e.Plaintext
+  if dialConf.TLS && conf.TLSClientAuth {
+    return nil, fmt.Errorf("the gateway has no client certificate for %s, make its first endpoint plaintext", e)
+  }
+  conn, err := rest.Dial(e.Target(), &dialConf)
+  if err != nil {
+    return nil, fmt.Errorf("failed to connect the gateway to the server: %v", err)
+  }
+  lis, scheme, err := rest.Listen(conf.GatewayAddr, conf)
+  if err != nil {
+    conn.Close()
+    return nil, fmt.Errorf("failed to listen for the gateway: %v", err)
+  }
+  gateway := rest.New(pb.NewSimpleClient(conn))
+  gateway.PingInterval = conf.GatewayPing
+  gateway.Origins = conf.GatewayOrigins
+  gatewayServer := &http.Server{Handler: gateway}
+  gatewayServer.RegisterOnShutdown(func() { conn.Close() })
+  go func() {
+    if err := gatewayServer.Serve(lis); err != http.ErrServerClosed {
+      logging.Warn("REST gateway stopped", "err", err)
+    }
+  }()
+  logging.Info("serving REST gateway", "url", scheme+"://"+lis.Addr().String()+rest.MaxPath)
+  return gatewayServer, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+  "bytes"
+  "context"
+  "crypto/tls"
+  "crypto/x509"
+  "encoding/json"
+  "net/http"
+  "os"
+  "testing"
+  "time"
+  
+  "github.com/golang/protobuf/proto"
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  "github.com/salman-ahmad/grpc-streaming/endpoint"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "github.com/salman-ahmad/grpc-streaming/rest"
+  "github.com/salman-ahmad/grpc-streaming/websocket"
+)
+
+func TestGateway(t *testing.T) {
+  gatewayAddr := "127.0.0.1:8081"
+  serverCmd := startServerWith("GRPC_PORT=7013", "GRPC_GATEWAY_ADDR="+gatewayAddr,
+    "GRPC_CHAIN=verify,range,max", "GRPC_RANGE_MAX=100")
+  defer stopServer(serverCmd)
+  
+  // numbers posted to the gateway run through the chain like any other
+  rsaPrivateKey := rsaPrivateKey()
+  var batch rest.Batch
+  for _, number := range []int64{40, 150, 60} {
+    signature, _ := rsaPrivateKey.Sign(crypto.Int64ToBytes(number))
+    batch.Numbers = append(batch.Numbers, rest.Number{Number: number, Signature: signature})
+  }
+  body, _ := json.Marshal(batch)
+  
+  response, err := http.Post("http://"+gatewayAddr+rest.MaxPath+"?session=gateway", "application/json", bytes.NewReader(body))
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  defer response.Body.Close()
+  var result rest.Result
+  json.NewDecoder(response.Body).Decode(&result)
+  if response.StatusCode != http.StatusOK || result.Max != 60 || len(result.Responses) != 3 {
+    t.Fatalf("Got: %d %v, wanted: max %d in %d responses\n", response.StatusCode, result, 60, 3)
+  }
+  if rejection := result.Responses[1].Rejection; rejection == nil || rejection.Stage != "range" {
+    t.Errorf("Got: %v, wanted: %s\n", result.Responses[1], "range rejection")
+  }
+}
+
+func TestGateway_WebSocket(t *testing.T) {
+  gatewayAddr := "127.0.0.1:8083"
+  serverCmd := startServerWith("GRPC_PORT=7015", "GRPC_GATEWAY_ADDR="+gatewayAddr,
+    "GRPC_CHAIN=verify,range,max", "GRPC_RANGE_MAX=100")
+  defer stopServer(serverCmd)
+  
+  // frames relayed by the bridge run through the chain like any other
+  rsaPrivateKey := rsaPrivateKey()
+  conn, _, err := websocket.Dial("ws://"+gatewayAddr+rest.StreamPath+"?session=websocket", []string{rest.ProtoProtocol}, nil)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  defer conn.Close()
+  for _, number := range []int64{40, 150, 60} {
+    signature, _ := rsaPrivateKey.Sign(crypto.Int64ToBytes(number))
+    frame, _ := proto.Marshal(&pb.MaxNumberRequest{Number: number, Signature: signature})
+    conn.WriteMessage(websocket.BinaryMessage, frame)
+  }
+  // a number signed for another number fails the stream
+  signature, _ := rsaPrivateKey.Sign(crypto.Int64ToBytes(70))
+  frame, _ := proto.Marshal(&pb.MaxNumberRequest{Number: 80, Signature: signature})
+  conn.WriteMessage(websocket.BinaryMessage, frame)
+  
+  conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+  var responses []pb.MaxNumberResponse
+  for {
+    _, frame, err := conn.ReadMessage()
+    if err != nil {
+      if closeErr, ok := err.(*websocket.CloseError); !ok || closeErr.Code == websocket.CloseNormal {
+        t.Errorf("Got: %v, wanted: %s\n", err, "stream failure")
+      }
+      break
+    }
+    var response pb.MaxNumberResponse
+    proto.Unmarshal(frame, &response)
+    responses = append(responses, response)
+  }
+  if len(responses) != 3 || responses[1].Rejection == nil || responses[2].Number != 60 {
+    t.Errorf("Got: %v, wanted: max %d in %d responses\n", responses, 60, 3)
+  }
+}
+
+func TestStartGateway_ClientAuth(t *testing.T) {
+  pki := newTestPKI(t, "leia")
+  defer os.RemoveAll(pki.dir)
+  conf := &config.Config{GatewayAddr: "127.0.0.1:8085", TLS: true, TLSClientAuth: true, TLSCert: pki.serverCert,
+    TLSKey: pki.serverKey}
+  
+  // the gateway has no certificate for a server that requires one
+  endpoints := []endpoint.Endpoint{{Network: "tcp", Address: "127.0.0.1:7029"}}
+  if _, err := startGateway(conf, endpoints); err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", err, "an error")
+  }
+  
+  // but calls the server over a plaintext endpoint
+  endpoints = append([]endpoint.Endpoint{{Network: "tcp", Address: "127.0.0.1:7030", Plaintext: true}}, endpoints...)
+  gatewayServer, err := startGateway(conf, endpoints)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  gatewayServer.Shutdown(context.Background())
+}
+
+func TestGateway_TLS(t *testing.T) {
+  pki := newTestPKI(t, "leia")
+  defer os.RemoveAll(pki.dir)
+  gatewayAddr := "127.0.0.1:8087"
+  serverCmd := startServerWith("GRPC_PORT=7031", "GRPC_GATEWAY_ADDR="+gatewayAddr, "GRPC_TLS=true",
+    "GRPC_TLS_CERT="+pki.serverCert, "GRPC_TLS_KEY="+pki.serverKey, "GRPC_TLS_CA="+pki.caPath)
+  defer stopServer(serverCmd)
+  
+  // the gateway serves its callers over TLS with the server's certificate
+  roots := x509.NewCertPool()
+  roots.AddCert(pki.ca)
+  client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+  signature, _ := rsaPrivateKey().Sign(crypto.Int64ToBytes(7))
+  body, _ := json.Marshal(rest.Number{Number: 7, Signature: signature})
+  response, err := client.Post("https://"+gatewayAddr+rest.MaxPath+"?session=tls", "application/json", bytes.NewReader(body))
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  defer response.Body.Close()
+  var result rest.Result
+  json.NewDecoder(response.Body).Decode(&result)
+  if response.StatusCode != http.StatusOK || result.Max != 7 {
+    t.Errorf("Got: %d %v, wanted: max %d\n", response.StatusCode, result, 7)
+  }
+}
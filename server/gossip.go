@@ -0,0 +1,87 @@
+package main
+
+import (
+  "fmt"
+  "net"
+  
+  "github.com/salman-ahmad/grpc-streaming/cluster"
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/gossip"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  "github.com/salman-ahmad/grpc-streaming/state"
+  "google.golang.org/grpc"
+)
+
+// gossipNode is the server's gossip node and the
+// server it serves the peers on
+type gossipNode struct {
+  *gossip.Node
+  addr   string
+  server *grpc.Server
+}
+
+// newGossipNode exchanges the sessions' maxima with the peers the
+// configuration names. Without a gossip address the server keeps
+// its sessions to itself
+func newGossipNode(conf *config.Config, sessions *state.Store) (*gossipNode, error) {
+  logging.Debug("newGossipNode()")
+  if conf.GossipAddr == "" {
+    return nil, nil
+  }
+  if len(conf.ClusterNodes) > 0 {
+    return nil, fmt.Errorf("a server can't both gossip and be a cluster node")
+  }
+  peers, err := cluster.ParseNodes(conf.GossipPeers)
+  if err != nil {
+    return nil, fmt.Errorf("failed to read gossip peers: %v", err)
+  }
+  identities := make([]string, 0, len(peers))
+  addrs := make([]string, 0, len(peers))
+  for identity, addr := range peers {
+    identities = append(identities, identity)
+    addrs = append(addrs, addr)
+  }
+  options, err := peerServerOptions(conf, "gossip peers", identities)
+  if err != nil {
+    return nil, err
+  }
+  node, err := gossip.New(gossip.Config{
+    ID:       conf.GossipAddr,
+    Peers:    addrs,
+    Interval: conf.GossipInterval,
+    Sessions: sessions,
+    Dial: func(addr string) (*grpc.ClientConn, error) {
+      return dialPeer(addr, conf, conf.GossipInterval)
+    },
+  })
+  if err != nil {
+    return nil, fmt.Errorf("failed to start gossip: %v", err)
+  }
+  server := grpc.NewServer(options...)
+  node.Register(server)
+  return &gossipNode{Node: node, addr: conf.GossipAddr, server: server}, nil
+}
+
+// start serves the PeerSync service to the peers on the gossip
+// address. Only the peers, known by their certificates, may call it
+func (g *gossipNode) start() error {
+  logging.Debug("start()")
+  lis, err := net.Listen("tcp", g.addr)
+  if err != nil {
+    return fmt.Errorf("failed to listen for gossip peers: %v", err)
+  }
+  go func() {
+    if err := g.server.Serve(lis); err != nil {
+      logging.Warn("gossip listener stopped", "err", err)
+    }
+  }()
+  g.Start()
+  logging.Info("serving gossip peers", "addr", lis.Addr().String())
+  return nil
+}
+
+// stop stops exchanging maxima with the peers
+func (g *gossipNode) stop() error {
+  g.server.Stop()
+  return g.Stop()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+  "context"
+  "os"
+  "testing"
+  
+  "github.com/salman-ahmad/grpc-streaming/auth"
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+func TestGossip(t *testing.T) {
+  pki := newTestPKI(t, "leia")
+  defer os.RemoveAll(pki.dir)
+  
+  // peers are known to each other by their certificates
+  ports := []string{"7022", "7023"}
+  identities := []string{"eu", "us"}
+  gossipAddrs := []string{"localhost:7122", "localhost:7123"}
+  var clients []pb.SimpleClient
+  for i, port := range ports {
+    env := append(pki.peerEnv(t, port, identities[i]), "GRPC_GOSSIP_ADDR="+gossipAddrs[i],
+      "GRPC_GOSSIP_PEERS="+identities[1-i]+"="+gossipAddrs[1-i], "GRPC_GOSSIP_INTERVAL=50ms")
+    serverCmd := startServerWith(env...)
+    defer stopServer(serverCmd)
+    conn := pki.dialTLS(t, port, false)
+    defer stopClient(conn)
+    clients = append(clients, pb.NewSimpleClient(conn))
+  }
+  ctx := auth.WithSession(context.Background(), "gossip")
+  privateKey := rsaPrivateKey()
+  signed := func(number int64) *pb.MaxNumberRequest {
+    signature, _ := privateKey.Sign(crypto.Int64ToBytes(number))
+    return &pb.MaxNumberRequest{Number: number, Signature: signature}
+  }
+  
+  // each server takes numbers on its own, and the larger maximum wins on both
+  if _, err := clients[0].SubmitNumber(ctx, signed(40)); err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  response, err := clients[1].SubmitNumber(ctx, signed(70))
+  if err != nil || response.Max.Max != 70 {
+    t.Fatalf("Got: %v %v, wanted: max %d\n", response, err, 70)
+  }
+  sessionMax := waitForMax(ctx, clients[0], 2)
+  if sessionMax == nil || sessionMax.Max != 70 || sessionMax.UpdatedAt != response.Max.UpdatedAt {
+    t.Errorf("Got: %v, wanted: max %d at %d\n", sessionMax, 70, response.Max.UpdatedAt)
+  }
+  
+  // a version is no cursor across gossiping servers, so it is ignored
+  watch, err := clients[0].WatchMax(ctx, &pb.WatchMaxRequest{SinceVersion: sessionMax.GetVersion()})
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  if current, err := watch.Recv(); err != nil || current.Max != 70 {
+    t.Errorf("Got: %v %v, wanted: max %d\n", current, err, 70)
+  }
+  
+  // a certificate of no peer can't pin a session's maximum
+  leia := pki.dialTLS(t, "7122", true)
+  defer stopClient(leia)
+  request := &pb.SyncRequest{Node: "leia", Maxima: []*pb.PeerMax{{Session: "gossip", Max: 9223372036854775807}}}
+  if _, err := pb.NewPeerSyncClient(leia).Sync(ctx, request); status.Code(err) != codes.PermissionDenied {
+    t.Errorf("Got: %v, wanted: %v\n", err, codes.PermissionDenied)
+  }
+  if sessionMax, err := clients[0].GetMax(ctx, &pb.GetMaxRequest{}); err != nil || sessionMax.Max != 70 {
+    t.Errorf("Got: %v %v, wanted: max %d\n", sessionMax, err, 70)
+  }
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+  "errors"
+  "strings"
+  
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/health"
+  healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthServices are the services whose health is reported,
+// besides the server as a whole under the empty name
+var healthServices = []string{"", "simple.Simple", "simple.Admin"}
+
+// readiness tells why the server can't serve streams: its keys aren't
+// loaded, its state isn't recovered or it is draining. It is nil when ready
+func (s *server) readiness() error {
+  if s.publicKey == nil || s.trustedKeys == nil {
+    return errors.New("public keys aren't loaded")
+  }
+  if s.sessions == nil {
+    return errors.New("state isn't recovered")
+  }
+  select {
+  case <-s.closing:
+    return errors.New("draining")
+  default:
+  }
+  return nil
+}
+
+// updateHealth reports the readiness of the server to health checks
+func (s *server) updateHealth() {
+  servingStatus := healthpb.HealthCheckResponse_SERVING
+  if err := s.readiness(); err != nil {
+    servingStatus = healthpb.HealthCheckResponse_NOT_SERVING
+    logging.Info("not ready", "reason", err)
+  }
+  for _, service := range healthServices {
+    s.health.SetServingStatus(service, servingStatus)
+  }
+}
+
+// newHealthServer makes a health server that reports every
+// service as not serving until the server updates it
+func newHealthServer() *health.Server {
+  healthServer := health.NewServer()
+  for _, service := range healthServices {
+    healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+  }
+  return healthServer
+}
+
+// isHealthCheck tells whether the method is one of the health service,
+// which load balancer and orchestrator probes call without credentials
+func isHealthCheck(method string) bool {
+  return strings.HasPrefix(method, "/grpc.health.v1.Health/")
+}
+
+// exceptHealthChecks wraps the interceptors of a feature
+// so they don't apply to health checks
+func exceptHealthChecks(
+  unary grpc.UnaryServerInterceptor,
+  stream grpc.StreamServerInterceptor) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+  
+  var exceptUnary grpc.UnaryServerInterceptor
+  if unary != nil {
+    exceptUnary = func(
+      ctx context.Context,
+      req interface{},
+      info *grpc.UnaryServerInfo,
+      handler grpc.UnaryHandler) (interface{}, error) {
+      
+      if isHealthCheck(info.FullMethod) {
+        return handler(ctx, req)
+      }
+      return unary(ctx, req, info, handler)
+    }
+  }
+  var exceptStream grpc.StreamServerInterceptor
+  if stream != nil {
+    exceptStream = func(
+      srv interface{},
+      ss grpc.ServerStream,
+      info *grpc.StreamServerInfo,
+      handler grpc.StreamHandler) error {
+      
+      if isHealthCheck(info.FullMethod) {
+        return handler(srv, ss)
+      }
+      return stream(srv, ss, info, handler)
+    }
+  }
+  return exceptUnary, exceptStream
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+  "context"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "syscall"
+  "testing"
+  
+  "google.golang.org/grpc/codes"
+  healthpb "google.golang.org/grpc/health/grpc_health_v1"
+  rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+  "google.golang.org/grpc/status"
+)
+
+func TestHealth_Draining(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "health")
+  defer os.RemoveAll(dir)
+  
+  // health checks don't need the tokens every other RPC needs
+  port := "7011"
+  serverCmd := startServerWith("GRPC_PORT="+port, "GRPC_AUTH=token", "GRPC_AUTH_TOKENS="+filepath.Join(dir, "tokens.json"))
+  conn := startClient(port)
+  defer stopClient(conn)
+  
+  client := healthpb.NewHealthClient(conn)
+  for _, service := range []string{"", "simple.Simple"} {
+    response, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+    if err != nil || response.Status != healthpb.HealthCheckResponse_SERVING {
+      t.Errorf("%q: Got: %v %v, wanted: %v\n", service, response, err, healthpb.HealthCheckResponse_SERVING)
+    }
+  }
+  _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"})
+  if status.Code(err) != codes.NotFound {
+    t.Errorf("Got: %v, wanted: %v\n", err, codes.NotFound)
+  }
+  
+  ctx, cancel := context.WithCancel(context.Background())
+  watch, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  if response, err := watch.Recv(); err != nil || response.Status != healthpb.HealthCheckResponse_SERVING {
+    t.Errorf("Got: %v %v, wanted: %v\n", response, err, healthpb.HealthCheckResponse_SERVING)
+  }
+  
+  // a draining server isn't serving; the open watch holds up its
+  // shutdown until the watch is cancelled
+  serverCmd.Process.Signal(syscall.SIGTERM)
+  if response, err := watch.Recv(); err != nil || response.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+    t.Errorf("Got: %v %v, wanted: %v\n", response, err, healthpb.HealthCheckResponse_NOT_SERVING)
+  }
+  cancel()
+  stopServer(serverCmd)
+}
+
+func TestReflection(t *testing.T) {
+  port := "7012"
+  serverCmd := startServerWith("GRPC_PORT=" + port)
+  defer stopServer(serverCmd)
+  conn := startClient(port)
+  defer stopClient(conn)
+  
+  stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  stream.Send(&rpb.ServerReflectionRequest{
+    MessageRequest: &rpb.ServerReflectionRequest_ListServices{ListServices: "*"},
+  })
+  response, err := stream.Recv()
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  stream.CloseSend()
+  
+  var services []string
+  for _, service := range response.GetListServicesResponse().GetService() {
+    services = append(services, service.Name)
+  }
+  sort.Strings(services)
+  expected := []string{"grpc.health.v1.Health", "grpc.reflection.v1alpha.ServerReflection", "simple.Admin", "simple.Simple"}
+  if len(services) != len(expected) {
+    t.Fatalf("Got: %v, wanted: %v\n", services, expected)
+  }
+  for i := range expected {
+    if services[i] != expected[i] {
+      t.Errorf("Got: %v, wanted: %v\n", services, expected)
+    }
+  }
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+  "strconv"
+  
+  "github.com/salman-ahmad/grpc-streaming/auth"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "github.com/salman-ahmad/grpc-streaming/state"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+// page sizes of GetMaxHistory
+const (
+  defaultPageSize = 100
+  maxPageSize     = 1000
+)
+
+// GetMaxHistory returns a page of the changes of the maximum of the
+// caller's session, newest first. The page token is the version the
+// next page starts below
+func (s server) GetMaxHistory(ctx context.Context, request *pb.GetMaxHistoryRequest) (*pb.MaxHistory, error) {
+  logging.Debug("GetMaxHistory()")
+  session, err := auth.SessionFromContext(ctx)
+  if err != nil {
+    return nil, status.Error(codes.InvalidArgument, err.Error())
+  }
+  pageSize := int(request.PageSize)
+  switch {
+  case pageSize < 0:
+    return nil, status.Errorf(codes.InvalidArgument, "page size %d is negative", pageSize)
+  case pageSize == 0:
+    pageSize = defaultPageSize
+  case pageSize > maxPageSize:
+    pageSize = maxPageSize
+  }
+  var before uint64
+  if request.PageToken != "" {
+    before, err = strconv.ParseUint(request.PageToken, 10, 64)
+    if err != nil || before == 0 {
+      return nil, status.Errorf(codes.InvalidArgument, "invalid page token %q", request.PageToken)
+    }
+  }
+  
+  transitions, more := s.sessions.History(session, before, pageSize)
+  history := &pb.MaxHistory{Changes: make([]*pb.SessionMax, len(transitions))}
+  for i, transition := range transitions {
+    history.Changes[i] = newSessionMax(state.Session{
+      Name:      session,
+      Max:       transition.Max,
+      Version:   transition.Version,
+      UpdatedBy: transition.UpdatedBy,
+      Sequence:  transition.Sequence,
+      UpdatedAt: transition.UpdatedAt,
+    })
+  }
+  if more {
+    history.NextPageToken = strconv.FormatUint(transitions[len(transitions)-1].Version, 10)
+  }
+  return history, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+  "context"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/auth"
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+func TestGetMaxHistory(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "state")
+  defer os.RemoveAll(dir)
+  statePath := filepath.Join(dir, "state.json")
+  
+  port := "7017"
+  serverCmd := startServerWith("GRPC_PORT="+port, "GRPC_STATE_FILE="+statePath, "GRPC_STATE_SAVE_DELAY=50ms")
+  conn := startClient(port)
+  ctx := auth.WithSession(context.Background(), "history")
+  stream, err := pb.NewSimpleClient(conn).FindMaxNumber(ctx)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  privateKey := rsaPrivateKey()
+  for i, number := range []int64{3, 9, 4, 11, 15} {
+    signature, _ := privateKey.Sign(crypto.Int64ToBytes(number))
+    stream.Send(&pb.MaxNumberRequest{Number: number, Signature: signature})
+    if number == 4 {
+      continue
+    }
+    response, err := stream.Recv()
+    if err != nil {
+      t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+    // responses tell who raised the maximum and when
+    if response.Number != number || response.Sequence != uint64(i+1) || response.UpdatedAt == 0 {
+      t.Errorf("Got: %+v, wanted: number %d sequence %d\n", response, number, i+1)
+    }
+  }
+  stream.CloseSend()
+  stream.Recv()
+  stopClient(conn)
+  
+  // the history survives a crash once it had time to be saved
+  time.Sleep(200 * time.Millisecond)
+  serverCmd.Process.Kill()
+  serverCmd.Wait()
+  serverCmd = startServerWith("GRPC_PORT="+port, "GRPC_STATE_FILE="+statePath)
+  defer stopServer(serverCmd)
+  conn = startClient(port)
+  defer stopClient(conn)
+  client := pb.NewSimpleClient(conn)
+  
+  var maxes []int64
+  var sequences []uint64
+  request := &pb.GetMaxHistoryRequest{PageSize: 3}
+  pages := 0
+  for {
+    history, err := client.GetMaxHistory(ctx, request)
+    if err != nil {
+      t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+    pages++
+    for _, change := range history.Changes {
+      if change.Session != "history" || change.UpdatedAt == 0 {
+        t.Errorf("Got: %+v, wanted: session %q with a time\n", change, "history")
+      }
+      maxes = append(maxes, change.Max)
+      sequences = append(sequences, change.Sequence)
+    }
+    if history.NextPageToken == "" {
+      break
+    }
+    request.PageToken = history.NextPageToken
+  }
+  if pages != 2 || len(maxes) != 4 || maxes[0] != 15 || maxes[3] != 3 || sequences[1] != 4 {
+    t.Errorf("Got: %d pages %v sequences %v, wanted: %d pages %v\n", pages, maxes, sequences, 2, []int64{15, 11, 9, 3})
+  }
+  
+  for _, request := range []*pb.GetMaxHistoryRequest{{PageSize: -1}, {PageToken: "x"}} {
+    if _, err := client.GetMaxHistory(ctx, request); status.Code(err) != codes.InvalidArgument {
+      t.Errorf("Got: %v, wanted: %v\n", err, codes.InvalidArgument)
+    }
+  }
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+  "golang.org/x/net/context"
+  "google.golang.org/grpc"
+)
+
+// interceptors collects the server interceptors of each feature;
+// grpc only takes one of each kind, so they are chained in the
+// order they were added, the first one outermost
+type interceptors struct {
+  unary  []grpc.UnaryServerInterceptor
+  stream []grpc.StreamServerInterceptor
+}
+
+// add appends the interceptors of a feature, which are nil when it is off
+func (i *interceptors) add(unary grpc.UnaryServerInterceptor, stream grpc.StreamServerInterceptor) {
+  if unary != nil {
+    i.unary = append(i.unary, unary)
+  }
+  if stream != nil {
+    i.stream = append(i.stream, stream)
+  }
+}
+
+func (i *interceptors) options() []grpc.ServerOption {
+  var options []grpc.ServerOption
+  if len(i.unary) > 0 {
+    options = append(options, grpc.UnaryInterceptor(chainUnary(i.unary)))
+  }
+  if len(i.stream) > 0 {
+    options = append(options, grpc.StreamInterceptor(chainStream(i.stream)))
+  }
+  return options
+}
+
+func chainUnary(chain []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+  return func(
+    ctx context.Context,
+    req interface{},
+    info *grpc.UnaryServerInfo,
+    handler grpc.UnaryHandler) (interface{}, error) {
+    
+    next := handler
+    for i := len(chain) - 1; i >= 0; i-- {
+      interceptor, inner := chain[i], next
+      next = func(ctx context.Context, req interface{}) (interface{}, error) {
+        return interceptor(ctx, req, info, inner)
+      }
+    }
+    return next(ctx, req)
+  }
+}
+
+func chainStream(chain []grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+  return func(
+    srv interface{},
+    stream grpc.ServerStream,
+    info *grpc.StreamServerInfo,
+    handler grpc.StreamHandler) error {
+    
+    next := handler
+    for i := len(chain) - 1; i >= 0; i-- {
+      interceptor, inner := chain[i], next
+      next = func(srv interface{}, stream grpc.ServerStream) error {
+        return interceptor(srv, stream, info, inner)
+      }
+    }
+    return next(srv, stream)
+  }
+}
+
+// contextStream replaces the context of a stream, so interceptors
+// can pass values on to the handler
+type contextStream struct {
+  grpc.ServerStream
+  ctx context.Context
+}
+
+func (s contextStream) Context() context.Context {
+  return s.ctx
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+  "fmt"
+  "net"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/endpoint"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  "google.golang.org/grpc"
+)
+
+// listenEndpoints reads the endpoints the server listens on,
+// or the port on every interface when none is configured
+func listenEndpoints(conf *config.Config) ([]endpoint.Endpoint, error) {
+  logging.Debug("listenEndpoints()")
+  specs := conf.Listen
+  if len(specs) == 0 {
+    specs = []string{":" + conf.Port}
+  }
+  endpoints := make([]endpoint.Endpoint, 0, len(specs))
+  for _, spec := range specs {
+    e, err := endpoint.Parse(spec)
+    if err != nil {
+      return nil, fmt.Errorf("failed to read listen address: %v", err)
+    }
+    endpoints = append(endpoints, e)
+  }
+  return endpoints, nil
+}
+
+// hasAdminEndpoint reports whether the Admin service
+// has endpoints of its own
+func hasAdminEndpoint(endpoints []endpoint.Endpoint) bool {
+  for _, e := range endpoints {
+    if e.Admin {
+      return true
+    }
+  }
+  return false
+}
+
+// endpointOptions are the server options of an endpoint: TLS
+// as configured, unless the endpoint is plaintext
+func endpointOptions(conf *config.Config, e endpoint.Endpoint) ([]grpc.ServerOption, error) {
+  if e.Plaintext {
+    return nil, nil
+  }
+  return serverOptions(conf)
+}
+
+// startListener listens on the endpoint
+func startListener(e endpoint.Endpoint) (net.Listener, error) {
+  logging.Debug("startListener()")
+  lis, err := e.Listen()
+  if err != nil {
+    return nil, fmt.Errorf("failed to listen on %s: %v", e, err)
+  }
+  logging.Info("starting server", "addr", e.String(), "plaintext", e.Plaintext, "admin", e.Admin)
+  return lis, nil
+}
+
+// gatewayEndpoint is the endpoint the gateway calls the server on:
+// the first one serving the Simple service
+func gatewayEndpoint(endpoints []endpoint.Endpoint) (endpoint.Endpoint, error) {
+  for _, e := range endpoints {
+    if !e.Admin {
+      return e, nil
+    }
+  }
+  return endpoint.Endpoint{}, fmt.Errorf("no endpoint serves the gateway's calls")
+}
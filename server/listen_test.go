@@ -0,0 +1,64 @@
+package main
+
+import (
+  "context"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  
+  "github.com/salman-ahmad/grpc-streaming/auth"
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  "github.com/salman-ahmad/grpc-streaming/endpoint"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+func TestListen(t *testing.T) {
+  pki := newTestPKI(t, "leia")
+  defer os.RemoveAll(pki.dir)
+  dir, _ := ioutil.TempDir("", "listen")
+  defer os.RemoveAll(dir)
+  socket := "unix://" + filepath.Join(dir, "max.sock")
+  
+  // a public TLS port, a plaintext socket for sidecars and a TLS admin port
+  env := append(pki.serverEnv("7026"), "GRPC_LISTEN=:7026,"+socket+"?plaintext,127.0.0.1:7027?admin")
+  serverCmd := startServerWith(env...)
+  defer stopServer(serverCmd)
+  public := pki.dialTLS(t, "7026", true)
+  defer stopClient(public)
+  admin := pki.dialTLS(t, "7027", true)
+  defer stopClient(admin)
+  sidecar, err := grpc.Dial(socket, grpc.WithInsecure(), grpc.WithDialer(endpoint.Dial))
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  defer stopClient(sidecar)
+  
+  ctx := auth.WithSession(context.Background(), "listen")
+  signature, _ := rsaPrivateKey().Sign(crypto.Int64ToBytes(42))
+  request := &pb.MaxNumberRequest{Number: 42, Signature: signature}
+  if _, err := pb.NewSimpleClient(sidecar).SubmitNumber(ctx, request); err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  sessionMax, err := pb.NewSimpleClient(public).GetMax(ctx, &pb.GetMaxRequest{})
+  if err != nil || sessionMax.Max != 42 {
+    t.Errorf("Got: %v %v, wanted: max %d\n", sessionMax, err, 42)
+  }
+  
+  // the Admin service is only served on the admin port, and only it is
+  if _, err := pb.NewAdminClient(admin).GetLogLevel(ctx, &pb.GetLogLevelRequest{}); err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+  for _, conn := range []*grpc.ClientConn{public, sidecar} {
+    _, err := pb.NewAdminClient(conn).GetLogLevel(ctx, &pb.GetLogLevelRequest{})
+    if status.Code(err) != codes.Unimplemented {
+      t.Errorf("Got: %v, wanted: %v\n", err, codes.Unimplemented)
+    }
+  }
+  if _, err := pb.NewSimpleClient(admin).GetMax(ctx, &pb.GetMaxRequest{}); status.Code(err) != codes.Unimplemented {
+    t.Errorf("Got: %v, wanted: %v\n", err, codes.Unimplemented)
+  }
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+  "os"
+  
+  "github.com/salman-ahmad/grpc-streaming/chain"
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+)
+
+// startLogging makes a logger with the configured format and
+// level the default logger of the server
+func startLogging(conf *config.Config) (*logging.Logger, error) {
+  level, err := logging.ParseLevel(conf.LogLevel)
+  if err != nil {
+    return nil, err
+  }
+  logger, err := logging.New(os.Stderr, conf.LogFormat, level)
+  if err != nil {
+    return nil, err
+  }
+  logging.SetDefault(logger)
+  return logger, nil
+}
+
+// streamLogger adds who the stream is from to every entry
+func streamLogger(streamState *chain.Stream) *logging.Logger {
+  return logging.With(
+    "stream", streamState.ID,
+    "peer", streamState.Peer,
+    "identity", streamState.Identity,
+    "session", streamState.Session)
+}
+
+// numberFields are the fields of entries about one number of a
+// stream. The key ID is the one the verify stage found, if any
+func numberFields(request *chain.Request, keyvals ...interface{}) []interface{} {
+  keyID := request.KeyID
+  if verifiedKeyID := request.Annotations["key_id"]; verifiedKeyID != "" {
+    keyID = verifiedKeyID
+  }
+  fields := []interface{}{"sequence", request.Sequence, "number", request.Number, "key_id", keyID}
+  return append(fields, keyvals...)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+  "fmt"
+  "net"
+  "net/http"
+  "strconv"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/chain"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  "github.com/salman-ahmad/grpc-streaming/metrics"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/status"
+)
+
+// serverMetrics are the metrics the server exposes on /metrics
+type serverMetrics struct {
+  registry           *metrics.Registry
+  streamsOpened      *metrics.CounterVec
+  streamsClosed      *metrics.CounterVec
+  streamsOpen        *metrics.Gauge
+  unaryCalls         *metrics.CounterVec
+  numbersReceived    *metrics.Counter
+  signaturesVerified *metrics.Counter
+  signaturesFailed   *metrics.Counter
+  rejections         *metrics.CounterVec
+  maxUpdatesSent     *metrics.Counter
+  verifyLatency      *metrics.Histogram
+  streamMessages     *metrics.CounterVec
+}
+
+func newServerMetrics() *serverMetrics {
+  r := metrics.NewRegistry()
+  return &serverMetrics{
+    registry:           r,
+    streamsOpened:      r.CounterVec("maxnumber_streams_opened_total", "Streams opened, by method.", "method"),
+    streamsClosed:      r.CounterVec("maxnumber_streams_closed_total", "Streams closed, by method and status code.", "method", "code"),
+    streamsOpen:        r.Gauge("maxnumber_streams_open", "Streams currently open."),
+    unaryCalls:         r.CounterVec("maxnumber_unary_calls_total", "Unary calls handled, by method and status code.", "method", "code"),
+    numbersReceived:    r.Counter("maxnumber_numbers_received_total", "Signed numbers received on all streams."),
+    signaturesVerified: r.Counter("maxnumber_signatures_verified_total", "Signatures that verified."),
+    signaturesFailed:   r.Counter("maxnumber_signatures_failed_total", "Signatures that failed to verify."),
+    rejections:         r.CounterVec("maxnumber_rejections_total", "Numbers rejected, by chain stage.", "stage"),
+    maxUpdatesSent:     r.Counter("maxnumber_max_updates_sent_total", "New maximums sent to streams."),
+    verifyLatency: r.Histogram("maxnumber_verify_duration_seconds",
+      "Time taken to verify a signature.", metrics.DefaultBuckets),
+    streamMessages: r.CounterVec("maxnumber_stream_messages_total",
+      "Messages of each open stream, by direction; rate() gives the stream's message rate.",
+      "stream", "session", "direction"),
+  }
+}
+
+// onVerify is the verify stage's hook
+func (m *serverMetrics) onVerify(elapsed time.Duration, verified bool) {
+  m.verifyLatency.Observe(elapsed.Seconds())
+  if verified {
+    m.signaturesVerified.Inc()
+  } else {
+    m.signaturesFailed.Inc()
+  }
+}
+
+// streamMetrics counts the messages of one stream
+type streamMetrics struct {
+  server         *serverMetrics
+  labels         []string
+  received, sent *metrics.Counter
+}
+
+func (m *serverMetrics) openStream(streamState *chain.Stream) streamMetrics {
+  id := strconv.FormatUint(streamState.ID, 10)
+  return streamMetrics{
+    server:   m,
+    labels:   []string{id, streamState.Session},
+    received: m.streamMessages.With(id, streamState.Session, "received"),
+    sent:     m.streamMessages.With(id, streamState.Session, "sent"),
+  }
+}
+
+func (sm streamMetrics) receivedNumber() {
+  sm.server.numbersReceived.Inc()
+  sm.received.Inc()
+}
+
+func (sm streamMetrics) sentResponse(updated bool, rejection *chain.Rejection) {
+  sm.sent.Inc()
+  if updated {
+    sm.server.maxUpdatesSent.Inc()
+  }
+  if rejection != nil {
+    sm.server.rejections.With(rejection.Stage).Inc()
+  }
+}
+
+// close drops the counters of the stream so closed streams
+// don't pile up in the metrics
+func (sm streamMetrics) close() {
+  sm.server.streamMessages.Delete(append(sm.labels, "received")...)
+  sm.server.streamMessages.Delete(append(sm.labels, "sent")...)
+}
+
+func (m *serverMetrics) unaryInterceptor() grpc.UnaryServerInterceptor {
+  return func(
+    ctx context.Context,
+    req interface{},
+    info *grpc.UnaryServerInfo,
+    handler grpc.UnaryHandler) (interface{}, error) {
+    
+    resp, err := handler(ctx, req)
+    m.unaryCalls.With(info.FullMethod, status.Code(err).String()).Inc()
+    return resp, err
+  }
+}
+
+func (m *serverMetrics) streamInterceptor() grpc.StreamServerInterceptor {
+  return func(
+    srv interface{},
+    stream grpc.ServerStream,
+    info *grpc.StreamServerInfo,
+    handler grpc.StreamHandler) error {
+    
+    m.streamsOpened.With(info.FullMethod).Inc()
+    m.streamsOpen.Add(1)
+    err := handler(srv, stream)
+    m.streamsOpen.Add(-1)
+    m.streamsClosed.With(info.FullMethod, status.Code(err).String()).Inc()
+    return err
+  }
+}
+
+// startMetrics serves the metrics on /metrics of the address
+func startMetrics(addr string, registry *metrics.Registry) error {
+  logging.Debug("startMetrics()")
+  if addr == "" {
+    logging.Info("metrics endpoint is off")
+    return nil
+  }
+  
+  lis, err := net.Listen("tcp", addr)
+  if err != nil {
+    return fmt.Errorf("failed to listen for metrics: %v", err)
+  }
+  mux := http.NewServeMux()
+  mux.Handle("/metrics", registry)
+  go func() {
+    if err := http.Serve(lis, mux); err != nil {
+      logging.Warn("metrics endpoint stopped", "err", err)
+    }
+  }()
+  logging.Info("serving metrics", "url", "http://"+lis.Addr().String()+"/metrics")
+  return nil
+}
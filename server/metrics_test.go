@@ -0,0 +1,80 @@
+package main
+
+import (
+  "context"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "strings"
+  "testing"
+  
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+)
+
+func scrape(t *testing.T, addr string) string {
+  resp, err := http.Get("http://" + addr + "/metrics")
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  defer resp.Body.Close()
+  body, _ := ioutil.ReadAll(resp.Body)
+  return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+  port, metricsAddr := "7008", "127.0.0.1:9108"
+  serverCmd := startServerWith("GRPC_PORT="+port, "GRPC_METRICS_ADDR="+metricsAddr)
+  defer stopServer(serverCmd)
+  conn := startClient(port)
+  defer stopClient(conn)
+  
+  stream, err := pb.NewSimpleClient(conn).FindMaxNumber(context.Background())
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  privateKey := rsaPrivateKey()
+  for _, number := range []int64{3, 1, 8} {
+    signature, _ := privateKey.Sign(crypto.Int64ToBytes(number))
+    stream.Send(&pb.MaxNumberRequest{Number: number, Signature: signature})
+  }
+  // two of the three numbers raise the maximum
+  for i := 0; i < 2; i++ {
+    if _, err := stream.Recv(); err != nil {
+      t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+  }
+  
+  open := scrape(t, metricsAddr)
+  for _, line := range []string{
+    "maxnumber_streams_open 1",
+    `maxnumber_stream_messages_total{stream="1",session="default",direction="sent"} 2`,
+  } {
+    if !strings.Contains(open, line+"\n") {
+      t.Errorf("Got: %s, wanted: %s\n", open, line)
+    }
+  }
+  
+  stream.CloseSend()
+  if _, err := stream.Recv(); err != io.EOF {
+    t.Errorf("Got: %v, wanted: %v\n", err, io.EOF)
+  }
+  closed := scrape(t, metricsAddr)
+  for _, line := range []string{
+    `maxnumber_streams_opened_total{method="/simple.Simple/FindMaxNumber"} 1`,
+    `maxnumber_streams_closed_total{method="/simple.Simple/FindMaxNumber",code="OK"} 1`,
+    "maxnumber_streams_open 0",
+    "maxnumber_numbers_received_total 3",
+    "maxnumber_signatures_verified_total 3",
+    "maxnumber_signatures_failed_total 0",
+    "maxnumber_max_updates_sent_total 2",
+    "maxnumber_verify_duration_seconds_count 3",
+  } {
+    if !strings.Contains(closed, line+"\n") {
+      t.Errorf("Got: %s, wanted: %s\n", closed, line)
+    }
+  }
+  if strings.Contains(closed, `stream="1"`) {
+    t.Errorf("Got: %s, wanted: no metrics of closed streams\n", closed)
+  }
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+  "crypto/tls"
+  "fmt"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/endpoint"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/credentials"
+  "google.golang.org/grpc/status"
+)
+
+// peerServerOptions are the options of the server that serves other
+// servers, the nodes of a cluster or the gossip peers. The calls there
+// change every session's maximum without tokens, the policy or the
+// chain, so servers must authenticate with a client certificate and
+// only the identities given may call
+func peerServerOptions(conf *config.Config, peers string, identities []string) ([]grpc.ServerOption, error) {
+  logging.Debug("peerServerOptions()")
+  if !conf.TLS || conf.TLSCA == "" || conf.TLSClientCert == "" {
+    return nil, fmt.Errorf("%s must authenticate each other, which needs TLS, a CA and a client certificate", peers)
+  }
+  tlsConfig, err := serverTLSConfig(conf)
+  if err != nil {
+    return nil, fmt.Errorf("failed to configure TLS: %v", err)
+  }
+  tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+  allowed := make(map[string]bool, len(identities))
+  for _, identity := range identities {
+    allowed[identity] = true
+  }
+  return []grpc.ServerOption{
+    grpc.Creds(credentials.NewTLS(tlsConfig)),
+    grpc.UnaryInterceptor(peerInterceptor(allowed, conf.TLSIdentity)),
+  }, nil
+}
+
+// peerInterceptor denies calls from servers whose certificate
+// identity isn't one of the allowed
+func peerInterceptor(allowed map[string]bool, field string) grpc.UnaryServerInterceptor {
+  return func(
+    ctx context.Context,
+    req interface{},
+    info *grpc.UnaryServerInfo,
+    handler grpc.UnaryHandler) (interface{}, error) {
+    
+    if identity := peerIdentity(ctx, field); !allowed[identity] {
+      logging.Warn("denied call of unknown peer", "method", info.FullMethod, "peer", peerAddress(ctx),
+        "identity", identity)
+      return nil, status.Errorf(codes.PermissionDenied, "%q is not a known peer", identity)
+    }
+    return handler(ctx, req)
+  }
+}
+
+// dialPeer connects to another server with the client's TLS settings,
+// whose certificate identifies the server to its peers. Calls between
+// servers aren't traced, and a restarted server is reconnected to
+// within maxDelay
+func dialPeer(addr string, conf *config.Config, maxDelay time.Duration) (*grpc.ClientConn, error) {
+  tlsConfig, err := endpoint.ClientTLSConfig(conf)
+  if err != nil {
+    return nil, fmt.Errorf("failed to configure TLS: %v", err)
+  }
+  return grpc.Dial(addr, grpc.WithBackoffMaxDelay(maxDelay),
+    grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+}
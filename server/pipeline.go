@@ -0,0 +1,112 @@
+package main
+
+import (
+  "runtime"
+  
+  "github.com/salman-ahmad/grpc-streaming/chain"
+  "golang.org/x/net/context"
+)
+
+// verifyJob is a request waiting for the concurrent stages of the chain
+type verifyJob struct {
+  request *chain.Request
+  result  chan error
+}
+
+// verified is a request together with the outcome of the concurrent stages
+type verified struct {
+  request *chain.Request
+  err     error
+}
+
+// verifierPool runs the concurrent stages of the chain, such as signature
+// verification, on a fixed number of goroutines shared by every stream
+type verifierPool struct {
+  jobs   chan verifyJob
+  verify func(*chain.Request) error
+}
+
+// newVerifierPool starts the workers; zero
+// workers means one per available CPU
+func newVerifierPool(workers int, verify func(*chain.Request) error) *verifierPool {
+  if workers <= 0 {
+    workers = runtime.GOMAXPROCS(0)
+  }
+  pool := &verifierPool{jobs: make(chan verifyJob, workers), verify: verify}
+  for i := 0; i < workers; i++ {
+    go pool.work()
+  }
+  return pool
+}
+
+func (p *verifierPool) work() {
+  for job := range p.jobs {
+    job.result <- p.verify(job.request)
+  }
+}
+
+// pipeline verifies requests on the pool as they arrive and returns the
+// results in the order the requests were received. At most window
+// requests are in flight, which pushes back on the receiver when the
+// stream sends faster than the pool verifies
+func (p *verifierPool) pipeline(
+  done <-chan struct{},
+  requests <-chan *chain.Request,
+  window int) <-chan verified {
+  
+  pending := make(chan verifyJob, window)
+  go func() {
+    defer close(pending)
+    for request := range requests {
+      job := verifyJob{request: request, result: make(chan error, 1)}
+      select {
+      case pending <- job:
+      case <-done:
+        return
+      }
+      select {
+      case p.jobs <- job:
+      case <-done:
+        return
+      }
+    }
+  }()
+  
+  // wait for the jobs in the order they were queued,
+  // so a fast worker can't overtake an earlier request
+  results := make(chan verified)
+  go func() {
+    defer close(results)
+    for job := range pending {
+      var err error
+      select {
+      case err = <-job.result:
+      case <-done:
+        return
+      }
+      select {
+      case results <- verified{request: job.request, err: err}:
+      case <-done:
+        return
+      }
+    }
+  }()
+  return results
+}
+
+// verifyOne runs one request through the concurrent stages
+// on the pool, for numbers that don't come from a stream
+func (p *verifierPool) verifyOne(ctx context.Context, request *chain.Request) error {
+  job := verifyJob{request: request, result: make(chan error, 1)}
+  select {
+  case p.jobs <- job:
+  case <-ctx.Done():
+    return ctx.Err()
+  }
+  select {
+  case err := <-job.result:
+    return err
+  case <-ctx.Done():
+    return ctx.Err()
+  }
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+  "errors"
+  "math/rand"
+  "testing"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/chain"
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  "golang.org/x/net/context"
+)
+
+// feed sends the requests to the pipeline and closes it
+func feed(requests []*chain.Request) <-chan *chain.Request {
+  in := make(chan *chain.Request)
+  go func() {
+    defer close(in)
+    for _, request := range requests {
+      in <- request
+    }
+  }()
+  return in
+}
+
+func TestVerifierPool_KeepsOrder(t *testing.T) {
+  // verify slower for earlier numbers so later ones finish first
+  pool := newVerifierPool(8, func(request *chain.Request) error {
+    time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+    return nil
+  })
+  
+  var requests []*chain.Request
+  for i := 0; i < 200; i++ {
+    requests = append(requests, &chain.Request{Number: int64(i)})
+  }
+  
+  done := make(chan struct{})
+  defer close(done)
+  expected := int64(0)
+  for result := range pool.pipeline(done, feed(requests), 16) {
+    if result.request.Number != expected {
+      t.Fatalf("Got: %d, wanted: %d\n", result.request.Number, expected)
+    }
+    expected++
+  }
+  if expected != int64(len(requests)) {
+    t.Errorf("Got: %d results, wanted: %d\n", expected, len(requests))
+  }
+}
+
+func TestVerifierPool_ReportsErrors(t *testing.T) {
+  expected := errors.New("bad signature")
+  pool := newVerifierPool(2, func(request *chain.Request) error {
+    if request.Number == 2 {
+      return expected
+    }
+    return nil
+  })
+  
+  requests := []*chain.Request{{Number: 1}, {Number: 2}, {Number: 3}}
+  done := make(chan struct{})
+  defer close(done)
+  for result := range pool.pipeline(done, feed(requests), 4) {
+    if result.request.Number == 2 && result.err != expected {
+      t.Errorf("Got: %v, wanted: %v\n", result.err, expected)
+    }
+    if result.request.Number != 2 && result.err != nil {
+      t.Errorf("Got: %v, wanted: %v\n", result.err, nil)
+    }
+  }
+}
+
+func TestVerifierPool_StopsWhenDone(t *testing.T) {
+  pool := newVerifierPool(1, func(request *chain.Request) error {
+    return nil
+  })
+  
+  // nothing reads the results, so closing done must unblock the pipeline
+  done := make(chan struct{})
+  in := make(chan *chain.Request)
+  results := pool.pipeline(done, in, 1)
+  in <- &chain.Request{Number: 1}
+  close(done)
+  
+  select {
+  case <-results:
+  case <-time.After(time.Second):
+    t.Errorf("Got: %s, wanted: %s\n", "blocked pipeline", "closed results")
+  }
+}
+
+func TestNewServer_NegativeVerify(t *testing.T) {
+  for _, c := range []config.Config{{VerifyWorkers: -1}, {VerifyWindow: -1}} {
+    if _, err := newServer(&c); err == nil {
+      t.Errorf("Got: %v, wanted: an error for %+v\n", err, c)
+    }
+  }
+}
+
+// signedRequests signs a handful of numbers with the configured key
+// and repeats them, since signing is much slower than verifying
+func signedRequests(b *testing.B, count int) []*chain.Request {
+  privateKey := rsaPrivateKey()
+  signed := make([]*chain.Request, 32)
+  for i := range signed {
+    number := int64(i)
+    signature, err := privateKey.Sign(crypto.Int64ToBytes(number))
+    if err != nil {
+      b.Fatal(err)
+    }
+    signed[i] = &chain.Request{Number: number, Signature: signature}
+  }
+  
+  requests := make([]*chain.Request, count)
+  for i := range requests {
+    requests[i] = signed[i%len(signed)]
+  }
+  return requests
+}
+
+// verifyChain builds the verify stage with the configured public key
+func verifyChain(b *testing.B) *chain.Chain {
+  publicKey, err := crypto.NewFileKey(absolutePath(b, conf.PublicKey))
+  if err != nil {
+    b.Fatal(err)
+  }
+  rsaPublicKey, err := crypto.NewRSAPublicKey(publicKey.Bytes())
+  if err != nil {
+    b.Fatal(err)
+  }
+  s := server{publicKey: rsaPublicKey}
+  c, err := chain.New([]string{"verify"}, chain.Env{Config: conf, Keys: s.publicKeysFor})
+  if err != nil {
+    b.Fatal(err)
+  }
+  return c
+}
+
+func absolutePath(b *testing.B, path string) string {
+  absPath, err := config.AbsolutePath(path)
+  if err != nil {
+    b.Fatal(err)
+  }
+  return absPath
+}
+
+// BenchmarkVerify_Inline is the baseline of verifying on the receiving
+// goroutine; it does not scale with GOMAXPROCS
+func BenchmarkVerify_Inline(b *testing.B) {
+  c := verifyChain(b)
+  requests := signedRequests(b, b.N)
+  b.ResetTimer()
+  for _, request := range requests {
+    if err := c.Handle(context.Background(), request); err != nil {
+      b.Fatal(err)
+    }
+  }
+}
+
+// BenchmarkVerify_Pipeline verifies one stream on a pool with a worker
+// per CPU; run it with -cpu 1,2,4,8 to see throughput scale
+func BenchmarkVerify_Pipeline(b *testing.B) {
+  c := verifyChain(b)
+  pool := newVerifierPool(0, func(request *chain.Request) error {
+    return c.Handle(context.Background(), request)
+  })
+  requests := signedRequests(b, b.N)
+  done := make(chan struct{})
+  defer close(done)
+  
+  b.ResetTimer()
+  for result := range pool.pipeline(done, feed(requests), 64) {
+    if result.err != nil {
+      b.Fatal(result.err)
+    }
+  }
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+  "fmt"
+  
+  "github.com/salman-ahmad/grpc-streaming/auth"
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+// authorize checks the policy allows the caller to call
+// the method on the session the RPC names
+func authorize(ctx context.Context, policy *auth.Policy, identityField, method string) error {
+  session, err := auth.SessionFromContext(ctx)
+  if err != nil {
+    return status.Error(codes.InvalidArgument, err.Error())
+  }
+  identity := callerIdentity(ctx, identityField)
+  if err := policy.Authorize(identity, method, session); err != nil {
+    logging.Warn("denied unauthorized call", "method", method, "peer", peerAddress(ctx),
+      "identity", identity, "session", session, "err", err)
+    return status.Errorf(codes.PermissionDenied, "permission denied: %v", err)
+  }
+  return nil
+}
+
+func unaryPolicyInterceptor(policy *auth.Policy, identityField string) grpc.UnaryServerInterceptor {
+  return func(
+    ctx context.Context,
+    req interface{},
+    info *grpc.UnaryServerInfo,
+    handler grpc.UnaryHandler) (interface{}, error) {
+    
+    if err := authorize(ctx, policy, identityField, info.FullMethod); err != nil {
+      return nil, err
+    }
+    return handler(ctx, req)
+  }
+}
+
+func streamPolicyInterceptor(policy *auth.Policy, identityField string) grpc.StreamServerInterceptor {
+  return func(
+    srv interface{},
+    stream grpc.ServerStream,
+    info *grpc.StreamServerInfo,
+    handler grpc.StreamHandler) error {
+    
+    if err := authorize(stream.Context(), policy, identityField, info.FullMethod); err != nil {
+      return err
+    }
+    return handler(srv, stream)
+  }
+}
+
+// policyInterceptors returns the interceptors that enforce the policy
+// file, or nil without one. They run after authentication so callers
+// are known by their token's subject
+func policyInterceptors(conf *config.Config) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor, error) {
+  logging.Debug("policyInterceptors()")
+  if conf.Policy == "" {
+    logging.Info("no policy, every caller may call every RPC")
+    return nil, nil, nil
+  }
+  
+  policyPath, err := config.AbsolutePath(conf.Policy)
+  if err != nil {
+    return nil, nil, fmt.Errorf("failed to calculate policy's absolute path: %v", err)
+  }
+  policy, err := auth.LoadPolicy(policyPath)
+  if err != nil {
+    return nil, nil, fmt.Errorf("failed to load policy: %v", err)
+  }
+  logging.Info("enforcing policy", "rules", len(policy.Rules), "path", policyPath)
+  return unaryPolicyInterceptor(policy, conf.TLSIdentity), streamPolicyInterceptor(policy, conf.TLSIdentity), nil
+}
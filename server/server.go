@@ -1,113 +1,628 @@
 package main
 
 import (
+  "fmt"
   "io"
-  "log"
-  "net"
+  "os"
+  "os/signal"
+  "sync"
+  "sync/atomic"
+  "syscall"
+  "time"
   
+  "github.com/salman-ahmad/grpc-streaming/audit"
+  "github.com/salman-ahmad/grpc-streaming/auth"
+  "github.com/salman-ahmad/grpc-streaming/chain"
   "github.com/salman-ahmad/grpc-streaming/config"
   "github.com/salman-ahmad/grpc-streaming/crypto"
+  "github.com/salman-ahmad/grpc-streaming/logging"
   pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "github.com/salman-ahmad/grpc-streaming/state"
+  "github.com/salman-ahmad/grpc-streaming/trace"
+  "golang.org/x/net/context"
   "google.golang.org/grpc"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/health"
+  healthpb "google.golang.org/grpc/health/grpc_health_v1"
+  "google.golang.org/grpc/peer"
+  "google.golang.org/grpc/reflection"
+  "google.golang.org/grpc/status"
 )
 
 type server struct {
-  publicKey crypto.PublicKey
+  publicKey    crypto.PublicKey
+  trustedKeys  map[string]crypto.PublicKey
+  identities   map[string][]string
+  identity     string
+  sequential   *chain.Chain
+  verifier     *verifierPool
+  verifyWindow int
+  sessions     *state.Store
+  audit        *audit.Log
+  cluster      *clusterNode
+  gossip       *gossipNode
+  metrics      *serverMetrics
+  health       *health.Server
+  // closing is closed when the server starts shutting down
+  closing chan struct{}
 }
 
-// TODO maybe use Chain of Responsibility pattern to verify
-//  signature and determine new maximum number
+// streamIDs numbers the streams in the logs
+var streamIDs uint64
+
+// publicKeysFor returns the key that verifies requests signed with the
+// given key ID. Requests without one are verified with the keys trusted
+// for the client's identity, or else with the default public key
+func (s server) publicKeysFor(keyID, identity string) ([]crypto.PublicKey, error) {
+  if keyID == "" && len(s.identities[identity]) > 0 {
+    keys := make([]crypto.PublicKey, 0, len(s.identities[identity]))
+    for _, id := range s.identities[identity] {
+      keys = append(keys, s.trustedKeys[id])
+    }
+    return keys, nil
+  }
+  if keyID == "" || keyID == s.publicKey.KeyID() {
+    return []crypto.PublicKey{s.publicKey}, nil
+  }
+  if key, ok := s.trustedKeys[keyID]; ok {
+    return []crypto.PublicKey{key}, nil
+  }
+  return nil, fmt.Errorf("key %s is not trusted", keyID)
+}
+
+// ownerOf returns the identity the trusted key with the
+// given ID belongs to. The default public key has no owner
+func (s server) ownerOf(keyID string) string {
+  for identity, keyIDs := range s.identities {
+    for _, id := range keyIDs {
+      if id == keyID {
+        return identity
+      }
+    }
+  }
+  return ""
+}
+
+// FindMaxNumber runs every request through the chain of stages
+// configured for the deployment and sends the stream's new
+// maximum whenever a request raises it, or the reason a
+// request was rejected
 func (s server) FindMaxNumber(stream pb.Simple_FindMaxNumberServer) error {
-  log.Println("FindMaxNumber()")
-  var maxNumber int64
+  logging.Debug("FindMaxNumber()")
+  ctx := stream.Context()
+  streamState := chain.NewStream(atomic.AddUint64(&streamIDs, 1), peerAddress(ctx))
+  streamState.Identity = callerIdentity(ctx, s.identity)
+  session, err := auth.SessionFromContext(ctx)
+  if err != nil {
+    return status.Error(codes.InvalidArgument, err.Error())
+  }
+  streamState.Session = session
+  logger := streamLogger(streamState)
+  logger.Info("opened stream")
+  
+  streamMetrics := s.metrics.openStream(streamState)
+  defer streamMetrics.close()
+  
+  done := make(chan struct{})
+  defer close(done)
+  requests := make(chan *chain.Request)
+  receiveErr := make(chan error, 1)
+  go receiveRequests(stream, streamState, logger, requests, receiveErr, done)
+  
+  // concurrent stages run in parallel but results come
+  // back in the order the numbers were received
+  results := s.verifier.pipeline(done, requests, s.verifyWindow)
+  var handled uint64
+  for {
+    var result verified
+    select {
+    case <-s.closing:
+      streamMetrics.sentResponse(false, nil)
+      return sendClosing(stream, streamState, logger, handled)
+    case next, ok := <-results:
+      if !ok {
+        return endOfStream(logger, receiveErr)
+      }
+      result = next
+    }
+    
+    streamMetrics.receivedNumber()
+    request, err := result.request, result.err
+    if err == nil {
+      aggregateCtx, span := startNumberSpan(ctx, request, "aggregate")
+      err = s.sequential.Handle(aggregateCtx, request)
+      span.SetError(err)
+      span.End()
+    }
+    
+    // a number that raised the stream's maximum is offered to the session,
+    // and only then recorded, with the session's maximum it resulted in
+    var current state.Session
+    var raised bool
+    if err == nil && request.Updated {
+      current, raised, err = s.offer(ctx, streamState.Session, request.Number, streamState.Identity, request.Sequence)
+    } else {
+      current, _ = s.sessions.Get(streamState.Session)
+    }
+    if recordErr := s.record(request, current.Max, err); recordErr != nil {
+      return recordErr
+    }
+    rejection, rejected := chain.IsRejection(err)
+    if err != nil && !rejected {
+      logger.Error("failed to process number", numberFields(request, "err", err)...)
+      return err
+    }
+    handled = request.Sequence
+    
+    // tell the stream why a number was rejected, or
+    // send the new max number when the chain accepted one
+    resp := &pb.MaxNumberResponse{Number: streamState.Max, Sequence: request.Sequence}
+    switch {
+    case rejected:
+      resp.Rejection = &pb.Rejection{
+        Stage:  rejection.Stage,
+        Code:   int32(rejection.Code),
+        Reason: rejection.Reason,
+        Number: request.Number,
+      }
+      logger.Info("rejected number", numberFields(request,
+        "stage", rejection.Stage, "code", rejection.Code, "reason", rejection.Reason)...)
+    case request.Updated:
+      resp.UpdatedBy = streamState.Identity
+      resp.UpdatedAt = time.Now().UnixNano()
+      if raised {
+        resp.UpdatedAt = current.UpdatedAt.UnixNano()
+        resp.SessionVersion = current.Version
+        logger.Info("raised max of session", numberFields(request, "max", current.Max, "version", current.Version)...)
+      }
+      logger.Debug("sending new maxNumber", numberFields(request, "max", streamState.Max)...)
+    default:
+      continue
+    }
+    _, span := startNumberSpan(ctx, request, "respond")
+    span.SetAttribute("max", resp.Number)
+    err = stream.Send(resp)
+    span.SetError(err)
+    span.End()
+    if err != nil {
+      logger.Warn("failed to send stream response", numberFields(request, "err", err)...)
+      return err
+    }
+    streamMetrics.sentResponse(request.Updated, rejection)
+  }
+}
+
+// endOfStream waits for the receiving goroutine and
+// reports why the client's side of the stream ended
+func endOfStream(logger *logging.Logger, receiveErr <-chan error) error {
+  if err := <-receiveErr; err != nil {
+    return err
+  }
+  logger.Info("end of stream")
+  return nil
+}
+
+// sendClosing tells the stream the server is shutting down, with
+// its final maximum and the last request the server handled
+func sendClosing(
+  stream pb.Simple_FindMaxNumberServer,
+  streamState *chain.Stream,
+  logger *logging.Logger,
+  handled uint64) error {
+  
+  logger.Info("closing stream", "max", streamState.Max, "sequence", handled)
+  resp := &pb.MaxNumberResponse{Number: streamState.Max, Sequence: handled, Closing: true}
+  if err := stream.Send(resp); err != nil {
+    logger.Warn("failed to send closing response", "err", err)
+    return err
+  }
+  return nil
+}
+
+func peerAddress(ctx context.Context) string {
+  if p, ok := peer.FromContext(ctx); ok {
+    return p.Addr.String()
+  }
+  return ""
+}
+
+// receiveRequests reads the stream until it ends and reports
+// nil for a clean end of stream, or the error that stopped it
+func receiveRequests(
+  stream pb.Simple_FindMaxNumberServer,
+  streamState *chain.Stream,
+  logger *logging.Logger,
+  requests chan<- *chain.Request,
+  receiveErr chan<- error,
+  done <-chan struct{}) {
   
+  defer close(requests)
+  var sequence uint64
   for {
     // receive new request from stream
     request, err := stream.Recv()
     if err == io.EOF {
-      log.Println("end of stream")
-      return nil
+      receiveErr <- nil
+      return
     }
     if err != nil {
-      log.Printf("failed to receive stream request: %v\n", err)
-      return err
+      logger.Warn("failed to receive stream request", "err", err)
+      receiveErr <- err
+      return
     }
-    log.Printf("received new number %d\n", request.Number)
+    sequence++
+    logger.Debug("received new number", "sequence", sequence, "number", request.Number, "key_id", request.KeyId)
     
-    // TODO maybe extract this block to a separate method?
-    numberBytes := crypto.Int64ToBytes(request.Number)
-    verified, err := s.publicKey.Verify(numberBytes, request.Signature)
-    if err != nil {
-      log.Printf("failed to verify signature: %v\n", err)
-      return err
+    // numbers sent without trace context belong to the stream's trace
+    traceparent := request.Traceparent
+    if traceparent == "" {
+      traceparent = trace.SpanFromContext(stream.Context()).Context().Traceparent()
     }
     
-    // when signature is verified and new number is larger
-    // then update new max number and send it to stream
-    if verified && crypto.IsNewInt64Max(maxNumber, request.Number) {
-      maxNumber = request.Number
-      resp := &pb.MaxNumberResponse{Number: maxNumber}
-      if err := stream.Send(resp); err != nil {
-        log.Printf("failed to send stream response: %v\n", err)
-        return err
-      }
-      log.Printf("sent new maxNumber %d\n", maxNumber)
+    select {
+    case requests <- &chain.Request{
+      Stream:      streamState,
+      Sequence:    sequence,
+      Number:      request.Number,
+      Signature:   request.Signature,
+      KeyID:       request.KeyId,
+      Traceparent: traceparent,
+    }:
+    case <-done:
+      receiveErr <- nil
+      return
     }
   }
 }
 
 func main() {
   
-  conf := loadConfig()
-  rsaPublicKey := rsaPublicKey(conf.PublicKey)
-  server := &server{publicKey: rsaPublicKey}
-  grpcServer := grpc.NewServer()
+  if err := run(); err != nil {
+    logging.Error("server failed", "err", err)
+    os.Exit(1)
+  }
+}
+
+// run starts the server and serves until it is told to shut down
+func run() (err error) {
+  conf, err := loadConfig()
+  if err != nil {
+    return err
+  }
+  logger, err := startLogging(conf)
+  if err != nil {
+    return fmt.Errorf("failed to configure logging: %v", err)
+  }
+  tracer, err := startTracing(conf)
+  if err != nil {
+    return err
+  }
+  defer func() {
+    if err := tracer.Shutdown(); err != nil {
+      logging.Warn("failed to export the last spans", "err", err)
+    }
+  }()
+  server, err := newServer(conf)
+  if err != nil {
+    return err
+  }
+  // however the server stops, its state is saved and its audit log closed
+  defer func() {
+    if closeErr := server.close(); err == nil {
+      err = closeErr
+    }
+  }()
+  
+  var intercept interceptors
+  intercept.add(trace.UnaryServerInterceptor(), trace.StreamServerInterceptor())
+  intercept.add(server.metrics.unaryInterceptor(), server.metrics.streamInterceptor())
+  unaryAuth, streamAuth, err := authInterceptors(conf)
+  if err != nil {
+    return err
+  }
+  intercept.add(exceptHealthChecks(unaryAuth, streamAuth))
+  unaryPolicy, streamPolicy, err := policyInterceptors(conf)
+  if err != nil {
+    return err
+  }
+  intercept.add(exceptHealthChecks(unaryPolicy, streamPolicy))
+  endpoints, err := listenEndpoints(conf)
+  if err != nil {
+    return err
+  }
+  if err := startMetrics(conf.MetricsAddr, server.metrics.registry); err != nil {
+    return err
+  }
+  
+  // every endpoint has a server of its own, as TLS and the services
+  // differ between them; endpoints for admin take the Admin service
+  // away from the others
+  adminEndpoints := hasAdminEndpoint(endpoints)
+  grpcServers := make([]*grpc.Server, 0, len(endpoints))
+  serveErr := make(chan error, len(endpoints))
+  for _, e := range endpoints {
+    options, err := endpointOptions(conf, e)
+    if err != nil {
+      return err
+    }
+    grpcServer := grpc.NewServer(append(options, intercept.options()...)...)
+    if !e.Admin {
+      pb.RegisterSimpleServer(grpcServer, server)
+    }
+    if e.Admin || !adminEndpoints {
+      pb.RegisterAdminServer(grpcServer, adminServer{logger: logger, identity: conf.TLSIdentity})
+    }
+    healthpb.RegisterHealthServer(grpcServer, server.health)
+    reflection.Register(grpcServer)
+    listener, err := startListener(e)
+    if err != nil {
+      return err
+    }
+    go func() {
+      serveErr <- grpcServer.Serve(listener)
+    }()
+    grpcServers = append(grpcServers, grpcServer)
+  }
+  if server.cluster != nil {
+    if err := server.cluster.start(); err != nil {
+      return err
+    }
+  }
+  if server.gossip != nil {
+    if err := server.gossip.start(); err != nil {
+      return err
+    }
+  }
+  server.updateHealth()
+  gatewayServer, err := startGateway(conf, endpoints)
+  if err != nil {
+    return err
+  }
+  
+  signals := make(chan os.Signal, 1)
+  signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+  select {
+  case err := <-serveErr:
+    stopServers(grpcServers)
+    return fmt.Errorf("failed to start server: %v", err)
+  case sig := <-signals:
+    logging.Info("shutting down", "signal", sig)
+  }
+  shutdown(grpcServers, server, conf.ShutdownTimeout, signals)
   
-  pb.RegisterSimpleServer(grpcServer, server)
-  listener := startListener(conf.Port)
-  err := grpcServer.Serve(listener)
+  // gateway requests end with the streams they called
+  if gatewayServer != nil {
+    ctx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+    defer cancel()
+    gatewayServer.Shutdown(ctx)
+  }
+  return nil
+}
+
+// newServer loads the keys and state of the server
+// and builds the chain its streams run through
+func newServer(conf *config.Config) (*server, error) {
+  logging.Debug("newServer()")
+  if conf.VerifyWorkers < 0 || conf.VerifyWindow < 0 {
+    return nil, fmt.Errorf("verify workers %d and verify window %d can't be negative",
+      conf.VerifyWorkers, conf.VerifyWindow)
+  }
+  rsaPublicKey, err := rsaPublicKey(conf.PublicKey)
+  if err != nil {
+    return nil, err
+  }
+  trustedKeys, identities, err := trustedKeys(conf.TrustStore)
+  if err != nil {
+    return nil, err
+  }
+  sessions, err := openState(conf.StateFile)
+  if err != nil {
+    return nil, err
+  }
+  sessions.SetHistorySize(conf.HistorySize)
+  sessions.SetSaveDelay(conf.StateSaveDelay)
+  auditLog, err := openAudit(conf.AuditLog)
+  if err != nil {
+    return nil, err
+  }
+  node, err := newClusterNode(conf, sessions)
+  if err != nil {
+    return nil, err
+  }
+  peers, err := newGossipNode(conf, sessions)
+  if err != nil {
+    return nil, err
+  }
+  server := &server{
+    publicKey:    rsaPublicKey,
+    trustedKeys:  trustedKeys,
+    identities:   identities,
+    identity:     conf.TLSIdentity,
+    sessions:     sessions,
+    audit:        auditLog,
+    cluster:      node,
+    gossip:       peers,
+    closing:      make(chan struct{}),
+    metrics:      newServerMetrics(),
+    health:       newHealthServer(),
+    verifyWindow: conf.VerifyWindow,
+  }
+  concurrent, sequential, err := buildChain(conf, chain.Env{
+    Config:   conf,
+    Keys:     server.publicKeysFor,
+    Owner:    server.ownerOf,
+    OnVerify: server.metrics.onVerify,
+  })
   if err != nil {
-    log.Fatalf("failed to start server: %v\n", err)
+    return nil, err
+  }
+  server.sequential = sequential
+  server.verifier = newVerifierPool(conf.VerifyWorkers, func(request *chain.Request) error {
+    ctx, span := startNumberSpan(context.Background(), request, "verify")
+    err := concurrent.Handle(ctx, request)
+    span.SetAttribute("key_id", request.Annotations["key_id"])
+    span.SetError(err)
+    span.End()
+    return err
+  })
+  return server, nil
+}
+
+// shutdown reports the server as not serving to health checks, stops
+// accepting connections, closes every stream with a final message and
+// waits for them to finish. Streams still open after the timeout,
+// or a second signal, are cut off
+func shutdown(grpcServers []*grpc.Server, s *server, timeout time.Duration, signals <-chan os.Signal) {
+  logging.Debug("shutdown()")
+  close(s.closing)
+  s.updateHealth()
+  stopped := make(chan struct{})
+  go func() {
+    var wg sync.WaitGroup
+    for _, grpcServer := range grpcServers {
+      wg.Add(1)
+      go func(grpcServer *grpc.Server) {
+        defer wg.Done()
+        grpcServer.GracefulStop()
+      }(grpcServer)
+    }
+    wg.Wait()
+    close(stopped)
+  }()
+  
+  select {
+  case <-stopped:
+    logging.Info("all streams closed")
+  case <-time.After(timeout):
+    logging.Warn("streams still open, stopping server", "timeout", timeout)
+    stopServers(grpcServers)
+  case sig := <-signals:
+    logging.Warn("received signal again, stopping server", "signal", sig)
+    stopServers(grpcServers)
+  }
+}
+
+// close leaves the cluster and stops gossip, so no more changes are
+// applied, then saves the state and closes the audit log
+func (s *server) close() error {
+  logging.Debug("close()")
+  if s.cluster != nil {
+    if err := s.cluster.stop(); err != nil {
+      logging.Warn("failed to leave cluster", "err", err)
+    }
+  }
+  if s.gossip != nil {
+    if err := s.gossip.stop(); err != nil {
+      logging.Warn("failed to stop gossip", "err", err)
+    }
+  }
+  var err error
+  if err = s.sessions.Save(); err != nil {
+    err = fmt.Errorf("failed to save state: %v", err)
+  } else {
+    logging.Info("saved state", "sessions", len(s.sessions.Sessions()))
+  }
+  if s.audit != nil {
+    if closeErr := s.audit.Close(); closeErr != nil && err == nil {
+      err = fmt.Errorf("failed to close audit log: %v", closeErr)
+    }
+  }
+  return err
+}
+
+// stopServers closes every connection of the servers right away
+func stopServers(grpcServers []*grpc.Server) {
+  for _, grpcServer := range grpcServers {
+    grpcServer.Stop()
   }
 }
 
-func loadConfig() *config.Config {
-  log.Println("loadConfig()")
+func loadConfig() (*config.Config, error) {
+  logging.Debug("loadConfig()")
   conf, err := config.LoadConfig()
   if err != nil {
-    log.Fatalf("failed to read configuration :%v\n", err)
+    return nil, fmt.Errorf("failed to read configuration: %v", err)
   }
-  log.Println("processed configuration")
-  return conf
+  logging.Debug("processed configuration")
+  return conf, nil
 }
 
-func rsaPublicKey(key string) crypto.PublicKey {
-  log.Println("rsaPublicKey()")
+func rsaPublicKey(key string) (crypto.PublicKey, error) {
+  logging.Debug("rsaPublicKey()")
   pubKeyPath, err := config.AbsolutePath(key)
   if err != nil {
-    log.Fatalf("failed to calculate private key's absloute path :%v\n", err)
+    return nil, fmt.Errorf("failed to calculate public key's absolute path: %v", err)
   }
   
   publicKey, err := crypto.NewFileKey(pubKeyPath)
   if err != nil {
-    log.Fatalf("failed to load public key: %v\n", err)
+    return nil, fmt.Errorf("failed to load public key: %v", err)
   }
-  log.Printf("using public key %s\n", pubKeyPath)
+  logging.Info("using public key", "path", pubKeyPath)
   rsaPublicKey, err := crypto.NewRSAPublicKey(publicKey.Bytes())
   
   if err != nil {
-    log.Fatalf("failed to read public key: %v\n", err)
+    return nil, fmt.Errorf("failed to read public key: %v", err)
   }
-  log.Println("parsed RSA public key")
-  return rsaPublicKey
+  logging.Debug("parsed RSA public key")
+  return rsaPublicKey, nil
 }
 
-func startListener(port string) net.Listener {
-  log.Println("startListener()")
-  lis, err := net.Listen("tcp", ":"+port)
+// trustedKeys parses the keys in the trust store and
+// lists the IDs of the keys trusted for each identity
+func trustedKeys(trustStore string) (map[string]crypto.PublicKey, map[string][]string, error) {
+  logging.Debug("trustedKeys()")
+  trustStorePath, err := config.AbsolutePath(trustStore)
+  if err != nil {
+    return nil, nil, fmt.Errorf("failed to calculate trust store's absolute path: %v", err)
+  }
+  
+  store, err := crypto.LoadTrustStore(trustStorePath)
+  if err != nil {
+    return nil, nil, fmt.Errorf("failed to load trust store: %v", err)
+  }
+  keys, err := store.PublicKeys()
+  if err != nil {
+    return nil, nil, fmt.Errorf("failed to read trusted keys: %v", err)
+  }
+  identities := make(map[string][]string)
+  for _, trusted := range store.Keys() {
+    if trusted.Identity != "" {
+      identities[trusted.Identity] = append(identities[trusted.Identity], trusted.ID)
+    }
+  }
+  logging.Info("loaded trusted keys", "keys", len(keys), "path", trustStorePath)
+  return keys, identities, nil
+}
+
+// buildChain builds the stages named in the configuration and splits
+// them into the ones that run on the worker pool and the rest
+func buildChain(conf *config.Config, env chain.Env) (*chain.Chain, *chain.Chain, error) {
+  logging.Debug("buildChain()")
+  c, err := chain.New(conf.Chain, env)
+  if err != nil {
+    return nil, nil, fmt.Errorf("failed to build request chain: %v", err)
+  }
+  concurrent, sequential := c.Split()
+  logging.Info("using request chain", "stages", c.Names(), "parallel", concurrent.Names())
+  return concurrent, sequential, nil
+}
+
+// openState recovers the sessions saved by the last run
+func openState(stateFile string) (*state.Store, error) {
+  logging.Debug("openState()")
+  statePath := ""
+  if stateFile != "" {
+    var err error
+    if statePath, err = config.AbsolutePath(stateFile); err != nil {
+      return nil, fmt.Errorf("failed to calculate state file's absolute path: %v", err)
+    }
+  }
+  
+  sessions, err := state.Open(statePath)
   if err != nil {
-    log.Fatalf("failed to listen to port: %v\n", err)
+    return nil, fmt.Errorf("failed to recover state: %v", err)
   }
-  log.Printf("starting server on port %s\n", port)
-  return lis
+  logging.Info("recovered state", "sessions", len(sessions.Sessions()), "path", statePath)
+  return sessions, nil
 }
@@ -7,6 +7,7 @@ import (
   "net"
   "os"
   "os/exec"
+  "syscall"
   "testing"
   "time"
   
@@ -19,16 +20,14 @@ import (
 var simpleClient pb.SimpleClient
 var conf *config.Config
 
+// always rebuild so the tests never run a stale server; go build
+// only recompiles what changed since the last run
 func buildServer() {
   log.Println("buildServer()")
-  if _, err := os.Stat("../server/server"); os.IsNotExist(err) {
-    log.Println("file does not exist; build it")
-    cmd := exec.Command("go", "build", "-o", "server/server", "server/server.go")
-    cmd.Dir = ".."
-    err := cmd.Start()
-    if err != nil {
-      log.Fatalf("Server file failed to build: %v\n", err)
-    }
+  cmd := exec.Command("go", "build", "-o", "server/server", "./server")
+  cmd.Dir = ".."
+  if out, err := cmd.CombinedOutput(); err != nil {
+    log.Fatalf("Server file failed to build: %v\n%s", err, out)
   }
 }
 
@@ -37,6 +36,8 @@ func startServer() *exec.Cmd {
   cmdStr := "server/server"
   serverCmd := exec.Command(cmdStr)
   serverCmd.Dir = ".."
+  // keep the state of test servers out of the home directory
+  serverCmd.Env = append(os.Environ(), "GRPC_STATE_FILE=")
   
   err := serverCmd.Start()
   if err != nil {
@@ -54,10 +55,25 @@ func startServer() *exec.Cmd {
   return serverCmd
 }
 
+// stopServer asks the server to shut down gracefully and waits
+// for it, killing it if it doesn't exit in time
 func stopServer(serverCmd *exec.Cmd) {
   log.Println("stopServer()")
-  if err := serverCmd.Process.Kill(); err != nil {
-    log.Fatalf("failed to kill process: %v\n", err)
+  if err := serverCmd.Process.Signal(syscall.SIGTERM); err != nil {
+    log.Fatalf("failed to stop process: %v\n", err)
+  }
+  
+  exited := make(chan error, 1)
+  go func() { exited <- serverCmd.Wait() }()
+  select {
+  case err := <-exited:
+    if err != nil {
+      log.Printf("server exited: %v\n", err)
+    }
+  case <-time.After(15 * time.Second):
+    log.Println("server didn't stop, killing it")
+    serverCmd.Process.Kill()
+    <-exited
   }
 }
 
@@ -81,7 +97,10 @@ func stopClient(conn *grpc.ClientConn) {
 }
 
 func TestMain(m *testing.M) {
-  conf = loadConfig()
+  var err error
+  if conf, err = loadConfig(); err != nil {
+    log.Fatalf("failed to read configuration: %v\n", err)
+  }
   buildServer()
   serverCmd := startServer()
   clientConn := startClient(conf.Port)
@@ -162,3 +181,63 @@ func TestFindMaxNumber(t *testing.T) {
     t.Errorf("Got: %d, wanted: %d\n", actualMaxNumber, expectedMaxNumber)
   }
 }
+
+// startServerWith starts another server configured by
+// the given environment variables on top of the defaults
+func startServerWith(env ...string) *exec.Cmd {
+  log.Println("startServerWith()")
+  serverCmd := exec.Command("server/server")
+  serverCmd.Dir = ".."
+  serverCmd.Env = append(append(os.Environ(), "GRPC_STATE_FILE="), env...)
+  
+  if err := serverCmd.Start(); err != nil {
+    log.Fatalf("Server failed to start: %v\n", err)
+  }
+  time.Sleep(1 * time.Second)
+  return serverCmd
+}
+
+func TestFindMaxNumber_Rejection(t *testing.T) {
+  port := "7001"
+  serverCmd := startServerWith("GRPC_PORT="+port, "GRPC_CHAIN=verify,range,max", "GRPC_RANGE_MAX=100")
+  defer stopServer(serverCmd)
+  conn := startClient(port)
+  defer stopClient(conn)
+  
+  stream, err := pb.NewSimpleClient(conn).FindMaxNumber(context.Background())
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  rsaPrivateKey := rsaPrivateKey()
+  for _, number := range []int64{50, 150, 80} {
+    signature, _ := rsaPrivateKey.Sign(crypto.Int64ToBytes(number))
+    stream.Send(&pb.MaxNumberRequest{Number: number, Signature: signature})
+  }
+  stream.CloseSend()
+  
+  var responses []*pb.MaxNumberResponse
+  for {
+    response, err := stream.Recv()
+    if err == io.EOF {
+      break
+    }
+    if err != nil {
+      t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+    responses = append(responses, response)
+  }
+  
+  if len(responses) != 3 {
+    t.Fatalf("Got: %d responses, wanted: %d\n", len(responses), 3)
+  }
+  rejected := responses[1]
+  if rejected.Sequence != 2 || rejected.Rejection == nil || rejected.Rejection.Stage != "range" {
+    t.Errorf("Got: %v, wanted: %s\n", rejected, "range rejection of request 2")
+  }
+  if rejected.Number != 50 || rejected.Rejection.Number != 150 {
+    t.Errorf("Got: %v, wanted: max %d and rejected %d\n", rejected, 50, 150)
+  }
+  if responses[2].Number != 80 || responses[2].Rejection != nil {
+    t.Errorf("Got: %v, wanted: max %d\n", responses[2], 80)
+  }
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+  "context"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "syscall"
+  "testing"
+  
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "github.com/salman-ahmad/grpc-streaming/state"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+func TestFindMaxNumber_GracefulShutdown(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "state")
+  defer os.RemoveAll(dir)
+  statePath := filepath.Join(dir, "state.json")
+  
+  port := "7007"
+  serverCmd := startServerWith("GRPC_PORT="+port, "GRPC_STATE_FILE="+statePath)
+  conn := startClient(port)
+  defer stopClient(conn)
+  
+  stream, err := pb.NewSimpleClient(conn).FindMaxNumber(context.Background())
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  privateKey := rsaPrivateKey()
+  for _, number := range []int64{5, 12} {
+    signature, _ := privateKey.Sign(crypto.Int64ToBytes(number))
+    stream.Send(&pb.MaxNumberRequest{Number: number, Signature: signature})
+    if _, err := stream.Recv(); err != nil {
+      t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+  }
+  
+  watch, err := pb.NewSimpleClient(conn).WatchMax(context.Background(), &pb.WatchMaxRequest{})
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  if sessionMax, err := watch.Recv(); err != nil || sessionMax.Max != 12 || sessionMax.Version != 2 {
+    t.Fatalf("Got: %v %v, wanted: max %d version %d\n", sessionMax, err, 12, 2)
+  }
+  
+  // the streams are still open when the server is asked to stop,
+  // and watchers are told to watch another server
+  serverCmd.Process.Signal(syscall.SIGTERM)
+  if _, err := watch.Recv(); status.Code(err) != codes.Unavailable {
+    t.Errorf("Got: %v, wanted: %v\n", err, codes.Unavailable)
+  }
+  response, err := stream.Recv()
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  if !response.Closing || response.Number != 12 || response.Sequence != 2 {
+    t.Errorf("Got: %+v, wanted: closing with number %d sequence %d\n", response, 12, 2)
+  }
+  if _, err := stream.Recv(); err != io.EOF {
+    t.Errorf("Got: %v, wanted: %v\n", err, io.EOF)
+  }
+  if err := serverCmd.Wait(); err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+  
+  store, _ := state.Open(statePath)
+  session, _ := store.Get("default")
+  if session.Max != 12 || session.Version != 2 {
+    t.Errorf("Got: %+v, wanted: max %d version %d\n", session, 12, 2)
+  }
+}
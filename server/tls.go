@@ -0,0 +1,105 @@
+package main
+
+import (
+  "crypto/tls"
+  "crypto/x509"
+  "fmt"
+  "io/ioutil"
+  
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/credentials"
+  "google.golang.org/grpc/peer"
+)
+
+// loadCertPool reads the PEM encoded CA certificates in the file
+func loadCertPool(path string) (*x509.CertPool, error) {
+  caPath, err := config.AbsolutePath(path)
+  if err != nil {
+    return nil, err
+  }
+  content, err := ioutil.ReadFile(caPath)
+  if err != nil {
+    return nil, err
+  }
+  pool := x509.NewCertPool()
+  if !pool.AppendCertsFromPEM(content) {
+    return nil, fmt.Errorf("no certificates found in %s", caPath)
+  }
+  return pool, nil
+}
+
+// serverTLSConfig builds the TLS configuration of the server. With a CA,
+// client certificates it signed are verified when given, and required
+// when client auth is on
+func serverTLSConfig(conf *config.Config) (*tls.Config, error) {
+  certPath, err := config.AbsolutePath(conf.TLSCert)
+  if err != nil {
+    return nil, err
+  }
+  keyPath, err := config.AbsolutePath(conf.TLSKey)
+  if err != nil {
+    return nil, err
+  }
+  cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+  if err != nil {
+    return nil, err
+  }
+  
+  tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+  if conf.TLSCA == "" {
+    if conf.TLSClientAuth {
+      return nil, fmt.Errorf("client auth needs a CA to verify client certificates")
+    }
+    return tlsConfig, nil
+  }
+  
+  if tlsConfig.ClientCAs, err = loadCertPool(conf.TLSCA); err != nil {
+    return nil, err
+  }
+  tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+  if conf.TLSClientAuth {
+    tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+  }
+  return tlsConfig, nil
+}
+
+func serverOptions(conf *config.Config) ([]grpc.ServerOption, error) {
+  logging.Debug("serverOptions()")
+  if !conf.TLS {
+    logging.Info("TLS is off")
+    return nil, nil
+  }
+  
+  tlsConfig, err := serverTLSConfig(conf)
+  if err != nil {
+    return nil, fmt.Errorf("failed to configure TLS: %v", err)
+  }
+  logging.Info("TLS is on", "client_auth", tlsConfig.ClientAuth)
+  return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(tlsConfig))}, nil
+}
+
+// certIdentity maps a client certificate to an identity, either
+// its subject's common name or its whole subject
+func certIdentity(cert *x509.Certificate, field string) string {
+  if field == "subject" {
+    return cert.Subject.String()
+  }
+  return cert.Subject.CommonName
+}
+
+// peerIdentity returns the identity of a client that authenticated
+// with a verified certificate, or an empty string
+func peerIdentity(ctx context.Context, field string) string {
+  p, ok := peer.FromContext(ctx)
+  if !ok {
+    return ""
+  }
+  tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+  if !ok || len(tlsInfo.State.VerifiedChains) == 0 {
+    return ""
+  }
+  return certIdentity(tlsInfo.State.VerifiedChains[0][0], field)
+}
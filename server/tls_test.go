@@ -0,0 +1,283 @@
+package main
+
+import (
+  "context"
+  "crypto/ecdsa"
+  "crypto/elliptic"
+  "crypto/rand"
+  "crypto/tls"
+  "crypto/x509"
+  "crypto/x509/pkix"
+  "encoding/pem"
+  "io"
+  "io/ioutil"
+  "math/big"
+  "net"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/credentials"
+)
+
+// writePEM writes the DER bytes as a PEM file in the directory
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+  path := filepath.Join(dir, name)
+  content := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+  if err := ioutil.WriteFile(path, content, 0600); err != nil {
+    t.Fatal(err)
+  }
+  return path
+}
+
+// issueCert creates a key and a certificate for it, signed by the
+// parent or self signed when there is no parent; it returns the
+// certificate, its key and their PEM files
+func issueCert(
+  t *testing.T,
+  dir, name string,
+  template *x509.Certificate,
+  parent *x509.Certificate,
+  parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+  
+  key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  if err != nil {
+    t.Fatal(err)
+  }
+  template.SerialNumber = big.NewInt(time.Now().UnixNano())
+  template.NotBefore = time.Now().Add(-time.Hour)
+  template.NotAfter = time.Now().Add(time.Hour)
+  if parent == nil {
+    parent, parentKey = template, key
+  }
+  
+  der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+  if err != nil {
+    t.Fatal(err)
+  }
+  cert, _ := x509.ParseCertificate(der)
+  keyDER, _ := x509.MarshalECPrivateKey(key)
+  certPath := writePEM(t, dir, name+".crt", "CERTIFICATE", der)
+  keyPath := writePEM(t, dir, name+".key", "EC PRIVATE KEY", keyDER)
+  return cert, key, certPath, keyPath
+}
+
+type testPKI struct {
+  dir                   string
+  ca                    *x509.Certificate
+  caKey                 *ecdsa.PrivateKey
+  caPath                string
+  serverCert, serverKey string
+  clientCert, clientKey string
+}
+
+// newTestPKI creates a CA, a certificate for the server on localhost
+// and a client certificate for the given common name
+func newTestPKI(t *testing.T, clientName string) testPKI {
+  dir, _ := ioutil.TempDir("", "pki")
+  pki := testPKI{dir: dir}
+  
+  pki.ca, pki.caKey, pki.caPath, _ = issueCert(t, dir, "ca", &x509.Certificate{
+    Subject:               pkix.Name{CommonName: "test ca"},
+    IsCA:                  true,
+    BasicConstraintsValid: true,
+    KeyUsage:              x509.KeyUsageCertSign,
+  }, nil, nil)
+  
+  _, _, pki.serverCert, pki.serverKey = issueCert(t, dir, "server", &x509.Certificate{
+    Subject:     pkix.Name{CommonName: "localhost"},
+    DNSNames:    []string{"localhost"},
+    IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+    ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+  }, pki.ca, pki.caKey)
+  
+  pki.clientCert, pki.clientKey = pki.issueClient(t, clientName)
+  return pki
+}
+
+// issueClient creates a client certificate for the given common name
+// and returns its PEM files
+func (p testPKI) issueClient(t *testing.T, name string) (string, string) {
+  _, _, certPath, keyPath := issueCert(t, p.dir, "client-"+name, &x509.Certificate{
+    Subject:     pkix.Name{CommonName: name, Organization: []string{"rebels"}},
+    ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+  }, p.ca, p.caKey)
+  return certPath, keyPath
+}
+
+// peerEnv configures a server that authenticates to other servers
+// as the identity, and whose clients may leave out certificates
+func (p testPKI) peerEnv(t *testing.T, port, identity string) []string {
+  certPath, keyPath := p.issueClient(t, identity)
+  return []string{
+    "GRPC_PORT=" + port,
+    "GRPC_TLS=true",
+    "GRPC_TLS_CERT=" + p.serverCert,
+    "GRPC_TLS_KEY=" + p.serverKey,
+    "GRPC_TLS_CA=" + p.caPath,
+    "GRPC_TLS_CLIENT_CERT=" + certPath,
+    "GRPC_TLS_CLIENT_KEY=" + keyPath,
+  }
+}
+
+func (p testPKI) serverEnv(port string) []string {
+  return []string{
+    "GRPC_PORT=" + port,
+    "GRPC_TLS=true",
+    "GRPC_TLS_CERT=" + p.serverCert,
+    "GRPC_TLS_KEY=" + p.serverKey,
+    "GRPC_TLS_CA=" + p.caPath,
+    "GRPC_TLS_CLIENT_AUTH=true",
+  }
+}
+
+// dialTLS connects with the client certificate, or without one when
+// withCert is false
+func (p testPKI) dialTLS(t *testing.T, port string, withCert bool, options ...grpc.DialOption) *grpc.ClientConn {
+  caPEM, _ := ioutil.ReadFile(p.caPath)
+  roots := x509.NewCertPool()
+  roots.AppendCertsFromPEM(caPEM)
+  tlsConfig := &tls.Config{RootCAs: roots}
+  if withCert {
+    cert, err := tls.LoadX509KeyPair(p.clientCert, p.clientKey)
+    if err != nil {
+      t.Fatal(err)
+    }
+    tlsConfig.Certificates = []tls.Certificate{cert}
+  }
+  
+  options = append(options, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+  conn, err := grpc.Dial("localhost:"+port, options...)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return conn
+}
+
+// trustKey writes a trust store with a new key for the identity
+func trustKey(t *testing.T, dir, identity string) (string, crypto.PrivateKey) {
+  key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  keyDER, _ := x509.MarshalECPrivateKey(key)
+  publicDER, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+  privateKey, err := crypto.NewPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+  if err != nil {
+    t.Fatal(err)
+  }
+  
+  path := filepath.Join(dir, "trust_store.json")
+  store, _ := crypto.LoadTrustStore(path)
+  if _, err := store.Add(identity, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})); err != nil {
+    t.Fatal(err)
+  }
+  if err := store.Save(); err != nil {
+    t.Fatal(err)
+  }
+  return path, privateKey
+}
+
+// sendSigned sends the numbers signed with the key, naming the key ID
+// if given, and returns the last response, or the error ending the stream
+func sendSigned(
+  ctx context.Context,
+  conn *grpc.ClientConn,
+  key crypto.PrivateKey,
+  keyID string,
+  numbers ...int64) (*pb.MaxNumberResponse, error) {
+  
+  stream, err := pb.NewSimpleClient(conn).FindMaxNumber(ctx)
+  if err != nil {
+    return nil, err
+  }
+  for _, number := range numbers {
+    signature, _ := key.Sign(crypto.Int64ToBytes(number))
+    stream.Send(&pb.MaxNumberRequest{Number: number, Signature: signature, KeyId: keyID})
+  }
+  stream.CloseSend()
+  
+  var last *pb.MaxNumberResponse
+  for {
+    response, err := stream.Recv()
+    if err == io.EOF {
+      return last, nil
+    }
+    if err != nil {
+      return nil, err
+    }
+    last = response
+  }
+}
+
+func TestFindMaxNumber_MutualTLS(t *testing.T) {
+  pki := newTestPKI(t, "leia")
+  defer os.RemoveAll(pki.dir)
+  trustStore, leiaKey := trustKey(t, pki.dir, "leia")
+  
+  port := "7002"
+  env := append(pki.serverEnv(port), "GRPC_TRUST_STORE="+trustStore)
+  serverCmd := startServerWith(env...)
+  defer stopServer(serverCmd)
+  
+  // no key ID: the server picks leia's key from her certificate
+  conn := pki.dialTLS(t, port, true)
+  defer conn.Close()
+  response, err := sendSigned(context.Background(), conn, leiaKey, "", 3, 9, 4)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  if response.Number != 9 {
+    t.Errorf("Got: %d, wanted: %d\n", response.Number, 9)
+  }
+}
+
+func TestFindMaxNumber_MutualTLSKeyOfOtherPeer(t *testing.T) {
+  pki := newTestPKI(t, "leia")
+  defer os.RemoveAll(pki.dir)
+  trustKey(t, pki.dir, "leia")
+  trustStore, hanKey := trustKey(t, pki.dir, "han")
+  
+  port := "7004"
+  env := append(pki.serverEnv(port), "GRPC_TRUST_STORE="+trustStore)
+  serverCmd := startServerWith(env...)
+  defer stopServer(serverCmd)
+  
+  // han's key is trusted, but not over leia's connection
+  conn := pki.dialTLS(t, port, true)
+  defer conn.Close()
+  response, err := sendSigned(context.Background(), conn, hanKey, hanKey.KeyID(), 5)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  if response.Rejection == nil || codes.Code(response.Rejection.Code) != codes.PermissionDenied {
+    t.Errorf("Got: %v, wanted: %v\n", response.Rejection, codes.PermissionDenied)
+  }
+}
+
+func TestFindMaxNumber_MutualTLSWithoutCert(t *testing.T) {
+  pki := newTestPKI(t, "leia")
+  defer os.RemoveAll(pki.dir)
+  
+  port := "7003"
+  serverCmd := startServerWith(pki.serverEnv(port)...)
+  defer stopServer(serverCmd)
+  
+  conn := pki.dialTLS(t, port, false)
+  defer conn.Close()
+  if _, err := sendSigned(context.Background(), conn, rsaPrivateKey(), "", 1); err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "handshake error")
+  }
+}
+
+func TestCertIdentity(t *testing.T) {
+  cert := &x509.Certificate{Subject: pkix.Name{CommonName: "leia", Organization: []string{"rebels"}}}
+  if actual := certIdentity(cert, "cn"); actual != "leia" {
+    t.Errorf("Got: %s, wanted: %s\n", actual, "leia")
+  }
+  if actual := certIdentity(cert, "subject"); actual != "CN=leia,O=rebels" {
+    t.Errorf("Got: %s, wanted: %s\n", actual, "CN=leia,O=rebels")
+  }
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+  "fmt"
+  
+  "github.com/salman-ahmad/grpc-streaming/chain"
+  "github.com/salman-ahmad/grpc-streaming/config"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  "github.com/salman-ahmad/grpc-streaming/trace"
+  "golang.org/x/net/context"
+)
+
+// startTracing makes the configured exporter the destination of the
+// server's spans. Trace context is propagated even when nothing is exported
+func startTracing(conf *config.Config) (*trace.Tracer, error) {
+  logging.Debug("startTracing()")
+  traceFile, err := config.AbsolutePath(conf.TraceFile)
+  if err != nil {
+    return nil, fmt.Errorf("failed to calculate trace file's absolute path: %v", err)
+  }
+  exporter, err := trace.NewExporter(conf.TraceExporter, conf.TraceEndpoint, traceFile)
+  if err != nil {
+    return nil, fmt.Errorf("failed to start tracing: %v", err)
+  }
+  if exporter == nil {
+    logging.Info("trace exporter is off")
+  } else {
+    logging.Info("exporting spans", "exporter", conf.TraceExporter)
+  }
+  
+  tracer := trace.NewTracer("maxnumber-server", exporter)
+  trace.SetDefault(tracer)
+  return tracer, nil
+}
+
+// numberContext makes spans started from the context
+// children of the client's span sending the number
+func numberContext(ctx context.Context, request *chain.Request) context.Context {
+  if parent, err := trace.ParseTraceparent(request.Traceparent); err == nil {
+    return trace.ContextWithRemoteParent(ctx, parent)
+  }
+  return ctx
+}
+
+// startNumberSpan starts a span about one number of a stream
+func startNumberSpan(ctx context.Context, request *chain.Request, name string) (context.Context, *trace.Span) {
+  ctx, span := trace.Start(numberContext(ctx, request), name)
+  span.SetAttribute("stream", request.Stream.ID)
+  span.SetAttribute("sequence", request.Sequence)
+  span.SetAttribute("number", request.Number)
+  return ctx, span
+}
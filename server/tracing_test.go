@@ -0,0 +1,79 @@
+package main
+
+import (
+  "bufio"
+  "context"
+  "encoding/json"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "github.com/salman-ahmad/grpc-streaming/trace"
+)
+
+func readSpans(t *testing.T, path string) []trace.SpanData {
+  file, err := os.Open(path)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  defer file.Close()
+  
+  var spans []trace.SpanData
+  scanner := bufio.NewScanner(file)
+  for scanner.Scan() {
+    var span trace.SpanData
+    json.Unmarshal(scanner.Bytes(), &span)
+    spans = append(spans, span)
+  }
+  return spans
+}
+
+func TestFindMaxNumber_Tracing(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "trace")
+  defer os.RemoveAll(dir)
+  traceFile := filepath.Join(dir, "traces.jsonl")
+  
+  port := "7009"
+  serverCmd := startServerWith("GRPC_PORT="+port, "GRPC_TRACE_EXPORTER=file", "GRPC_TRACE_FILE="+traceFile)
+  conn := startClient(port)
+  defer stopClient(conn)
+  
+  // the client's span sending the number, as client.sendNumbers starts it
+  clientTracer := trace.NewTracer("test-client", nil)
+  _, send := clientTracer.Start(context.Background(), "send")
+  stream, err := pb.NewSimpleClient(conn).FindMaxNumber(context.Background())
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  signature, _ := rsaPrivateKey().Sign(crypto.Int64ToBytes(42))
+  stream.Send(&pb.MaxNumberRequest{Number: 42, Signature: signature, Traceparent: send.Context().Traceparent()})
+  stream.CloseSend()
+  for {
+    if _, err := stream.Recv(); err == io.EOF {
+      break
+    } else if err != nil {
+      t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+  }
+  stopServer(serverCmd)
+  
+  names := make(map[string]bool)
+  for _, span := range readSpans(t, traceFile) {
+    if span.TraceID != send.Context().TraceID.String() {
+      continue
+    }
+    if span.ParentSpanID != send.Context().SpanID.String() {
+      t.Errorf("Got: %s, wanted: %s\n", span.ParentSpanID, send.Context().SpanID)
+    }
+    names[span.Name] = true
+  }
+  for _, name := range []string{"verify", "aggregate", "respond"} {
+    if !names[name] {
+      t.Errorf("Got: %v, wanted: span %s in the number's trace\n", names, name)
+    }
+  }
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+  "sync/atomic"
+  
+  "github.com/salman-ahmad/grpc-streaming/auth"
+  "github.com/salman-ahmad/grpc-streaming/chain"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "github.com/salman-ahmad/grpc-streaming/state"
+  "github.com/salman-ahmad/grpc-streaming/trace"
+  "golang.org/x/net/context"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+// SubmitNumber runs the number through the chain as a stream of one,
+// which starts at the session's maximum so the max stage only accepts
+// numbers that raise it, then offers it to the session like a stream would
+func (s server) SubmitNumber(ctx context.Context, request *pb.MaxNumberRequest) (*pb.SubmitNumberResponse, error) {
+  logging.Debug("SubmitNumber()")
+  session, err := auth.SessionFromContext(ctx)
+  if err != nil {
+    return nil, status.Error(codes.InvalidArgument, err.Error())
+  }
+  streamState := chain.NewStream(atomic.AddUint64(&streamIDs, 1), peerAddress(ctx))
+  streamState.Identity = callerIdentity(ctx, s.identity)
+  streamState.Session = session
+  streamState.Max = chain.NoMax
+  if current, ok := s.sessions.Get(session); ok && current.Version > 0 {
+    streamState.Max = current.Max
+  }
+  logger := streamLogger(streamState)
+  
+  traceparent := request.Traceparent
+  if traceparent == "" {
+    traceparent = trace.SpanFromContext(ctx).Context().Traceparent()
+  }
+  chainRequest := &chain.Request{
+    Stream:      streamState,
+    Sequence:    1,
+    Number:      request.Number,
+    Signature:   request.Signature,
+    KeyID:       request.KeyId,
+    Traceparent: traceparent,
+  }
+  err = s.verifier.verifyOne(ctx, chainRequest)
+  if err == nil {
+    aggregateCtx, span := startNumberSpan(ctx, chainRequest, "aggregate")
+    err = s.sequential.Handle(aggregateCtx, chainRequest)
+    span.SetError(err)
+    span.End()
+  }
+  
+  // the number is recorded once offered, with the session's maximum
+  var current state.Session
+  var raised bool
+  if err == nil && chainRequest.Updated {
+    current, raised, err = s.offer(ctx, session, request.Number, streamState.Identity, chainRequest.Sequence)
+  } else {
+    current, _ = s.sessions.Get(session)
+  }
+  if recordErr := s.record(chainRequest, current.Max, err); recordErr != nil {
+    return nil, recordErr
+  }
+  if rejection, rejected := chain.IsRejection(err); rejected {
+    logger.Info("rejected number", numberFields(chainRequest,
+      "stage", rejection.Stage, "code", rejection.Code, "reason", rejection.Reason)...)
+    return nil, status.Error(rejection.Code, rejection.Error())
+  }
+  if err != nil {
+    logger.Error("failed to process number", numberFields(chainRequest, "err", err)...)
+    return nil, err
+  }
+  if raised {
+    logger.Info("raised max of session", numberFields(chainRequest, "max", current.Max)...)
+  } else {
+    logger.Debug("submitted number", numberFields(chainRequest, "max", current.Max)...)
+  }
+  return &pb.SubmitNumberResponse{Max: newSessionMax(current), Raised: raised}, nil
+}
+
+// GetMax returns the maximum of the caller's session, with version 0
+// for a session no number was submitted to yet
+func (s server) GetMax(ctx context.Context, request *pb.GetMaxRequest) (*pb.SessionMax, error) {
+  logging.Debug("GetMax()")
+  session, err := auth.SessionFromContext(ctx)
+  if err != nil {
+    return nil, status.Error(codes.InvalidArgument, err.Error())
+  }
+  current, _ := s.sessions.Get(session)
+  return newSessionMax(current), nil
+}
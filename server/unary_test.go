@@ -0,0 +1,120 @@
+package main
+
+import (
+  "context"
+  "testing"
+  
+  "github.com/salman-ahmad/grpc-streaming/auth"
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+func TestSubmitNumber(t *testing.T) {
+  port := "7016"
+  serverCmd := startServerWith("GRPC_PORT="+port, "GRPC_CHAIN=verify,replay,range,max", "GRPC_RANGE_MAX=100")
+  defer stopServer(serverCmd)
+  conn := startClient(port)
+  defer stopClient(conn)
+  client := pb.NewSimpleClient(conn)
+  ctx := auth.WithSession(context.Background(), "unary")
+  privateKey := rsaPrivateKey()
+  signed := func(number int64) *pb.MaxNumberRequest {
+    signature, _ := privateKey.Sign(crypto.Int64ToBytes(number))
+    return &pb.MaxNumberRequest{Number: number, Signature: signature}
+  }
+  
+  // the session's maximum is shared with the streams
+  stream, err := client.FindMaxNumber(ctx)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  stream.Send(signed(40))
+  if _, err := stream.Recv(); err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  stream.CloseSend()
+  
+  first := signed(70)
+  tests := []struct {
+    name    string
+    ctx     context.Context
+    request *pb.MaxNumberRequest
+    max     int64
+    version uint64
+    raised  bool
+    code    codes.Code
+  }{
+    {"below the max", ctx, signed(30), 40, 1, false, codes.OK},
+    {"raises the max", ctx, first, 70, 2, true, codes.OK},
+    {"replayed", ctx, first, 0, 0, false, codes.AlreadyExists},
+    {"out of range", ctx, signed(150), 0, 0, false, codes.OutOfRange},
+    {"bad signature", ctx, &pb.MaxNumberRequest{Number: 80, Signature: first.Signature}, 0, 0, false, codes.Unknown},
+    {"first of a session", auth.WithSession(context.Background(), "fresh"), signed(-5), -5, 1, true, codes.OK},
+  }
+  for _, test := range tests {
+    response, err := client.SubmitNumber(test.ctx, test.request)
+    if status.Code(err) != test.code {
+      t.Errorf("%s: Got: %v, wanted: %v\n", test.name, err, test.code)
+      continue
+    }
+    if err == nil && (response.Max.Max != test.max || response.Max.Version != test.version || response.Raised != test.raised) {
+      t.Errorf("%s: Got: %v, wanted: max %d version %d raised %v\n", test.name, response, test.max, test.version, test.raised)
+    }
+  }
+  
+  sessionMax, err := client.GetMax(ctx, &pb.GetMaxRequest{})
+  if err != nil || sessionMax.Max != 70 || sessionMax.Version != 2 || sessionMax.UpdatedAt == 0 {
+    t.Errorf("Got: %v %v, wanted: max %d version %d\n", sessionMax, err, 70, 2)
+  }
+  sessionMax, err = client.GetMax(auth.WithSession(context.Background(), "empty"), &pb.GetMaxRequest{})
+  if err != nil || sessionMax.Version != 0 || sessionMax.Session != "empty" {
+    t.Errorf("Got: %v %v, wanted: version %d\n", sessionMax, err, 0)
+  }
+}
+
+func TestSubmitNumber_Jump(t *testing.T) {
+  port := "7028"
+  serverCmd := startServerWith("GRPC_PORT="+port, "GRPC_CHAIN=verify,jump,max", "GRPC_MAX_JUMP=100")
+  defer stopServer(serverCmd)
+  conn := startClient(port)
+  defer stopClient(conn)
+  client := pb.NewSimpleClient(conn)
+  ctx := auth.WithSession(context.Background(), "jump")
+  privateKey := rsaPrivateKey()
+  signed := func(number int64) *pb.MaxNumberRequest {
+    signature, _ := privateKey.Sign(crypto.Int64ToBytes(number))
+    return &pb.MaxNumberRequest{Number: number, Signature: signature}
+  }
+  
+  // the first number of a session has no maximum to jump from
+  tests := []struct {
+    number int64
+    code   codes.Code
+  }{
+    {5000, codes.OK},
+    {5100, codes.OK},
+    {5201, codes.OutOfRange},
+  }
+  for _, test := range tests {
+    if _, err := client.SubmitNumber(ctx, signed(test.number)); status.Code(err) != test.code {
+      t.Errorf("%d: Got: %v, wanted: %v\n", test.number, err, test.code)
+    }
+  }
+  
+  // a stream starts at 0, so its first number can't jump further
+  stream, err := client.FindMaxNumber(auth.WithSession(context.Background(), "jump-stream"))
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  stream.Send(signed(150))
+  stream.Send(signed(90))
+  stream.CloseSend()
+  if response, err := stream.Recv(); err != nil || response.Rejection == nil || response.Rejection.Stage != "jump" {
+    t.Errorf("Got: %v %v, wanted: %s\n", response, err, "a jump rejection")
+  }
+  if response, err := stream.Recv(); err != nil || response.Rejection != nil || response.Number != 90 {
+    t.Errorf("Got: %v %v, wanted: %d\n", response, err, 90)
+  }
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+  "github.com/salman-ahmad/grpc-streaming/auth"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+  pb "github.com/salman-ahmad/grpc-streaming/proto"
+  "github.com/salman-ahmad/grpc-streaming/state"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+)
+
+// WatchMax sends the maximum of the caller's session right away, unless
+// the watcher already saw its version, then every change of it until the
+// watcher goes away. Streams are ended with Unavailable when the server
+// shuts down, so watchers reconnect to another one with their version.
+// Gossiping replicas each count their own versions, so with gossip on a
+// version seen elsewhere means nothing and the maximum is always sent
+func (s server) WatchMax(request *pb.WatchMaxRequest, stream pb.Simple_WatchMaxServer) error {
+  logging.Debug("WatchMax()")
+  ctx := stream.Context()
+  session, err := auth.SessionFromContext(ctx)
+  if err != nil {
+    return status.Error(codes.InvalidArgument, err.Error())
+  }
+  logger := logging.With("peer", peerAddress(ctx), "identity", callerIdentity(ctx, s.identity), "session", session)
+  
+  current, updates, stop := s.sessions.Watch(session)
+  defer stop()
+  logger.Info("watching max", "since_version", request.SinceVersion, "version", current.Version)
+  if request.SinceVersion == 0 || request.SinceVersion != current.Version || s.gossip != nil {
+    if err := sendSessionMax(stream, logger, current); err != nil {
+      return err
+    }
+  }
+  
+  for {
+    select {
+    case <-ctx.Done():
+      logger.Info("stopped watching max")
+      return nil
+    case <-s.closing:
+      logger.Info("closing watch")
+      return status.Error(codes.Unavailable, "server is shutting down")
+    case update := <-updates:
+      if err := sendSessionMax(stream, logger, update); err != nil {
+        return err
+      }
+    }
+  }
+}
+
+func sendSessionMax(stream pb.Simple_WatchMaxServer, logger *logging.Logger, session state.Session) error {
+  logger.Debug("sending max of session", "max", session.Max, "version", session.Version)
+  if err := stream.Send(newSessionMax(session)); err != nil {
+    logger.Warn("failed to send max of session", "err", err)
+    return err
+  }
+  return nil
+}
+
+func newSessionMax(session state.Session) *pb.SessionMax {
+  sessionMax := &pb.SessionMax{
+    Session:   session.Name,
+    Max:       session.Max,
+    Version:   session.Version,
+    UpdatedBy: session.UpdatedBy,
+    Sequence:  session.Sequence,
+  }
+  if session.Version > 0 {
+    sessionMax.UpdatedAt = session.UpdatedAt.UnixNano()
+  }
+  return sessionMax
+}
@@ -0,0 +1,381 @@
+package state
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "sort"
+  "sync"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/atomicfile"
+  "github.com/salman-ahmad/grpc-streaming/crypto"
+  "github.com/salman-ahmad/grpc-streaming/logging"
+)
+
+// DefaultHistorySize is how many changes of its maximum a session keeps
+const DefaultHistorySize = 1000
+
+// Session is the maximum shared by every client of a session
+type Session struct {
+  Name string `json:"name"`
+  Max  int64  `json:"max"`
+  // Version counts the updates of the maximum; 0 means no number yet
+  Version   uint64 `json:"version"`
+  UpdatedBy string `json:"updated_by,omitempty"`
+  // Sequence is the number's sequence on the stream that sent it
+  Sequence  uint64    `json:"sequence,omitempty"`
+  UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Transition is a change of a session's maximum
+type Transition struct {
+  Max       int64     `json:"max"`
+  Version   uint64    `json:"version"`
+  UpdatedBy string    `json:"updated_by,omitempty"`
+  Sequence  uint64    `json:"sequence,omitempty"`
+  UpdatedAt time.Time `json:"updated_at"`
+}
+
+// savedSession is a session with its history, as saved to the file
+type savedSession struct {
+  Session
+  History []Transition `json:"history,omitempty"`
+}
+
+// Store keeps the state of every session in memory and
+// persists it to a JSON file when saved
+type Store struct {
+  mu          sync.Mutex
+  path        string
+  sessions    map[string]*Session
+  history     map[string][]Transition
+  historySize int
+  watchers    map[string]map[chan Session]struct{}
+  // saveDelay is how long after an update the state is saved,
+  // and saveTimer the pending save
+  saveDelay time.Duration
+  saveTimer *time.Timer
+  // saving keeps saves in order, so an older state
+  // never replaces a newer one
+  saving sync.Mutex
+}
+
+// Open reads the state saved at the given path; a missing file
+// is a fresh store. An empty path keeps state in memory only
+func Open(path string) (*Store, error) {
+  store := &Store{
+    path:        path,
+    sessions:    make(map[string]*Session),
+    history:     make(map[string][]Transition),
+    historySize: DefaultHistorySize,
+    watchers:    make(map[string]map[chan Session]struct{}),
+  }
+  if path == "" {
+    return store, nil
+  }
+  content, err := ioutil.ReadFile(path)
+  if os.IsNotExist(err) {
+    return store, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  
+  var sessions []savedSession
+  if err := json.Unmarshal(content, &sessions); err != nil {
+    return nil, fmt.Errorf("failed to parse state %s: %v", path, err)
+  }
+  store.load(sessions)
+  return store, nil
+}
+
+// load adds the saved sessions and their history. It runs with s.mu held
+func (s *Store) load(sessions []savedSession) {
+  for i := range sessions {
+    s.sessions[sessions[i].Name] = &sessions[i].Session
+    if len(sessions[i].History) > 0 {
+      s.history[sessions[i].Name] = sessions[i].History
+    }
+  }
+}
+
+// SetHistorySize bounds the changes kept per session; the
+// oldest are forgotten first. Zero or less keeps none
+func (s *Store) SetHistorySize(size int) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.historySize = size
+  for name := range s.history {
+    s.trimHistory(name)
+  }
+}
+
+// SetSaveDelay saves the state at most the delay after each update, so a
+// crash loses no more than the updates of the last delay. Updates within
+// the delay are saved together. Zero or less only saves when Save is called
+func (s *Store) SetSaveDelay(delay time.Duration) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.saveDelay = delay
+}
+
+// Offer raises the session's maximum to the number if it is larger,
+// or if the session has none yet, and returns the session's state
+// and whether the number raised it. by and sequence tell who sent
+// the number and which of their stream's numbers it was
+func (s *Store) Offer(name string, number int64, by string, sequence uint64) (Session, bool) {
+  return s.OfferAt(name, number, by, sequence, time.Now())
+}
+
+// OfferAt is Offer at the given time, for replicas that
+// must all record the same time for the same change
+func (s *Store) OfferAt(name string, number int64, by string, sequence uint64, at time.Time) (Session, bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  
+  session, ok := s.sessions[name]
+  if ok && session.Version > 0 && !crypto.IsNewInt64Max(session.Max, number) {
+    return *session, false
+  }
+  return s.update(name, number, by, sequence, at), true
+}
+
+// Supersedes tells whether a session's maximum wins over the maximum
+// another replica has for it: a larger number wins, and of two equal
+// numbers the one set first wins, or at the same time the one set by
+// the smaller identity. Merging maxima in any order, any number of
+// times, leaves every replica with the same one
+func Supersedes(session, other Session) bool {
+  if crypto.IsNewInt64Max(other.Max, session.Max) {
+    return true
+  }
+  if crypto.IsNewInt64Max(session.Max, other.Max) {
+    return false
+  }
+  if !session.UpdatedAt.Equal(other.UpdatedAt) {
+    return session.UpdatedAt.Before(other.UpdatedAt)
+  }
+  return session.UpdatedBy < other.UpdatedBy
+}
+
+// Merge takes the maximum another replica has for the session if this
+// one has none yet or the other supersedes it, counting it as an update
+// of the session. The version isn't taken, as every replica counts its own
+func (s *Store) Merge(remote Session) (Session, bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  
+  session, ok := s.sessions[remote.Name]
+  if ok && session.Version > 0 && !Supersedes(remote, *session) {
+    return *session, false
+  }
+  return s.update(remote.Name, remote.Max, remote.UpdatedBy, remote.Sequence, remote.UpdatedAt), true
+}
+
+// update sets the session's maximum, records the change
+// and notifies the watchers. It runs with s.mu held
+func (s *Store) update(name string, number int64, by string, sequence uint64, at time.Time) Session {
+  session, ok := s.sessions[name]
+  if !ok {
+    session = &Session{Name: name}
+    s.sessions[name] = session
+  }
+  session.Max = number
+  session.Version++
+  session.UpdatedBy = by
+  session.Sequence = sequence
+  session.UpdatedAt = at.UTC()
+  s.history[name] = append(s.history[name], Transition{
+    Max:       session.Max,
+    Version:   session.Version,
+    UpdatedBy: session.UpdatedBy,
+    Sequence:  session.Sequence,
+    UpdatedAt: session.UpdatedAt,
+  })
+  s.trimHistory(name)
+  s.notify(*session)
+  s.scheduleSave()
+  return *session
+}
+
+// scheduleSave saves the state after the save delay, unless a save is
+// already pending, which will include the update. It runs with s.mu held
+func (s *Store) scheduleSave() {
+  if s.path == "" || s.saveDelay <= 0 || s.saveTimer != nil {
+    return
+  }
+  s.saveTimer = time.AfterFunc(s.saveDelay, func() {
+    if err := s.Save(); err != nil {
+      logging.Warn("failed to save state", "path", s.path, "err", err)
+    }
+  })
+}
+
+// trimHistory drops the oldest changes beyond the history size. It runs with s.mu held
+func (s *Store) trimHistory(name string) {
+  history := s.history[name]
+  if excess := len(history) - s.historySize; excess > 0 {
+    // copy, so the dropped changes don't stay in the backing array
+    s.history[name] = append([]Transition(nil), history[excess:]...)
+  }
+  if len(s.history[name]) == 0 {
+    delete(s.history, name)
+  }
+}
+
+// History returns up to limit changes of the session's maximum, newest
+// first, starting below the version before, or at the newest when before
+// is 0. more tells whether older changes follow the ones returned
+func (s *Store) History(name string, before uint64, limit int) ([]Transition, bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  
+  history := s.history[name]
+  i := len(history) - 1
+  for i >= 0 && before > 0 && history[i].Version >= before {
+    i--
+  }
+  transitions := make([]Transition, 0, limit)
+  for ; i >= 0 && len(transitions) < limit; i-- {
+    transitions = append(transitions, history[i])
+  }
+  return transitions, i >= 0
+}
+
+// Watch returns the state of the session and a channel of its updates
+// until stop is called. A watcher that falls behind only gets the
+// latest update, as every update supersedes the ones before it
+func (s *Store) Watch(name string) (Session, <-chan Session, func()) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  
+  updates := make(chan Session, 1)
+  if s.watchers[name] == nil {
+    s.watchers[name] = make(map[chan Session]struct{})
+  }
+  s.watchers[name][updates] = struct{}{}
+  stop := func() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    delete(s.watchers[name], updates)
+    if len(s.watchers[name]) == 0 {
+      delete(s.watchers, name)
+    }
+  }
+  
+  session, ok := s.sessions[name]
+  if !ok {
+    return Session{Name: name}, updates, stop
+  }
+  return *session, updates, stop
+}
+
+// notify hands the update to the session's watchers without waiting,
+// replacing an update a watcher hasn't taken yet. It runs with s.mu held
+func (s *Store) notify(session Session) {
+  for updates := range s.watchers[session.Name] {
+    select {
+    case <-updates:
+    default:
+    }
+    updates <- session
+  }
+}
+
+// Get returns the state of the session, if it has any
+func (s *Store) Get(name string) (Session, bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  
+  session, ok := s.sessions[name]
+  if !ok {
+    return Session{Name: name}, false
+  }
+  return *session, true
+}
+
+// Sessions returns the state of every session sorted by name
+func (s *Store) Sessions() []Session {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  
+  sessions := make([]Session, 0, len(s.sessions))
+  for _, session := range s.sessions {
+    sessions = append(sessions, *session)
+  }
+  sort.Slice(sessions, func(i, j int) bool {
+    return sessions[i].Name < sessions[j].Name
+  })
+  return sessions
+}
+
+// saved returns every session with its history, sorted by name.
+// A save that is pending is no longer needed, as it would save the same
+func (s *Store) saved() []savedSession {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  
+  if s.saveTimer != nil {
+    s.saveTimer.Stop()
+    s.saveTimer = nil
+  }
+  return s.list()
+}
+
+// list returns the sessions and their history sorted by name. It runs with s.mu held
+func (s *Store) list() []savedSession {
+  saved := make([]savedSession, 0, len(s.sessions))
+  for name, session := range s.sessions {
+    saved = append(saved, savedSession{Session: *session, History: append([]Transition(nil), s.history[name]...)})
+  }
+  sort.Slice(saved, func(i, j int) bool {
+    return saved[i].Name < saved[j].Name
+  })
+  return saved
+}
+
+// Snapshot returns the sessions and their history, as Restore takes them
+func (s *Store) Snapshot() ([]byte, error) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  return json.Marshal(s.list())
+}
+
+// Restore replaces the sessions and their history with a snapshot, and
+// tells the watchers of the sessions that changed. It's saved like an update
+func (s *Store) Restore(snapshot []byte) error {
+  var sessions []savedSession
+  if err := json.Unmarshal(snapshot, &sessions); err != nil {
+    return fmt.Errorf("failed to parse snapshot: %v", err)
+  }
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  previous := s.sessions
+  s.sessions = make(map[string]*Session, len(sessions))
+  s.history = make(map[string][]Transition, len(sessions))
+  s.load(sessions)
+  for name, session := range s.sessions {
+    s.trimHistory(name)
+    if old, ok := previous[name]; !ok || old.Version != session.Version || old.Max != session.Max {
+      s.notify(*session)
+    }
+  }
+  s.scheduleSave()
+  return nil
+}
+
+// Save writes the sessions and their history to the state file
+func (s *Store) Save() error {
+  if s.path == "" {
+    return nil
+  }
+  s.saving.Lock()
+  defer s.saving.Unlock()
+  content, err := json.MarshalIndent(s.saved(), "", "  ")
+  if err != nil {
+    return err
+  }
+  return atomicfile.Write(s.path, content, 0600)
+}
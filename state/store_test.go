@@ -0,0 +1,221 @@
+package state
+
+import (
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+)
+
+func TestStore_Offer(t *testing.T) {
+  store, _ := Open("")
+  
+  // the first number of a session is its maximum, even when negative
+  if session, raised := store.Offer("rebels", -5, "leia", 1); !raised || session.Max != -5 {
+    t.Errorf("Got: %d %v, wanted: %d %v\n", session.Max, raised, -5, true)
+  }
+  if session, raised := store.Offer("rebels", -9, "luke", 1); raised || session.Max != -5 {
+    t.Errorf("Got: %d %v, wanted: %d %v\n", session.Max, raised, -5, false)
+  }
+  session, raised := store.Offer("rebels", 7, "luke", 1)
+  if !raised || session.Max != 7 || session.Version != 2 || session.UpdatedBy != "luke" {
+    t.Errorf("Got: %+v, wanted: max %d version %d by %s\n", session, 7, 2, "luke")
+  }
+  if _, ok := store.Get("empire"); ok {
+    t.Errorf("Got: %v, wanted: %v\n", ok, false)
+  }
+}
+
+func TestStore_SaveOpen(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "state")
+  defer os.RemoveAll(dir)
+  path := filepath.Join(dir, "state.json")
+  
+  store, err := Open(path)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  store.Offer("rebels", 42, "leia", 1)
+  store.Offer("empire", 3, "vader", 1)
+  if err := store.Save(); err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  
+  reopened, err := Open(path)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  session, _ := reopened.Get("rebels")
+  if session.Max != 42 || session.Version != 1 || session.UpdatedBy != "leia" {
+    t.Errorf("Got: %+v, wanted: max %d version %d by %s\n", session, 42, 1, "leia")
+  }
+  if len(reopened.Sessions()) != 2 {
+    t.Errorf("Got: %d, wanted: %d\n", len(reopened.Sessions()), 2)
+  }
+}
+
+func TestStore_SaveDelay(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "state")
+  defer os.RemoveAll(dir)
+  path := filepath.Join(dir, "state.json")
+  
+  // updates are saved without calling Save, as a crashed server wouldn't
+  store, _ := Open(path)
+  store.SetSaveDelay(10 * time.Millisecond)
+  store.Offer("rebels", 42, "leia", 1)
+  store.Offer("rebels", 50, "luke", 2)
+  time.Sleep(100 * time.Millisecond)
+  store.Offer("empire", 3, "vader", 1)
+  time.Sleep(100 * time.Millisecond)
+  
+  reopened, err := Open(path)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  if session, _ := reopened.Get("rebels"); session.Max != 50 || session.Version != 2 {
+    t.Errorf("Got: %+v, wanted: max %d version %d\n", session, 50, 2)
+  }
+  if session, _ := reopened.Get("empire"); session.Max != 3 {
+    t.Errorf("Got: %+v, wanted: max %d\n", session, 3)
+  }
+}
+
+func TestStore_Merge(t *testing.T) {
+  at := time.Unix(1760871600, 0).UTC()
+  leia := Session{Name: "rebels", Max: 7, UpdatedBy: "leia", Sequence: 2, UpdatedAt: at}
+  luke := Session{Name: "rebels", Max: 7, UpdatedBy: "luke", Sequence: 1, UpdatedAt: at}
+  han := Session{Name: "rebels", Max: 7, UpdatedBy: "han", Sequence: 1, UpdatedAt: at.Add(time.Second)}
+  vader := Session{Name: "rebels", Max: 3, UpdatedBy: "vader", Sequence: 1, UpdatedAt: at.Add(-time.Second)}
+  
+  // replicas merging the same maxima in any order end up with the same one
+  for _, order := range [][]Session{{leia, luke, han, vader}, {vader, han, luke, leia}, {han, leia, vader, luke, leia}} {
+    store, _ := Open("")
+    for _, remote := range order {
+      store.Merge(remote)
+    }
+    session, _ := store.Get("rebels")
+    if session.Max != 7 || session.UpdatedBy != "leia" || session.Sequence != 2 || !session.UpdatedAt.Equal(at) {
+      t.Errorf("Got: %+v, wanted: max %d by %s\n", session, 7, "leia")
+    }
+  }
+  
+  // merging counts as an update of the replica's own version
+  store, _ := Open("")
+  store.Offer("rebels", 5, "chewie", 1)
+  if session, merged := store.Merge(leia); !merged || session.Version != 2 {
+    t.Errorf("Got: %+v %v, wanted: version %d %v\n", session, merged, 2, true)
+  }
+  if session, merged := store.Merge(han); merged || session.UpdatedBy != "leia" {
+    t.Errorf("Got: %+v %v, wanted: by %s %v\n", session, merged, "leia", false)
+  }
+}
+
+func TestStore_Watch(t *testing.T) {
+  store, _ := Open("")
+  store.Offer("rebels", 3, "leia", 1)
+  
+  current, updates, stop := store.Watch("rebels")
+  defer stop()
+  if current.Max != 3 || current.Version != 1 {
+    t.Errorf("Got: %+v, wanted: max %d version %d\n", current, 3, 1)
+  }
+  
+  // a watcher that falls behind gets the latest update only
+  store.Offer("rebels", 5, "luke", 1)
+  store.Offer("rebels", 4, "han", 1)
+  store.Offer("rebels", 8, "han", 1)
+  store.Offer("empire", 10, "vader", 1)
+  if update := <-updates; update.Max != 8 || update.Version != 3 || update.UpdatedBy != "han" {
+    t.Errorf("Got: %+v, wanted: max %d version %d by %s\n", update, 8, 3, "han")
+  }
+  select {
+  case update := <-updates:
+    t.Errorf("Got: %+v, wanted: %s\n", update, "no update")
+  default:
+  }
+  
+  stop()
+  store.Offer("rebels", 9, "luke", 1)
+  if len(store.watchers) != 0 || len(updates) != 0 {
+    t.Errorf("Got: %d watched sessions, wanted: %d\n", len(store.watchers), 0)
+  }
+}
+
+func TestStore_History(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "state")
+  defer os.RemoveAll(dir)
+  path := filepath.Join(dir, "state.json")
+  
+  store, _ := Open(path)
+  store.SetHistorySize(4)
+  for i, number := range []int64{1, 5, 3, 7, 9, 12} {
+    store.Offer("rebels", number, "leia", uint64(i+1))
+  }
+  if err := store.Save(); err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  
+  // the history survives a restart, keeping the newest changes
+  reopened, _ := Open(path)
+  tests := []struct {
+    before   uint64
+    limit    int
+    versions []uint64
+    more     bool
+  }{
+    {0, 2, []uint64{5, 4}, true},
+    {4, 2, []uint64{3, 2}, false},
+    {2, 2, []uint64{}, false},
+    {0, 10, []uint64{5, 4, 3, 2}, false},
+  }
+  for _, test := range tests {
+    transitions, more := reopened.History("rebels", test.before, test.limit)
+    versions := []uint64{}
+    for _, transition := range transitions {
+      versions = append(versions, transition.Version)
+    }
+    if fmt.Sprint(versions) != fmt.Sprint(test.versions) || more != test.more {
+      t.Errorf("before %d: Got: %v %v, wanted: %v %v\n", test.before, versions, more, test.versions, test.more)
+    }
+  }
+  newest, _ := reopened.History("rebels", 0, 1)
+  if newest[0].Max != 12 || newest[0].Sequence != 6 || newest[0].UpdatedBy != "leia" {
+    t.Errorf("Got: %+v, wanted: max %d sequence %d\n", newest[0], 12, 6)
+  }
+}
+
+func TestStore_SnapshotRestore(t *testing.T) {
+  store, _ := Open("")
+  store.Offer("rebels", 3, "leia", 1)
+  store.Offer("rebels", 8, "luke", 2)
+  store.Offer("empire", 10, "vader", 1)
+  snapshot, err := store.Snapshot()
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  
+  // the restored store replaces its sessions, and watchers
+  // hear of the sessions that changed
+  restored, _ := Open("")
+  restored.Offer("rebels", 5, "han", 1)
+  restored.Offer("jedi", 1, "yoda", 1)
+  _, updates, stop := restored.Watch("rebels")
+  defer stop()
+  if err := restored.Restore(snapshot); err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  if update := <-updates; update.Max != 8 || update.Version != 2 {
+    t.Errorf("Got: %+v, wanted: max %d version %d\n", update, 8, 2)
+  }
+  if _, ok := restored.Get("jedi"); ok {
+    t.Errorf("Got: %v, wanted: %v\n", ok, false)
+  }
+  if history, _ := restored.History("rebels", 0, 10); len(history) != 2 || history[0].Max != 8 {
+    t.Errorf("Got: %+v, wanted: %d changes ending with %d\n", history, 2, 8)
+  }
+  if err := restored.Restore([]byte("not json")); err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "error")
+  }
+}
@@ -0,0 +1,248 @@
+package trace
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "os"
+  "strconv"
+  "sync"
+  "time"
+  
+  "github.com/salman-ahmad/grpc-streaming/logging"
+)
+
+// NewExporter builds the exporter of the given kind: "otlp" sends spans
+// to the endpoint, "file" appends them to the file, and "" exports nothing
+func NewExporter(kind, endpoint, file string) (Exporter, error) {
+  switch kind {
+  case "":
+    return nil, nil
+  case "otlp":
+    return NewOTLPExporter(endpoint), nil
+  case "file":
+    return NewFileExporter(file)
+  }
+  return nil, fmt.Errorf("unknown trace exporter %q, expected otlp or file", kind)
+}
+
+// FileExporter appends spans to a file as JSON lines
+type FileExporter struct {
+  mu      sync.Mutex
+  file    *os.File
+  encoder *json.Encoder
+}
+
+func NewFileExporter(path string) (*FileExporter, error) {
+  file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+  if err != nil {
+    return nil, err
+  }
+  return &FileExporter{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (f *FileExporter) Export(span SpanData) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  f.encoder.Encode(span)
+}
+
+func (f *FileExporter) Shutdown() error {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  return f.file.Close()
+}
+
+const (
+  otlpBatchSize     = 512
+  otlpQueueSize     = 4096
+  otlpFlushInterval = 2 * time.Second
+)
+
+// OTLPExporter sends spans in batches to an OpenTelemetry collector
+// over OTLP/HTTP with the JSON encoding. Spans are dropped rather
+// than slowing the traced code down when the collector falls behind,
+// and once the exporter is shut down
+type OTLPExporter struct {
+  endpoint string
+  client   *http.Client
+  queue    chan SpanData
+  // stop is closed on shutdown, and done once the queue is sent
+  stop chan struct{}
+  done chan struct{}
+  once sync.Once
+}
+
+// NewOTLPExporter exports to the traces endpoint of a collector,
+// e.g. http://localhost:4318/v1/traces
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+  o := &OTLPExporter{
+    endpoint: endpoint,
+    client:   &http.Client{Timeout: 10 * time.Second},
+    queue:    make(chan SpanData, otlpQueueSize),
+    stop:     make(chan struct{}),
+    done:     make(chan struct{}),
+  }
+  go o.run()
+  return o
+}
+
+// Export queues the span. The queue is never closed, as spans
+// may still end after the exporter was shut down
+func (o *OTLPExporter) Export(span SpanData) {
+  select {
+  case <-o.stop:
+    return
+  default:
+  }
+  select {
+  case o.queue <- span:
+  default:
+  }
+}
+
+func (o *OTLPExporter) run() {
+  defer close(o.done)
+  ticker := time.NewTicker(otlpFlushInterval)
+  defer ticker.Stop()
+  
+  var batch []SpanData
+  failing := false
+  flush := func() {
+    if len(batch) == 0 {
+      return
+    }
+    // a collector that is down fails every batch, so
+    // only the first failure is a warning
+    err := o.send(batch)
+    switch {
+    case err != nil && !failing:
+      logging.Warn("failed to send spans", "endpoint", o.endpoint, "spans", len(batch), "err", err)
+    case err != nil:
+      logging.Debug("failed to send spans", "endpoint", o.endpoint, "spans", len(batch), "err", err)
+    case failing:
+      logging.Info("sending spans again", "endpoint", o.endpoint)
+    }
+    failing = err != nil
+    batch = nil
+  }
+  for {
+    select {
+    case span := <-o.queue:
+      if batch = append(batch, span); len(batch) >= otlpBatchSize {
+        flush()
+      }
+    case <-ticker.C:
+      flush()
+    case <-o.stop:
+      // send what was queued before the shutdown
+      for {
+        select {
+        case span := <-o.queue:
+          if batch = append(batch, span); len(batch) >= otlpBatchSize {
+            flush()
+          }
+        default:
+          flush()
+          return
+        }
+      }
+    }
+  }
+}
+
+func (o *OTLPExporter) send(batch []SpanData) error {
+  content, err := json.Marshal(otlpRequest(batch))
+  if err != nil {
+    return err
+  }
+  resp, err := o.client.Post(o.endpoint, "application/json", bytes.NewReader(content))
+  if err != nil {
+    return err
+  }
+  resp.Body.Close()
+  if resp.StatusCode/100 != 2 {
+    return fmt.Errorf("collector answered %s", resp.Status)
+  }
+  return nil
+}
+
+// Shutdown sends the spans still queued
+func (o *OTLPExporter) Shutdown() error {
+  o.once.Do(func() { close(o.stop) })
+  select {
+  case <-o.done:
+    return nil
+  case <-time.After(o.client.Timeout):
+    return fmt.Errorf("timed out sending spans to %s", o.endpoint)
+  }
+}
+
+type otlpKeyValue struct {
+  Key   string                 `json:"key"`
+  Value map[string]interface{} `json:"value"`
+}
+
+func otlpAttributes(attributes map[string]interface{}) []otlpKeyValue {
+  values := make([]otlpKeyValue, 0, len(attributes))
+  for key, value := range attributes {
+    var typed map[string]interface{}
+    switch v := value.(type) {
+    case bool:
+      typed = map[string]interface{}{"boolValue": v}
+    case int:
+      typed = map[string]interface{}{"intValue": strconv.Itoa(v)}
+    case int64:
+      typed = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+    case uint64:
+      typed = map[string]interface{}{"intValue": strconv.FormatUint(v, 10)}
+    default:
+      typed = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+    }
+    values = append(values, otlpKeyValue{Key: key, Value: typed})
+  }
+  return values
+}
+
+// otlpRequest builds an ExportTraceServiceRequest in its JSON encoding,
+// with one resource per service
+func otlpRequest(batch []SpanData) map[string]interface{} {
+  byService := make(map[string][]interface{})
+  var services []string
+  for _, span := range batch {
+    status := map[string]interface{}{"code": 1}
+    if span.Error != "" {
+      status = map[string]interface{}{"code": 2, "message": span.Error}
+    }
+    otlpSpan := map[string]interface{}{
+      "traceId":           span.TraceID,
+      "spanId":            span.SpanID,
+      "parentSpanId":      span.ParentSpanID,
+      "name":              span.Name,
+      "kind":              1,
+      "startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+      "endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+      "attributes":        otlpAttributes(span.Attributes),
+      "status":            status,
+    }
+    if _, ok := byService[span.Service]; !ok {
+      services = append(services, span.Service)
+    }
+    byService[span.Service] = append(byService[span.Service], otlpSpan)
+  }
+  
+  resourceSpans := make([]interface{}, 0, len(services))
+  for _, service := range services {
+    resourceSpans = append(resourceSpans, map[string]interface{}{
+      "resource": map[string]interface{}{
+        "attributes": otlpAttributes(map[string]interface{}{"service.name": service}),
+      },
+      "scopeSpans": []interface{}{map[string]interface{}{
+        "scope": map[string]interface{}{"name": "github.com/salman-ahmad/grpc-streaming/trace"},
+        "spans": byService[service],
+      }},
+    })
+  }
+  return map[string]interface{}{"resourceSpans": resourceSpans}
+}
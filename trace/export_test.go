@@ -0,0 +1,88 @@
+package trace
+
+import (
+  "bufio"
+  "context"
+  "encoding/json"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func TestFileExporter(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "trace")
+  defer os.RemoveAll(dir)
+  path := filepath.Join(dir, "traces.jsonl")
+  
+  exporter, err := NewExporter("file", "", path)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  tracer := NewTracer("test", exporter)
+  for _, name := range []string{"sign", "send"} {
+    _, span := tracer.Start(context.Background(), name)
+    span.End()
+  }
+  tracer.Shutdown()
+  
+  file, _ := os.Open(path)
+  defer file.Close()
+  var names []string
+  scanner := bufio.NewScanner(file)
+  for scanner.Scan() {
+    var span SpanData
+    if err := json.Unmarshal(scanner.Bytes(), &span); err != nil {
+      t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+    names = append(names, span.Name)
+  }
+  if len(names) != 2 || names[0] != "sign" || names[1] != "send" {
+    t.Errorf("Got: %v, wanted: %v\n", names, []string{"sign", "send"})
+  }
+}
+
+func TestOTLPExporter(t *testing.T) {
+  received := make(chan map[string]interface{}, 1)
+  collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    var request map[string]interface{}
+    json.NewDecoder(r.Body).Decode(&request)
+    received <- request
+  }))
+  defer collector.Close()
+  
+  tracer := NewTracer("test", NewOTLPExporter(collector.URL+"/v1/traces"))
+  _, span := tracer.Start(context.Background(), "verify")
+  span.SetAttribute("number", int64(7))
+  span.End()
+  if err := tracer.Shutdown(); err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  
+  request := <-received
+  resource := request["resourceSpans"].([]interface{})[0].(map[string]interface{})
+  scope := resource["scopeSpans"].([]interface{})[0].(map[string]interface{})
+  otlpSpan := scope["spans"].([]interface{})[0].(map[string]interface{})
+  if otlpSpan["name"] != "verify" || otlpSpan["traceId"] != span.Context().TraceID.String() {
+    t.Errorf("Got: %v, wanted: span %s of trace %s\n", otlpSpan, "verify", span.Context().TraceID)
+  }
+  attribute := otlpSpan["attributes"].([]interface{})[0].(map[string]interface{})
+  if attribute["value"].(map[string]interface{})["intValue"] != "7" {
+    t.Errorf("Got: %v, wanted: %s\n", attribute, "7")
+  }
+  
+  // spans that end after the shutdown are dropped
+  _, late := tracer.Start(context.Background(), "late")
+  late.End()
+  if err := tracer.Shutdown(); err != nil {
+    t.Errorf("Got: %v, wanted: %v\n", err, nil)
+  }
+}
+
+func TestNewExporter_Unknown(t *testing.T) {
+  if _, err := NewExporter("zipkin", "", ""); err == nil {
+    t.Errorf("Got: %v, wanted: %s\n", nil, "unknown trace exporter")
+  }
+}
@@ -0,0 +1,137 @@
+package trace
+
+import (
+  "io"
+  
+  "golang.org/x/net/context"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/metadata"
+  "google.golang.org/grpc/status"
+)
+
+// TraceparentKey is the metadata key of the W3C trace context
+const TraceparentKey = "traceparent"
+
+// Inject adds the current span's trace context to the outgoing metadata
+func Inject(ctx context.Context) context.Context {
+  if sc := parentOf(ctx); sc.IsValid() {
+    return metadata.AppendToOutgoingContext(ctx, TraceparentKey, sc.Traceparent())
+  }
+  return ctx
+}
+
+// Extract makes the trace context in the incoming metadata, if any,
+// the parent of spans started from the returned context
+func Extract(ctx context.Context) context.Context {
+  md, _ := metadata.FromIncomingContext(ctx)
+  values := md.Get(TraceparentKey)
+  if len(values) == 0 {
+    return ctx
+  }
+  if parent, err := ParseTraceparent(values[0]); err == nil {
+    return ContextWithRemoteParent(ctx, parent)
+  }
+  return ctx
+}
+
+func endRPC(span *Span, err error) {
+  span.SetAttribute("rpc.grpc.status_code", status.Code(err).String())
+  span.SetError(err)
+  span.End()
+}
+
+// UnaryServerInterceptor traces every unary RPC as a child of the caller's span
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+  return func(
+    ctx context.Context,
+    req interface{},
+    info *grpc.UnaryServerInfo,
+    handler grpc.UnaryHandler) (interface{}, error) {
+    
+    ctx, span := Start(Extract(ctx), info.FullMethod)
+    resp, err := handler(ctx, req)
+    endRPC(span, err)
+    return resp, err
+  }
+}
+
+// tracedServerStream carries the RPC's span in its context
+type tracedServerStream struct {
+  grpc.ServerStream
+  ctx context.Context
+}
+
+func (s tracedServerStream) Context() context.Context {
+  return s.ctx
+}
+
+// StreamServerInterceptor traces every streaming RPC as a child of the caller's span
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+  return func(
+    srv interface{},
+    stream grpc.ServerStream,
+    info *grpc.StreamServerInfo,
+    handler grpc.StreamHandler) error {
+    
+    ctx, span := Start(Extract(stream.Context()), info.FullMethod)
+    err := handler(srv, tracedServerStream{ServerStream: stream, ctx: ctx})
+    endRPC(span, err)
+    return err
+  }
+}
+
+// UnaryClientInterceptor traces every unary call and sends its trace context
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+  return func(
+    ctx context.Context,
+    method string,
+    req, reply interface{},
+    cc *grpc.ClientConn,
+    invoker grpc.UnaryInvoker,
+    opts ...grpc.CallOption) error {
+    
+    ctx, span := Start(ctx, method)
+    err := invoker(Inject(ctx), method, req, reply, cc, opts...)
+    endRPC(span, err)
+    return err
+  }
+}
+
+// tracedClientStream ends the stream's span when the stream is done,
+// which the caller learns from an error on receive
+type tracedClientStream struct {
+  grpc.ClientStream
+  span *Span
+}
+
+func (s tracedClientStream) RecvMsg(m interface{}) error {
+  err := s.ClientStream.RecvMsg(m)
+  if err != nil {
+    if err == io.EOF {
+      endRPC(s.span, nil)
+    } else {
+      endRPC(s.span, err)
+    }
+  }
+  return err
+}
+
+// StreamClientInterceptor traces every stream and sends its trace context
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+  return func(
+    ctx context.Context,
+    desc *grpc.StreamDesc,
+    cc *grpc.ClientConn,
+    method string,
+    streamer grpc.Streamer,
+    opts ...grpc.CallOption) (grpc.ClientStream, error) {
+    
+    ctx, span := Start(ctx, method)
+    stream, err := streamer(Inject(ctx), desc, cc, method, opts...)
+    if err != nil {
+      endRPC(span, err)
+      return nil, err
+    }
+    return tracedClientStream{ClientStream: stream, span: span}, nil
+  }
+}
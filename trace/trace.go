@@ -0,0 +1,237 @@
+package trace
+
+import (
+  "crypto/rand"
+  "encoding/hex"
+  "fmt"
+  "strings"
+  "sync"
+  "time"
+  
+  "golang.org/x/net/context"
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// SpanContext identifies a span across processes
+type SpanContext struct {
+  TraceID TraceID
+  SpanID  SpanID
+  Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+  return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats the span context as a W3C traceparent header
+func (sc SpanContext) Traceparent() string {
+  flags := "00"
+  if sc.Sampled {
+    flags = "01"
+  }
+  return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent reads a W3C traceparent header
+func ParseTraceparent(header string) (SpanContext, error) {
+  var sc SpanContext
+  parts := strings.Split(header, "-")
+  if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+    return sc, fmt.Errorf("traceparent %q is not valid", header)
+  }
+  traceID, err := hex.DecodeString(parts[1])
+  if err != nil || len(traceID) != len(sc.TraceID) {
+    return sc, fmt.Errorf("trace id of traceparent %q is not valid", header)
+  }
+  spanID, err := hex.DecodeString(parts[2])
+  if err != nil || len(spanID) != len(sc.SpanID) {
+    return sc, fmt.Errorf("span id of traceparent %q is not valid", header)
+  }
+  flags, err := hex.DecodeString(parts[3])
+  if err != nil || len(flags) != 1 {
+    return sc, fmt.Errorf("flags of traceparent %q are not valid", header)
+  }
+  
+  copy(sc.TraceID[:], traceID)
+  copy(sc.SpanID[:], spanID)
+  sc.Sampled = flags[0]&1 == 1
+  if !sc.IsValid() {
+    return sc, fmt.Errorf("traceparent %q has a zero id", header)
+  }
+  return sc, nil
+}
+
+// SpanData is a finished span as exporters see it
+type SpanData struct {
+  Service      string                 `json:"service"`
+  Name         string                 `json:"name"`
+  TraceID      string                 `json:"trace_id"`
+  SpanID       string                 `json:"span_id"`
+  ParentSpanID string                 `json:"parent_span_id,omitempty"`
+  Start        time.Time              `json:"start"`
+  End          time.Time              `json:"end"`
+  Attributes   map[string]interface{} `json:"attributes,omitempty"`
+  Error        string                 `json:"error,omitempty"`
+}
+
+// Span is an operation being traced. A nil span does nothing, so
+// code can trace without checking whether tracing is on
+type Span struct {
+  tracer  *Tracer
+  context SpanContext
+  mu      sync.Mutex
+  data    SpanData
+  ended   bool
+}
+
+func (s *Span) Context() SpanContext {
+  if s == nil {
+    return SpanContext{}
+  }
+  return s.context
+}
+
+// SetAttribute records a string, integer or boolean value on the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+  if s == nil {
+    return
+  }
+  s.mu.Lock()
+  if s.data.Attributes == nil {
+    s.data.Attributes = make(map[string]interface{})
+  }
+  s.data.Attributes[key] = value
+  s.mu.Unlock()
+}
+
+// SetError marks the span as failed when err isn't nil
+func (s *Span) SetError(err error) {
+  if s == nil || err == nil {
+    return
+  }
+  s.mu.Lock()
+  s.data.Error = err.Error()
+  s.mu.Unlock()
+}
+
+// End finishes the span and exports it if it is sampled
+func (s *Span) End() {
+  if s == nil {
+    return
+  }
+  s.mu.Lock()
+  if s.ended {
+    s.mu.Unlock()
+    return
+  }
+  s.ended = true
+  s.data.End = time.Now()
+  data := s.data
+  s.mu.Unlock()
+  
+  if s.context.Sampled && s.tracer.exporter != nil {
+    s.tracer.exporter.Export(data)
+  }
+}
+
+// Exporter sends finished spans somewhere they can be analyzed
+type Exporter interface {
+  Export(span SpanData)
+  // Shutdown exports buffered spans and releases the exporter
+  Shutdown() error
+}
+
+// Tracer starts spans of a service and hands them to its exporter.
+// A tracer without an exporter still propagates trace context
+type Tracer struct {
+  service  string
+  exporter Exporter
+}
+
+func NewTracer(service string, exporter Exporter) *Tracer {
+  return &Tracer{service: service, exporter: exporter}
+}
+
+func (t *Tracer) Shutdown() error {
+  if t.exporter == nil {
+    return nil
+  }
+  return t.exporter.Shutdown()
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// ContextWithRemoteParent makes spans started from the context
+// children of a span in another process, in place of the current span
+func ContextWithRemoteParent(ctx context.Context, parent SpanContext) context.Context {
+  return context.WithValue(context.WithValue(ctx, spanKey{}, (*Span)(nil)), remoteKey{}, parent)
+}
+
+// SpanFromContext returns the current span, or nil
+func SpanFromContext(ctx context.Context) *Span {
+  span, _ := ctx.Value(spanKey{}).(*Span)
+  return span
+}
+
+// parentOf returns the context of the current span, or else of the
+// remote parent, which is invalid for new traces
+func parentOf(ctx context.Context) SpanContext {
+  if span := SpanFromContext(ctx); span != nil {
+    return span.context
+  }
+  parent, _ := ctx.Value(remoteKey{}).(SpanContext)
+  return parent
+}
+
+func randomID(id []byte) {
+  if _, err := rand.Read(id); err != nil {
+    panic(fmt.Sprintf("trace: failed to read random bytes: %v", err))
+  }
+}
+
+// Start begins a span, a child of the current span of the context if
+// there is one, and returns a context carrying it
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+  parent := parentOf(ctx)
+  span := &Span{tracer: t, data: SpanData{Service: t.service, Name: name, Start: time.Now()}}
+  span.context.Sampled = t.exporter != nil
+  if parent.IsValid() {
+    span.context.TraceID = parent.TraceID
+    span.data.ParentSpanID = parent.SpanID.String()
+  } else {
+    randomID(span.context.TraceID[:])
+  }
+  randomID(span.context.SpanID[:])
+  span.data.TraceID = span.context.TraceID.String()
+  span.data.SpanID = span.context.SpanID.String()
+  return context.WithValue(ctx, spanKey{}, span), span
+}
+
+var (
+  defaultMu     sync.RWMutex
+  defaultTracer = NewTracer("", nil)
+)
+
+// SetDefault sets the tracer Start uses
+func SetDefault(t *Tracer) {
+  defaultMu.Lock()
+  defaultTracer = t
+  defaultMu.Unlock()
+}
+
+func Default() *Tracer {
+  defaultMu.RLock()
+  defer defaultMu.RUnlock()
+  return defaultTracer
+}
+
+// Start begins a span with the default tracer
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+  return Default().Start(ctx, name)
+}
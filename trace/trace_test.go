@@ -0,0 +1,90 @@
+package trace
+
+import (
+  "context"
+  "sync"
+  "testing"
+)
+
+// recorder keeps exported spans in memory
+type recorder struct {
+  mu    sync.Mutex
+  spans []SpanData
+}
+
+func (r *recorder) Export(span SpanData) {
+  r.mu.Lock()
+  r.spans = append(r.spans, span)
+  r.mu.Unlock()
+}
+
+func (r *recorder) Shutdown() error { return nil }
+
+func TestTraceparent_RoundTrip(t *testing.T) {
+  header := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+  sc, err := ParseTraceparent(header)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  if !sc.Sampled || sc.Traceparent() != header {
+    t.Errorf("Got: %s, wanted: %s\n", sc.Traceparent(), header)
+  }
+  
+  for _, bad := range []string{
+    "",
+    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+    "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+    "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba9-01",
+    "00-xyz92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+  } {
+    if _, err := ParseTraceparent(bad); err == nil {
+      t.Errorf("Got: %v, wanted: an error for %q\n", nil, bad)
+    }
+  }
+}
+
+func TestTracer_Parents(t *testing.T) {
+  exported := &recorder{}
+  tracer := NewTracer("test", exported)
+  
+  ctx, root := tracer.Start(context.Background(), "root")
+  _, child := tracer.Start(ctx, "child")
+  child.SetAttribute("number", int64(7))
+  child.End()
+  root.End()
+  root.End()
+  
+  if len(exported.spans) != 2 {
+    t.Fatalf("Got: %d, wanted: %d\n", len(exported.spans), 2)
+  }
+  childData, rootData := exported.spans[0], exported.spans[1]
+  if childData.TraceID != rootData.TraceID || childData.ParentSpanID != rootData.SpanID {
+    t.Errorf("Got: %+v, wanted: a child of %+v\n", childData, rootData)
+  }
+  if rootData.ParentSpanID != "" || childData.Attributes["number"] != int64(7) {
+    t.Errorf("Got: %+v %+v, wanted: a root and a child with number 7\n", rootData, childData)
+  }
+  
+  // a remote parent takes the place of the current span
+  remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+  _, span := tracer.Start(ContextWithRemoteParent(ctx, remote), "remote child")
+  span.End()
+  last := exported.spans[2]
+  if last.TraceID != remote.TraceID.String() || last.ParentSpanID != remote.SpanID.String() {
+    t.Errorf("Got: %+v, wanted: a child of %s\n", last, remote.Traceparent())
+  }
+}
+
+func TestTracer_NoExporter(t *testing.T) {
+  tracer := NewTracer("test", nil)
+  _, span := tracer.Start(context.Background(), "propagated")
+  if !span.Context().IsValid() || span.Context().Sampled {
+    t.Errorf("Got: %+v, wanted: a valid span context that isn't sampled\n", span.Context())
+  }
+  span.End()
+  
+  var none *Span
+  none.SetAttribute("ignored", true)
+  none.End()
+}
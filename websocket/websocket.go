@@ -0,0 +1,445 @@
+package websocket
+
+import (
+  "bufio"
+  "crypto/rand"
+  "crypto/sha1"
+  "encoding/base64"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "io"
+  "net"
+  "net/http"
+  "net/url"
+  "strings"
+  "sync"
+  "time"
+  "unicode/utf8"
+)
+
+// Message types, the opcodes of RFC 6455 frames
+const (
+  continuationFrame = 0
+  TextMessage       = 1
+  BinaryMessage     = 2
+  CloseMessage      = 8
+  PingMessage       = 9
+  PongMessage       = 10
+)
+
+// Close codes of RFC 6455
+const (
+  CloseNormal          = 1000
+  CloseGoingAway       = 1001
+  CloseProtocolError   = 1002
+  CloseNoStatus        = 1005
+  CloseInvalidPayload  = 1007
+  ClosePolicyViolation = 1008
+  CloseMessageTooBig   = 1009
+  CloseInternalError   = 1011
+)
+
+// maxControlPayload is the most a ping, pong or close frame carries
+const maxControlPayload = 125
+
+// acceptGUID is appended to the client's key to prove the
+// server understood the handshake
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+  ErrReadLimit    = errors.New("websocket: message exceeds read limit")
+  ErrClosed       = errors.New("websocket: close frame already sent")
+  ErrBadHandshake = errors.New("websocket: bad handshake")
+)
+
+// CloseError is returned by ReadMessage when the peer closes the connection
+type CloseError struct {
+  Code int
+  Text string
+}
+
+func (e *CloseError) Error() string {
+  return fmt.Sprintf("websocket: closed with %d %s", e.Code, e.Text)
+}
+
+func acceptKey(key string) string {
+  sum := sha1.Sum([]byte(key + acceptGUID))
+  return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Conn is a WebSocket connection. Messages are read by one goroutine
+// at a time, while any goroutine may write
+type Conn struct {
+  conn   net.Conn
+  reader *bufio.Reader
+  // client connections mask the frames they send, servers require it
+  client      bool
+  subprotocol string
+  readLimit   int64
+  onPong      func() error
+  
+  writeMu   sync.Mutex
+  closeSent bool
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, client bool, subprotocol string) *Conn {
+  return &Conn{conn: conn, reader: reader, client: client, subprotocol: subprotocol, readLimit: 1 << 20}
+}
+
+// headerContains tells whether a comma separated header has the token
+func headerContains(header http.Header, name, token string) bool {
+  for _, value := range header[http.CanonicalHeaderKey(name)] {
+    for _, field := range strings.Split(value, ",") {
+      if strings.EqualFold(strings.TrimSpace(field), token) {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+// allowedOrigin tells whether a browser on the origin may open a
+// WebSocket: one from the request's own host, or one of the origins,
+// where "*" allows any. Clients other than browsers send no origin
+func allowedOrigin(r *http.Request, origins []string) bool {
+  origin := r.Header.Get("Origin")
+  if origin == "" {
+    return true
+  }
+  for _, allowed := range origins {
+    if allowed == "*" || strings.EqualFold(allowed, origin) {
+      return true
+    }
+  }
+  u, err := url.Parse(origin)
+  return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// Upgrade completes the handshake of a WebSocket request, choosing
+// the first of the client's subprotocols the server supports. It
+// answers requests that aren't a valid handshake with an HTTP error,
+// and those of browsers on other origins than the allowed ones with 403
+func Upgrade(w http.ResponseWriter, r *http.Request, subprotocols, origins []string) (*Conn, error) {
+  fail := func(status int, reason string) (*Conn, error) {
+    http.Error(w, reason, status)
+    return nil, fmt.Errorf("websocket: %s", reason)
+  }
+  if r.Method != http.MethodGet {
+    return fail(http.StatusMethodNotAllowed, "handshake needs GET")
+  }
+  if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+    return fail(http.StatusBadRequest, "not a websocket handshake")
+  }
+  if r.Header.Get("Sec-WebSocket-Version") != "13" {
+    w.Header().Set("Sec-WebSocket-Version", "13")
+    return fail(http.StatusUpgradeRequired, "unsupported websocket version")
+  }
+  key := r.Header.Get("Sec-WebSocket-Key")
+  if key == "" {
+    return fail(http.StatusBadRequest, "handshake has no key")
+  }
+  if !allowedOrigin(r, origins) {
+    return fail(http.StatusForbidden, "origin not allowed")
+  }
+  hijacker, ok := w.(http.Hijacker)
+  if !ok {
+    return fail(http.StatusInternalServerError, "connection can't be taken over")
+  }
+  
+  var subprotocol string
+  for _, offered := range strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",") {
+    offered = strings.TrimSpace(offered)
+    for _, supported := range subprotocols {
+      if subprotocol == "" && offered == supported {
+        subprotocol = supported
+      }
+    }
+  }
+  
+  conn, buffered, err := hijacker.Hijack()
+  if err != nil {
+    return nil, err
+  }
+  if buffered.Reader.Buffered() > 0 {
+    conn.Close()
+    return nil, errors.New("websocket: client sent data before the handshake ended")
+  }
+  response := "HTTP/1.1 101 Switching Protocols\r\n" +
+    "Upgrade: websocket\r\n" +
+    "Connection: Upgrade\r\n" +
+    "Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n"
+  if subprotocol != "" {
+    response += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+  }
+  if _, err := io.WriteString(conn, response+"\r\n"); err != nil {
+    conn.Close()
+    return nil, err
+  }
+  return newConn(conn, buffered.Reader, false, subprotocol), nil
+}
+
+// Dial opens a WebSocket connection to a ws:// URL, offering the subprotocols
+func Dial(url string, subprotocols []string, header http.Header) (*Conn, *http.Response, error) {
+  request, err := http.NewRequest(http.MethodGet, strings.Replace(url, "ws://", "http://", 1), nil)
+  if err != nil {
+    return nil, nil, err
+  }
+  for name, values := range header {
+    request.Header[name] = values
+  }
+  nonce := make([]byte, 16)
+  rand.Read(nonce)
+  key := base64.StdEncoding.EncodeToString(nonce)
+  request.Header.Set("Upgrade", "websocket")
+  request.Header.Set("Connection", "Upgrade")
+  request.Header.Set("Sec-WebSocket-Key", key)
+  request.Header.Set("Sec-WebSocket-Version", "13")
+  if len(subprotocols) > 0 {
+    request.Header.Set("Sec-WebSocket-Protocol", strings.Join(subprotocols, ", "))
+  }
+  
+  conn, err := net.Dial("tcp", request.URL.Host)
+  if err != nil {
+    return nil, nil, err
+  }
+  if err := request.Write(conn); err != nil {
+    conn.Close()
+    return nil, nil, err
+  }
+  reader := bufio.NewReader(conn)
+  response, err := http.ReadResponse(reader, request)
+  if err != nil {
+    conn.Close()
+    return nil, nil, err
+  }
+  if response.StatusCode != http.StatusSwitchingProtocols || response.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+    conn.Close()
+    return nil, response, ErrBadHandshake
+  }
+  return newConn(conn, reader, true, response.Header.Get("Sec-WebSocket-Protocol")), response, nil
+}
+
+// Subprotocol is the subprotocol both sides agreed on, if any
+func (c *Conn) Subprotocol() string {
+  return c.subprotocol
+}
+
+// SetReadLimit bounds the size of a message; larger
+// ones close the connection with CloseMessageTooBig
+func (c *Conn) SetReadLimit(limit int64) {
+  c.readLimit = limit
+}
+
+// SetPongHandler is called by ReadMessage for every pong the peer sends
+func (c *Conn) SetPongHandler(onPong func() error) {
+  c.onPong = onPong
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+  return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+  return c.conn.SetWriteDeadline(t)
+}
+
+// ReadMessage returns the next text or binary message, joining its
+// fragments, and fails the connection on text that isn't UTF-8. Pings
+// are answered and pongs handed to the pong handler meanwhile. A close
+// from the peer is answered and returned as a CloseError
+func (c *Conn) ReadMessage() (int, []byte, error) {
+  var messageType int
+  var message []byte
+  for {
+    fin, opcode, payload, err := c.readFrame()
+    if err != nil {
+      return 0, nil, err
+    }
+    switch opcode {
+    case PingMessage:
+      if err := c.WriteControl(PongMessage, payload); err != nil && err != ErrClosed {
+        return 0, nil, err
+      }
+      continue
+    case PongMessage:
+      if c.onPong != nil {
+        if err := c.onPong(); err != nil {
+          return 0, nil, err
+        }
+      }
+      continue
+    case CloseMessage:
+      closeErr := &CloseError{Code: CloseNoStatus}
+      if len(payload) >= 2 {
+        closeErr.Code = int(binary.BigEndian.Uint16(payload))
+        closeErr.Text = string(payload[2:])
+      }
+      c.WriteClose(closeErr.Code, "")
+      return 0, nil, closeErr
+    case TextMessage, BinaryMessage:
+      if messageType != 0 {
+        return 0, nil, c.fail(CloseProtocolError, "new message before the last one ended")
+      }
+      messageType = opcode
+    case continuationFrame:
+      if messageType == 0 {
+        return 0, nil, c.fail(CloseProtocolError, "continuation without a message")
+      }
+    default:
+      return 0, nil, c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", opcode))
+    }
+    
+    if int64(len(message)+len(payload)) > c.readLimit {
+      c.WriteClose(CloseMessageTooBig, "")
+      return 0, nil, ErrReadLimit
+    }
+    message = append(message, payload...)
+    if fin {
+      if messageType == TextMessage && !utf8.Valid(message) {
+        return 0, nil, c.fail(CloseInvalidPayload, "text message isn't valid UTF-8")
+      }
+      return messageType, message, nil
+    }
+  }
+}
+
+func (c *Conn) readFrame() (bool, int, []byte, error) {
+  var header [2]byte
+  if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+    return false, 0, nil, err
+  }
+  fin := header[0]&0x80 != 0
+  opcode := int(header[0] & 0x0f)
+  masked := header[1]&0x80 != 0
+  length := int64(header[1] & 0x7f)
+  if header[0]&0x70 != 0 {
+    return false, 0, nil, c.fail(CloseProtocolError, "reserved bits are set")
+  }
+  if masked == c.client {
+    return false, 0, nil, c.fail(CloseProtocolError, "frame masking is wrong")
+  }
+  
+  switch length {
+  case 126:
+    var extended [2]byte
+    if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+      return false, 0, nil, err
+    }
+    length = int64(binary.BigEndian.Uint16(extended[:]))
+  case 127:
+    var extended [8]byte
+    if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+      return false, 0, nil, err
+    }
+    length = int64(binary.BigEndian.Uint64(extended[:]))
+  }
+  if opcode >= CloseMessage && (length > maxControlPayload || !fin) {
+    return false, 0, nil, c.fail(CloseProtocolError, "control frame is fragmented or too long")
+  }
+  if length < 0 || length > c.readLimit {
+    c.WriteClose(CloseMessageTooBig, "")
+    return false, 0, nil, ErrReadLimit
+  }
+  
+  var mask [4]byte
+  if masked {
+    if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+      return false, 0, nil, err
+    }
+  }
+  payload := make([]byte, length)
+  if _, err := io.ReadFull(c.reader, payload); err != nil {
+    return false, 0, nil, err
+  }
+  if masked {
+    for i := range payload {
+      payload[i] ^= mask[i%4]
+    }
+  }
+  return fin, opcode, payload, nil
+}
+
+// fail closes the connection because the peer broke the protocol
+func (c *Conn) fail(code int, reason string) error {
+  c.WriteClose(code, reason)
+  return fmt.Errorf("websocket: %s", reason)
+}
+
+// WriteMessage sends a text or binary message in one frame
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+  c.writeMu.Lock()
+  defer c.writeMu.Unlock()
+  if c.closeSent {
+    return ErrClosed
+  }
+  return c.writeFrame(messageType, data)
+}
+
+// WriteControl sends a ping or pong
+func (c *Conn) WriteControl(messageType int, data []byte) error {
+  if len(data) > maxControlPayload {
+    return fmt.Errorf("websocket: control payload of %d bytes is too long", len(data))
+  }
+  return c.WriteMessage(messageType, data)
+}
+
+// WriteClose starts closing the connection with the code and
+// reason; nothing else can be written after it
+func (c *Conn) WriteClose(code int, reason string) error {
+  c.writeMu.Lock()
+  defer c.writeMu.Unlock()
+  if c.closeSent {
+    return nil
+  }
+  c.closeSent = true
+  var payload []byte
+  if code != CloseNoStatus {
+    if len(reason) > maxControlPayload-2 {
+      reason = reason[:maxControlPayload-2]
+    }
+    payload = make([]byte, 2, 2+len(reason))
+    binary.BigEndian.PutUint16(payload, uint16(code))
+    payload = append(payload, reason...)
+  }
+  return c.writeFrame(CloseMessage, payload)
+}
+
+func (c *Conn) writeFrame(opcode int, data []byte) error {
+  frame := make([]byte, 0, 14+len(data))
+  frame = append(frame, 0x80|byte(opcode))
+  var maskBit byte
+  if c.client {
+    maskBit = 0x80
+  }
+  switch {
+  case len(data) < 126:
+    frame = append(frame, maskBit|byte(len(data)))
+  case len(data) <= 0xffff:
+    frame = append(frame, maskBit|126, byte(len(data)>>8), byte(len(data)))
+  default:
+    frame = append(frame, maskBit|127)
+    var length [8]byte
+    binary.BigEndian.PutUint64(length[:], uint64(len(data)))
+    frame = append(frame, length[:]...)
+  }
+  
+  if !c.client {
+    frame = append(frame, data...)
+  } else {
+    var mask [4]byte
+    rand.Read(mask[:])
+    frame = append(frame, mask[:]...)
+    for i, b := range data {
+      frame = append(frame, b^mask[i%4])
+    }
+  }
+  _, err := c.conn.Write(frame)
+  return err
+}
+
+// Close closes the underlying connection, without a close frame
+// unless WriteClose sent one before
+func (c *Conn) Close() error {
+  return c.conn.Close()
+}
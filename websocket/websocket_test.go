@@ -0,0 +1,190 @@
+package websocket
+
+import (
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+)
+
+// startEcho serves a connection that echoes every message back
+func startEcho(t *testing.T, limit int64) *httptest.Server {
+  return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    conn, err := Upgrade(w, r, []string{"echo.v2", "echo.v1"}, []string{"https://app.example"})
+    if err != nil {
+      return
+    }
+    defer conn.Close()
+    conn.SetReadLimit(limit)
+    for {
+      messageType, message, err := conn.ReadMessage()
+      if err != nil {
+        return
+      }
+      if err := conn.WriteMessage(messageType, message); err != nil {
+        return
+      }
+    }
+  }))
+}
+
+func wsURL(server *httptest.Server) string {
+  return strings.Replace(server.URL, "http://", "ws://", 1)
+}
+
+func TestAcceptKey(t *testing.T) {
+  // the example of RFC 6455
+  got := acceptKey("dGhlIHNhbXBsZSBub25jZQ==")
+  if got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+    t.Errorf("Got: %s, wanted: %s\n", got, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+  }
+}
+
+func TestConn_Echo(t *testing.T) {
+  server := startEcho(t, 1<<20)
+  defer server.Close()
+  conn, _, err := Dial(wsURL(server), []string{"echo.v1"}, nil)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  defer conn.Close()
+  if conn.Subprotocol() != "echo.v1" {
+    t.Errorf("Got: %s, wanted: %s\n", conn.Subprotocol(), "echo.v1")
+  }
+  
+  tests := []struct {
+    messageType int
+    message     string
+  }{
+    {TextMessage, "hello"},
+    {BinaryMessage, strings.Repeat("b", 300)},
+    {TextMessage, strings.Repeat("l", 70000)},
+    {TextMessage, ""},
+  }
+  for _, test := range tests {
+    if err := conn.WriteMessage(test.messageType, []byte(test.message)); err != nil {
+      t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+    messageType, message, err := conn.ReadMessage()
+    if err != nil || messageType != test.messageType || string(message) != test.message {
+      t.Errorf("Got: %d %d bytes %v, wanted: %d %d bytes\n", messageType, len(message), err, test.messageType, len(test.message))
+    }
+  }
+}
+
+func TestConn_PingPong(t *testing.T) {
+  server := startEcho(t, 1<<20)
+  defer server.Close()
+  conn, _, err := Dial(wsURL(server), nil, nil)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  defer conn.Close()
+  
+  pongs := 0
+  conn.SetPongHandler(func() error {
+    pongs++
+    return nil
+  })
+  conn.WriteControl(PingMessage, []byte("are you there"))
+  conn.WriteMessage(TextMessage, []byte("after the ping"))
+  _, message, err := conn.ReadMessage()
+  if err != nil || pongs != 1 || string(message) != "after the ping" {
+    t.Errorf("Got: %d pongs %q %v, wanted: %d pongs\n", pongs, message, err, 1)
+  }
+}
+
+func TestConn_Close(t *testing.T) {
+  server := startEcho(t, 10)
+  defer server.Close()
+  
+  tests := []struct {
+    name    string
+    message string
+    code    int
+  }{
+    {"normal", "", CloseNormal},
+    {"too big", "more than ten bytes", CloseMessageTooBig},
+    {"invalid utf-8", "\xff\xfe", CloseInvalidPayload},
+  }
+  for _, test := range tests {
+    conn, _, err := Dial(wsURL(server), nil, nil)
+    if err != nil {
+      t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+    }
+    if test.message != "" {
+      conn.WriteMessage(TextMessage, []byte(test.message))
+    } else {
+      conn.WriteClose(CloseNormal, "bye")
+    }
+    _, _, err = conn.ReadMessage()
+    closeErr, ok := err.(*CloseError)
+    if !ok || closeErr.Code != test.code {
+      t.Errorf("%s: Got: %v, wanted: %d\n", test.name, err, test.code)
+    }
+    conn.Close()
+  }
+}
+
+func TestUpgrade_UnmaskedFrame(t *testing.T) {
+  server := startEcho(t, 1<<20)
+  defer server.Close()
+  conn, _, err := Dial(wsURL(server), nil, nil)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  defer conn.Close()
+  
+  // a client must mask its frames, so the server fails the connection
+  conn.conn.Write([]byte{0x81, 0x02, 'h', 'i'})
+  conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+  _, _, err = conn.ReadMessage()
+  if closeErr, ok := err.(*CloseError); !ok || closeErr.Code != CloseProtocolError {
+    t.Errorf("Got: %v, wanted: %d\n", err, CloseProtocolError)
+  }
+}
+
+func TestUpgrade_NotWebSocket(t *testing.T) {
+  server := startEcho(t, 1<<20)
+  defer server.Close()
+  response, err := http.Get(server.URL)
+  if err != nil {
+    t.Fatalf("Got: %v, wanted: %v\n", err, nil)
+  }
+  response.Body.Close()
+  if response.StatusCode != http.StatusBadRequest {
+    t.Errorf("Got: %d, wanted: %d\n", response.StatusCode, http.StatusBadRequest)
+  }
+}
+
+func TestUpgrade_Origin(t *testing.T) {
+  server := startEcho(t, 1<<20)
+  defer server.Close()
+  
+  tests := []struct {
+    origin  string
+    allowed bool
+  }{
+    {"", true},
+    {server.URL, true},
+    {"https://app.example", true},
+    {"https://evil.example", false},
+  }
+  for _, test := range tests {
+    header := http.Header{}
+    if test.origin != "" {
+      header.Set("Origin", test.origin)
+    }
+    conn, response, err := Dial(wsURL(server), nil, header)
+    if test.allowed && err != nil {
+      t.Errorf("%s: Got: %v, wanted: %v\n", test.origin, err, nil)
+    }
+    if !test.allowed && (err != ErrBadHandshake || response.StatusCode != http.StatusForbidden) {
+      t.Errorf("%s: Got: %v, wanted: %d\n", test.origin, err, http.StatusForbidden)
+    }
+    if conn != nil {
+      conn.Close()
+    }
+  }
+}